@@ -180,10 +180,13 @@ id:
 #
 #  profile - Name of the signing profile to use in issuing the certificate
 #  label - Label to use in HSM operations
+#  sighash - Hash algorithm used by the CA to sign the certificate (SHA256,
+#            SHA384 or SHA512); defaults to the hash algorithm for the CA key
 #############################################################################
 enrollment:
   profile:
   label:
+  sighash:
 
 #############################################################################
 # Name of the CA to connect to within the fabric-ca server
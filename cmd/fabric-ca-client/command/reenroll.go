@@ -67,6 +67,7 @@ func (c *ClientCmd) runReenroll() error {
 	req := &api.ReenrollmentRequest{
 		Label:   c.clientCfg.Enrollment.Label,
 		Profile: c.clientCfg.Enrollment.Profile,
+		SigHash: c.clientCfg.Enrollment.SigHash,
 		CSR:     &c.clientCfg.CSR,
 		CAName:  c.clientCfg.CAName,
 	}
@@ -86,6 +86,9 @@ debug: false
 # Size limit of an acceptable CRL in bytes (default: 512000)
 crlsizelimit: 512000
 
+# Size limit of an acceptable CSR in bytes (default: 1048576)
+csrsizelimit: 1048576
+
 #############################################################################
 #  TLS section for the server's listening port
 #
@@ -124,6 +127,16 @@ ca:
   certfile:
   # Chain file
   chainfile:
+  # Do not import the key from the key file if it is not found in the BCCSP
+  # keystore, e.g. when the key must be held by an HSM
+  disablekeyfilefallback: false
+  # Curves of the ECDSA private keys which can be imported into BCCSP; one or
+  # more of P-256, P-384 and P-521 (default: all of them)
+  allowedcurves:
+  # Draw serial numbers and software keys from the random number generator of
+  # the BCCSP provider if it exposes one; crypto/rand is used otherwise. None
+  # of the SW, PKCS11 and PLUGIN providers expose their generator.
+  usecsprng: false
 
 #############################################################################
 #  The gencrl REST endpoint is used to generate a CRL that contains revoked
@@ -136,7 +149,41 @@ crl:
   # is used to set the 'Next Update' date of the CRL.
   expiry: 24h
 
+###############################################################################
+#  The serial section controls how the serial numbers of the certificates
+#  issued by the CA are chosen. If the source is "random", the serial numbers
+#  are randomly generated. If the source is "sequential", the serial numbers
+#  are allocated in increasing order and the last allocated serial number is
+#  stored in hex in the specified file.
+#############################################################################
+serial:
+  # Source of the serial numbers; one of: random, sequential
+  source: random
+  # File in which the last issued serial number is stored
+  file: serial
+
+###############################################################################
+#  The csrreplay section controls the rejection of CSRs which have already
+#  been processed by the CA. A CSR which is resubmitted within the window is
+#  rejected. Replay protection is disabled if the window is zero.
+#############################################################################
+csrreplay:
+  # Duration within which a CSR which was already processed is rejected
+  window: 0s
+
+###############################################################################
+#  The aia section contains the URLs embedded in the Authority Information
+#  Access extension of the certificates issued by the CA, so that clients can
+#  check their revocation status with OCSP and retrieve the CA certificate.
+#  They apply to all signing profiles which do not set their own URLs.
 #############################################################################
+aia:
+  # URL of the OCSP responder of the CA
+  ocspurl:
+  # URLs from which the CA certificate can be retrieved
+  issuerurls:
+
+###########################################################################
 #  The registry section controls how the fabric-ca-server does two things:
 #  1) authenticates enrollment requests which contain a username and password
 #     (also known as an enrollment ID and secret).
@@ -293,12 +340,18 @@ affiliations:
 #
 #  The "tls" profile subsection is used to sign TLS certificate requests;
 #  the default expiration ("expiry" field) is "8760h", which is 1 year in hours.
+#
+#  The "backdate" field of a profile is subtracted from the issuance time to
+#  set the NotBefore time of issued certificates, so that they are valid in
+#  spite of clock skew between the CA and verifiers. It defaults to "5m" and
+#  is limited to "24h".
 #############################################################################
 signing:
     default:
       usage:
         - digital signature
       expiry: 8760h
+      backdate: 5m
     profiles:
       ca:
          usage:
@@ -317,6 +370,26 @@ signing:
             - key agreement
          expiry: 8760h
 
+#############################################################################
+#  Profile constraints section
+#
+#  Additional constraints enforced when issuing certificates with a signing
+#  profile, keyed by profile name; the default profile is named "default".
+#
+#  The "keyalgos" field restricts the key algorithms ("ecdsa" or "rsa")
+#  allowed in CSRs signed with the profile. The "gmt0015" field requires the
+#  certificates issued with the profile to conform to the GM/T 0015
+#  certificate profile, which requires an SM2 CA; SM2 CAs are not supported,
+#  so the CA fails to start if it is true. For example, the following
+#  restricts the "tls" profile to ECDSA keys:
+#
+#  profileconstraints:
+#    tls:
+#      keyalgos:
+#        - ecdsa
+#############################################################################
+profileconstraints:
+
 ###########################################################################
 #  Certificate Signing Request (CSR) section.
 #  This controls the creation of the root CA certificate.
@@ -367,6 +440,11 @@ idemix:
   # by the prover to prove to the verifier that her credential is not revoked.
   rhpoolsize: 1000
 
+  # Specifies how revocation handles are allocated: 'sequential' allocates the handles of the pool
+  # in order, while 'random' allocates random handles, so that the handles of the credentials don't
+  # reveal how many credentials were issued. The default is 'sequential'.
+  rhallocation: sequential
+
   # The Idemix credential issuance is a two step process. First step is to  get a nonce from the issuer
   # and second step is send credential request that is constructed using the nonce to the isuser to
   # request a credential. This configuration property specifies expiration for the nonces. By default is
@@ -377,6 +455,12 @@ idemix:
   #  The value is expressed in the time.Duration format (see https://golang.org/pkg/time/#ParseDuration)
   noncesweepinterval: 15m
 
+  # Specifies how long a credential revocation information (CRI) is valid. The expiry of the CRI
+  # is returned with it, so that clients can cache it until then; the issuer creates a new CRI
+  # once it expires. By default, a CRI is valid until the epoch changes. The value is expressed
+  # in the time.Duration format (see https://golang.org/pkg/time/#ParseDuration).
+  crivalidity:
+
 #############################################################################
 # BCCSP (BlockChain Crypto Service Provider) section is used to select which
 # crypto library implementation to use
@@ -15,6 +15,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"syscall"
 	"testing"
 
 	"github.com/cloudflare/cfssl/log"
@@ -566,3 +567,40 @@ func TestOperationsTLSCertKeyConfig(t *testing.T) {
 	assert.Equal(t, cmd.cfg.Operations.TLS.CertFile, filepath.Join(homeDir, certFile))
 	assert.Equal(t, cmd.cfg.Operations.TLS.KeyFile, filepath.Join(homeDir, keyFile))
 }
+
+// countingReloader is a tlsReloader which reports each reload on reloaded
+type countingReloader struct {
+	reloaded chan struct{}
+	err      error
+}
+
+func (r *countingReloader) ReloadTLSCertificate() error {
+	err := r.err
+	r.reloaded <- struct{}{}
+	return err
+}
+
+func TestReloadTLSOnSignal(t *testing.T) {
+	srv := &countingReloader{reloaded: make(chan struct{})}
+	sigs := make(chan os.Signal)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		reloadTLSOnSignal(srv, sigs, done)
+		close(stopped)
+	}()
+
+	for i := 0; i < 2; i++ {
+		sigs <- syscall.SIGHUP
+		<-srv.reloaded
+	}
+	// A failed reload does not stop the signal handling
+	srv.err = errors.New("reload failed")
+	sigs <- syscall.SIGHUP
+	<-srv.reloaded
+	sigs <- syscall.SIGHUP
+	<-srv.reloaded
+
+	close(done)
+	<-stopped
+}
@@ -0,0 +1,52 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/cloudflare/cfssl/log"
+)
+
+// tlsReloader reloads the TLS certificate of a server
+type tlsReloader interface {
+	ReloadTLSCertificate() error
+}
+
+// reloadTLSOnSIGHUP reloads the TLS certificate of srv each time the process
+// receives SIGHUP, so that a renewed certificate can be picked up without a
+// restart. The returned function stops handling the signal.
+func reloadTLSOnSIGHUP(srv tlsReloader) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	done := make(chan struct{})
+	go reloadTLSOnSignal(srv, sigs, done)
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// reloadTLSOnSignal reloads the TLS certificate of srv for each signal
+// received on sigs until done is closed. A failed reload is logged and the
+// current certificate is kept.
+func reloadTLSOnSignal(srv tlsReloader, sigs <-chan os.Signal, done <-chan struct{}) {
+	for {
+		select {
+		case sig := <-sigs:
+			log.Infof("Received %s, reloading the TLS certificate", sig)
+			err := srv.ReloadTLSCertificate()
+			if err != nil {
+				log.Errorf("Failed to reload the TLS certificate: %s", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
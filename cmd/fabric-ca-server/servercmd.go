@@ -118,7 +118,9 @@ func (s *ServerCmd) init() {
 		if len(args) > 0 {
 			return errors.Errorf(extraArgsError, args, startCmd.UsageString())
 		}
-		err := s.getServer().Start()
+		srv := s.getServer()
+		defer reloadTLSOnSIGHUP(srv)()
+		err := srv.Start()
 		if err != nil {
 			return err
 		}
@@ -61,6 +61,9 @@ type EnrollmentRequest struct {
 	Profile string `json:"profile,omitempty" help:"Name of the signing profile to use in issuing the certificate"`
 	// Label is the label to use in HSM operations
 	Label string `json:"label,omitempty" help:"Label to use in HSM operations"`
+	// SigHash is the hash algorithm used by the CA to sign the certificate;
+	// if not set, the CA uses the default hash algorithm for its key
+	SigHash string `json:"sig_hash,omitempty" help:"Hash algorithm used by the CA to sign the certificate (SHA256, SHA384 or SHA512)"`
 	// CSR is Certificate Signing Request info
 	CSR *CSRInfo `json:"csr,omitempty" skip:"true"` // Skipping this because we pull the CSR from the CSR flags
 	// The type of the enrollment request: x509 or idemix
@@ -79,6 +82,8 @@ type ReenrollmentRequest struct {
 	Profile string `json:"profile,omitempty"`
 	// Label is the label to use in HSM operations
 	Label string `json:"label,omitempty"`
+	// SigHash is the hash algorithm used by the CA to sign the certificate
+	SigHash string `json:"sig_hash,omitempty"`
 	// CSR is Certificate Signing Request info
 	CSR *CSRInfo `json:"csr,omitempty"`
 	// CAName is the name of the CA to connect to
@@ -86,6 +91,9 @@ type ReenrollmentRequest struct {
 	// AttrReqs are requests for attributes to add to the certificate.
 	// Each attribute is added only if the requestor owns the attribute.
 	AttrReqs []*AttributeRequest `json:"attr_reqs,omitempty"`
+	// AllowAlgorithmChange allows the key of the new certificate to have
+	// another algorithm than the key of the certificate being reenrolled
+	AllowAlgorithmChange bool `json:"-" skip:"true"`
 }
 
 // RevocationRequest is a revocation request for a single certificate or all certificates
@@ -157,6 +165,9 @@ type GetCRIRequest struct {
 type GetCRIResponse struct {
 	// CRI is base64 encoded proto bytes of idemix.CredentialRevocationInformation
 	CRI string
+	// Expiry is the time in RFC 3339 format after which the CRI must be
+	// fetched again, or empty if the CRI is valid until the epoch changes
+	Expiry string `json:",omitempty"`
 }
 
 // AddIdentityRequest represents the request to add a new identity to the
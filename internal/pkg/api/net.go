@@ -42,6 +42,8 @@ type EnrollmentRequestNet struct {
 	signer.SignRequest
 	CAName   string
 	AttrReqs []*AttributeRequest `json:"attr_reqs,omitempty"`
+	// SigHash is the hash algorithm used by the CA to sign the certificate
+	SigHash string `json:"sig_hash,omitempty"`
 }
 
 // IdemixEnrollmentRequestNet is a request to enroll an identity and get idemix credential
@@ -56,6 +58,8 @@ type ReenrollmentRequestNet struct {
 	signer.SignRequest
 	CAName   string
 	AttrReqs []*AttributeRequest `json:"attr_reqs,omitempty"`
+	// SigHash is the hash algorithm used by the CA to sign the certificate
+	SigHash string `json:"sig_hash,omitempty"`
 }
 
 // RevocationRequestNet is a revocation request which flows over the network
@@ -0,0 +1,157 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+var (
+	oidExtSubjectKeyID     = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidExtKeyUsage         = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtSubjectAltName   = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtAuthorityKeyID   = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidExtExtKeyUsage      = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any",
+	x509.ExtKeyUsageServerAuth:      "Server Authentication",
+	x509.ExtKeyUsageClientAuth:      "Client Authentication",
+	x509.ExtKeyUsageCodeSigning:     "Code Signing",
+	x509.ExtKeyUsageEmailProtection: "Email Protection",
+	x509.ExtKeyUsageTimeStamping:    "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP Signing",
+}
+
+// ExtensionInfo contains the readable form of a certificate extension
+type ExtensionInfo struct {
+	// OID is the object identifier of the extension in dotted form
+	OID string `json:"oid"`
+	// Name is the name of the extension, or empty if the extension is unknown
+	Name string `json:"name,omitempty"`
+	// Critical is true if the extension is marked critical
+	Critical bool `json:"critical"`
+	// Value is the decoded value of the extension if it is known, otherwise
+	// the hex encoding of the raw value
+	Value string `json:"value"`
+}
+
+// DumpCertExtensions returns the extensions of the certificate in the PEM
+// encoded certFile in readable form
+func DumpCertExtensions(certFile string) ([]ExtensionInfo, error) {
+	cert, err := GetX509CertificateFromPEMFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	return GetCertExtensions(cert), nil
+}
+
+// GetCertExtensions returns the extensions of cert in readable form
+func GetCertExtensions(cert *x509.Certificate) []ExtensionInfo {
+	exts := make([]ExtensionInfo, 0, len(cert.Extensions))
+	for _, ext := range cert.Extensions {
+		info := ExtensionInfo{
+			OID:      ext.Id.String(),
+			Critical: ext.Critical,
+		}
+		switch {
+		case ext.Id.Equal(oidExtBasicConstraints):
+			info.Name = "Basic Constraints"
+			info.Value = basicConstraintsString(cert)
+		case ext.Id.Equal(oidExtKeyUsage):
+			info.Name = "Key Usage"
+			info.Value = keyUsageString(cert.KeyUsage)
+		case ext.Id.Equal(oidExtExtKeyUsage):
+			info.Name = "Extended Key Usage"
+			info.Value = extKeyUsageString(cert)
+		case ext.Id.Equal(oidExtSubjectAltName):
+			info.Name = "Subject Alternative Name"
+			info.Value = subjectAltNameString(cert)
+		case ext.Id.Equal(oidExtSubjectKeyID):
+			info.Name = "Subject Key Identifier"
+			info.Value = hex.EncodeToString(cert.SubjectKeyId)
+		case ext.Id.Equal(oidExtAuthorityKeyID):
+			info.Name = "Authority Key Identifier"
+			info.Value = hex.EncodeToString(cert.AuthorityKeyId)
+		default:
+			info.Value = hex.EncodeToString(ext.Value)
+		}
+		exts = append(exts, info)
+	}
+	return exts
+}
+
+func basicConstraintsString(cert *x509.Certificate) string {
+	s := fmt.Sprintf("CA:%t", cert.IsCA)
+	if cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		s = fmt.Sprintf("%s, pathlen:%d", s, cert.MaxPathLen)
+	}
+	return s
+}
+
+func keyUsageString(ku x509.KeyUsage) string {
+	var usages []string
+	for _, u := range keyUsageNames {
+		if ku&u.usage != 0 {
+			usages = append(usages, u.name)
+		}
+	}
+	return strings.Join(usages, ", ")
+}
+
+func extKeyUsageString(cert *x509.Certificate) string {
+	var usages []string
+	for _, eku := range cert.ExtKeyUsage {
+		if name, ok := extKeyUsageNames[eku]; ok {
+			usages = append(usages, name)
+		} else {
+			usages = append(usages, fmt.Sprintf("%d", eku))
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		usages = append(usages, oid.String())
+	}
+	return strings.Join(usages, ", ")
+}
+
+func subjectAltNameString(cert *x509.Certificate) string {
+	var names []string
+	for _, name := range cert.DNSNames {
+		names = append(names, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		names = append(names, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, "URI:"+uri.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,41 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpCertExtensions(t *testing.T) {
+	exts, err := DumpCertExtensions(filepath.Join("testdata", "tls_server-cert.pem"))
+	assert.NoError(t, err)
+	assert.Len(t, exts, 6)
+
+	byOID := map[string]ExtensionInfo{}
+	for _, ext := range exts {
+		byOID[ext.OID] = ext
+	}
+	assert.Equal(t, ExtensionInfo{OID: "2.5.29.15", Name: "Key Usage", Critical: true,
+		Value: "Digital Signature, Key Encipherment, Key Agreement"}, byOID["2.5.29.15"])
+	assert.Equal(t, ExtensionInfo{OID: "2.5.29.37", Name: "Extended Key Usage",
+		Value: "Server Authentication, Client Authentication"}, byOID["2.5.29.37"])
+	assert.Equal(t, ExtensionInfo{OID: "2.5.29.19", Name: "Basic Constraints", Critical: true,
+		Value: "CA:false"}, byOID["2.5.29.19"])
+	assert.Equal(t, ExtensionInfo{OID: "2.5.29.14", Name: "Subject Key Identifier",
+		Value: "55d56325efd43330183feaaab68c89df5446da17"}, byOID["2.5.29.14"])
+	assert.Equal(t, ExtensionInfo{OID: "2.5.29.35", Name: "Authority Key Identifier",
+		Value: "1767423daa9e823fc4c51d9f5bc399d1b59c4810"}, byOID["2.5.29.35"])
+	assert.Equal(t, ExtensionInfo{OID: "2.5.29.17", Name: "Subject Alternative Name",
+		Value: "DNS:localhost"}, byOID["2.5.29.17"])
+
+	_, err = DumpCertExtensions("doesnotexist.pem")
+	assert.Error(t, err)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"sync"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/pkg/errors"
+)
+
+// Hash families of certificate signatures
+const (
+	HashFamilySHA2 = "SHA2"
+	HashFamilySHA3 = "SHA3"
+	HashFamilySM3  = "SM3"
+)
+
+// sha3SignatureOIDs are the OIDs of the signature algorithms using SHA3, which
+// crypto/x509 doesn't know
+var sha3SignatureOIDs = []asn1.ObjectIdentifier{
+	{2, 16, 840, 1, 101, 3, 4, 3, 10}, // ecdsa-with-SHA3-256
+	{2, 16, 840, 1, 101, 3, 4, 3, 11}, // ecdsa-with-SHA3-384
+	{2, 16, 840, 1, 101, 3, 4, 3, 12}, // ecdsa-with-SHA3-512
+	{2, 16, 840, 1, 101, 3, 4, 3, 14}, // id-rsassa-pkcs1-v1_5-with-sha3-256
+	{2, 16, 840, 1, 101, 3, 4, 3, 15}, // id-rsassa-pkcs1-v1_5-with-sha3-384
+	{2, 16, 840, 1, 101, 3, 4, 3, 16}, // id-rsassa-pkcs1-v1_5-with-sha3-512
+}
+
+// certHashCheck holds whether a mismatch between the signature hash of a
+// certificate and the hash family of the CSP is an error
+var certHashCheck = struct {
+	sync.RWMutex
+	strict bool
+}{}
+
+// SetStrictCertHashCheck sets whether GetSignerFromCert fails, rather than
+// logging a warning, when the signature of the certificate uses a hash which
+// is not from the hash family configured for the BCCSP provider. A warning is
+// logged by default.
+func SetStrictCertHashCheck(strict bool) {
+	certHashCheck.Lock()
+	defer certHashCheck.Unlock()
+	certHashCheck.strict = strict
+}
+
+// CertSignatureHashFamily returns the hash family of the hash algorithm of the
+// signature of cert: SHA2, SHA3 or SM3. An error is returned for other hash
+// algorithms, such as SHA1 and MD5.
+func CertSignatureHashFamily(cert *x509.Certificate) (string, error) {
+	switch cert.SignatureAlgorithm {
+	case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+		x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return HashFamilySHA2, nil
+	case x509.UnknownSignatureAlgorithm:
+	default:
+		return "", errors.Errorf("The signature algorithm %s of the certificate uses a hash of no supported hash family", cert.SignatureAlgorithm)
+	}
+	var gmCert gmCertificate
+	if _, err := asn1.Unmarshal(cert.Raw, &gmCert); err != nil {
+		return "", errors.Wrap(err, "Failed to decode certificate")
+	}
+	oid := gmCert.SignatureAlgorithm.Algorithm
+	if oid.Equal(oidSignatureSM3WithSM2) {
+		return HashFamilySM3, nil
+	}
+	for _, sha3OID := range sha3SignatureOIDs {
+		if oid.Equal(sha3OID) {
+			return HashFamilySHA3, nil
+		}
+	}
+	return "", errors.Errorf("Unknown signature algorithm %s of the certificate", oid)
+}
+
+// checkCertHashFamily checks that the hash of the signature of cert is from
+// the hash family configured for csp, if it is known. A mismatch is logged,
+// or returned as an error if SetStrictCertHashCheck was called with true.
+func checkCertHashFamily(cert *x509.Certificate, csp bccsp.BCCSP) error {
+	family := getCSPOptions(csp).hashFamily
+	if family == "" {
+		return nil
+	}
+	certHashCheck.RLock()
+	strict := certHashCheck.strict
+	certHashCheck.RUnlock()
+	certFamily, err := CertSignatureHashFamily(cert)
+	if err == nil && certFamily == family {
+		return nil
+	}
+	if err == nil {
+		err = errors.Errorf("The certificate '%s' is signed with a %s hash, but the BCCSP provider is configured for the %s hash family",
+			cert.Subject.CommonName, certFamily, family)
+	} else {
+		err = errors.WithMessage(err, fmt.Sprintf("The signature hash of the certificate '%s' can't be checked against the %s hash family of the BCCSP provider",
+			cert.Subject.CommonName, family))
+	}
+	if strict {
+		return err
+	}
+	log.Warning(err)
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertSignatureHashFamily(t *testing.T) {
+	cert, err := GetX509CertificateFromPEMFile(filepath.Join("testdata", "ec.pem"))
+	FatalError(t, err, "Failed to read certificate")
+	family, err := CertSignatureHashFamily(cert)
+	assert.NoError(t, err)
+	assert.Equal(t, HashFamilySHA2, family)
+
+	sm2Key, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	gmCert := createGMCertificate(t, "gm", "gm", sm2Key, sm2Key, nil)
+	family, err = CertSignatureHashFamily(&x509.Certificate{Raw: gmCert})
+	assert.NoError(t, err)
+	assert.Equal(t, HashFamilySM3, family)
+
+	_, err = CertSignatureHashFamily(&x509.Certificate{SignatureAlgorithm: x509.SHA1WithRSA})
+	assert.Error(t, err, "SHA1 is not a supported hash family")
+}
+
+func TestGetSignerFromCertHashFamily(t *testing.T) {
+	defer SetStrictCertHashCheck(false)
+	cert, err := GetX509CertificateFromPEMFile(filepath.Join("testdata", "ec.pem"))
+	FatalError(t, err, "Failed to read certificate")
+	tests := []struct {
+		family     string
+		consistent bool
+	}{
+		{"SHA2", true},
+		{"SHA3", false},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "hashfamily")
+		FatalError(t, err, "Failed to create temp directory")
+		defer os.RemoveAll(dir)
+		opts := &factory.FactoryOpts{
+			ProviderName: "SW",
+			SwOpts: &factory.SwOpts{
+				HashFamily:   test.family,
+				SecLevel:     256,
+				FileKeystore: &factory.FileKeystoreOpts{KeyStorePath: dir},
+			},
+		}
+		familyCSP, err := GetBCCSP(opts, dir)
+		FatalError(t, err, "Failed to get BCCSP")
+		_, err = ImportBCCSPKeyFromPEM(filepath.Join("testdata", "ec-key.pem"), familyCSP, false)
+		FatalError(t, err, "Failed to import key")
+
+		SetStrictCertHashCheck(false)
+		_, _, err = GetSignerFromCert(cert, familyCSP)
+		assert.NoError(t, err, "A hash family mismatch should only be logged by default")
+
+		SetStrictCertHashCheck(true)
+		_, _, err = GetSignerFromCert(cert, familyCSP)
+		if test.consistent {
+			assert.NoError(t, err, "A SHA2 certificate should be accepted by a %s provider", test.family)
+		} else if assert.Error(t, err, "A SHA2 certificate should be rejected by a %s provider", test.family) {
+			assert.Contains(t, err.Error(), "configured for the SHA3 hash family")
+		}
+
+		// The hash family still applies once options are set on the CSP
+		configured, err := ConfigureCSP(familyCSP, CSPOptions{})
+		FatalError(t, err, "Failed to configure CSP")
+		_, _, err = GetSignerFromCert(cert, configured)
+		assert.Equal(t, test.consistent, err == nil, "The %s hash family should be checked for a configured CSP", test.family)
+	}
+
+	// The hash family of CSPs not returned by GetBCCSP is unknown
+	_, err = ImportBCCSPKeyFromPEM(filepath.Join("testdata", "ec-key.pem"), csp, false)
+	FatalError(t, err, "Failed to import key")
+	SetStrictCertHashCheck(true)
+	_, _, err = GetSignerFromCert(cert, csp)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,253 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gmExtKeyUsages maps the OIDs of the extended key usages to their crypto/x509
+// value, for the certificates which crypto/x509 can't parse
+var gmExtKeyUsages = map[string]x509.ExtKeyUsage{
+	"2.5.29.37.0":       x509.ExtKeyUsageAny,
+	"1.3.6.1.5.5.7.3.1": x509.ExtKeyUsageServerAuth,
+	"1.3.6.1.5.5.7.3.2": x509.ExtKeyUsageClientAuth,
+	"1.3.6.1.5.5.7.3.3": x509.ExtKeyUsageCodeSigning,
+	"1.3.6.1.5.5.7.3.4": x509.ExtKeyUsageEmailProtection,
+	"1.3.6.1.5.5.7.3.8": x509.ExtKeyUsageTimeStamping,
+	"1.3.6.1.5.5.7.3.9": x509.ExtKeyUsageOCSPSigning,
+}
+
+// FormatCert returns a readable description of the certificate in the PEM
+// encoded certFile, similar to the output of 'openssl x509 -text': its
+// subject, issuer, serial number, validity, public key and extensions, among
+// which the subject alternative names and key usages. SM2 certificates, which
+// crypto/x509 can't parse, are supported.
+func FormatCert(certFile string) (string, error) {
+	pemBytes, err := ReadFile(certFile)
+	if err != nil {
+		return "", err
+	}
+	block, _, err := decodePEM(pemBytes)
+	if err != nil {
+		return "", errors.WithMessage(err, fmt.Sprintf("Failed to PEM decode certificate in '%s'", certFile))
+	}
+	if block.Type != "CERTIFICATE" {
+		return "", errors.Errorf("The PEM block in '%s' is of type '%s' instead of 'CERTIFICATE'", certFile, block.Type)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		if !isGMCertificate(block.Bytes) {
+			return "", errors.Wrapf(err, "Invalid certificate in '%s'", certFile)
+		}
+		cert, err = parseGMCertificate(block.Bytes)
+		if err != nil {
+			return "", errors.WithMessage(err, fmt.Sprintf("Invalid SM2 certificate in '%s'", certFile))
+		}
+	}
+	return formatCert(cert), nil
+}
+
+func formatCert(cert *x509.Certificate) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Certificate:\n")
+	fmt.Fprintf(&b, "    Version: %d\n", cert.Version)
+	fmt.Fprintf(&b, "    Serial Number: %s\n", GetSerialAsHex(cert.SerialNumber))
+	fmt.Fprintf(&b, "    Signature Algorithm: %s\n", signatureAlgorithmName(cert))
+	fmt.Fprintf(&b, "    Issuer: %s\n", cert.Issuer)
+	fmt.Fprintf(&b, "    Validity:\n")
+	fmt.Fprintf(&b, "        Not Before: %s\n", cert.NotBefore.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "        Not After: %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "    Subject: %s\n", cert.Subject)
+	fmt.Fprintf(&b, "    Subject Public Key Info:\n")
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if isSM2Curve(pub.Curve) {
+			fmt.Fprintf(&b, "        Public Key Algorithm: SM2\n")
+		} else {
+			fmt.Fprintf(&b, "        Public Key Algorithm: ECDSA\n")
+		}
+		fmt.Fprintf(&b, "        Curve: %s\n", pub.Curve.Params().Name)
+	case *rsa.PublicKey:
+		fmt.Fprintf(&b, "        Public Key Algorithm: RSA\n")
+		fmt.Fprintf(&b, "        Key Size: %d bits\n", pub.N.BitLen())
+	case ed25519.PublicKey:
+		fmt.Fprintf(&b, "        Public Key Algorithm: Ed25519\n")
+	default:
+		fmt.Fprintf(&b, "        Public Key Algorithm: %s\n", cert.PublicKeyAlgorithm)
+	}
+	exts := GetCertExtensions(cert)
+	if len(exts) > 0 {
+		fmt.Fprintf(&b, "    X509v3 Extensions:\n")
+	}
+	for _, ext := range exts {
+		name := ext.Name
+		if name == "" {
+			name = ext.OID
+		}
+		if ext.Critical {
+			name += " (critical)"
+		}
+		fmt.Fprintf(&b, "        %s:\n            %s\n", name, ext.Value)
+	}
+	return b.String()
+}
+
+func signatureAlgorithmName(cert *x509.Certificate) string {
+	if cert.SignatureAlgorithm != x509.UnknownSignatureAlgorithm {
+		return cert.SignatureAlgorithm.String()
+	}
+	var gmCert gmCertificate
+	if _, err := asn1.Unmarshal(cert.Raw, &gmCert); err != nil {
+		return "unknown"
+	}
+	if gmCert.SignatureAlgorithm.Algorithm.Equal(oidSignatureSM3WithSM2) {
+		return "SM3-SM2"
+	}
+	return gmCert.SignatureAlgorithm.Algorithm.String()
+}
+
+// parseGMCertificate parses the DER encoded SM2 certificate into the fields
+// of an x509.Certificate which are needed to describe it. The signature of the
+// certificate is not verified.
+func parseGMCertificate(der []byte) (*x509.Certificate, error) {
+	var gmCert gmCertificate
+	rest, err := asn1.Unmarshal(der, &gmCert)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode certificate")
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("Trailing data after the certificate")
+	}
+	tbs := &gmCert.TBSCertificate
+	cert := &x509.Certificate{
+		Raw:               der,
+		RawTBSCertificate: tbs.Raw,
+		RawIssuer:         tbs.Issuer.FullBytes,
+		RawSubject:        tbs.Subject.FullBytes,
+		Signature:         gmCert.SignatureValue.RightAlign(),
+		Version:           tbs.Version + 1,
+		SerialNumber:      tbs.SerialNumber,
+		NotBefore:         tbs.Validity.NotBefore,
+		NotAfter:          tbs.Validity.NotAfter,
+		Extensions:        tbs.Extensions,
+	}
+	cert.RawSubjectPublicKeyInfo, err = asn1.Marshal(tbs.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode public key")
+	}
+	for _, name := range []struct {
+		raw  asn1.RawValue
+		name *pkix.Name
+	}{{tbs.Issuer, &cert.Issuer}, {tbs.Subject, &cert.Subject}} {
+		var rdns pkix.RDNSequence
+		if _, err := asn1.Unmarshal(name.raw.FullBytes, &rdns); err != nil {
+			return nil, errors.Wrap(err, "Failed to decode name")
+		}
+		name.name.FillFromRDNSequence(&rdns)
+	}
+	x, y := elliptic.Unmarshal(SM2P256(), tbs.PublicKey.PublicKey.RightAlign())
+	if x != nil {
+		cert.PublicKey = &ecdsa.PublicKey{Curve: SM2P256(), X: x, Y: y}
+	}
+	for _, ext := range tbs.Extensions {
+		err = parseGMExtension(cert, ext)
+		if err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("Invalid extension %s", ext.Id))
+		}
+	}
+	return cert, nil
+}
+
+// parseGMExtension sets the fields of cert for the extensions which are
+// described by GetCertExtensions
+func parseGMExtension(cert *x509.Certificate, ext pkix.Extension) error {
+	var err error
+	switch {
+	case ext.Id.Equal(oidExtBasicConstraints):
+		var constraints gmBasicConstraints
+		_, err = asn1.Unmarshal(ext.Value, &constraints)
+		cert.BasicConstraintsValid = true
+		cert.IsCA = constraints.IsCA
+		cert.MaxPathLen = constraints.MaxPathLen
+		cert.MaxPathLenZero = constraints.MaxPathLen == 0
+	case ext.Id.Equal(oidExtKeyUsage):
+		var usage asn1.BitString
+		_, err = asn1.Unmarshal(ext.Value, &usage)
+		for i := 0; i < 9; i++ {
+			if usage.At(i) == 1 {
+				cert.KeyUsage |= 1 << uint(i)
+			}
+		}
+	case ext.Id.Equal(oidExtExtKeyUsage):
+		var oids []asn1.ObjectIdentifier
+		_, err = asn1.Unmarshal(ext.Value, &oids)
+		for _, oid := range oids {
+			if eku, ok := gmExtKeyUsages[oid.String()]; ok {
+				cert.ExtKeyUsage = append(cert.ExtKeyUsage, eku)
+			} else {
+				cert.UnknownExtKeyUsage = append(cert.UnknownExtKeyUsage, oid)
+			}
+		}
+	case ext.Id.Equal(oidExtSubjectAltName):
+		err = parseGMSubjectAltNames(cert, ext.Value)
+	case ext.Id.Equal(oidExtSubjectKeyID):
+		_, err = asn1.Unmarshal(ext.Value, &cert.SubjectKeyId)
+	case ext.Id.Equal(oidExtAuthorityKeyID):
+		var aki struct {
+			ID []byte `asn1:"optional,tag:0"`
+		}
+		_, err = asn1.Unmarshal(ext.Value, &aki)
+		cert.AuthorityKeyId = aki.ID
+	}
+	return err
+}
+
+// parseGMSubjectAltNames sets the subject alternative names of cert from the
+// value of the subject alternative name extension
+func parseGMSubjectAltNames(cert *x509.Certificate, value []byte) error {
+	var names []asn1.RawValue
+	if _, err := asn1.Unmarshal(value, &names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name.Class != asn1.ClassContextSpecific {
+			continue
+		}
+		switch name.Tag {
+		case generalNameEmail:
+			cert.EmailAddresses = append(cert.EmailAddresses, string(name.Bytes))
+		case generalNameDNS:
+			cert.DNSNames = append(cert.DNSNames, string(name.Bytes))
+		case generalNameURI:
+			uri, err := url.Parse(string(name.Bytes))
+			if err != nil {
+				return errors.Wrapf(err, "Invalid URI '%s'", name.Bytes)
+			}
+			cert.URIs = append(cert.URIs, uri)
+		case generalNameIP:
+			if len(name.Bytes) != net.IPv4len && len(name.Bytes) != net.IPv6len {
+				return errors.Errorf("Invalid IP address of %d bytes", len(name.Bytes))
+			}
+			cert.IPAddresses = append(cert.IPAddresses, append(net.IP{}, name.Bytes...))
+		}
+	}
+	return nil
+}
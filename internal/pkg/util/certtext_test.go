@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certtext")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+
+	caKey, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	key, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	sans, err := asn1.Marshal([]asn1.RawValue{
+		{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte("peer0.example.com")},
+		{Class: asn1.ClassContextSpecific, Tag: 7, Bytes: net.ParseIP("10.0.0.1").To4()},
+	})
+	FatalError(t, err, "Failed to marshal subject alternative names")
+	// digitalSignature and keyEncipherment
+	ku, err := asn1.Marshal(asn1.BitString{Bytes: []byte{0xa0}, BitLength: 3})
+	FatalError(t, err, "Failed to marshal key usage")
+	eku, err := asn1.Marshal([]asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 1}})
+	FatalError(t, err, "Failed to marshal extended key usage")
+	der := createGMCertificate(t, "gmca", "peer0", caKey, key, []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 17}, Value: sans},
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 15}, Critical: true, Value: ku},
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 37}, Value: eku},
+	})
+	certFile := filepath.Join(dir, "sm2-cert.pem")
+	FatalError(t, ioutil.WriteFile(certFile, CertificateToPEM(der, nil), 0644), "Failed to write certificate")
+
+	text, err := FormatCert(certFile)
+	FatalError(t, err, "Failed to format SM2 certificate")
+	for _, field := range []string{
+		"Serial Number: 4d2",
+		"Signature Algorithm: SM3-SM2",
+		"Issuer: CN=gmca",
+		"Subject: CN=peer0",
+		"Not Before: ",
+		"Not After: ",
+		"Public Key Algorithm: SM2",
+		"Curve: SM2-P-256",
+		"DNS:peer0.example.com, IP:10.0.0.1",
+		"Key Usage (critical):\n            Digital Signature, Key Encipherment",
+		"Server Authentication",
+	} {
+		assert.Contains(t, text, field)
+	}
+
+	text, err = FormatCert(filepath.Join("testdata", "ec.pem"))
+	FatalError(t, err, "Failed to format ECDSA certificate")
+	assert.Contains(t, text, "Public Key Algorithm: ECDSA")
+	assert.Contains(t, text, "Curve: P-256")
+	assert.Contains(t, text, "Signature Algorithm: ECDSA-SHA256")
+
+	_, err = FormatCert(filepath.Join("testdata", "ec-key.pem"))
+	assert.Error(t, err, "Formatting a key should fail")
+	_, err = FormatCert(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,345 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/pkg/errors"
+)
+
+// CheckCAPathLen returns an error if the CA certificate parent may not issue a
+// CA certificate with the path length constraint maxPathLen. A negative
+// maxPathLen, or a maxPathLen of 0 with maxPathLenZero false, means that the
+// issued CA certificate has no path length constraint.
+func CheckCAPathLen(parent *x509.Certificate, maxPathLen int, maxPathLenZero bool) error {
+	if !parent.BasicConstraintsValid || !parent.IsCA {
+		return errors.Errorf("The certificate '%s' is not a CA certificate", parent.Subject.CommonName)
+	}
+	// The parent has no path length constraint
+	if parent.MaxPathLen < 0 || (parent.MaxPathLen == 0 && !parent.MaxPathLenZero) {
+		return nil
+	}
+	if parent.MaxPathLen == 0 {
+		return errors.Errorf("The CA certificate '%s' has a path length constraint of 0 and cannot issue CA certificates",
+			parent.Subject.CommonName)
+	}
+	if maxPathLen < 0 || (maxPathLen == 0 && !maxPathLenZero) {
+		return errors.Errorf("The issued CA certificate would have no path length constraint, but the path length constraint of the CA certificate '%s' is %d",
+			parent.Subject.CommonName, parent.MaxPathLen)
+	}
+	if maxPathLen >= parent.MaxPathLen {
+		return errors.Errorf("The path length constraint %d of the issued CA certificate exceeds the maximum of %d allowed by the CA certificate '%s'",
+			maxPathLen, parent.MaxPathLen-1, parent.Subject.CommonName)
+	}
+	return nil
+}
+
+// LoadRoots returns a pool containing the PEM encoded root certificates in
+// rootFiles. If useSystemRoots is true, the roots are added to a copy of the
+// system trust store, so that standard certificates issued by public CAs can
+// be verified without configuring their roots. GM (SM2) roots are never found
+// in the system trust store and must always be provided in rootFiles.
+func LoadRoots(rootFiles []string, useSystemRoots bool) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if useSystemRoots {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			// Continue with the provided roots only
+			log.Warningf("Failed to load the system trust store: %s", err)
+		} else {
+			pool = systemPool
+		}
+	}
+	for _, rootFile := range rootFiles {
+		rootPEM, err := ioutil.ReadFile(rootFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read '%s'", rootFile)
+		}
+		if !pool.AppendCertsFromPEM(rootPEM) {
+			return nil, errors.Errorf("Failed to process certificate from file %s", rootFile)
+		}
+	}
+	return pool, nil
+}
+
+// VerifyChainLinkage verifies the authority key identifier linkage of the
+// PEM encoded certificate chain chainPEM, which is ordered from the leaf
+// certificate to the root. The AuthorityKeyId of each certificate must match
+// the SubjectKeyId of the next certificate in the chain; an error describing
+// the first break in the chain is returned otherwise.
+func VerifyChainLinkage(chainPEM []byte) error {
+	certs, err := GetX509CertificatesFromPEM(chainPEM)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return errors.New("No certificates found in the chain")
+	}
+	for i := 0; i < len(certs)-1; i++ {
+		cert, issuer := certs[i], certs[i+1]
+		if len(cert.AuthorityKeyId) == 0 {
+			return errors.Errorf("Certificate %d ('%s') of the chain has no authority key identifier",
+				i, cert.Subject.CommonName)
+		}
+		if len(issuer.SubjectKeyId) == 0 {
+			return errors.Errorf("Certificate %d ('%s') of the chain has no subject key identifier",
+				i+1, issuer.Subject.CommonName)
+		}
+		if !bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId) {
+			return errors.Errorf("The authority key identifier %s of certificate %d ('%s') does not match the subject key identifier %s of certificate %d ('%s')",
+				hex.EncodeToString(cert.AuthorityKeyId), i, cert.Subject.CommonName,
+				hex.EncodeToString(issuer.SubjectKeyId), i+1, issuer.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// BuildCABundle returns the PEM encoded CA certificates of the certificate
+// chain chainPEM, which clients can use to verify the certificates issued by
+// the chain. The leaf certificate and any other non-CA certificate is removed,
+// duplicates are dropped, and the CA certificates are ordered so that each
+// certificate precedes its issuer, with the root last.
+func BuildCABundle(chainPEM []byte) ([]byte, error) {
+	certs, err := GetX509CertificatesFromPEM(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+	var cas []*x509.Certificate
+	for _, cert := range certs {
+		if !cert.BasicConstraintsValid || !cert.IsCA {
+			continue
+		}
+		dup := false
+		for _, ca := range cas {
+			if bytes.Equal(ca.Raw, cert.Raw) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			cas = append(cas, cert)
+		}
+	}
+	if len(cas) == 0 {
+		return nil, errors.New("No CA certificates found in the chain")
+	}
+	// The height of a certificate is its distance to the top of the chain
+	issuerOf := func(cert *x509.Certificate) *x509.Certificate {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			return nil
+		}
+		for _, ca := range cas {
+			if ca != cert && bytes.Equal(cert.RawIssuer, ca.RawSubject) {
+				return ca
+			}
+		}
+		return nil
+	}
+	height := make(map[*x509.Certificate]int, len(cas))
+	for _, ca := range cas {
+		for issuer := issuerOf(ca); issuer != nil && height[ca] < len(cas); issuer = issuerOf(issuer) {
+			height[ca]++
+		}
+	}
+	sort.SliceStable(cas, func(i, j int) bool {
+		return height[cas[i]] > height[cas[j]]
+	})
+	var bundle []byte
+	for _, ca := range cas {
+		bundle = append(bundle, CertificateToPEM(ca.Raw, nil)...)
+	}
+	return bundle, nil
+}
+
+// VerifyServerCert verifies the DER encoded certificate chain rawChain
+// presented by a TLS server, leaf first, against roots. The leaf certificate
+// must be valid for server authentication and for the host dnsName; the other
+// certificates of the chain are used as intermediates.
+func VerifyServerCert(rawChain [][]byte, dnsName string, roots *x509.CertPool) error {
+	if len(rawChain) == 0 {
+		return errors.New("The server presented no certificates")
+	}
+	if roots == nil {
+		return errors.New("No root certificates were provided")
+	}
+	certs := make([]*x509.Certificate, len(rawChain))
+	for i, raw := range rawChain {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to parse certificate %d of the server chain", i)
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       dnsName,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to verify the certificate of server '%s'", dnsName)
+	}
+	return nil
+}
+
+// ChainsShareRoot returns true if the PEM encoded certificate chains chainA
+// and chainB end with the same root certificate. Each chain is walked from its
+// first certificate, through the issuers found in the chain, up to its
+// self-issued root, which must be part of the chain. The roots are compared by
+// their public key and, when both have one, by their subject key identifier.
+// SM2 certificates, which crypto/x509 can't parse, are supported; their
+// signatures are not verified.
+func ChainsShareRoot(chainA, chainB []byte) (bool, error) {
+	rootA, err := chainRoot(chainA)
+	if err != nil {
+		return false, errors.WithMessage(err, "Invalid first chain")
+	}
+	rootB, err := chainRoot(chainB)
+	if err != nil {
+		return false, errors.WithMessage(err, "Invalid second chain")
+	}
+	if len(rootA.SubjectKeyId) > 0 && len(rootB.SubjectKeyId) > 0 &&
+		!bytes.Equal(rootA.SubjectKeyId, rootB.SubjectKeyId) {
+		return false, nil
+	}
+	return bytes.Equal(rootA.RawSubjectPublicKeyInfo, rootB.RawSubjectPublicKeyInfo), nil
+}
+
+// ChainDepth returns the depth of the PEM encoded certificate chain chainPEM,
+// which is the number of CA certificates above its leaf certificate. The
+// certificates may be in any order: the leaf is the certificate which issued no
+// other certificate of the chain, and the chain is ordered by walking from the
+// leaf through the issuers found in the chain. An error is returned if the
+// chain is broken, i.e. if it has no single leaf, if a certificate above the
+// leaf is not a CA certificate or if a certificate is not on the path from the
+// leaf. The last certificate of the path need not be a self-issued root. SM2
+// certificates, which crypto/x509 can't parse, are supported; their signatures
+// are not verified.
+func ChainDepth(chainPEM []byte) (int, error) {
+	certs, err := parseChainCerts(chainPEM)
+	if err != nil {
+		return 0, err
+	}
+	var leaves []*x509.Certificate
+	for _, cert := range certs {
+		leaf := true
+		for _, other := range certs {
+			if other != cert && chainIssuer(certs, other) == cert {
+				leaf = false
+				break
+			}
+		}
+		if leaf {
+			leaves = append(leaves, cert)
+		}
+	}
+	if len(leaves) != 1 {
+		return 0, errors.Errorf("The chain is broken; it has %d leaf certificates instead of 1", len(leaves))
+	}
+	depth := 0
+	cert := leaves[0]
+	for !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		issuer := chainIssuer(certs, cert)
+		if issuer == nil {
+			break
+		}
+		if !issuer.BasicConstraintsValid || !issuer.IsCA {
+			return 0, errors.Errorf("The chain is broken; the issuer '%s' of certificate '%s' is not a CA certificate",
+				issuer.Subject.CommonName, cert.Subject.CommonName)
+		}
+		depth++
+		if depth >= len(certs) {
+			return 0, errors.New("The chain is broken; it has a loop")
+		}
+		cert = issuer
+	}
+	if depth+1 != len(certs) {
+		return 0, errors.Errorf("The chain is broken; %d of its %d certificates are not on the path from the leaf certificate '%s'",
+			len(certs)-depth-1, len(certs), leaves[0].Subject.CommonName)
+	}
+	return depth, nil
+}
+
+// chainRoot returns the self-issued root certificate reached from the first
+// certificate of the PEM encoded chain chainPEM
+func chainRoot(chainPEM []byte) (*x509.Certificate, error) {
+	certs, err := parseChainCerts(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+	cert := certs[0]
+	// Each certificate of the chain is visited at most once
+	for i := 0; i < len(certs); i++ {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			return cert, nil
+		}
+		issuer := chainIssuer(certs, cert)
+		if issuer == nil {
+			return nil, errors.Errorf("The issuer of certificate '%s' is not in the chain", cert.Subject.CommonName)
+		}
+		cert = issuer
+	}
+	return nil, errors.New("The chain has a loop and no root certificate")
+}
+
+// parseChainCerts parses the certificates of the PEM encoded chain chainPEM,
+// including SM2 certificates
+func parseChainCerts(chainPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := chainPEM; ; {
+		block, remaining, err := decodePEM(rest)
+		if err != nil {
+			// Only text without PEM blocks may follow the certificates
+			if len(certs) > 0 && !bytes.Contains(rest, []byte("-----BEGIN ")) {
+				return certs, nil
+			}
+			return nil, errors.WithMessage(err, fmt.Sprintf("Failed to decode certificate %d of the chain", len(certs)))
+		}
+		rest = remaining
+		if block.Type != certPemType {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			if !isGMCertificate(block.Bytes) {
+				return nil, errors.Wrapf(err, "Failed to parse certificate %d of the chain", len(certs))
+			}
+			cert, err = parseGMCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.WithMessage(err, fmt.Sprintf("Failed to parse SM2 certificate %d of the chain", len(certs)))
+			}
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// chainIssuer returns the certificate of certs which issued cert, other than
+// cert itself, or nil if there is none. The issuer is matched by name and, when
+// both certificates have one, by key identifier.
+func chainIssuer(certs []*x509.Certificate, cert *x509.Certificate) *x509.Certificate {
+	for _, issuer := range certs {
+		if issuer == cert || !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		if len(cert.AuthorityKeyId) == 0 || len(issuer.SubjectKeyId) == 0 ||
+			bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId) {
+			return issuer
+		}
+	}
+	return nil
+}
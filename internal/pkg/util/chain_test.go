@@ -0,0 +1,327 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+type testChainCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestChainCert creates a certificate named name, signed by parent or
+// self-signed if parent is nil
+func newTestChainCert(t *testing.T, name string, isCA bool, parent *testChainCert) *testChainCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		SubjectKeyId:          []byte(name),
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	FatalError(t, err, "Failed to create certificate")
+	cert, err := x509.ParseCertificate(der)
+	FatalError(t, err, "Failed to parse certificate")
+	return &testChainCert{cert: cert, key: key, pem: CertificateToPEM(der, nil)}
+}
+
+func chainPEM(certs ...*testChainCert) []byte {
+	var chain []byte
+	for _, c := range certs {
+		chain = append(chain, c.pem...)
+	}
+	return chain
+}
+
+func TestVerifyChainLinkage(t *testing.T) {
+	root := newTestChainCert(t, "root", true, nil)
+	ica := newTestChainCert(t, "ica", true, root)
+	leaf := newTestChainCert(t, "leaf", false, ica)
+	otherICA := newTestChainCert(t, "otherica", true, root)
+
+	assert.NoError(t, VerifyChainLinkage(chainPEM(leaf, ica, root)))
+	assert.NoError(t, VerifyChainLinkage(chainPEM(leaf, ica)))
+	assert.NoError(t, VerifyChainLinkage(chainPEM(root)))
+
+	err := VerifyChainLinkage(chainPEM(leaf, otherICA, root))
+	if assert.Error(t, err, "Chain with a mismatched AKI should fail") {
+		assert.Contains(t, err.Error(), "certificate 0 ('leaf')")
+		assert.Contains(t, err.Error(), "certificate 1 ('otherica')")
+	}
+
+	err = VerifyChainLinkage(chainPEM(leaf, root))
+	assert.Error(t, err, "Chain with a missing intermediate should fail")
+
+	err = VerifyChainLinkage([]byte("not a chain"))
+	assert.Error(t, err, "Empty chain should fail")
+}
+
+func TestLoadRoots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "roots")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	root := newTestChainCert(t, "customroot", true, nil)
+	leaf := newTestChainCert(t, "leaf", false, root)
+	rootFile := filepath.Join(dir, "root.pem")
+	FatalError(t, ioutil.WriteFile(rootFile, root.pem, 0644), "Failed to write root certificate")
+
+	systemPool, err := x509.SystemCertPool()
+	FatalError(t, err, "Failed to load system trust store")
+
+	for _, useSystemRoots := range []bool{false, true} {
+		pool, err := LoadRoots([]string{rootFile}, useSystemRoots)
+		FatalError(t, err, "Failed to load roots")
+		_, err = leaf.cert.Verify(x509.VerifyOptions{Roots: pool})
+		assert.NoError(t, err, "Leaf should verify against the custom root")
+		if useSystemRoots {
+			assert.Len(t, pool.Subjects(), len(systemPool.Subjects())+1)
+		} else {
+			assert.Len(t, pool.Subjects(), 1)
+		}
+	}
+	// The system trust store is not modified
+	newSystemPool, err := x509.SystemCertPool()
+	FatalError(t, err, "Failed to load system trust store")
+	assert.Len(t, newSystemPool.Subjects(), len(systemPool.Subjects()))
+
+	_, err = LoadRoots([]string{filepath.Join(dir, "missing.pem")}, false)
+	assert.Error(t, err, "Loading a missing root file should fail")
+	badFile := filepath.Join(dir, "bad.pem")
+	FatalError(t, ioutil.WriteFile(badFile, []byte("garbage"), 0644), "Failed to write file")
+	_, err = LoadRoots([]string{badFile}, true)
+	assert.Error(t, err, "Loading an invalid root file should fail")
+}
+
+func TestCheckCAPathLen(t *testing.T) {
+	newCA := func(maxPathLen int, maxPathLenZero bool) *x509.Certificate {
+		// Only the basic constraints are checked, so the certificate need not be signed
+		return &x509.Certificate{
+			Subject:               pkix.Name{CommonName: "ca"},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			MaxPathLen:            maxPathLen,
+			MaxPathLenZero:        maxPathLenZero,
+		}
+	}
+
+	// A CA without a path length constraint can issue any CA certificate
+	unlimited := newCA(-1, false)
+	assert.NoError(t, CheckCAPathLen(unlimited, -1, false))
+	assert.NoError(t, CheckCAPathLen(unlimited, 5, false))
+	assert.NoError(t, CheckCAPathLen(newCA(0, false), 0, true))
+
+	// A CA with path length 0 cannot issue CA certificates
+	err := CheckCAPathLen(newCA(0, true), 0, true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "path length constraint of 0")
+	}
+
+	pathLen2 := newCA(2, false)
+	assert.NoError(t, CheckCAPathLen(pathLen2, 1, false))
+	assert.NoError(t, CheckCAPathLen(pathLen2, 0, true))
+	assert.Error(t, CheckCAPathLen(pathLen2, 2, false), "Path length equal to the parent's should be rejected")
+	assert.Error(t, CheckCAPathLen(pathLen2, 3, false), "Path length greater than the parent's should be rejected")
+	assert.Error(t, CheckCAPathLen(pathLen2, -1, false), "Unconstrained path length should be rejected")
+	assert.Error(t, CheckCAPathLen(pathLen2, 0, false), "Unconstrained path length should be rejected")
+
+	assert.Error(t, CheckCAPathLen(&x509.Certificate{}, 0, true), "Non-CA certificate should be rejected")
+}
+
+func TestBuildCABundle(t *testing.T) {
+	root := newTestChainCert(t, "root", true, nil)
+	ica := newTestChainCert(t, "ica", true, root)
+	leaf := newTestChainCert(t, "leaf", false, ica)
+
+	for _, chain := range [][]byte{
+		chainPEM(leaf, ica, root),
+		chainPEM(leaf, root, ica),
+		chainPEM(leaf, ica, root, ica, root),
+	} {
+		bundle, err := BuildCABundle(chain)
+		FatalError(t, err, "Failed to build CA bundle")
+		certs, err := GetX509CertificatesFromPEM(bundle)
+		FatalError(t, err, "Failed to parse CA bundle")
+		if assert.Len(t, certs, 2, "The bundle should hold the intermediate and the root") {
+			assert.Equal(t, ica.cert.Raw, certs[0].Raw)
+			assert.Equal(t, root.cert.Raw, certs[1].Raw)
+		}
+		for _, cert := range certs {
+			assert.NotEqual(t, leaf.cert.Raw, cert.Raw, "The leaf should be excluded from the bundle")
+		}
+	}
+
+	_, err := BuildCABundle(chainPEM(leaf))
+	assert.Error(t, err, "A chain without CA certificates should fail")
+	_, err = BuildCABundle([]byte("not a chain"))
+	assert.Error(t, err)
+}
+
+func TestVerifyServerCert(t *testing.T) {
+	root := newTestChainCert(t, "root", true, nil)
+	ica := newTestChainCert(t, "ica", true, root)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"server.example.com"},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, tmpl, ica.cert, &key.PublicKey, ica.key)
+	FatalError(t, err, "Failed to create server certificate")
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	chain := [][]byte{leaf, ica.cert.Raw}
+
+	assert.NoError(t, VerifyServerCert(chain, "server.example.com", roots))
+
+	err = VerifyServerCert(chain, "other.example.com", roots)
+	if assert.Error(t, err, "Verification should fail on a hostname mismatch") {
+		assert.Contains(t, err.Error(), "other.example.com")
+	}
+	assert.Error(t, VerifyServerCert([][]byte{leaf}, "server.example.com", roots), "Verification should fail without the intermediate")
+	assert.Error(t, VerifyServerCert(chain, "server.example.com", x509.NewCertPool()), "Verification should fail against other roots")
+	assert.Error(t, VerifyServerCert([][]byte{[]byte("garbage")}, "server.example.com", roots))
+	assert.Error(t, VerifyServerCert(nil, "server.example.com", roots))
+	assert.Error(t, VerifyServerCert(chain, "server.example.com", nil))
+}
+
+func TestChainsShareRoot(t *testing.T) {
+	root := newTestChainCert(t, "root", true, nil)
+	ica := newTestChainCert(t, "ica", true, root)
+	leaf1 := newTestChainCert(t, "leaf1", false, ica)
+	leaf2 := newTestChainCert(t, "leaf2", false, root)
+	otherRoot := newTestChainCert(t, "otherroot", true, nil)
+	otherLeaf := newTestChainCert(t, "otherleaf", false, otherRoot)
+
+	shared, err := ChainsShareRoot(chainPEM(leaf1, ica, root), chainPEM(leaf2, root))
+	assert.NoError(t, err)
+	assert.True(t, shared, "Chains under the same root should share it")
+	shared, err = ChainsShareRoot(chainPEM(leaf1, root, ica), chainPEM(root))
+	assert.NoError(t, err)
+	assert.True(t, shared, "The order of the chain shouldn't matter")
+	shared, err = ChainsShareRoot(chainPEM(leaf1, ica, root), chainPEM(otherLeaf, otherRoot))
+	assert.NoError(t, err)
+	assert.False(t, shared, "Chains under different roots should not share a root")
+
+	_, err = ChainsShareRoot(chainPEM(leaf1, ica), chainPEM(leaf2, root))
+	assert.Error(t, err, "A chain without its root should fail")
+	_, err = ChainsShareRoot(chainPEM(leaf2, root), []byte("not a chain"))
+	assert.Error(t, err)
+
+	// SM2 roots with the same name are told apart by their public key
+	rootKey, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	otherRootKey, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	key, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	gmPEM := func(issuer, subject string, issuerKey, key *ecdsa.PrivateKey) []byte {
+		return CertificateToPEM(createGMCertificate(t, issuer, subject, issuerKey, key, nil), nil)
+	}
+	gmRoot := gmPEM("gmroot", "gmroot", rootKey, rootKey)
+	gmChainA := append(gmPEM("gmroot", "gmleaf1", rootKey, key), gmRoot...)
+	gmChainB := append(gmPEM("gmroot", "gmleaf2", rootKey, key), gmRoot...)
+	gmChainC := append(gmPEM("gmroot", "gmleaf3", otherRootKey, key), gmPEM("gmroot", "gmroot", otherRootKey, otherRootKey)...)
+	shared, err = ChainsShareRoot(gmChainA, gmChainB)
+	assert.NoError(t, err)
+	assert.True(t, shared, "SM2 chains under the same root should share it")
+	shared, err = ChainsShareRoot(gmChainA, gmChainC)
+	assert.NoError(t, err)
+	assert.False(t, shared, "SM2 chains under different roots should not share a root")
+	shared, err = ChainsShareRoot(gmChainA, chainPEM(leaf2, root))
+	assert.NoError(t, err)
+	assert.False(t, shared)
+}
+
+func TestChainDepth(t *testing.T) {
+	root := newTestChainCert(t, "root", true, nil)
+	ica1 := newTestChainCert(t, "ica1", true, root)
+	ica2 := newTestChainCert(t, "ica2", true, ica1)
+	leaf1 := newTestChainCert(t, "leaf1", false, root)
+	leaf3 := newTestChainCert(t, "leaf3", false, ica2)
+	nonCA := newTestChainCert(t, "nonca", false, root)
+	leafOfNonCA := newTestChainCert(t, "leafofnonca", false, nonCA)
+
+	tests := []struct {
+		chain []byte
+		depth int
+	}{
+		{chainPEM(leaf1, root), 1},
+		{chainPEM(leaf3, ica2, ica1, root), 3},
+		{chainPEM(root, ica1, leaf3, ica2), 3},
+		{chainPEM(leaf3, ica2), 1},
+		{chainPEM(root), 0},
+	}
+	for i, test := range tests {
+		depth, err := ChainDepth(test.chain)
+		if assert.NoError(t, err, "Test %d failed", i) {
+			assert.Equal(t, test.depth, depth, "Test %d failed", i)
+		}
+	}
+
+	for name, chain := range map[string][]byte{
+		"missing intermediate": chainPEM(leaf3, ica1, root),
+		"two leaves":           chainPEM(leaf1, leaf3, ica2, ica1, root),
+		"non-CA issuer":        chainPEM(leafOfNonCA, nonCA, root),
+		"not a chain":          []byte("not a chain"),
+	} {
+		_, err := ChainDepth(chain)
+		assert.Error(t, err, "A chain with a %s should fail", name)
+	}
+
+	// SM2 chain
+	rootKey, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	key, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	bc, err := asn1.Marshal(struct {
+		IsCA bool `asn1:"optional"`
+	}{IsCA: true})
+	FatalError(t, err, "Failed to marshal basic constraints")
+	gmRoot := createGMCertificate(t, "gmroot", "gmroot", rootKey, rootKey,
+		[]pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, Value: bc}})
+	gmLeaf := createGMCertificate(t, "gmroot", "gmleaf", rootKey, key, nil)
+	depth, err := ChainDepth(append(CertificateToPEM(gmLeaf, nil), CertificateToPEM(gmRoot, nil)...))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, depth)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time to the certificate issuance functions
+type Clock interface {
+	Now() time.Time
+}
+
+// currentClock is the Clock which reads the time of the Clock set by SetClock
+type currentClock struct{}
+
+func (currentClock) Now() time.Time {
+	return Now()
+}
+
+// wallClock is the Clock which returns the system time
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+var clock = struct {
+	sync.RWMutex
+	c Clock
+}{c: wallClock{}}
+
+// SetClock sets the Clock from which the certificate issuance functions read
+// the current time, so that tests can freeze time. A nil Clock restores the
+// system time.
+func SetClock(c Clock) {
+	clock.Lock()
+	defer clock.Unlock()
+	if c == nil {
+		c = wallClock{}
+	}
+	clock.c = c
+}
+
+// GetClock returns a Clock which reads the time of the Clock set by SetClock,
+// so that components taking a Clock follow a clock set later on
+func GetClock() Clock {
+	return currentClock{}
+}
+
+// Now returns the current time of the Clock set by SetClock
+func Now() time.Time {
+	clock.RLock()
+	defer clock.RUnlock()
+	return clock.c.Now()
+}
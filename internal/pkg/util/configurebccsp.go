@@ -18,6 +18,57 @@ import (
 	"github.com/pkg/errors"
 )
 
+// supportedBCCSPProviders are the BCCSP providers available in this build
+var supportedBCCSPProviders = []string{"SW", "PKCS11", "PLUGIN"}
+
+// bccspHashFamily returns the hash family configured for the BCCSP provider
+// in opts, or an empty string if none is configured
+func bccspHashFamily(opts *factory.FactoryOpts) string {
+	switch strings.ToUpper(opts.ProviderName) {
+	case "SW":
+		if opts.SwOpts != nil {
+			return opts.SwOpts.HashFamily
+		}
+	case "PKCS11":
+		if opts.Pkcs11Opts != nil {
+			return opts.Pkcs11Opts.HashFamily
+		}
+	}
+	return ""
+}
+
+// bccspSecLevel returns the security level configured for the BCCSP provider
+// in opts, or 0 if none is configured
+func bccspSecLevel(opts *factory.FactoryOpts) int {
+	switch strings.ToUpper(opts.ProviderName) {
+	case "SW":
+		if opts.SwOpts != nil {
+			return opts.SwOpts.SecLevel
+		}
+	case "PKCS11":
+		if opts.Pkcs11Opts != nil {
+			return opts.Pkcs11Opts.SecLevel
+		}
+	}
+	return 0
+}
+
+// bccspKeystorePath returns the path of the file keystore configured for the
+// BCCSP provider in opts, or an empty string if none is configured
+func bccspKeystorePath(opts *factory.FactoryOpts) string {
+	switch strings.ToUpper(opts.ProviderName) {
+	case "SW":
+		if opts.SwOpts != nil && opts.SwOpts.FileKeystore != nil {
+			return opts.SwOpts.FileKeystore.KeyStorePath
+		}
+	case "PKCS11":
+		if opts.Pkcs11Opts != nil && opts.Pkcs11Opts.FileKeystore != nil {
+			return opts.Pkcs11Opts.FileKeystore.KeyStorePath
+		}
+	}
+	return ""
+}
+
 // ConfigureBCCSP configures BCCSP, using
 func ConfigureBCCSP(optsPtr **factory.FactoryOpts, mspDir, homeDir string) error {
 	var err error
@@ -17,6 +17,36 @@ import (
 	"github.com/pkg/errors"
 )
 
+// supportedBCCSPProviders are the BCCSP providers available in this build
+var supportedBCCSPProviders = []string{"SW", "PLUGIN"}
+
+// bccspHashFamily returns the hash family configured for the BCCSP provider
+// in opts, or an empty string if none is configured
+func bccspHashFamily(opts *factory.FactoryOpts) string {
+	if strings.ToUpper(opts.ProviderName) == "SW" && opts.SwOpts != nil {
+		return opts.SwOpts.HashFamily
+	}
+	return ""
+}
+
+// bccspSecLevel returns the security level configured for the BCCSP provider
+// in opts, or 0 if none is configured
+func bccspSecLevel(opts *factory.FactoryOpts) int {
+	if strings.ToUpper(opts.ProviderName) == "SW" && opts.SwOpts != nil {
+		return opts.SwOpts.SecLevel
+	}
+	return 0
+}
+
+// bccspKeystorePath returns the path of the file keystore configured for the
+// BCCSP provider in opts, or an empty string if none is configured
+func bccspKeystorePath(opts *factory.FactoryOpts) string {
+	if strings.ToUpper(opts.ProviderName) == "SW" && opts.SwOpts != nil && opts.SwOpts.FileKeystore != nil {
+		return opts.SwOpts.FileKeystore.KeyStorePath
+	}
+	return ""
+}
+
 // ConfigureBCCSP configures BCCSP, using
 func ConfigureBCCSP(optsPtr **factory.FactoryOpts, mspDir, homeDir string) error {
 	var err error
@@ -0,0 +1,330 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// reasonCodeOID is the OID of the reason code CRL entry extension
+// (RFC 5280, 5.3.1)
+var reasonCodeOID = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// ParseCRLEntryReason returns the revocation reason code of the certificate
+// with the specified serial number in the PEM or DER encoded CRL. The reason
+// code is 0 (unspecified) if the CRL entry has no reason code extension. An
+// error is returned if the serial number is not revoked by the CRL.
+func ParseCRLEntryReason(crlPEM []byte, serial *big.Int) (int, error) {
+	if serial == nil {
+		return 0, errors.New("Serial number must be different from nil")
+	}
+	crl, err := x509.ParseCRL(crlPEM)
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to parse CRL")
+	}
+	for _, entry := range crl.TBSCertList.RevokedCertificates {
+		if entry.SerialNumber == nil || entry.SerialNumber.Cmp(serial) != 0 {
+			continue
+		}
+		for _, ext := range entry.Extensions {
+			if !ext.Id.Equal(reasonCodeOID) {
+				continue
+			}
+			var reason asn1.Enumerated
+			rest, err := asn1.Unmarshal(ext.Value, &reason)
+			if err != nil {
+				return 0, errors.Wrapf(err, "Invalid reason code of the CRL entry for serial number %s", GetSerialAsHex(serial))
+			}
+			if len(rest) != 0 {
+				return 0, errors.Errorf("Trailing data after the reason code of the CRL entry for serial number %s", GetSerialAsHex(serial))
+			}
+			return int(reason), nil
+		}
+		return 0, nil
+	}
+	return 0, errors.Errorf("Serial number %s is not revoked by the CRL", GetSerialAsHex(serial))
+}
+
+// oidExtCRLNumber is the OID of the CRL number extension (RFC 5280, 5.2.3)
+var oidExtCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+
+// CRLNumberSource is a source of CRL numbers
+type CRLNumberSource interface {
+	// Next returns the CRL number to use for the next generated CRL
+	Next() (*big.Int, error)
+}
+
+// FileCRLNumberSource returns monotonically increasing CRL numbers. The last
+// CRL number returned is persisted in a file before it is returned, so that
+// CRL numbers never decrease across restarts. The file must not be shared by
+// multiple sources.
+type FileCRLNumberSource struct {
+	mutex sync.Mutex
+	file  string
+	last  *big.Int
+}
+
+// NewFileCRLNumberSource returns a new CRL number source which persists the
+// last CRL number in file. If file exists, it must contain the decimal CRL
+// number last returned; otherwise the first CRL number returned is 1.
+func NewFileCRLNumberSource(file string) (*FileCRLNumberSource, error) {
+	last := big.NewInt(0)
+	buf, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "Failed to read CRL number file '%s'", file)
+	}
+	if err == nil {
+		str := strings.TrimSpace(string(buf))
+		if _, ok := last.SetString(str, 10); !ok || last.Sign() < 0 {
+			return nil, errors.Errorf("Invalid CRL number '%s' in file '%s'", str, file)
+		}
+	}
+	return &FileCRLNumberSource{file: file, last: last}, nil
+}
+
+// Next returns the CRL number following the last CRL number returned
+func (fs *FileCRLNumberSource) Next() (*big.Int, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	next := new(big.Int).Add(fs.last, big.NewInt(1))
+	err := WriteFile(fs.file, []byte(next.String()+"\n"), 0644)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to store CRL number")
+	}
+	fs.last = next
+	return new(big.Int).Set(next), nil
+}
+
+// crlSignatureAlgorithms are the algorithm identifiers of the signature
+// algorithms which StreamCRL can sign with
+var crlSignatureAlgorithms = map[x509.SignatureAlgorithm]pkix.AlgorithmIdentifier{
+	x509.ECDSAWithSHA256: {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+	x509.ECDSAWithSHA384: {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}},
+	x509.ECDSAWithSHA512: {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}},
+	x509.SHA256WithRSA:   {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+	x509.SHA384WithRSA:   {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, Parameters: asn1.NullRawValue},
+	x509.SHA512WithRSA:   {Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, Parameters: asn1.NullRawValue},
+}
+
+// crlEntrySizeHint is the approximate size of a DER encoded CRL entry
+const crlEntrySizeHint = 48
+
+// StreamCRL writes to w the PEM encoded CRL, signed with signer on behalf of
+// the CA certificate issuer, listing the revoked certificates received from
+// entries until the channel is closed. Only the DER encoding of the entries is
+// kept in memory, in a buffer preallocated for sizeHint entries, and the CRL is
+// signed once all entries have been received, so peak memory is bounded by the
+// size of the encoded CRL rather than by the parsed entries.
+func StreamCRL(w io.Writer, entries <-chan pkix.RevokedCertificate, sizeHint int, signer crypto.Signer,
+	issuer *x509.Certificate, thisUpdate, nextUpdate time.Time) error {
+	return streamCRL(w, entries, sizeHint, signer, issuer, thisUpdate, nextUpdate, nil)
+}
+
+// GenerateCRL returns the PEM encoded CRL, signed with signer on behalf of the
+// CA certificate issuer, listing the revoked certificates. The CRL has a CRL
+// number extension with the next number of numbers.
+func GenerateCRL(revoked []pkix.RevokedCertificate, signer crypto.Signer, issuer *x509.Certificate,
+	thisUpdate, nextUpdate time.Time, numbers CRLNumberSource) ([]byte, error) {
+	if numbers == nil {
+		return nil, errors.New("The CRL number source must be different from nil")
+	}
+	number, err := numbers.Next()
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to get the CRL number")
+	}
+	entries := make(chan pkix.RevokedCertificate, len(revoked))
+	for _, entry := range revoked {
+		entries <- entry
+	}
+	close(entries)
+	var buf bytes.Buffer
+	err = streamCRL(&buf, entries, len(revoked), signer, issuer, thisUpdate, nextUpdate, number)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// streamCRL implements StreamCRL; the CRL has a CRL number extension if
+// number is different from nil
+func streamCRL(w io.Writer, entries <-chan pkix.RevokedCertificate, sizeHint int, signer crypto.Signer,
+	issuer *x509.Certificate, thisUpdate, nextUpdate time.Time, number *big.Int) error {
+	if signer == nil || issuer == nil {
+		return errors.New("The CRL signer and issuer certificate must be different from nil")
+	}
+	hash := crypto.SHA256
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		var err error
+		if hash, err = minECDSAHash(pub.Curve); err != nil {
+			return err
+		}
+	}
+	sigAlg, err := SignatureAlgorithmForHash(signer.Public(), hash)
+	if err != nil {
+		return err
+	}
+	algID, err := asn1.Marshal(crlSignatureAlgorithms[sigAlg])
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode the CRL signature algorithm")
+	}
+
+	var revoked bytes.Buffer
+	if sizeHint > 0 {
+		revoked.Grow(sizeHint * crlEntrySizeHint)
+	}
+	for entry := range entries {
+		if entry.SerialNumber == nil {
+			return errors.New("CRL entry without a serial number")
+		}
+		der, err := asn1.Marshal(entry)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to encode the CRL entry for serial number %s", GetSerialAsHex(entry.SerialNumber))
+		}
+		revoked.Write(der)
+	}
+
+	// The TBSCertList is hashed in pieces so the entries are never copied
+	var pieces [][]byte
+	for _, v := range []interface{}{1, asn1.RawValue{FullBytes: algID}, asn1.RawValue{FullBytes: issuer.RawSubject},
+		thisUpdate.UTC(), nextUpdate.UTC()} {
+		der, err := asn1.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "Failed to encode the CRL")
+		}
+		pieces = append(pieces, der)
+	}
+	if revoked.Len() > 0 {
+		pieces = append(pieces, derHeader(0x30, revoked.Len()), revoked.Bytes())
+	}
+	var crlExts []pkix.Extension
+	if len(issuer.SubjectKeyId) > 0 {
+		aki, err := asn1.Marshal(struct {
+			ID []byte `asn1:"optional,tag:0"`
+		}{issuer.SubjectKeyId})
+		if err != nil {
+			return errors.Wrap(err, "Failed to encode the CRL authority key identifier")
+		}
+		crlExts = append(crlExts, pkix.Extension{Id: oidExtAuthorityKeyID, Value: aki})
+	}
+	if number != nil {
+		num, err := asn1.Marshal(number)
+		if err != nil {
+			return errors.Wrap(err, "Failed to encode the CRL number")
+		}
+		crlExts = append(crlExts, pkix.Extension{Id: oidExtCRLNumber, Value: num})
+	}
+	if len(crlExts) > 0 {
+		exts, err := asn1.Marshal(crlExts)
+		if err != nil {
+			return errors.Wrap(err, "Failed to encode the CRL extensions")
+		}
+		pieces = append(pieces, derHeader(0xa0, len(exts)), exts)
+	}
+	tbsLen := 0
+	for _, piece := range pieces {
+		tbsLen += len(piece)
+	}
+	pieces = append([][]byte{derHeader(0x30, tbsLen)}, pieces...)
+
+	h := hash.New()
+	for _, piece := range pieces {
+		h.Write(piece)
+	}
+	sig, err := signer.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return errors.Wrap(err, "Failed to sign the CRL")
+	}
+	sigBits, err := asn1.Marshal(asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)})
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode the CRL signature")
+	}
+	crlLen := len(pieces[0]) + tbsLen + len(algID) + len(sigBits)
+	pieces = append([][]byte{derHeader(0x30, crlLen)}, pieces...)
+	pieces = append(pieces, algID, sigBits)
+
+	if _, err = io.WriteString(w, "-----BEGIN X509 CRL-----\n"); err != nil {
+		return errors.Wrap(err, "Failed to write the CRL")
+	}
+	lw := &pemLineWriter{w: w}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+	for _, piece := range pieces {
+		if _, err = enc.Write(piece); err != nil {
+			return errors.Wrap(err, "Failed to write the CRL")
+		}
+	}
+	if err = enc.Close(); err != nil {
+		return errors.Wrap(err, "Failed to write the CRL")
+	}
+	if lw.used > 0 {
+		if _, err = io.WriteString(w, "\n"); err != nil {
+			return errors.Wrap(err, "Failed to write the CRL")
+		}
+	}
+	if _, err = io.WriteString(w, "-----END X509 CRL-----\n"); err != nil {
+		return errors.Wrap(err, "Failed to write the CRL")
+	}
+	return nil
+}
+
+// derHeader returns the DER identifier and length octets of an element with
+// the specified tag and content length
+func derHeader(tag byte, length int) []byte {
+	if length < 0x80 {
+		return []byte{tag, byte(length)}
+	}
+	var lenBytes []byte
+	for l := length; l > 0; l >>= 8 {
+		lenBytes = append([]byte{byte(l)}, lenBytes...)
+	}
+	return append([]byte{tag, 0x80 | byte(len(lenBytes))}, lenBytes...)
+}
+
+// pemLineWriter breaks the base64 data written to it into lines of 64
+// characters, as in PEM
+type pemLineWriter struct {
+	w    io.Writer
+	used int
+}
+
+func (l *pemLineWriter) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		chunk := 64 - l.used
+		if chunk > len(b) {
+			chunk = len(b)
+		}
+		if _, err := l.w.Write(b[:chunk]); err != nil {
+			return n, err
+		}
+		n += chunk
+		l.used += chunk
+		b = b[chunk:]
+		if l.used == 64 {
+			if _, err := l.w.Write([]byte{'\n'}); err != nil {
+				return n, err
+			}
+			l.used = 0
+		}
+	}
+	return n, nil
+}
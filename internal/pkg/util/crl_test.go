@@ -0,0 +1,231 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCRLEntryReason(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "crl"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	FatalError(t, err, "Failed to create CA certificate")
+	caCert, err := x509.ParseCertificate(der)
+	FatalError(t, err, "Failed to parse CA certificate")
+
+	reason, err := asn1.Marshal(asn1.Enumerated(RevocationReasonCodes["keycompromise"]))
+	FatalError(t, err, "Failed to marshal reason code")
+	revoked := []pkix.RevokedCertificate{
+		{
+			SerialNumber:   big.NewInt(10),
+			RevocationTime: time.Now(),
+			Extensions:     []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 21}, Value: reason}},
+		},
+		{
+			SerialNumber:   big.NewInt(11),
+			RevocationTime: time.Now(),
+		},
+	}
+	crlDER, err := caCert.CreateCRL(rand.Reader, priv, revoked, time.Now(), time.Now().Add(time.Hour))
+	FatalError(t, err, "Failed to create CRL")
+	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER})
+
+	code, err := ParseCRLEntryReason(crlPEM, big.NewInt(10))
+	assert.NoError(t, err)
+	assert.Equal(t, RevocationReasonCodes["keycompromise"], code)
+
+	// Without a reason code extension, the reason is unspecified
+	code, err = ParseCRLEntryReason(crlDER, big.NewInt(11))
+	assert.NoError(t, err)
+	assert.Equal(t, RevocationReasonCodes["unspecified"], code)
+
+	_, err = ParseCRLEntryReason(crlPEM, big.NewInt(12))
+	if assert.Error(t, err, "Parsing the reason of a serial number which is not revoked should fail") {
+		assert.Contains(t, err.Error(), "is not revoked")
+	}
+	_, err = ParseCRLEntryReason([]byte("not a CRL"), big.NewInt(10))
+	assert.Error(t, err, "Parsing an invalid CRL should fail")
+	_, err = ParseCRLEntryReason(crlPEM, nil)
+	assert.Error(t, err, "Parsing the reason of a nil serial number should fail")
+}
+
+func newTestCRLIssuer(t testing.TB) (*x509.Certificate, *ecdsa.PrivateKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ECDSA key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "crl"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %s", err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %s", err)
+	}
+	return caCert, priv
+}
+
+func streamTestCRL(caCert *x509.Certificate, priv *ecdsa.PrivateKey, n int) ([]byte, error) {
+	entries := make(chan pkix.RevokedCertificate)
+	go func() {
+		defer close(entries)
+		revokedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+		for i := 0; i < n; i++ {
+			entries <- pkix.RevokedCertificate{SerialNumber: big.NewInt(int64(1000 + i)), RevocationTime: revokedAt}
+		}
+	}()
+	var buf bytes.Buffer
+	err := StreamCRL(&buf, entries, n, priv, caCert, time.Now(), time.Now().Add(time.Hour))
+	return buf.Bytes(), err
+}
+
+func TestStreamCRL(t *testing.T) {
+	caCert, priv := newTestCRLIssuer(t)
+
+	for _, n := range []int{0, 1, 100000} {
+		crlPEM, err := streamTestCRL(caCert, priv, n)
+		FatalError(t, err, "Failed to stream CRL")
+		block, rest := pem.Decode(crlPEM)
+		if !assert.NotNil(t, block, "The CRL should be PEM encoded") {
+			continue
+		}
+		assert.Equal(t, "X509 CRL", block.Type)
+		assert.Empty(t, rest)
+		crl, err := x509.ParseCRL(crlPEM)
+		FatalError(t, err, "Failed to parse streamed CRL")
+		assert.NoError(t, caCert.CheckCRLSignature(crl), "The CRL signature should be valid")
+		assert.Equal(t, caCert.Subject.String(), crl.TBSCertList.Issuer.String())
+		revoked := crl.TBSCertList.RevokedCertificates
+		if assert.Len(t, revoked, n) && n > 0 {
+			assert.Equal(t, int64(1000), revoked[0].SerialNumber.Int64())
+			assert.Equal(t, int64(1000+n-1), revoked[n-1].SerialNumber.Int64())
+		}
+		if assert.Len(t, crl.TBSCertList.Extensions, 1) {
+			assert.True(t, crl.TBSCertList.Extensions[0].Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 35}))
+		}
+	}
+
+	entries := make(chan pkix.RevokedCertificate, 1)
+	entries <- pkix.RevokedCertificate{RevocationTime: time.Now()}
+	close(entries)
+	err := StreamCRL(&bytes.Buffer{}, entries, 0, priv, caCert, time.Now(), time.Now().Add(time.Hour))
+	assert.Error(t, err, "An entry without serial number should fail")
+	err = StreamCRL(&bytes.Buffer{}, nil, 0, nil, caCert, time.Now(), time.Now().Add(time.Hour))
+	assert.Error(t, err, "Streaming a CRL without signer should fail")
+}
+
+func TestGenerateCRL(t *testing.T) {
+	caCert, priv := newTestCRLIssuer(t)
+	dir, err := ioutil.TempDir("", "crlnumber")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	numberFile := filepath.Join(dir, "crlnumber")
+	numbers, err := NewFileCRLNumberSource(numberFile)
+	FatalError(t, err, "Failed to create CRL number source")
+
+	crlNumber := func(crlPEM []byte) *big.Int {
+		crl, err := x509.ParseCRL(crlPEM)
+		FatalError(t, err, "Failed to parse CRL")
+		assert.NoError(t, caCert.CheckCRLSignature(crl), "The CRL signature should be valid")
+		for _, ext := range crl.TBSCertList.Extensions {
+			if ext.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 20}) {
+				number := new(big.Int)
+				_, err = asn1.Unmarshal(ext.Value, &number)
+				FatalError(t, err, "Failed to unmarshal CRL number")
+				return number
+			}
+		}
+		t.Fatal("The CRL should have a CRL number")
+		return nil
+	}
+	revoked := []pkix.RevokedCertificate{{SerialNumber: big.NewInt(10), RevocationTime: time.Now()}}
+	crl1, err := GenerateCRL(revoked, priv, caCert, time.Now(), time.Now().Add(time.Hour), numbers)
+	FatalError(t, err, "Failed to generate CRL")
+	crl2, err := GenerateCRL(nil, priv, caCert, time.Now(), time.Now().Add(time.Hour), numbers)
+	FatalError(t, err, "Failed to generate CRL")
+	number1, number2 := crlNumber(crl1), crlNumber(crl2)
+	assert.Equal(t, int64(1), number1.Int64())
+	assert.True(t, number2.Cmp(number1) > 0, "The second CRL should have a higher CRL number")
+
+	// The CRL number is persisted and is unique when generated concurrently
+	numbers, err = NewFileCRLNumberSource(numberFile)
+	FatalError(t, err, "Failed to reload CRL number source")
+	var wg sync.WaitGroup
+	results := make(chan int64, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			number, err := numbers.Next()
+			if assert.NoError(t, err) {
+				results <- number.Int64()
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+	seen := map[int64]bool{}
+	for number := range results {
+		assert.True(t, number > number2.Int64(), "CRL numbers should increase across restarts")
+		assert.False(t, seen[number], "CRL number %d was returned twice", number)
+		seen[number] = true
+	}
+	assert.Len(t, seen, 10)
+
+	_, err = GenerateCRL(revoked, priv, caCert, time.Now(), time.Now().Add(time.Hour), nil)
+	assert.Error(t, err, "Generating a CRL without a CRL number source should fail")
+	FatalError(t, ioutil.WriteFile(numberFile, []byte("bad"), 0644), "Failed to write CRL number file")
+	_, err = NewFileCRLNumberSource(numberFile)
+	assert.Error(t, err, "An invalid CRL number file should be rejected")
+}
+
+func BenchmarkStreamCRL(b *testing.B) {
+	caCert, priv := newTestCRLIssuer(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := streamTestCRL(caCert, priv, 100000); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
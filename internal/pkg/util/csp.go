@@ -17,16 +17,27 @@ limitations under the License.
 package util
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"strings"
+	"sync"
 	_ "time" // for ocspSignerFromConfig
 
 	_ "github.com/cloudflare/cfssl/cli" // for ocspSignerFromConfig
@@ -39,11 +50,54 @@ import (
 	"github.com/cloudflare/cfssl/signer/local"
 	"github.com/hyperledger/fabric/bccsp"
 	"github.com/hyperledger/fabric/bccsp/factory"
-	cspsigner "github.com/hyperledger/fabric/bccsp/signer"
 	"github.com/hyperledger/fabric/bccsp/utils"
 	"github.com/pkg/errors"
 )
 
+// AlgorithmSpec identifies a key algorithm and size that can be requested
+// in a CSR key request
+type AlgorithmSpec struct {
+	Algo string `json:"algo"`
+	Size int    `json:"size"`
+}
+
+// maxPEMKeySize is the maximum size of a PEM encoded private key accepted for import
+const maxPEMKeySize = 64 * 1024
+
+// candidateAlgorithms are the key algorithms which are probed by
+// SupportedAlgorithms. SM2 is probed with SM2KeyGenOpts, which is defined by
+// this package: a GM provider is only found to support SM2 if it recognizes
+// these options, and a provider which only accepts its own SM2 option types
+// is reported as not supporting SM2.
+var candidateAlgorithms = []AlgorithmSpec{
+	{Algo: "ecdsa", Size: 256},
+	{Algo: "ecdsa", Size: 384},
+	{Algo: "ecdsa", Size: 521},
+	{Algo: "rsa", Size: 2048},
+	{Algo: "rsa", Size: 3072},
+	{Algo: "rsa", Size: 4096},
+	{Algo: "gmsm2", Size: 256},
+}
+
+// DefaultAllowedECDSACurves are the curves of the ECDSA private keys which can
+// be imported unless the AllowedECDSACurves option is set on the CSP
+var DefaultAllowedECDSACurves = []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()}
+
+// oidPublicKeyECDSA is the algorithm identifier of ECDSA keys in PKCS#8
+var oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+
+// goCurveOIDs are the OIDs of the curves which Go can parse
+var goCurveOIDs = []string{"1.3.132.0.33", "1.2.840.10045.3.1.7", "1.3.132.0.34", "1.3.132.0.35"}
+
+// namedCurveOIDs are the names of well-known curves which Go can't parse, so
+// that the curve of a rejected key can be named
+var namedCurveOIDs = map[string]string{
+	"1.3.132.0.10":          "secp256k1",
+	"1.3.36.3.3.2.8.1.1.7":  "brainpoolP256r1",
+	"1.3.36.3.3.2.8.1.1.11": "brainpoolP384r1",
+	"1.2.156.10197.1.301":   "SM2",
+}
+
 // GetDefaultBCCSP returns the default BCCSP
 func GetDefaultBCCSP() bccsp.BCCSP {
 	return factory.GetDefault()
@@ -64,13 +118,96 @@ func InitBCCSP(optsPtr **factory.FactoryOpts, mspDir, homeDir string) (bccsp.BCC
 
 // GetBCCSP returns BCCSP
 func GetBCCSP(opts *factory.FactoryOpts, homeDir string) (bccsp.BCCSP, error) {
+	err := checkBCCSPProvider(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get BCCSP from the opts
 	csp, err := factory.GetBCCSPFromOpts(opts)
 	if err != nil {
 		return nil, errors.WithMessage(err, "Failed to get BCCSP with opts")
 	}
-	return csp, nil
+	return &configuredCSP{
+		BCCSP: csp,
+		opts: CSPOptions{
+			keystoreDir: registeredKeystoreDir(opts),
+			hashFamily:  strings.ToUpper(bccspHashFamily(opts)),
+		},
+		algs: &algorithmCache{},
+	}, nil
+}
+
+// checkBCCSPProvider returns an actionable error if the BCCSP provider named in
+// opts is not available in this build, such as a GM provider whose library is
+// not compiled in, rather than letting the BCCSP factory fail with a cryptic error
+func checkBCCSPProvider(opts *factory.FactoryOpts) error {
+	if opts == nil {
+		return errors.New("No BCCSP options were provided")
+	}
+	for _, provider := range supportedBCCSPProviders {
+		if opts.ProviderName == provider {
+			return nil
+		}
+	}
+	return errors.Errorf("The BCCSP provider '%s' is not available in this build; the available providers are %s. "+
+		"Set 'bccsp.default' to one of the available providers, or use a build that includes the '%s' provider",
+		opts.ProviderName, strings.Join(supportedBCCSPProviders, ", "), opts.ProviderName)
+}
+
+// CheckHashFamily returns a descriptive error if the hash family configured
+// for the BCCSP provider in opts is not compatible with the CA key pub. SM2
+// keys require the GMSM3 hash family, and ECDSA and RSA keys require the SHA2
+// or SHA3 hash family. A provider whose hash family is not known is accepted.
+func CheckHashFamily(opts *factory.FactoryOpts, pub crypto.PublicKey) error {
+	if opts == nil {
+		return errors.New("No BCCSP options were provided")
+	}
+	family := bccspHashFamily(opts)
+	keyType, expected := "", "SHA2 or SHA3"
+	compatible := []string{"", "SHA2", "SHA3"}
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		keyType = "ECDSA"
+		if IsSM2PublicKey(pub) {
+			keyType, expected = "SM2", "GMSM3"
+			compatible = []string{"", "GMSM3"}
+		}
+	case *rsa.PublicKey:
+		keyType = "RSA"
+	default:
+		return errors.Errorf("Unsupported CA key type %T; must be SM2, ECDSA or RSA", pub)
+	}
+	for _, f := range compatible {
+		if strings.ToUpper(family) == f {
+			return nil
+		}
+	}
+	return errors.Errorf("The BCCSP hash family '%s' is not compatible with the %s key of the CA; "+
+		"set the hash family of the '%s' BCCSP provider to %s", family, keyType, opts.ProviderName, expected)
+}
+
+// DiffCSPConfig returns a human-readable description of each difference
+// between the BCCSP configurations a and b: their provider, hash family,
+// security level and keystore path. A nil configuration has no provider.
+func DiffCSPConfig(a, b *factory.FactoryOpts) []string {
+	if a == nil {
+		a = &factory.FactoryOpts{}
+	}
+	if b == nil {
+		b = &factory.FactoryOpts{}
+	}
+	var diffs []string
+	diff := func(field string, va, vb interface{}) {
+		if va != vb {
+			diffs = append(diffs, fmt.Sprintf("%s: '%v' != '%v'", field, va, vb))
+		}
+	}
+	diff("provider", strings.ToUpper(a.ProviderName), strings.ToUpper(b.ProviderName))
+	diff("hash family", bccspHashFamily(a), bccspHashFamily(b))
+	diff("security level", bccspSecLevel(a), bccspSecLevel(b))
+	diff("keystore path", bccspKeystorePath(a), bccspKeystorePath(b))
+	return diffs
 }
 
 // makeFileNamesAbsolute makes all relative file names associated with CSP absolute,
@@ -87,34 +224,106 @@ func makeFileNamesAbsolute(opts *factory.FactoryOpts, homeDir string) error {
 // BccspBackedSigner attempts to create a signer using csp bccsp.BCCSP. This csp could be SW (golang crypto)
 // PKCS11 or whatever BCCSP-conformant library is configured
 func BccspBackedSigner(caFile, keyFile string, policy *config.Signing, csp bccsp.BCCSP) (signer.Signer, error) {
+	return BccspBackedSignerWithHash(caFile, keyFile, policy, csp, 0)
+}
+
+// BccspBackedSignerWithHash is like BccspBackedSigner, but the returned signer
+// signs certificates using hash. If hash is zero, the default hash algorithm
+// for the CA key is used.
+func BccspBackedSignerWithHash(caFile, keyFile string, policy *config.Signing, csp bccsp.BCCSP, hash crypto.Hash) (signer.Signer, error) {
+	return newBccspSigner(caFile, keyFile, policy, csp, hash, false)
+}
+
+// BccspBackedSignerWithTemporaryKey is like BccspBackedSigner, but if the
+// private key of the CA is not found in the BCCSP keystore, it is imported
+// from keyFile only for the lifetime of the returned signer and is not stored
+// in the keystore, e.g. to cross-sign certificates with another CA's key.
+func BccspBackedSignerWithTemporaryKey(caFile, keyFile string, policy *config.Signing, csp bccsp.BCCSP) (signer.Signer, error) {
+	return newBccspSigner(caFile, keyFile, policy, csp, 0, true)
+}
+
+// newBccspSigner returns the signer of BccspBackedSignerWithHash; if
+// temporary is true, a key imported from keyFile is not stored
+func newBccspSigner(caFile, keyFile string, policy *config.Signing, csp bccsp.BCCSP, hash crypto.Hash, temporary bool) (signer.Signer, error) {
+	cspSigner, parsedCa, err := getCASigner(caFile, keyFile, csp, temporary)
+	if err != nil {
+		return nil, err
+	}
+
+	sigAlgo := signer.DefaultSigAlgo(cspSigner)
+	if hash != 0 {
+		sigAlgo, err = SignatureAlgorithmForHash(cspSigner.Public(), hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = CheckKeySignatureAlgorithm(cspSigner.Public(), sigAlgo)
+	if err != nil {
+		return nil, errors.WithMessage(err, "The CA key can't sign certificates")
+	}
+	signer, err := local.NewSigner(cspSigner, parsedCa, sigAlgo, policy)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create new signer")
+	}
+	return &bccspSigner{Signer: signer, key: cspSigner, sigAlgo: sigAlgo, rng: RandReader(csp)}, nil
+}
+
+// bccspSigner is the cfssl local signer returned by BccspBackedSigner, which
+// also gives access to the CA key to sign certificates from templates
+type bccspSigner struct {
+	*local.Signer
+	key     crypto.Signer
+	sigAlgo x509.SignatureAlgorithm
+	// rng is the random number generator of the CSP of the CA key, from
+	// which the serial numbers of the certificates signed from templates
+	// are drawn unless a serial number source is given
+	rng io.Reader
+}
+
+// LocalSigner returns the cfssl local signer of s, which is either a local
+// signer or a signer returned by BccspBackedSigner
+func LocalSigner(s signer.Signer) (*local.Signer, bool) {
+	switch s := s.(type) {
+	case *local.Signer:
+		return s, true
+	case *bccspSigner:
+		return s.Signer, true
+	}
+	return nil, false
+}
+
+// getCASigner returns a crypto.Signer for the private key of the CA certificate
+// in caFile along with the parsed certificate. The private key is looked up in
+// the BCCSP keystore first, and imported from keyFile if it is not found there;
+// the imported key is only stored in the keystore if temporary is false. The
+// key file is not used if the DisableKeyFileFallback option is set on csp.
+func getCASigner(caFile, keyFile string, csp bccsp.BCCSP, temporary bool) (crypto.Signer, *x509.Certificate, error) {
 	_, cspSigner, parsedCa, err := GetSignerFromCertFile(caFile, csp)
 	if err != nil {
+		if getCSPOptions(csp).DisableKeyFileFallback {
+			return nil, nil, errors.WithMessage(err, "Could not find the private key in BCCSP keystore and the key file fallback is disabled")
+		}
 		// Fallback: attempt to read out of keyFile and import
 		log.Debugf("No key found in BCCSP keystore, attempting fallback")
 		var key bccsp.Key
 		var signer crypto.Signer
 
-		key, err = ImportBCCSPKeyFromPEM(keyFile, csp, false)
+		key, err = ImportBCCSPKeyFromPEM(keyFile, csp, temporary)
 		if err != nil {
-			return nil, errors.WithMessage(err, fmt.Sprintf("Could not find the private key in BCCSP keystore nor in keyfile '%s'", keyFile))
+			return nil, nil, errors.WithMessage(err, fmt.Sprintf("Could not find the private key in BCCSP keystore nor in keyfile '%s'", keyFile))
 		}
 
-		signer, err = cspsigner.New(csp, key)
+		signer, err = NewCryptoSigner(csp, key)
 		if err != nil {
-			return nil, errors.WithMessage(err, "Failed initializing CryptoSigner")
+			return nil, nil, errors.WithMessage(err, "Failed initializing CryptoSigner")
 		}
 		cspSigner = signer
 	}
-
-	signer, err := local.NewSigner(cspSigner, parsedCa, signer.DefaultSigAlgo(cspSigner), policy)
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed to create new signer")
-	}
-	return signer, nil
+	return cspSigner, parsedCa, nil
 }
 
 // getBCCSPKeyOpts generates a key as specified in the request.
-// This supports ECDSA and RSA.
+// This supports ECDSA, RSA, Ed25519 and, with a GM provider, SM2.
 func getBCCSPKeyOpts(kr *csr.KeyRequest, ephemeral bool) (opts bccsp.KeyGenOpts, err error) {
 	if kr == nil {
 		return &bccsp.ECDSAKeyGenOpts{Temporary: ephemeral}, nil
@@ -146,16 +355,65 @@ func getBCCSPKeyOpts(kr *csr.KeyRequest, ephemeral bool) (opts bccsp.KeyGenOpts,
 		default:
 			return nil, errors.Errorf("Invalid ECDSA key size: %d", kr.Size())
 		}
+	case "ed25519":
+		if kr.Size() != 0 && kr.Size() != 256 {
+			return nil, errors.Errorf("Invalid Ed25519 key size: %d", kr.Size())
+		}
+		return &ed25519KeyGenOpts{Temporary: ephemeral}, nil
+	case "gmsm2":
+		if kr.Size() != 0 && kr.Size() != 256 {
+			return nil, errors.Errorf("Invalid SM2 key size: %d", kr.Size())
+		}
+		return &SM2KeyGenOpts{Temporary: ephemeral}, nil
 	default:
 		return nil, errors.Errorf("Invalid algorithm: %s", kr.Algo())
 	}
 }
 
+// SupportedAlgorithms returns the key algorithms supported by csp. Each
+// candidate algorithm is probed by generating an ephemeral key; the result is
+// cached for the CSPs returned by GetBCCSP and ConfigureCSP, and computed on
+// each call for other CSPs.
+func SupportedAlgorithms(csp bccsp.BCCSP) []AlgorithmSpec {
+	c, ok := csp.(*configuredCSP)
+	if !ok || c.algs == nil {
+		return probeAlgorithms(csp)
+	}
+	c.algs.once.Do(func() {
+		c.algs.algs = probeAlgorithms(c.BCCSP)
+	})
+	return append([]AlgorithmSpec{}, c.algs.algs...)
+}
+
+// probeAlgorithms returns the candidate algorithms for which csp generates an
+// ephemeral key
+func probeAlgorithms(csp bccsp.BCCSP) []AlgorithmSpec {
+	algs := []AlgorithmSpec{}
+	for _, alg := range candidateAlgorithms {
+		opts, err := getBCCSPKeyOpts(&csr.KeyRequest{A: alg.Algo, S: alg.Size}, true)
+		if err != nil {
+			log.Debugf("Key algorithm %s-%d is not supported: %s", alg.Algo, alg.Size, err)
+			continue
+		}
+		_, err = csp.KeyGen(opts)
+		if err != nil {
+			log.Debugf("Key algorithm %s-%d is not supported by the CSP: %s", alg.Algo, alg.Size, err)
+			continue
+		}
+		algs = append(algs, alg)
+	}
+	return algs
+}
+
 // GetSignerFromCert load private key represented by ski and return bccsp signer that conforms to crypto.Signer
 func GetSignerFromCert(cert *x509.Certificate, csp bccsp.BCCSP) (bccsp.Key, crypto.Signer, error) {
 	if csp == nil {
 		return nil, nil, errors.New("CSP was not initialized")
 	}
+	err := checkCertHashFamily(cert, csp)
+	if err != nil {
+		return nil, nil, err
+	}
 	// get the public key in the right format
 	certPubK, err := csp.KeyImport(cert, &bccsp.X509PublicKeyImportOpts{Temporary: true})
 	if err != nil {
@@ -164,6 +422,19 @@ func GetSignerFromCert(cert *x509.Certificate, csp bccsp.BCCSP) (bccsp.Key, cryp
 	// Get the key given the SKI value
 	ski := certPubK.SKI()
 	privateKey, err := csp.GetKey(ski)
+	if (err != nil || !privateKey.Private()) && len(cert.SubjectKeyId) > 0 && !bytes.Equal(cert.SubjectKeyId, ski) {
+		// The key may be stored under the subject key identifier of the
+		// certificate, e.g. in an HSM where the key was labelled with it
+		log.Warningf("The private key of the certificate '%s' was not found for SKI '%s'; retrying with its subject key identifier '%s'",
+			cert.Subject.CommonName, hex.EncodeToString(ski), hex.EncodeToString(cert.SubjectKeyId))
+		if key, kerr := csp.GetKey(cert.SubjectKeyId); kerr == nil && key.Private() {
+			privateKey, ski, err = key, cert.SubjectKeyId, nil
+		}
+	}
+	if (err != nil || !privateKey.Private()) && keystoreEmpty(csp) {
+		return nil, nil, errors.WithMessage(ErrKeystoreEmpty,
+			fmt.Sprintf("Could not find the private key with SKI '%s'", hex.EncodeToString(ski)))
+	}
 	if err != nil {
 		return nil, nil, errors.WithMessage(err, "Could not find matching private key for SKI")
 	}
@@ -173,7 +444,7 @@ func GetSignerFromCert(cert *x509.Certificate, csp bccsp.BCCSP) (bccsp.Key, cryp
 		return nil, nil, errors.Errorf("The private key associated with the certificate with SKI '%s' was not found", hex.EncodeToString(ski))
 	}
 	// Construct and initialize the signer
-	signer, err := cspsigner.New(csp, privateKey)
+	signer, err := NewCryptoSigner(csp, privateKey)
 	if err != nil {
 		return nil, nil, errors.WithMessage(err, "Failed to load ski from bccsp")
 	}
@@ -187,8 +458,15 @@ func GetSignerFromCertFile(certFile string, csp bccsp.BCCSP) (bccsp.Key, crypto.
 	if err != nil {
 		return nil, nil, nil, errors.Wrapf(err, "Could not read certFile '%s'", certFile)
 	}
+	return GetSignerFromCertPEM(certBytes, csp)
+}
+
+// GetSignerFromCertPEM parses the PEM-encoded certificate and returns the bccsp
+// key and signer for the private key matching it, so that inline certificate
+// material can be used without writing it to a file first
+func GetSignerFromCertPEM(certPEM []byte, csp bccsp.BCCSP) (bccsp.Key, crypto.Signer, *x509.Certificate, error) {
 	// Parse certificate
-	parsedCa, err := helpers.ParseCertificatePEM(certBytes)
+	parsedCa, err := helpers.ParseCertificatePEM(certPEM)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -197,46 +475,217 @@ func GetSignerFromCertFile(certFile string, csp bccsp.BCCSP) (bccsp.Key, crypto.
 	return key, cspSigner, parsedCa, err
 }
 
+// AssertCAKeyMatchesCert checks that the private key which csp holds for the
+// CA certificate in certFile corresponds to that certificate: the public key
+// of its signer must be the public key of the certificate. The returned error
+// must prevent the CA from starting.
+func AssertCAKeyMatchesCert(certFile string, csp bccsp.BCCSP) error {
+	_, signer, cert, err := GetSignerFromCertFile(certFile, csp)
+	if err != nil {
+		return err
+	}
+	signerPub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal the public key of the CA key")
+	}
+	if !bytes.Equal(signerPub, cert.RawSubjectPublicKeyInfo) {
+		return errors.Errorf("The CA key in the keystore does not match the public key of the CA certificate '%s'", certFile)
+	}
+	return nil
+}
+
 // BCCSPKeyRequestGenerate generates keys through BCCSP
-// somewhat mirroring to cfssl/req.KeyRequest.Generate()
+// somewhat mirroring to cfssl/req.KeyRequest.Generate().
+// The key is generated and stored by myCSP, so callers using several CSPs,
+// such as an HSM and a software keystore, choose where the key lives.
 func BCCSPKeyRequestGenerate(req *csr.CertificateRequest, myCSP bccsp.BCCSP) (bccsp.Key, crypto.Signer, error) {
+	return BCCSPKeyRequestGenerateWithEphemeral(req, myCSP, false)
+}
+
+// BCCSPKeyRequestGenerateWithEphemeral generates keys through BCCSP. If ephemeral
+// is true, the generated key is not stored in the BCCSP keystore.
+func BCCSPKeyRequestGenerateWithEphemeral(req *csr.CertificateRequest, myCSP bccsp.BCCSP, ephemeral bool) (bccsp.Key, crypto.Signer, error) {
+	if myCSP == nil {
+		return nil, nil, errors.New("CSP was not initialized")
+	}
 	log.Infof("generating key: %+v", req.KeyRequest)
-	keyOpts, err := getBCCSPKeyOpts(req.KeyRequest, false)
+	keyOpts, err := getBCCSPKeyOpts(req.KeyRequest, ephemeral)
 	if err != nil {
 		return nil, nil, err
 	}
-	key, err := myCSP.KeyGen(keyOpts)
+	var key bccsp.Key
+	if opts, ok := keyOpts.(*ed25519KeyGenOpts); ok {
+		key, err = generateEd25519Key(myCSP, opts)
+	} else if r := insecureKeyGenReader(myCSP); r != nil {
+		key, err = insecureGenerateKey(myCSP, r, keyOpts)
+	} else {
+		key, err = myCSP.KeyGen(keyOpts)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
-	cspSigner, err := cspsigner.New(myCSP, key)
+	cspSigner, err := NewCryptoSigner(myCSP, key)
 	if err != nil {
 		return nil, nil, errors.WithMessage(err, "Failed initializing CryptoSigner")
 	}
 	return key, cspSigner, nil
 }
 
-// ImportBCCSPKeyFromPEM attempts to create a private BCCSP key from a pem file keyFile
+// KeyResult is the result of the generation of one key by GenerateKeysBatch
+type KeyResult struct {
+	Key    bccsp.Key
+	Signer crypto.Signer
+	Err    error
+}
+
+// GenerateKeysBatch generates the keys described by the key requests of reqs
+// with csp, running at most concurrency generations in parallel. The i-th
+// result corresponds to the i-th request; a failure to generate one key does
+// not prevent the others from being generated.
+func GenerateKeysBatch(reqs []*csr.CertificateRequest, csp bccsp.BCCSP, concurrency int) ([]KeyResult, error) {
+	if csp == nil {
+		return nil, errors.New("CSP was not initialized")
+	}
+	if concurrency < 1 {
+		return nil, errors.Errorf("Invalid key generation concurrency %d; must be at least 1", concurrency)
+	}
+	results := make([]KeyResult, len(reqs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		if req == nil {
+			results[i].Err = errors.New("Certificate request must be different from nil")
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, req *csr.CertificateRequest) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			r := &results[i]
+			r.Key, r.Signer, r.Err = BCCSPKeyRequestGenerate(req, csp)
+		}(i, req)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// CheckCSPHealth checks that csp is able to generate keys and to sign and
+// verify with them. An ephemeral key is used so that the keystore is not
+// modified by the check.
+func CheckCSPHealth(csp bccsp.BCCSP) error {
+	if csp == nil {
+		return errors.New("CSP was not initialized")
+	}
+	key, signer, err := BCCSPKeyRequestGenerateWithEphemeral(&csr.CertificateRequest{}, csp, true)
+	if err != nil {
+		return errors.WithMessage(err, "CSP failed to generate a key")
+	}
+	digest := sha256.Sum256([]byte("fabric-ca health check"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return errors.WithMessage(err, "CSP failed to sign")
+	}
+	valid, err := csp.Verify(key, sig, digest[:], nil)
+	if err != nil {
+		return errors.WithMessage(err, "CSP failed to verify signature")
+	}
+	if !valid {
+		return errors.New("CSP produced an invalid signature")
+	}
+	return nil
+}
+
+// ImportBCCSPKeyFromPEM attempts to create a private BCCSP key from a pem file keyFile.
+// The key is imported into myCSP, which is the only CSP the key is stored in.
+// If temporary is true, the key is only held in memory and is not written to the
+// keystore of myCSP.
 func ImportBCCSPKeyFromPEM(keyFile string, myCSP bccsp.BCCSP, temporary bool) (bccsp.Key, error) {
+	key, _, err := ImportBCCSPKeyFromPEMWithHeaders(keyFile, myCSP, temporary)
+	return key, err
+}
+
+// ImportBCCSPKeyFromEncryptedPEM attempts to create a private BCCSP key from
+// the pem file keyFile holding a private key encrypted with password, which is
+// decrypted with DecryptPEMPrivateKey. The key is imported as by
+// ImportBCCSPKeyFromPEM.
+func ImportBCCSPKeyFromEncryptedPEM(keyFile string, password []byte, myCSP bccsp.BCCSP, temporary bool) (bccsp.Key, error) {
 	keyBuff, err := ioutil.ReadFile(keyFile)
 	if err != nil {
 		return nil, err
 	}
-	key, err := utils.PEMtoPrivateKey(keyBuff, nil)
+	defer ZeroizeKeyBytes(keyBuff)
+	plain, err := DecryptPEMPrivateKey(keyBuff, password)
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to decrypt private key from %s", keyFile))
+	}
+	defer ZeroizeKeyBytes(plain)
+	return importBCCSPKeyFromPEMBytes(keyFile, plain, myCSP, temporary)
+}
+
+// ImportBCCSPKeyFromPEMWithHeaders attempts to create a private BCCSP key from a pem
+// file keyFile, and also returns the headers of the PEM block so that they can be
+// preserved when the key material is exported again
+func ImportBCCSPKeyFromPEMWithHeaders(keyFile string, myCSP bccsp.BCCSP, temporary bool) (bccsp.Key, map[string]string, error) {
+	keyBuff, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ZeroizeKeyBytes(keyBuff)
+	var headers map[string]string
+	if block, _, err := decodePEM(keyBuff); err == nil {
+		headers = block.Headers
+	}
+	key, err := importBCCSPKeyFromPEMBytes(keyFile, keyBuff, myCSP, temporary)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, headers, nil
+}
+
+// ImportBCCSPKeyFromPEMBytes attempts to create a private BCCSP key from the PEM
+// encoded private key in keyBuff. keyBuff is left untouched; callers which no
+// longer need it should clear it with ZeroizeKeyBytes.
+func ImportBCCSPKeyFromPEMBytes(keyBuff []byte, myCSP bccsp.BCCSP, temporary bool) (bccsp.Key, error) {
+	return importBCCSPKeyFromPEMBytes("PEM bytes", keyBuff, myCSP, temporary)
+}
+
+func importBCCSPKeyFromPEMBytes(keyFile string, keyBuff []byte, myCSP bccsp.BCCSP, temporary bool) (bccsp.Key, error) {
+	if myCSP == nil {
+		return nil, errors.New("CSP was not initialized")
+	}
+	key, err := decodePEMPrivateKey(keyBuff)
 	if err != nil {
+		// Keys on curves unknown to Go fail to parse; report their curve
+		if name := unknownECDSACurve(keyBuff); name != "" {
+			return nil, errors.Errorf("Failed to import ECDSA private key for '%s': the curve %s is not allowed", keyFile, name)
+		}
 		return nil, errors.WithMessage(err, fmt.Sprintf("Failed parsing private key from %s", keyFile))
 	}
 	switch key.(type) {
 	case *ecdsa.PrivateKey:
+		if err = checkECDSACurve(myCSP, key.(*ecdsa.PrivateKey).Curve); err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("Failed to import ECDSA private key for '%s'", keyFile))
+		}
 		priv, err := utils.PrivateKeyToDER(key.(*ecdsa.PrivateKey))
 		if err != nil {
 			return nil, errors.WithMessage(err, fmt.Sprintf("Failed to convert ECDSA private key for '%s'", keyFile))
 		}
+		// The CSP parses its own copy of the key, so the DER can be cleared
+		defer ZeroizeKeyBytes(priv)
 		sk, err := myCSP.KeyImport(priv, &bccsp.ECDSAPrivateKeyImportOpts{Temporary: temporary})
 		if err != nil {
 			return nil, errors.WithMessage(err, fmt.Sprintf("Failed to import ECDSA private key for '%s'", keyFile))
 		}
 		return sk, nil
+	case ed25519.PrivateKey:
+		sk, err := wrapEd25519Key(key.(ed25519.PrivateKey), temporary)
+		if err != nil {
+			return nil, errors.WithMessage(err, fmt.Sprintf("Failed to import Ed25519 private key for '%s'", keyFile))
+		}
+		return sk, nil
 	case *rsa.PrivateKey:
 		return nil, errors.Errorf("Failed to import RSA key from %s; RSA private key import is not supported", keyFile)
 	default:
@@ -244,6 +693,274 @@ func ImportBCCSPKeyFromPEM(keyFile string, myCSP bccsp.BCCSP, temporary bool) (b
 	}
 }
 
+// ZeroizeKeyBytes overwrites the key material in b with zeros. This is a best
+// effort: copies of the key made by the Go runtime or by the libraries which
+// parsed it are not cleared. Keys held by an HSM through the PKCS11 provider
+// never enter process memory and need no zeroization.
+func ZeroizeKeyBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// ImportCAMaterial imports the private key in keyFile into the BCCSP keystore
+// and loads the certificate in certFile. An error is returned if the private
+// key does not correspond to the public key of the certificate, in which case
+// the key is not stored in the keystore.
+func ImportCAMaterial(certFile, keyFile string, csp bccsp.BCCSP) (*x509.Certificate, bccsp.Key, error) {
+	if csp == nil {
+		return nil, nil, errors.New("CSP was not initialized")
+	}
+	cert, err := GetX509CertificateFromPEMFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPubK, err := csp.KeyImport(cert, &bccsp.X509PublicKeyImportOpts{Temporary: true})
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, fmt.Sprintf("Failed to import public key of certificate '%s'", certFile))
+	}
+	// The key is only stored once it is known to match the certificate
+	key, err := ImportBCCSPKeyFromPEM(keyFile, csp, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !SKIEqual(certPubK.SKI(), key.SKI()) {
+		return nil, nil, errors.Errorf("The private key in '%s' does not match the public key of the certificate in '%s'", keyFile, certFile)
+	}
+	key, err = ImportBCCSPKeyFromPEM(keyFile, csp, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// ComputeSKI returns the subject key identifier which csp computes for the
+// public key pub, which must be an ECDSA or RSA public key
+func ComputeSKI(pub interface{}, csp bccsp.BCCSP) ([]byte, error) {
+	if csp == nil {
+		return nil, errors.New("CSP was not initialized")
+	}
+	var opts bccsp.KeyImportOpts
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		opts = &bccsp.ECDSAGoPublicKeyImportOpts{Temporary: true}
+	case *rsa.PublicKey:
+		opts = &bccsp.RSAGoPublicKeyImportOpts{Temporary: true}
+	default:
+		return nil, errors.Errorf("Unsupported public key type %T; must be ECDSA or RSA", pub)
+	}
+	key, err := csp.KeyImport(pub, opts)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to import public key")
+	}
+	return key.SKI(), nil
+}
+
+// SKIEqual returns true if the key identifiers a and b are equal. The
+// comparison takes a time which is independent of the contents of a and b,
+// so it should be used wherever a key identifier gates an access decision.
+func SKIEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// VerifyWithPublicKey verifies with csp that sig is a signature of digest by
+// the private key of pub, without requiring a certificate. pub is either an
+// ECDSA or SM2 public key or its DER encoded PKIX representation. For SM2
+// keys, which the BCCSP providers don't support, digest is the digest
+// computed by SM2Digest and the signature is verified in software. The
+// returned boolean is false if the signature is invalid.
+func VerifyWithPublicKey(pub interface{}, digest, sig []byte, csp bccsp.BCCSP) (bool, error) {
+	if csp == nil {
+		return false, errors.New("CSP was not initialized")
+	}
+	if der, ok := pub.([]byte); ok {
+		var err error
+		pub, err = x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			var gmErr error
+			pub, gmErr = parseSM2PublicKey(der)
+			if gmErr != nil {
+				return false, errors.Wrap(err, "Failed to parse public key")
+			}
+		}
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return false, errors.Errorf("Unsupported public key type %T; must be ECDSA or SM2", pub)
+	}
+	if isSM2Curve(ecPub.Curve) {
+		if len(digest) != sm3Size {
+			return false, errors.Errorf("Invalid SM2 digest of %d bytes; must be %d bytes", len(digest), sm3Size)
+		}
+		return sm2VerifyDigest(ecPub, new(big.Int).SetBytes(digest), sig), nil
+	}
+	key, err := csp.KeyImport(ecPub, &bccsp.ECDSAGoPublicKeyImportOpts{Temporary: true})
+	if err != nil {
+		return false, errors.WithMessage(err, "Failed to import public key")
+	}
+	valid, err := csp.Verify(key, sig, digest, nil)
+	if err != nil {
+		// A malformed signature is not a valid signature
+		log.Debugf("Failed to verify signature: %s", err)
+		return false, nil
+	}
+	return valid, nil
+}
+
+// EnsureSKI sets the subject key identifier of template to the SKI of pub, as
+// computed by ComputeSKI, if template does not have one
+func EnsureSKI(template *x509.Certificate, pub interface{}, csp bccsp.BCCSP) error {
+	if template == nil {
+		return errors.New("Certificate template must be different from nil")
+	}
+	if len(template.SubjectKeyId) > 0 {
+		return nil
+	}
+	ski, err := ComputeSKI(pub, csp)
+	if err != nil {
+		return errors.WithMessage(err, "Failed to compute the subject key identifier")
+	}
+	template.SubjectKeyId = ski
+	return nil
+}
+
+// decodePEMPrivateKey validates and decodes the PEM encoded private key in raw.
+// Malformed input results in an error rather than a panic.
+func decodePEMPrivateKey(raw []byte) (key interface{}, err error) {
+	if len(raw) == 0 {
+		return nil, errors.New("Invalid PEM; it must not be empty")
+	}
+	if len(raw) > maxPEMKeySize {
+		return nil, errors.Errorf("Invalid PEM; its size of %d bytes exceeds the maximum of %d bytes", len(raw), maxPEMKeySize)
+	}
+	raw, copied := normalizePEM(raw)
+	if copied {
+		defer ZeroizeKeyBytes(raw)
+	}
+	block, _, err := decodePEM(raw)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to decode PEM block")
+	}
+	if len(block.Bytes) == 0 {
+		return nil, errors.Errorf("PEM block of type '%s' contains no key data", block.Type)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			key = nil
+			err = errors.Errorf("Failed to parse private key: %v", r)
+		}
+	}()
+	// The BCCSP utilities don't support Ed25519 keys, which are PKCS#8 encoded
+	if block.Type == "PRIVATE KEY" {
+		if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			if edKey, ok := k.(ed25519.PrivateKey); ok {
+				return edKey, nil
+			}
+		}
+	}
+	return utils.PEMtoPrivateKey(raw, nil)
+}
+
+// ParseECDSACurves returns the curves named by names, which are the names of
+// DefaultAllowedECDSACurves, e.g. "P-256", in any case
+func ParseECDSACurves(names []string) ([]elliptic.Curve, error) {
+	var curves []elliptic.Curve
+	for _, name := range names {
+		var curve elliptic.Curve
+		for _, c := range DefaultAllowedECDSACurves {
+			if strings.EqualFold(c.Params().Name, name) {
+				curve = c
+				break
+			}
+		}
+		if curve == nil {
+			return nil, errors.Errorf("Unsupported ECDSA curve '%s'", name)
+		}
+		curves = append(curves, curve)
+	}
+	return curves, nil
+}
+
+// checkECDSACurve returns an error if ECDSA keys on curve can't be imported
+// into csp, according to its AllowedECDSACurves option
+func checkECDSACurve(csp bccsp.BCCSP, curve elliptic.Curve) error {
+	allowedCurves := getCSPOptions(csp).AllowedECDSACurves
+	if len(allowedCurves) == 0 {
+		allowedCurves = DefaultAllowedECDSACurves
+	}
+	var names []string
+	for _, allowed := range allowedCurves {
+		if allowed == curve {
+			return nil
+		}
+		names = append(names, allowed.Params().Name)
+	}
+	return errors.Errorf("the curve %s is not allowed; allowed curves are: %s",
+		curve.Params().Name, strings.Join(names, ", "))
+}
+
+// unknownECDSACurve returns the name, or the OID if it has no known name, of
+// the curve of the PEM encoded SEC1 or PKCS#8 ECDSA private key in raw, or an
+// empty string if raw is not such a key
+func unknownECDSACurve(raw []byte) string {
+	block, _, err := decodePEM(raw)
+	if err != nil {
+		return ""
+	}
+	var oid asn1.ObjectIdentifier
+	var sec1 struct {
+		Version    int
+		PrivateKey []byte
+		Curve      asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+		PublicKey  asn1.BitString        `asn1:"optional,explicit,tag:1"`
+	}
+	var pkcs8 struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+	if _, err := asn1.Unmarshal(block.Bytes, &sec1); err == nil && len(sec1.Curve) > 0 {
+		oid = sec1.Curve
+	} else if _, err := asn1.Unmarshal(block.Bytes, &pkcs8); err == nil && pkcs8.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		if _, err = asn1.Unmarshal(pkcs8.Algo.Parameters.FullBytes, &oid); err != nil {
+			return ""
+		}
+	} else {
+		return ""
+	}
+	// Keys on curves known to Go failed to parse for another reason
+	for _, known := range goCurveOIDs {
+		if oid.String() == known {
+			return ""
+		}
+	}
+	if name, ok := namedCurveOIDs[oid.String()]; ok {
+		return name
+	}
+	return oid.String()
+}
+
+// KeyPairSource indicates where the private key of a key pair loaded by
+// LoadX509KeyPairWithSource was found
+type KeyPairSource int
+
+const (
+	// KeyPairSourceBCCSP means that the private key was found by BCCSP
+	KeyPairSourceBCCSP KeyPairSource = iota + 1
+	// KeyPairSourceFile means that the private key was read from the key file
+	KeyPairSourceFile
+)
+
+func (s KeyPairSource) String() string {
+	switch s {
+	case KeyPairSourceBCCSP:
+		return "BCCSP"
+	case KeyPairSourceFile:
+		return "file"
+	}
+	return "unknown"
+}
+
 // LoadX509KeyPair reads and parses a public/private key pair from a pair
 // of files. The files must contain PEM encoded data. The certificate file
 // may contain intermediate certificates following the leaf certificate to
@@ -253,10 +970,17 @@ func ImportBCCSPKeyFromPEM(keyFile string, myCSP bccsp.BCCSP, temporary bool) (b
 // This function originated from crypto/tls/tls.go and was adapted to use a
 // BCCSP Signer
 func LoadX509KeyPair(certFile, keyFile string, csp bccsp.BCCSP) (*tls.Certificate, error) {
+	cert, _, err := LoadX509KeyPairWithSource(certFile, keyFile, csp)
+	return cert, err
+}
+
+// LoadX509KeyPairWithSource is like LoadX509KeyPair, but also returns whether
+// the private key was found by BCCSP or read from keyFile by the fallback
+func LoadX509KeyPairWithSource(certFile, keyFile string, csp bccsp.BCCSP) (*tls.Certificate, KeyPairSource, error) {
 
 	certPEMBlock, err := ioutil.ReadFile(certFile)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	cert := &tls.Certificate{}
@@ -276,19 +1000,20 @@ func LoadX509KeyPair(certFile, keyFile string, csp bccsp.BCCSP) (*tls.Certificat
 
 	if len(cert.Certificate) == 0 {
 		if len(skippedBlockTypes) == 0 {
-			return nil, errors.Errorf("Failed to find PEM block in file %s", certFile)
+			return nil, 0, errors.Errorf("Failed to find PEM block in file %s", certFile)
 		}
 		if len(skippedBlockTypes) == 1 && strings.HasSuffix(skippedBlockTypes[0], "PRIVATE KEY") {
-			return nil, errors.Errorf("Failed to find certificate PEM data in file %s, but did find a private key; PEM inputs may have been switched", certFile)
+			return nil, 0, errors.Errorf("Failed to find certificate PEM data in file %s, but did find a private key; PEM inputs may have been switched", certFile)
 		}
-		return nil, errors.Errorf("Failed to find \"CERTIFICATE\" PEM block in file %s after skipping PEM blocks of the following types: %v", certFile, skippedBlockTypes)
+		return nil, 0, errors.Errorf("Failed to find \"CERTIFICATE\" PEM block in file %s after skipping PEM blocks of the following types: %v", certFile, skippedBlockTypes)
 	}
 
 	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	source := KeyPairSourceBCCSP
 	_, cert.PrivateKey, err = GetSignerFromCert(x509Cert, csp)
 	if err != nil {
 		if keyFile != "" {
@@ -296,14 +1021,16 @@ func LoadX509KeyPair(certFile, keyFile string, csp bccsp.BCCSP) (*tls.Certificat
 			log.Debugf("Attempting fallback with certfile %s and keyfile %s", certFile, keyFile)
 			fallbackCerts, err := tls.LoadX509KeyPair(certFile, keyFile)
 			if err != nil {
-				return nil, errors.Wrapf(err, "Could not get the private key %s that matches %s", keyFile, certFile)
+				return nil, 0, errors.Wrapf(err, "Could not get the private key %s that matches %s", keyFile, certFile)
 			}
+			log.Infof("The private key of TLS certificate %s was loaded from keyfile %s rather than BCCSP", certFile, keyFile)
 			cert = &fallbackCerts
+			source = KeyPairSourceFile
 		} else {
-			return nil, errors.WithMessage(err, "Could not load TLS certificate with BCCSP")
+			return nil, 0, errors.WithMessage(err, "Could not load TLS certificate with BCCSP")
 		}
 
 	}
 
-	return cert, nil
+	return cert, source, nil
 }
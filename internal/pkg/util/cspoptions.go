@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/elliptic"
+	"io"
+	"sync"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/pkg/errors"
+)
+
+// CSPOptions are the options of the key operations which the functions of
+// this package perform with a CSP, beyond what the BCCSP factory options
+// configure. The zero value selects the defaults.
+type CSPOptions struct {
+	// DisableKeyFileFallback prevents BccspBackedSigner from importing the
+	// private key of the CA from its key file when it is not found in the
+	// BCCSP keystore, so that only keys held by the provider, e.g. an HSM,
+	// are used
+	DisableKeyFileFallback bool
+	// AllowedECDSACurves are the curves of the ECDSA private keys which can
+	// be imported; DefaultAllowedECDSACurves if empty
+	AllowedECDSACurves []elliptic.Curve
+	// UseCSPRNG draws serial numbers and software keys from the random number
+	// generator of the CSP if it implements RNGProvider; see RandReader. Keys
+	// generated by the provider itself, such as ECDSA and RSA keys, are drawn
+	// from the provider's own generator, which is crypto/rand for SW.
+	UseCSPRNG bool
+	// InsecureKeyGenReader, if not nil, is the reader from which
+	// BCCSPKeyRequestGenerate derives the ECDSA keys generated with the SW
+	// provider instead of a secure random source, so that tests can
+	// generate the same keys from the same seed. Keys derived from a
+	// predictable reader are predictable too: this is INSECURE and must only
+	// be used for testing. It requires UnsafeAllowInsecureKeyGen.
+	InsecureKeyGenReader io.Reader
+	// UnsafeAllowInsecureKeyGen must be true for InsecureKeyGenReader to be
+	// set, so that it can't be enabled by accident
+	UnsafeAllowInsecureKeyGen bool
+
+	// keystoreDir is the directory of the SW file keystore of the CSP, if
+	// any, recorded by GetBCCSP so that an empty keystore can be detected
+	keystoreDir string
+	// hashFamily is the hash family configured for the CSP, if any, recorded
+	// by GetBCCSP so that the certificates of its keys can be checked
+	hashFamily string
+}
+
+// configuredCSP is a CSP along with the options set by ConfigureCSP
+type configuredCSP struct {
+	bccsp.BCCSP
+	opts CSPOptions
+	// algs caches the key algorithms supported by the CSP; it is shared by
+	// the configured CSPs of the same provider
+	algs *algorithmCache
+}
+
+// algorithmCache holds the result of SupportedAlgorithms for a CSP, which is
+// computed once since it generates keys
+type algorithmCache struct {
+	once sync.Once
+	algs []AlgorithmSpec
+}
+
+// ConfigureCSP returns a CSP which performs the operations of csp and to which
+// the functions of this package apply opts. The options of a CSP returned by
+// ConfigureCSP are replaced, except for the keystore and hash family recorded
+// by GetBCCSP, which are kept. An error is returned if InsecureKeyGenReader is
+// set without UnsafeAllowInsecureKeyGen.
+func ConfigureCSP(csp bccsp.BCCSP, opts CSPOptions) (bccsp.BCCSP, error) {
+	if csp == nil {
+		return nil, errors.New("CSP was not initialized")
+	}
+	if opts.InsecureKeyGenReader != nil {
+		if !opts.UnsafeAllowInsecureKeyGen {
+			return nil, errors.New("Deterministic key generation is insecure and must be explicitly allowed")
+		}
+		log.Warning("Deterministic key generation is enabled; generated keys are NOT secure")
+	}
+	opts.AllowedECDSACurves = append([]elliptic.Curve(nil), opts.AllowedECDSACurves...)
+	prev := getCSPOptions(csp)
+	opts.keystoreDir = prev.keystoreDir
+	opts.hashFamily = prev.hashFamily
+	return &configuredCSP{BCCSP: baseCSP(csp), opts: opts, algs: algorithmCacheOf(csp)}, nil
+}
+
+// getCSPOptions returns the options set on csp by ConfigureCSP, or the default
+// options if csp was not configured
+func getCSPOptions(csp bccsp.BCCSP) CSPOptions {
+	if c, ok := csp.(*configuredCSP); ok {
+		return c.opts
+	}
+	return CSPOptions{}
+}
+
+// algorithmCacheOf returns the algorithm cache of csp if csp is a configured
+// CSP, or a new one otherwise
+func algorithmCacheOf(csp bccsp.BCCSP) *algorithmCache {
+	if c, ok := csp.(*configuredCSP); ok && c.algs != nil {
+		return c.algs
+	}
+	return &algorithmCache{}
+}
+
+// baseCSP returns the CSP configured by csp if csp was returned by
+// ConfigureCSP, or csp otherwise, so that the provider can be identified
+func baseCSP(csp bccsp.BCCSP) bccsp.BCCSP {
+	if c, ok := csp.(*configuredCSP); ok {
+		return c.BCCSP
+	}
+	return csp
+}
@@ -0,0 +1,508 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/pkg/errors"
+)
+
+// DefaultCSRSizeLimit is the default size limit of an acceptable PEM encoded
+// certificate signing request in bytes
+const DefaultCSRSizeLimit = 1024 * 1024
+
+// CheckCSRSize returns an error if the PEM encoded certificate signing request
+// is larger than limit bytes. If limit is not positive, DefaultCSRSizeLimit is
+// used. This check is cheap and should be done before parsing the request.
+func CheckCSRSize(csrPEM []byte, limit int) error {
+	if limit <= 0 {
+		limit = DefaultCSRSizeLimit
+	}
+	if len(csrPEM) > limit {
+		return errors.Errorf("CSR too large: %d bytes exceeds the limit of %d bytes", len(csrPEM), limit)
+	}
+	return nil
+}
+
+// ParseCSRPEM parses a PEM encoded certificate signing request. A request
+// with an SM2 key must be signed with SM3 with SM2, and its signature is
+// verified as it is parsed; its PublicKey is an *ecdsa.PublicKey on the
+// SM2P256 curve and its SignatureAlgorithm is x509.UnknownSignatureAlgorithm.
+func ParseCSRPEM(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _, err := decodePEM(csrPEM)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to PEM decode certificate signing request")
+	}
+	if block.Type != "NEW CERTIFICATE REQUEST" && block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.Errorf("Invalid PEM block type '%s'; expecting a certificate signing request", block.Type)
+	}
+	return ParseCSR(block.Bytes)
+}
+
+// ParseCSR parses a DER encoded certificate signing request like ParseCSRPEM
+func ParseCSR(der []byte) (*x509.CertificateRequest, error) {
+	if isGMCSR(der) {
+		return parseGMCSR(der)
+	}
+	csrReq, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse certificate signing request")
+	}
+	return csrReq, nil
+}
+
+var (
+	// oidSM2 is the OID of the SM2 curve, which also identifies SM2 public keys
+	oidSM2 = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301}
+	// oidSignatureSM3WithSM2 is the OID of the SM3 with SM2 signature algorithm
+	oidSignatureSM3WithSM2 = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 501}
+)
+
+// gmCertificateRequest is the ASN.1 structure of a certificate signing
+// request, which is decoded without interpreting its algorithms
+type gmCertificateRequest struct {
+	TBSCSR struct {
+		Raw       asn1.RawContent
+		Version   int
+		Subject   asn1.RawValue
+		PublicKey struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		RawAttributes []asn1.RawValue `asn1:"tag:0"`
+	}
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+// isGMCSR returns true if the DER encoded certificate signing request has an
+// SM2 public key or an SM3 with SM2 signature, as produced by GM tools
+func isGMCSR(der []byte) bool {
+	var gmCSR gmCertificateRequest
+	if _, err := asn1.Unmarshal(der, &gmCSR); err != nil {
+		return false
+	}
+	if gmCSR.SignatureAlgorithm.Algorithm.Equal(oidSignatureSM3WithSM2) {
+		return true
+	}
+	keyAlg := gmCSR.TBSCSR.PublicKey.Algorithm
+	if keyAlg.Algorithm.Equal(oidSM2) {
+		return true
+	}
+	var curve asn1.ObjectIdentifier
+	if keyAlg.Algorithm.Equal(oidPublicKeyECDSA) {
+		if _, err := asn1.Unmarshal(keyAlg.Parameters.FullBytes, &curve); err == nil {
+			return curve.Equal(oidSM2)
+		}
+	}
+	return false
+}
+
+// oidExtensionRequest is the OID of the PKCS#9 extensionRequest attribute
+var oidExtensionRequest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+
+// parseGMCSR parses the DER encoded certificate signing request with an SM2
+// public key and verifies its SM3 with SM2 signature
+func parseGMCSR(der []byte) (*x509.CertificateRequest, error) {
+	var gmCSR gmCertificateRequest
+	rest, err := asn1.Unmarshal(der, &gmCSR)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse certificate signing request")
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("Trailing data after the certificate signing request")
+	}
+	tbs := &gmCSR.TBSCSR
+	var nonconformities []string
+	checkGMPublicKey(tbs.PublicKey.Algorithm, tbs.PublicKey.PublicKey, func(format string, args ...interface{}) {
+		nonconformities = append(nonconformities, fmt.Sprintf(format, args...))
+	})
+	if len(nonconformities) > 0 {
+		return nil, errors.Errorf("Invalid SM2 public key in the certificate signing request: %s", strings.Join(nonconformities, "; "))
+	}
+	if !gmCSR.SignatureAlgorithm.Algorithm.Equal(oidSignatureSM3WithSM2) {
+		return nil, errors.Errorf("Unsupported signature algorithm %s for the SM2 key of the certificate signing request",
+			gmCSR.SignatureAlgorithm.Algorithm)
+	}
+	x, y := elliptic.Unmarshal(SM2P256(), tbs.PublicKey.PublicKey.RightAlign())
+	csrReq := &x509.CertificateRequest{
+		Raw:                      der,
+		RawTBSCertificateRequest: tbs.Raw,
+		RawSubject:               tbs.Subject.FullBytes,
+		Version:                  tbs.Version,
+		Signature:                gmCSR.SignatureValue.RightAlign(),
+		PublicKeyAlgorithm:       x509.ECDSA,
+		PublicKey:                &ecdsa.PublicKey{Curve: SM2P256(), X: x, Y: y},
+	}
+	if !verifyGMCSRSignature(csrReq) {
+		return nil, errors.New("Failed to verify the SM2 signature of the certificate signing request")
+	}
+	csrReq.RawSubjectPublicKeyInfo, err = asn1.Marshal(tbs.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode public key")
+	}
+	var rdns pkix.RDNSequence
+	if _, err = asn1.Unmarshal(tbs.Subject.FullBytes, &rdns); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode subject")
+	}
+	csrReq.Subject.FillFromRDNSequence(&rdns)
+	for _, raw := range tbs.RawAttributes {
+		var attr rawCSRAttribute
+		if _, err = asn1.Unmarshal(raw.FullBytes, &attr); err != nil {
+			return nil, errors.Wrap(err, "Failed to parse CSR attribute")
+		}
+		if !attr.Type.Equal(oidExtensionRequest) || len(attr.Values) == 0 {
+			continue
+		}
+		if _, err = asn1.Unmarshal(attr.Values[0].FullBytes, &csrReq.Extensions); err != nil {
+			return nil, errors.Wrap(err, "Failed to parse the requested extensions")
+		}
+	}
+	// The subject alternative names are parsed like those of GM certificates
+	var names x509.Certificate
+	for _, ext := range csrReq.Extensions {
+		if ext.Id.Equal(oidExtSubjectAltName) {
+			if err = parseGMSubjectAltNames(&names, ext.Value); err != nil {
+				return nil, errors.WithMessage(err, "Invalid subject alternative name extension")
+			}
+		}
+	}
+	csrReq.DNSNames, csrReq.EmailAddresses = names.DNSNames, names.EmailAddresses
+	csrReq.IPAddresses, csrReq.URIs = names.IPAddresses, names.URIs
+	return csrReq, nil
+}
+
+// verifyGMCSRSignature returns true if the signature of csrReq is a valid SM2
+// signature by its SM2 public key. GM tools compute the signature with the
+// default user ID, whereas OpenSSL 3.0 uses an empty user ID unless one is
+// set, so both are accepted.
+func verifyGMCSRSignature(csrReq *x509.CertificateRequest) bool {
+	pub, ok := csrReq.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !isSM2Curve(pub.Curve) {
+		return false
+	}
+	if SM2Verify(pub, csrReq.RawTBSCertificateRequest, csrReq.Signature, nil) {
+		return true
+	}
+	e, err := sm2DigestWithID(pub, csrReq.RawTBSCertificateRequest, nil)
+	return err == nil && sm2VerifyDigest(pub, e, csrReq.Signature)
+}
+
+// checkCSRSignature verifies the signature of csrReq, which is an SM2
+// signature if csrReq has an SM2 public key
+func checkCSRSignature(csrReq *x509.CertificateRequest) error {
+	if IsSM2PublicKey(csrReq.PublicKey) {
+		if !verifyGMCSRSignature(csrReq) {
+			return errors.New("SM2 verification failure")
+		}
+		return nil
+	}
+	return csrReq.CheckSignature()
+}
+
+// VerifyCSRPOP verifies the proof-of-possession of the PEM encoded certificate
+// signing request, that is that it is signed by the private key corresponding
+// to the public key it contains
+func VerifyCSRPOP(csrPEM []byte) error {
+	csrReq, err := ParseCSRPEM(csrPEM)
+	if err != nil {
+		return err
+	}
+	return VerifyParsedCSRPOP(csrReq)
+}
+
+// VerifyParsedCSRPOP is like VerifyCSRPOP for a parsed certificate signing
+// request
+func VerifyParsedCSRPOP(csrReq *x509.CertificateRequest) error {
+	err := checkCSRSignature(csrReq)
+	if err != nil {
+		return errors.Wrap(err, "Certificate signing request proof-of-possession verification failed")
+	}
+	return nil
+}
+
+// CSRsEquivalent parses the PEM encoded certificate signing requests a and b
+// and returns true if they request a certificate for the same subject, subject
+// alternative names and public key. The signatures and the other attributes of
+// the requests, such as a challenge password, are ignored, as is the order of
+// the subject alternative names.
+func CSRsEquivalent(a, b []byte) (bool, error) {
+	csrA, err := ParseCSRPEM(a)
+	if err != nil {
+		return false, errors.WithMessage(err, "Failed to parse the first CSR")
+	}
+	csrB, err := ParseCSRPEM(b)
+	if err != nil {
+		return false, errors.WithMessage(err, "Failed to parse the second CSR")
+	}
+	if csrA.Subject.String() != csrB.Subject.String() {
+		return false, nil
+	}
+	if !reflect.DeepEqual(csrSANs(csrA), csrSANs(csrB)) {
+		return false, nil
+	}
+	pubA, err := x509.MarshalPKIXPublicKey(csrA.PublicKey)
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to marshal the public key of the first CSR")
+	}
+	pubB, err := x509.MarshalPKIXPublicKey(csrB.PublicKey)
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to marshal the public key of the second CSR")
+	}
+	return bytes.Equal(pubA, pubB), nil
+}
+
+// csrSANs returns the subject alternative names of csrReq in canonical form:
+// prefixed with their type, DNS names and email addresses in lower case, and
+// sorted
+func csrSANs(csrReq *x509.CertificateRequest) []string {
+	var sans []string
+	for _, name := range csrReq.DNSNames {
+		sans = append(sans, "DNS:"+strings.ToLower(name))
+	}
+	for _, email := range csrReq.EmailAddresses {
+		sans = append(sans, "email:"+strings.ToLower(email))
+	}
+	for _, ip := range csrReq.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range csrReq.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	sort.Strings(sans)
+	return sans
+}
+
+// CheckCSRSignatureAlgorithm parses the PEM encoded certificate signing
+// request and returns an error if its declared signature algorithm is not an
+// algorithm of the type of its public key, e.g. an RSA signature algorithm in
+// a CSR carrying an ECDSA key. A CSR with an SM2 key is signed with SM3 with
+// SM2, as checked by ParseCSRPEM.
+func CheckCSRSignatureAlgorithm(csrPEM []byte) error {
+	csrReq, err := ParseCSRPEM(csrPEM)
+	if err != nil {
+		return err
+	}
+	return CheckParsedCSRSignatureAlgorithm(csrReq)
+}
+
+// CheckParsedCSRSignatureAlgorithm is like CheckCSRSignatureAlgorithm for a
+// parsed certificate signing request
+func CheckParsedCSRSignatureAlgorithm(csrReq *x509.CertificateRequest) error {
+	if IsSM2PublicKey(csrReq.PublicKey) {
+		return nil
+	}
+	var keyAlgo x509.PublicKeyAlgorithm
+	switch csrReq.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		keyAlgo = x509.ECDSA
+	case *rsa.PublicKey:
+		keyAlgo = x509.RSA
+	default:
+		return errors.Errorf("Unsupported public key type %T in the CSR", csrReq.PublicKey)
+	}
+	if csrReq.PublicKeyAlgorithm != keyAlgo {
+		return errors.Errorf("The public key algorithm %s of the CSR does not match its %s public key",
+			csrReq.PublicKeyAlgorithm, keyAlgo)
+	}
+	sigKeyAlgo := signatureAlgorithmKeyType(csrReq.SignatureAlgorithm)
+	if sigKeyAlgo != x509.ECDSA && sigKeyAlgo != x509.RSA {
+		return errors.Errorf("Unsupported signature algorithm %s in the CSR", csrReq.SignatureAlgorithm)
+	}
+	if sigKeyAlgo != keyAlgo {
+		return errors.Errorf("The signature algorithm %s of the CSR does not match its %s public key",
+			csrReq.SignatureAlgorithm, keyAlgo)
+	}
+	return nil
+}
+
+// CheckCSRKeyStrength parses the PEM encoded certificate signing request and
+// returns an error if its public key is weaker than minECDSA bits for ECDSA
+// keys or minRSA bits for RSA keys. SM2 keys, which are on the 256-bit SM2P256
+// curve, are always accepted. Keys of any other type are rejected.
+func CheckCSRKeyStrength(csrPEM []byte, minECDSA int, minRSA int) error {
+	csrReq, err := ParseCSRPEM(csrPEM)
+	if err != nil {
+		return err
+	}
+	switch pub := csrReq.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if isSM2Curve(pub.Curve) {
+			break
+		}
+		size := pub.Curve.Params().BitSize
+		if size < minECDSA {
+			return errors.Errorf("The ECDSA key size of %d bits in the CSR is less than the minimum of %d bits", size, minECDSA)
+		}
+	case *rsa.PublicKey:
+		size := pub.N.BitLen()
+		if size < minRSA {
+			return errors.Errorf("The RSA key size of %d bits in the CSR is less than the minimum of %d bits", size, minRSA)
+		}
+	default:
+		return errors.Errorf("Unsupported public key type %T in the CSR", pub)
+	}
+	return nil
+}
+
+// challengePasswordOID is the OID of the PKCS#9 challengePassword attribute
+var challengePasswordOID = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+// CSRAttribute is an attribute of a certificate signing request (RFC 2986).
+// Each value is ASN.1 encoded with encoding/asn1.
+type CSRAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []interface{}
+}
+
+// ChallengePasswordAttribute returns the PKCS#9 challengePassword attribute
+// with the specified password
+func ChallengePasswordAttribute(password string) CSRAttribute {
+	return CSRAttribute{Type: challengePasswordOID, Values: []interface{}{password}}
+}
+
+// ASN.1 structures of a certificate signing request, which allow attributes
+// of any form, unlike the ones of crypto/x509
+type rawCSR struct {
+	TBS                asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+type rawTBSCSR struct {
+	Version       int
+	Subject       asn1.RawValue
+	PublicKey     asn1.RawValue
+	RawAttributes []asn1.RawValue `asn1:"tag:0"`
+}
+
+type rawCSRAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// GenerateCSRWithAttributes generates a PEM encoded certificate signing
+// request like csr.Generate, which also contains attrs
+func GenerateCSRWithAttributes(priv crypto.Signer, req *csr.CertificateRequest, attrs []CSRAttribute) ([]byte, error) {
+	csrPEM, err := csr.Generate(priv, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs) == 0 {
+		return csrPEM, nil
+	}
+	csrReq, err := ParseCSRPEM(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	var outer rawCSR
+	if _, err = asn1.Unmarshal(csrReq.Raw, &outer); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse certificate signing request")
+	}
+	var tbs rawTBSCSR
+	if _, err = asn1.Unmarshal(csrReq.RawTBSCertificateRequest, &tbs); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse certificate signing request info")
+	}
+	for _, attr := range attrs {
+		rawAttr := rawCSRAttribute{Type: attr.Type}
+		for _, value := range attr.Values {
+			der, err := asn1.Marshal(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to marshal value of CSR attribute %s", attr.Type)
+			}
+			rawAttr.Values = append(rawAttr.Values, asn1.RawValue{FullBytes: der})
+		}
+		der, err := asn1.Marshal(rawAttr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to marshal CSR attribute %s", attr.Type)
+		}
+		tbs.RawAttributes = append(tbs.RawAttributes, asn1.RawValue{FullBytes: der})
+	}
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal certificate signing request info")
+	}
+	// Sign the new request info with the same signature algorithm
+	hash, err := signatureAlgorithmHash(csrReq.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(tbsDER)
+	sig, err := priv.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sign certificate signing request")
+	}
+	outer.TBS = asn1.RawValue{FullBytes: tbsDER}
+	outer.SignatureValue = asn1.BitString{Bytes: sig, BitLength: len(sig) * 8}
+	der, err := asn1.Marshal(outer)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal certificate signing request")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// GetCSRChallengePassword returns the PKCS#9 challengePassword attribute of
+// the PEM encoded certificate signing request, or an empty string if it has
+// none
+func GetCSRChallengePassword(csrPEM []byte) (string, error) {
+	csrReq, err := ParseCSRPEM(csrPEM)
+	if err != nil {
+		return "", err
+	}
+	var tbs rawTBSCSR
+	if _, err = asn1.Unmarshal(csrReq.RawTBSCertificateRequest, &tbs); err != nil {
+		return "", errors.Wrap(err, "Failed to parse certificate signing request info")
+	}
+	for _, raw := range tbs.RawAttributes {
+		var attr rawCSRAttribute
+		if _, err = asn1.Unmarshal(raw.FullBytes, &attr); err != nil {
+			return "", errors.Wrap(err, "Failed to parse CSR attribute")
+		}
+		if !attr.Type.Equal(challengePasswordOID) {
+			continue
+		}
+		if len(attr.Values) != 1 {
+			return "", errors.Errorf("The challengePassword attribute must have exactly one value, not %d", len(attr.Values))
+		}
+		var password string
+		if _, err = asn1.Unmarshal(attr.Values[0].FullBytes, &password); err != nil {
+			return "", errors.Wrap(err, "Invalid challengePassword attribute")
+		}
+		return password, nil
+	}
+	return "", nil
+}
+
+// signatureAlgorithmHash returns the hash algorithm used by the ECDSA or RSA
+// signature algorithm alg
+func signatureAlgorithmHash(alg x509.SignatureAlgorithm) (crypto.Hash, error) {
+	switch alg {
+	case x509.ECDSAWithSHA256, x509.SHA256WithRSA:
+		return crypto.SHA256, nil
+	case x509.ECDSAWithSHA384, x509.SHA384WithRSA:
+		return crypto.SHA384, nil
+	case x509.ECDSAWithSHA512, x509.SHA512WithRSA:
+		return crypto.SHA512, nil
+	}
+	return 0, errors.Errorf("Unsupported signature algorithm %s", alg)
+}
@@ -0,0 +1,250 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfssl/csr"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyCSRPOP(t *testing.T) {
+	csrPEM := newTestCSR(t, "user1")
+	assert.NoError(t, VerifyCSRPOP(csrPEM))
+
+	// Tamper with the signature of the CSR
+	block, _ := pem.Decode(csrPEM)
+	tampered := make([]byte, len(block.Bytes))
+	copy(tampered, block.Bytes)
+	tampered[len(tampered)-5] ^= 0xFF
+	tamperedPEM := pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: tampered})
+	err := VerifyCSRPOP(tamperedPEM)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "proof-of-possession verification failed")
+	}
+	// The parsed form of the CSR is checked the same way
+	tamperedReq, err := ParseCSR(tampered)
+	FatalError(t, err, "Failed to parse CSR")
+	assert.Error(t, VerifyParsedCSRPOP(tamperedReq))
+	csrReq, err := ParseCSR(block.Bytes)
+	FatalError(t, err, "Failed to parse CSR")
+	assert.NoError(t, VerifyParsedCSRPOP(csrReq))
+	assert.NoError(t, CheckParsedCSRSignatureAlgorithm(csrReq))
+
+	certPEM, err := ioutil.ReadFile(filepath.Join("testdata", "ec.pem"))
+	assert.NoError(t, err)
+	err = VerifyCSRPOP(certPEM)
+	assert.Error(t, err)
+
+	err = VerifyCSRPOP([]byte("garbage"))
+	assert.Error(t, err)
+}
+
+func TestParseCSRPEM(t *testing.T) {
+	csrReq, err := ParseCSRPEM(newTestCSR(t, "user1"))
+	FatalError(t, err, "Failed to parse ECDSA CSR")
+	assert.Equal(t, "user1", csrReq.Subject.CommonName)
+	assert.Equal(t, x509.ECDSA, csrReq.PublicKeyAlgorithm)
+
+	// CSR generated with an SM2 key and an SM3 with SM2 signature by
+	// openssl req -new -key sm2.key -sm3
+	gmCSR, err := ioutil.ReadFile(filepath.Join("testdata", "sm2-csr.pem"))
+	FatalError(t, err, "Failed to read GM CSR")
+	csrReq, err = ParseCSRPEM(gmCSR)
+	FatalError(t, err, "Failed to parse GM CSR")
+	assert.Equal(t, "gmuser", csrReq.Subject.CommonName)
+	assert.Equal(t, x509.ECDSA, csrReq.PublicKeyAlgorithm)
+	assert.True(t, IsSM2PublicKey(csrReq.PublicKey))
+	assert.NoError(t, VerifyCSRPOP(gmCSR))
+	assert.NoError(t, CheckCSRSignatureAlgorithm(gmCSR))
+
+	// CSR signed with the default user ID, as GM tools do, by
+	// openssl req -new -key sm2.key -sm3 -sigopt distid:1234567812345678
+	gmCSR, err = ioutil.ReadFile(filepath.Join("testdata", "sm2-id-csr.pem"))
+	FatalError(t, err, "Failed to read GM CSR")
+	csrReq, err = ParseCSRPEM(gmCSR)
+	FatalError(t, err, "Failed to parse GM CSR signed with the default user ID")
+	assert.Equal(t, "gmuser3", csrReq.Subject.CommonName)
+	assert.NoError(t, VerifyCSRPOP(gmCSR))
+
+	// The requested subject alternative names are parsed
+	gmCSR, err = ioutil.ReadFile(filepath.Join("testdata", "sm2-san-csr.pem"))
+	FatalError(t, err, "Failed to read GM CSR")
+	csrReq, err = ParseCSRPEM(gmCSR)
+	FatalError(t, err, "Failed to parse GM CSR")
+	assert.Equal(t, "gmuser2", csrReq.Subject.CommonName)
+	assert.Equal(t, []string{"Org1"}, csrReq.Subject.Organization)
+	assert.Equal(t, []string{"gm.example.com"}, csrReq.DNSNames)
+	assert.Equal(t, []string{"gm@example.com"}, csrReq.EmailAddresses)
+	if assert.Len(t, csrReq.IPAddresses, 1) {
+		assert.Equal(t, "10.0.0.1", csrReq.IPAddresses[0].String())
+	}
+
+	// A GM CSR with an invalid signature is rejected
+	block, _ := pem.Decode(gmCSR)
+	der := append([]byte{}, block.Bytes...)
+	der[len(der)-1] ^= 0x01
+	_, err = ParseCSRPEM(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Failed to verify the SM2 signature")
+	}
+}
+
+func TestCheckCSRSize(t *testing.T) {
+	csrPEM := newTestCSR(t, "user1")
+	assert.NoError(t, CheckCSRSize(csrPEM, 0))
+	assert.NoError(t, CheckCSRSize(csrPEM, len(csrPEM)))
+	err := CheckCSRSize(csrPEM, len(csrPEM)-1)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "CSR too large")
+	}
+	err = CheckCSRSize(make([]byte, DefaultCSRSizeLimit+1), 0)
+	assert.Error(t, err, "CSR larger than the default limit should be rejected")
+}
+
+func TestCheckCSRKeyStrength(t *testing.T) {
+	newCSR := func(priv interface{}) []byte {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "user1"}}, priv)
+		FatalError(t, err, "Failed to create CSR")
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	FatalError(t, err, "Failed to generate RSA key")
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	FatalError(t, err, "Failed to generate RSA key")
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+
+	err = CheckCSRKeyStrength(newCSR(rsa1024), 256, 2048)
+	if assert.Error(t, err, "1024-bit RSA key should be rejected") {
+		assert.Contains(t, err.Error(), "RSA key size of 1024 bits")
+	}
+	assert.NoError(t, CheckCSRKeyStrength(newCSR(rsa2048), 256, 2048))
+	assert.NoError(t, CheckCSRKeyStrength(newTestCSR(t, "user1"), 256, 2048))
+	assert.Error(t, CheckCSRKeyStrength(newCSR(p224), 256, 2048), "P-224 key should be rejected")
+	assert.Error(t, CheckCSRKeyStrength([]byte("garbage"), 256, 2048))
+
+	// SM2 keys are accepted whatever the minimum ECDSA key size
+	gmCSR, err := ioutil.ReadFile(filepath.Join("testdata", "sm2-csr.pem"))
+	FatalError(t, err, "Failed to read GM CSR")
+	assert.NoError(t, CheckCSRKeyStrength(gmCSR, 256, 2048))
+	assert.NoError(t, CheckCSRKeyStrength(gmCSR, 384, 2048))
+}
+
+func TestCheckCSRSignatureAlgorithm(t *testing.T) {
+	csrPEM := newTestCSR(t, "user1")
+	assert.NoError(t, CheckCSRSignatureAlgorithm(csrPEM))
+
+	// Claim an RSA signature algorithm for the ECDSA key of the CSR
+	block, _ := pem.Decode(csrPEM)
+	var outer struct {
+		TBS    asn1.RawValue
+		SigAlg pkix.AlgorithmIdentifier
+		Sig    asn1.BitString
+	}
+	_, err := asn1.Unmarshal(block.Bytes, &outer)
+	FatalError(t, err, "Failed to unmarshal CSR")
+	outer.SigAlg = pkix.AlgorithmIdentifier{
+		Algorithm:  asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11},
+		Parameters: asn1.NullRawValue,
+	}
+	der, err := asn1.Marshal(outer)
+	FatalError(t, err, "Failed to marshal CSR")
+	mismatchedPEM := pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	err = CheckCSRSignatureAlgorithm(mismatchedPEM)
+	if assert.Error(t, err, "CSR with an RSA signature algorithm and an ECDSA key should be rejected") {
+		assert.Contains(t, err.Error(), "does not match its ECDSA public key")
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	FatalError(t, err, "Failed to generate RSA key")
+	der, err = x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "user1"}}, rsaKey)
+	FatalError(t, err, "Failed to create CSR")
+	assert.NoError(t, CheckCSRSignatureAlgorithm(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})))
+	assert.Error(t, CheckCSRSignatureAlgorithm([]byte("garbage")))
+}
+
+func TestCSRsEquivalent(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	newCSR := func(tmpl *x509.CertificateRequest) []byte {
+		der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, priv)
+		FatalError(t, err, "Failed to create CSR")
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+	subject := pkix.Name{CommonName: "peer1", Organization: []string{"Org1"}}
+	csr1 := newCSR(&x509.CertificateRequest{Subject: subject, DNSNames: []string{"peer1.example.com", "peer1"}})
+	// ECDSA signatures are randomized, so the signatures of both CSRs differ
+	csr2 := newCSR(&x509.CertificateRequest{Subject: subject, DNSNames: []string{"PEER1", "peer1.example.com"}})
+	assert.NotEqual(t, csr1, csr2)
+	equivalent, err := CSRsEquivalent(csr1, csr2)
+	FatalError(t, err, "Failed to compare CSRs")
+	assert.True(t, equivalent, "CSRs differing only in their signatures should be equivalent")
+
+	csr3 := newCSR(&x509.CertificateRequest{Subject: subject, DNSNames: []string{"peer1.example.com", "peer2"}})
+	equivalent, err = CSRsEquivalent(csr1, csr3)
+	FatalError(t, err, "Failed to compare CSRs")
+	assert.False(t, equivalent, "CSRs with different SANs should not be equivalent")
+
+	csr4 := newCSR(&x509.CertificateRequest{Subject: pkix.Name{CommonName: "peer2", Organization: []string{"Org1"}},
+		DNSNames: []string{"peer1.example.com", "peer1"}})
+	equivalent, err = CSRsEquivalent(csr1, csr4)
+	FatalError(t, err, "Failed to compare CSRs")
+	assert.False(t, equivalent, "CSRs with different subjects should not be equivalent")
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject,
+		DNSNames: []string{"peer1.example.com", "peer1"}}, other)
+	FatalError(t, err, "Failed to create CSR")
+	equivalent, err = CSRsEquivalent(csr1, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	FatalError(t, err, "Failed to compare CSRs")
+	assert.False(t, equivalent, "CSRs with different keys should not be equivalent")
+
+	_, err = CSRsEquivalent(csr1, []byte("garbage"))
+	assert.Error(t, err)
+}
+
+func TestGenerateCSRWithAttributes(t *testing.T) {
+	req := &csr.CertificateRequest{
+		CN:         "user1",
+		Hosts:      []string{"peer1.example.com"},
+		KeyRequest: csr.NewKeyRequest(),
+	}
+	_, cspSigner, err := BCCSPKeyRequestGenerateWithEphemeral(req, csp, true)
+	FatalError(t, err, "Failed to generate key")
+
+	csrPEM, err := GenerateCSRWithAttributes(cspSigner, req, []CSRAttribute{ChallengePasswordAttribute("secret")})
+	FatalError(t, err, "Failed to generate CSR with attributes")
+	assert.NoError(t, VerifyCSRPOP(csrPEM), "The CSR with attributes must be correctly signed")
+	csrReq, err := ParseCSRPEM(csrPEM)
+	FatalError(t, err, "Failed to parse CSR")
+	assert.Equal(t, "user1", csrReq.Subject.CommonName)
+	assert.Equal(t, []string{"peer1.example.com"}, csrReq.DNSNames)
+	password, err := GetCSRChallengePassword(csrPEM)
+	FatalError(t, err, "Failed to get challenge password")
+	assert.Equal(t, "secret", password)
+
+	// Without attributes, the CSR has no challenge password
+	csrPEM, err = GenerateCSRWithAttributes(cspSigner, req, nil)
+	FatalError(t, err, "Failed to generate CSR")
+	password, err = GetCSRChallengePassword(csrPEM)
+	FatalError(t, err, "Failed to get challenge password")
+	assert.Empty(t, password)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"context"
+	"crypto/x509"
+	"math/big"
+
+	"github.com/cloudflare/cfssl/signer"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/pkg/errors"
+)
+
+// CTLogClient submits precertificates to a certificate transparency log. The
+// LogClient of github.com/google/certificate-transparency-go/client
+// implements this interface.
+type CTLogClient interface {
+	// AddPreChain submits the precertificate chain, leaf first, to the log
+	// and returns the signed certificate timestamp issued by the log
+	AddPreChain(ctx context.Context, chain []ct.ASN1Cert) (*ct.SignedCertificateTimestamp, error)
+}
+
+// precertSigner is a signer which can issue a certificate from a
+// precertificate it signed previously, such as the cfssl local signer
+type precertSigner interface {
+	signer.Signer
+	Certificate(label, profile string) (*x509.Certificate, error)
+	SignFromPrecert(precert *x509.Certificate, scts []ct.SignedCertificateTimestamp) ([]byte, error)
+}
+
+// IssueCertificateWithSCTs signs req with s like IssueCertificate, embedding
+// in the certificate the signed certificate timestamps (SCTs) of the
+// certificate transparency logs. A precertificate is signed first and
+// submitted to each of the logs; the certificate is then issued from the
+// precertificate with the SCT list extension. s must support signing from a
+// precertificate, which is the case of the signers returned by
+// BccspBackedSigner.
+func IssueCertificateWithSCTs(ctx context.Context, s signer.Signer, req signer.SignRequest, logs []CTLogClient) ([]byte, *big.Int, error) {
+	if s == nil {
+		return nil, nil, errors.New("Signer must be different from nil")
+	}
+	ps, ok := s.(precertSigner)
+	if !ok {
+		return nil, nil, errors.Errorf("Signer of type %T can't sign certificates from precertificates", s)
+	}
+	if len(logs) == 0 {
+		return nil, nil, errors.New("No certificate transparency log was provided")
+	}
+	req.ReturnPrecert = true
+	precertPEM, err := s.Sign(req)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Precertificate signing failure")
+	}
+	precert, err := GetX509CertificateFromPEM(precertPEM)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Failed to parse the precertificate")
+	}
+	caCert, err := ps.Certificate(req.Label, req.Profile)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to get the signer certificate")
+	}
+	chain := []ct.ASN1Cert{{Data: precert.Raw}, {Data: caCert.Raw}}
+	scts := make([]ct.SignedCertificateTimestamp, 0, len(logs))
+	for i, log := range logs {
+		if log == nil {
+			return nil, nil, errors.Errorf("Certificate transparency log %d is nil", i)
+		}
+		sct, err := log.AddPreChain(ctx, chain)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Failed to submit the precertificate to a certificate transparency log")
+		}
+		if sct == nil {
+			return nil, nil, errors.New("A certificate transparency log returned no signed certificate timestamp")
+		}
+		scts = append(scts, *sct)
+	}
+	certPEM, err := ps.SignFromPrecert(precert, scts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to sign the certificate from the precertificate")
+	}
+	return certPEM, precert.SerialNumber, nil
+}
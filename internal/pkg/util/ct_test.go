@@ -0,0 +1,89 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/signer"
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockCTLog struct {
+	chain []ct.ASN1Cert
+	sct   *ct.SignedCertificateTimestamp
+	err   error
+}
+
+func (m *mockCTLog) AddPreChain(ctx context.Context, chain []ct.ASN1Cert) (*ct.SignedCertificateTimestamp, error) {
+	m.chain = chain
+	return m.sct, m.err
+}
+
+func TestIssueCertificateWithSCTs(t *testing.T) {
+	s := newTestCASigner(t)
+	req := signer.SignRequest{Request: string(newTestCSR(t, "user1"))}
+
+	sct := &ct.SignedCertificateTimestamp{
+		SCTVersion: ct.V1,
+		LogID:      ct.LogID{KeyID: [32]byte{1, 2, 3}},
+		Timestamp:  1234567890,
+		Extensions: ct.CTExtensions{},
+		Signature: ct.DigitallySigned{
+			Algorithm: cttls.SignatureAndHashAlgorithm{Hash: cttls.SHA256, Signature: cttls.ECDSA},
+			Signature: []byte("signature"),
+		},
+	}
+	log := &mockCTLog{sct: sct}
+	certPEM, serial, err := IssueCertificateWithSCTs(context.Background(), s, req, []CTLogClient{log})
+	FatalError(t, err, "Failed to issue certificate with SCTs")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse issued certificate")
+	assert.Equal(t, "user1", cert.Subject.CommonName)
+	assert.Equal(t, 0, serial.Cmp(cert.SerialNumber))
+
+	// The log got the precertificate, which has the same serial number
+	if assert.Len(t, log.chain, 2) {
+		precert, err := x509.ParseCertificate(log.chain[0].Data)
+		FatalError(t, err, "Failed to parse precertificate")
+		assert.Equal(t, 0, serial.Cmp(precert.SerialNumber))
+	}
+
+	var scts []ct.SignedCertificateTimestamp
+	for _, ext := range cert.Extensions {
+		assert.False(t, ext.Id.Equal(signer.CTPoisonOID), "The certificate should not have the poison extension")
+		if ext.Id.Equal(signer.SCTListOID) {
+			var list []byte
+			_, err = asn1.Unmarshal(ext.Value, &list)
+			FatalError(t, err, "Failed to unmarshal SCT list extension")
+			scts, err = helpers.DeserializeSCTList(list)
+			FatalError(t, err, "Failed to deserialize SCT list")
+		}
+	}
+	if assert.Len(t, scts, 1, "The SCT should be embedded in the certificate") {
+		assert.Equal(t, *sct, scts[0])
+	}
+
+	_, _, err = IssueCertificateWithSCTs(context.Background(), s, req, []CTLogClient{&mockCTLog{err: errors.New("log unavailable")}})
+	if assert.Error(t, err, "Issuance should fail if the CT log fails") {
+		assert.Contains(t, err.Error(), "log unavailable")
+	}
+	_, _, err = IssueCertificateWithSCTs(context.Background(), s, req, []CTLogClient{&mockCTLog{}})
+	assert.Error(t, err, "Issuance should fail if the CT log returns no SCT")
+	_, _, err = IssueCertificateWithSCTs(context.Background(), s, req, nil)
+	assert.Error(t, err, "Issuance should fail without CT logs")
+	_, _, err = IssueCertificateWithSCTs(context.Background(), nil, req, []CTLogClient{log})
+	assert.Error(t, err, "Issuance should fail without a signer")
+}
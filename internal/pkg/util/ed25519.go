@@ -0,0 +1,57 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/ed25519"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/pkg/errors"
+)
+
+// ed25519Algorithm is the name of the Ed25519 key algorithm
+const ed25519Algorithm = "ED25519"
+
+// ed25519KeyGenOpts are the options to generate an Ed25519 key. No BCCSP
+// provider supports Ed25519, so Ed25519 keys are held by a software signer
+// and can't be stored in a keystore.
+type ed25519KeyGenOpts struct {
+	Temporary bool
+}
+
+// Algorithm returns the key generation algorithm identifier
+func (opts *ed25519KeyGenOpts) Algorithm() string {
+	return ed25519Algorithm
+}
+
+// Ephemeral returns true if the key to generate has to be ephemeral
+func (opts *ed25519KeyGenOpts) Ephemeral() bool {
+	return opts.Temporary
+}
+
+// wrapEd25519Key returns a BCCSP key for the Ed25519 private key priv, held by
+// a software signer. An error is returned if the key must be stored in the
+// keystore of the CSP, because no BCCSP provider can store Ed25519 keys.
+func wrapEd25519Key(priv ed25519.PrivateKey, temporary bool) (bccsp.Key, error) {
+	if !temporary {
+		return nil, errors.New("The CSP cannot store Ed25519 keys; Ed25519 keys can only be used as temporary keys")
+	}
+	return WrapSigner(priv)
+}
+
+// generateEd25519Key generates an Ed25519 key as specified by opts, drawn from
+// the random number generator of csp
+func generateEd25519Key(csp bccsp.BCCSP, opts *ed25519KeyGenOpts) (bccsp.Key, error) {
+	if !opts.Temporary {
+		return nil, errors.New("The CSP cannot store Ed25519 keys; Ed25519 keys can only be generated as ephemeral keys")
+	}
+	_, priv, err := ed25519.GenerateKey(RandReader(csp))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate Ed25519 key")
+	}
+	return wrapEd25519Key(priv, true)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/cloudflare/cfssl/csr"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportEd25519Key(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	FatalError(t, err, "Failed to generate Ed25519 key")
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	FatalError(t, err, "Failed to marshal Ed25519 key")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	key, err := ImportBCCSPKeyFromPEMBytes(keyPEM, csp, true)
+	FatalError(t, err, "Failed to import Ed25519 key")
+	assert.True(t, key.Private())
+	signer, err := NewCryptoSigner(csp, key)
+	FatalError(t, err, "Failed to get signer for Ed25519 key")
+	assert.Equal(t, pub, signer.Public())
+
+	msg := []byte("message")
+	sig, err := signer.Sign(rand.Reader, msg, crypto.Hash(0))
+	FatalError(t, err, "Failed to sign with Ed25519 key")
+	assert.True(t, ed25519.Verify(pub, msg, sig), "The Ed25519 signature should be valid")
+	assert.False(t, ed25519.Verify(pub, []byte("other message"), sig))
+
+	_, err = ImportBCCSPKeyFromPEMBytes(keyPEM, csp, false)
+	if assert.Error(t, err, "Ed25519 keys can't be stored in the keystore") {
+		assert.Contains(t, err.Error(), "The CSP cannot store Ed25519 keys")
+	}
+}
+
+func TestGenerateEd25519Key(t *testing.T) {
+	req := &csr.CertificateRequest{KeyRequest: &csr.KeyRequest{A: "ed25519"}}
+	key, signer, err := BCCSPKeyRequestGenerateWithEphemeral(req, csp, true)
+	FatalError(t, err, "Failed to generate Ed25519 key")
+	assert.True(t, key.Private())
+	pub, ok := signer.Public().(ed25519.PublicKey)
+	if assert.True(t, ok, "The generated key should be an Ed25519 key") {
+		msg := []byte("message")
+		sig, err := signer.Sign(rand.Reader, msg, crypto.Hash(0))
+		FatalError(t, err, "Failed to sign with Ed25519 key")
+		assert.True(t, ed25519.Verify(pub, msg, sig), "The Ed25519 signature should be valid")
+	}
+
+	_, _, err = BCCSPKeyRequestGenerate(req, csp)
+	if assert.Error(t, err, "Ed25519 keys can't be stored in the keystore") {
+		assert.Contains(t, err.Error(), "The CSP cannot store Ed25519 keys")
+	}
+	_, _, err = BCCSPKeyRequestGenerateWithEphemeral(&csr.CertificateRequest{KeyRequest: &csr.KeyRequest{A: "ed25519", S: 384}}, csp, true)
+	assert.Error(t, err, "Ed25519 keys have a fixed size")
+}
@@ -0,0 +1,146 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// SM2Certificate is the GM form of a certificate, which holds the algorithms
+// and signature that crypto/x509 can't interpret for SM2 certificates
+type SM2Certificate struct {
+	// Raw is the DER encoding of the certificate
+	Raw []byte
+	// RawTBSCertificate is the DER encoding of the signed content
+	RawTBSCertificate []byte
+	// SignatureAlgorithm is the OID of the signature algorithm, e.g.
+	// 1.2.156.10197.1.501 for SM3 with SM2
+	SignatureAlgorithm asn1.ObjectIdentifier
+	// Signature is the signature of RawTBSCertificate by the issuer
+	Signature []byte
+	// PublicKey is the SM2 public key of the subject, or nil if it isn't
+	// an SM2 key
+	PublicKey *ecdsa.PublicKey
+}
+
+// CheckSignatureFrom verifies that the SM3 with SM2 signature of c was made
+// by the private key of parent, with the default user ID
+func (c *SM2Certificate) CheckSignatureFrom(parent *SM2Certificate) error {
+	if !c.SignatureAlgorithm.Equal(oidSignatureSM3WithSM2) {
+		return errors.Errorf("Unsupported signature algorithm %s; must be SM3 with SM2", c.SignatureAlgorithm)
+	}
+	if parent.PublicKey == nil {
+		return errors.New("The issuer certificate does not have an SM2 public key")
+	}
+	if !SM2Verify(parent.PublicKey, c.RawTBSCertificate, c.Signature, nil) {
+		return errors.New("SM2 verification failure")
+	}
+	return nil
+}
+
+// ParseCertBoth parses the PEM encoded certificate in certFile once and
+// returns both its GM and its crypto/x509 forms. The x509.Certificate of an
+// SM2 certificate, which crypto/x509 can't parse, holds the fields needed to
+// describe it, as decoded by the GM parser; its signature is not verified.
+func ParseCertBoth(certFile string) (*SM2Certificate, *x509.Certificate, error) {
+	pemBytes, err := ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _, err := decodePEM(pemBytes)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, fmt.Sprintf("Failed to PEM decode certificate in '%s'", certFile))
+	}
+	if block.Type != "CERTIFICATE" {
+		return nil, nil, errors.Errorf("The PEM block in '%s' is of type '%s' instead of 'CERTIFICATE'", certFile, block.Type)
+	}
+	gmCert, x509Cert, err := parseCertBoth(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, fmt.Sprintf("Invalid certificate in '%s'", certFile))
+	}
+	return gmCert, x509Cert, nil
+}
+
+// parseCertBoth returns the GM and crypto/x509 forms of the DER encoded
+// certificate
+func parseCertBoth(der []byte) (*SM2Certificate, *x509.Certificate, error) {
+	var x509Cert *x509.Certificate
+	var err error
+	if isGMCertificate(der) {
+		x509Cert, err = parseGMCertificate(der)
+	} else {
+		x509Cert, err = x509.ParseCertificate(der)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to parse certificate")
+	}
+	var raw gmCertificate
+	if _, err = asn1.Unmarshal(der, &raw); err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to decode certificate")
+	}
+	gmCert := &SM2Certificate{
+		Raw:                der,
+		RawTBSCertificate:  raw.TBSCertificate.Raw,
+		SignatureAlgorithm: raw.SignatureAlgorithm.Algorithm,
+		Signature:          raw.SignatureValue.RightAlign(),
+	}
+	if pub, ok := x509Cert.PublicKey.(*ecdsa.PublicKey); ok && isSM2Curve(pub.Curve) {
+		gmCert.PublicKey = pub
+	}
+	return gmCert, x509Cert, nil
+}
+
+// ConvertGMCertToX509File reads the SM2 certificate in inPath, which is
+// either PEM or DER encoded as GM tools produce it, and writes it to outPath
+// in the standard PEM encoding read by non-GM tools. The DER encoding of the
+// certificate is unchanged, so its signature remains valid; this is checked
+// by parsing the written certificate and, if it is self-issued, by
+// verifying its signature.
+func ConvertGMCertToX509File(inPath, outPath string) error {
+	in, err := ReadFile(inPath)
+	if err != nil {
+		return err
+	}
+	der := in
+	if block, _, err := decodePEM(in); err == nil {
+		if block.Type != "CERTIFICATE" {
+			return errors.Errorf("The PEM block in '%s' is of type '%s' instead of 'CERTIFICATE'", inPath, block.Type)
+		}
+		der = block.Bytes
+	}
+	if !isGMCertificate(der) {
+		return errors.Errorf("The certificate in '%s' is not an SM2 certificate", inPath)
+	}
+	gmCert, x509Cert, err := parseCertBoth(der)
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("Invalid SM2 certificate in '%s'", inPath))
+	}
+	out := CertificateToPEM(x509Cert.Raw, nil)
+	block, _, err := decodePEM(out)
+	if err != nil {
+		return errors.WithMessage(err, "Failed to decode the converted certificate")
+	}
+	outCert, _, err := parseCertBoth(block.Bytes)
+	if err != nil {
+		return errors.WithMessage(err, "Failed to parse the converted certificate")
+	}
+	if !bytes.Equal(outCert.RawTBSCertificate, gmCert.RawTBSCertificate) || !bytes.Equal(outCert.Signature, gmCert.Signature) {
+		return errors.New("The converted certificate differs from the SM2 certificate")
+	}
+	if bytes.Equal(x509Cert.RawIssuer, x509Cert.RawSubject) {
+		if err = outCert.CheckSignatureFrom(outCert); err != nil {
+			return errors.WithMessage(err, fmt.Sprintf("Invalid signature of the self-issued certificate in '%s'", inPath))
+		}
+	}
+	return WriteFile(outPath, out, 0644)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCertBoth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gmcert")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+
+	caKey, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	key, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	caDER := createGMCertificate(t, "gmca", "gmca", caKey, caKey, nil)
+	caFile := filepath.Join(dir, "sm2-ca.pem")
+	FatalError(t, ioutil.WriteFile(caFile, CertificateToPEM(caDER, nil), 0644), "Failed to write certificate")
+	certFile := filepath.Join(dir, "sm2-cert.pem")
+	der := createGMCertificate(t, "gmca", "peer0", caKey, key, nil)
+	FatalError(t, ioutil.WriteFile(certFile, CertificateToPEM(der, nil), 0644), "Failed to write certificate")
+
+	gmCert, x509Cert, err := ParseCertBoth(certFile)
+	FatalError(t, err, "Failed to parse SM2 certificate")
+	assert.Equal(t, der, gmCert.Raw)
+	assert.Equal(t, der, x509Cert.Raw)
+	assert.Equal(t, "peer0", x509Cert.Subject.CommonName)
+	assert.Equal(t, "gmca", x509Cert.Issuer.CommonName)
+	assert.Equal(t, int64(1234), x509Cert.SerialNumber.Int64())
+	assert.Equal(t, &key.PublicKey, gmCert.PublicKey)
+	assert.Equal(t, gmCert.PublicKey, x509Cert.PublicKey)
+	assert.Equal(t, gmCert.RawTBSCertificate, x509Cert.RawTBSCertificate)
+	assert.Equal(t, gmCert.Signature, x509Cert.Signature)
+
+	caCert, _, err := ParseCertBoth(caFile)
+	FatalError(t, err, "Failed to parse SM2 CA certificate")
+	assert.NoError(t, gmCert.CheckSignatureFrom(caCert))
+	assert.NoError(t, caCert.CheckSignatureFrom(caCert))
+	assert.Error(t, caCert.CheckSignatureFrom(gmCert), "The CA certificate is not signed by the key of peer0")
+
+	// Certificates which crypto/x509 parses have no SM2 public key
+	gmCert, x509Cert, err = ParseCertBoth(filepath.Join("testdata", "ec.pem"))
+	FatalError(t, err, "Failed to parse ECDSA certificate")
+	assert.Nil(t, gmCert.PublicKey)
+	assert.Equal(t, x509Cert.Raw, gmCert.Raw)
+	assert.Equal(t, x509Cert.RawTBSCertificate, gmCert.RawTBSCertificate)
+	assert.Equal(t, x509Cert.Signature, gmCert.Signature)
+	assert.Error(t, gmCert.CheckSignatureFrom(caCert), "ECDSA signatures are not SM2 signatures")
+
+	_, _, err = ParseCertBoth(filepath.Join("testdata", "ec-key.pem"))
+	assert.Error(t, err)
+	_, _, err = ParseCertBoth(filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err)
+}
+
+func TestConvertGMCertToX509File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gmconvert")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	der := createGMCertificate(t, "gmca", "gmca", key, key, nil)
+	outFile := filepath.Join(dir, "out.pem")
+
+	// GM tools commonly write DER encoded certificates
+	for name, content := range map[string][]byte{
+		"sm2-cert.der": der,
+		"sm2-cert.pem": CertificateToPEM(der, nil),
+	} {
+		inFile := filepath.Join(dir, name)
+		FatalError(t, ioutil.WriteFile(inFile, content, 0644), "Failed to write certificate")
+		os.Remove(outFile)
+		FatalError(t, ConvertGMCertToX509File(inFile, outFile), "Failed to convert "+name)
+		out, err := ioutil.ReadFile(outFile)
+		FatalError(t, err, "Failed to read converted certificate")
+		block, rest := pem.Decode(out)
+		if assert.NotNil(t, block, "The converted certificate should be PEM encoded") {
+			assert.Equal(t, "CERTIFICATE", block.Type)
+			assert.Equal(t, der, block.Bytes, "The DER encoding should be preserved")
+		}
+		assert.Empty(t, rest)
+		gmCert, x509Cert, err := ParseCertBoth(outFile)
+		FatalError(t, err, "Failed to parse converted certificate")
+		assert.Equal(t, "gmca", x509Cert.Subject.CommonName)
+		assert.NoError(t, gmCert.CheckSignatureFrom(gmCert))
+	}
+
+	// A self-issued certificate with an invalid signature is not converted
+	other, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	badFile := filepath.Join(dir, "bad.der")
+	FatalError(t, ioutil.WriteFile(badFile, createGMCertificate(t, "gmca", "gmca", other, key, nil), 0644), "Failed to write certificate")
+	os.Remove(outFile)
+	assert.Error(t, ConvertGMCertToX509File(badFile, outFile))
+	_, err = os.Stat(outFile)
+	assert.True(t, os.IsNotExist(err), "Nothing should be written")
+
+	// Standard certificates are not SM2 certificates
+	assert.Error(t, ConvertGMCertToX509File(filepath.Join("testdata", "ec.pem"), outFile))
+	assert.Error(t, ConvertGMCertToX509File(filepath.Join("testdata", "ec-key.pem"), outFile))
+	assert.Error(t, ConvertGMCertToX509File(filepath.Join(dir, "missing.pem"), outFile))
+}
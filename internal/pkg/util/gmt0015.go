@@ -0,0 +1,233 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GMT0015Error is returned by ValidateGMT0015 when a certificate does not
+// conform to the GM/T 0015 profile
+type GMT0015Error struct {
+	// Nonconformities describe each requirement of the profile which the
+	// certificate doesn't meet
+	Nonconformities []string
+}
+
+func (e *GMT0015Error) Error() string {
+	return fmt.Sprintf("The certificate does not conform to the GM/T 0015 profile: %s", strings.Join(e.Nonconformities, "; "))
+}
+
+// gmCertificate is the ASN.1 structure of a certificate, which is decoded
+// without interpreting its algorithms
+type gmCertificate struct {
+	TBSCertificate struct {
+		Raw                asn1.RawContent
+		Version            int `asn1:"optional,explicit,default:0,tag:0"`
+		SerialNumber       *big.Int
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		Issuer             asn1.RawValue
+		Validity           struct {
+			NotBefore, NotAfter time.Time
+		}
+		Subject   asn1.RawValue
+		PublicKey struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		IssuerUniqueID  asn1.BitString   `asn1:"optional,tag:1"`
+		SubjectUniqueID asn1.BitString   `asn1:"optional,tag:2"`
+		Extensions      []pkix.Extension `asn1:"optional,explicit,tag:3"`
+	}
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+// isGMCertificate returns true if the DER encoded certificate has an SM2
+// public key or an SM3 with SM2 signature
+func isGMCertificate(der []byte) bool {
+	var gmCert gmCertificate
+	if _, err := asn1.Unmarshal(der, &gmCert); err != nil {
+		return false
+	}
+	if gmCert.SignatureAlgorithm.Algorithm.Equal(oidSignatureSM3WithSM2) {
+		return true
+	}
+	keyAlg := gmCert.TBSCertificate.PublicKey.Algorithm
+	var curve asn1.ObjectIdentifier
+	if keyAlg.Algorithm.Equal(oidPublicKeyECDSA) {
+		if _, err := asn1.Unmarshal(keyAlg.Parameters.FullBytes, &curve); err == nil {
+			return curve.Equal(oidSM2)
+		}
+	}
+	return keyAlg.Algorithm.Equal(oidSM2)
+}
+
+// gmBasicConstraints is the ASN.1 structure of the basic constraints extension
+type gmBasicConstraints struct {
+	IsCA       bool `asn1:"optional"`
+	MaxPathLen int  `asn1:"optional,default:-1"`
+}
+
+// ValidateGMT0015 checks that cert conforms to the certificate profile of
+// GM/T 0015-2012: a version 3 certificate with a positive serial number of at
+// most 20 octets, signed with SM3 with SM2, for an uncompressed SM2 public key,
+// with non empty issuer and subject names, and with the subject key identifier,
+// key usage and, unless it is self-issued, authority key identifier extensions.
+// The key usage extension must be critical, and a certificate allowed to sign
+// certificates must have a critical basic constraints extension marking it as
+// a CA. As the standard library can't parse SM2 certificates, cert is checked
+// from its raw encoding, so only its Raw field needs to be set. If cert does
+// not conform, a *GMT0015Error listing all the nonconformities is returned.
+func ValidateGMT0015(cert *x509.Certificate) error {
+	if cert == nil || len(cert.Raw) == 0 {
+		return errors.New("Certificate must be different from nil and have its raw encoding")
+	}
+	var gmCert gmCertificate
+	rest, err := asn1.Unmarshal(cert.Raw, &gmCert)
+	if err != nil {
+		return errors.Wrap(err, "Failed to decode certificate")
+	}
+	if len(rest) > 0 {
+		return errors.New("Trailing data after the certificate")
+	}
+	tbs := &gmCert.TBSCertificate
+	var nonconformities []string
+	fail := func(format string, args ...interface{}) {
+		nonconformities = append(nonconformities, fmt.Sprintf(format, args...))
+	}
+
+	if tbs.Version != 2 {
+		fail("version is %d instead of 3", tbs.Version+1)
+	}
+	if tbs.SerialNumber == nil || tbs.SerialNumber.Sign() <= 0 {
+		fail("serial number is not positive")
+	} else if len(tbs.SerialNumber.Bytes()) > 20 {
+		fail("serial number is longer than 20 octets")
+	}
+	if !gmCert.SignatureAlgorithm.Algorithm.Equal(oidSignatureSM3WithSM2) {
+		fail("signature algorithm %s is not SM3 with SM2 (%s)", gmCert.SignatureAlgorithm.Algorithm, oidSignatureSM3WithSM2)
+	}
+	if !tbs.SignatureAlgorithm.Algorithm.Equal(gmCert.SignatureAlgorithm.Algorithm) {
+		fail("signature algorithm of the certificate content %s differs from the signature algorithm %s",
+			tbs.SignatureAlgorithm.Algorithm, gmCert.SignatureAlgorithm.Algorithm)
+	}
+	if len(tbs.Issuer.Bytes) == 0 {
+		fail("issuer name is empty")
+	}
+	if len(tbs.Subject.Bytes) == 0 {
+		fail("subject name is empty")
+	}
+	if !tbs.Validity.NotBefore.Before(tbs.Validity.NotAfter) {
+		fail("validity period ends before it starts")
+	}
+	checkGMPublicKey(tbs.PublicKey.Algorithm, tbs.PublicKey.PublicKey, fail)
+
+	exts := map[string]pkix.Extension{}
+	for _, ext := range tbs.Extensions {
+		if _, ok := exts[ext.Id.String()]; ok {
+			fail("extension %s appears more than once", ext.Id)
+		}
+		exts[ext.Id.String()] = ext
+	}
+	if _, ok := exts[oidExtSubjectKeyID.String()]; !ok {
+		fail("subject key identifier extension is missing")
+	}
+	if _, ok := exts[oidExtAuthorityKeyID.String()]; !ok && !bytes.Equal(tbs.Issuer.FullBytes, tbs.Subject.FullBytes) {
+		fail("authority key identifier extension is missing")
+	}
+	certSign := false
+	if ku, ok := exts[oidExtKeyUsage.String()]; !ok {
+		fail("key usage extension is missing")
+	} else {
+		if !ku.Critical {
+			fail("key usage extension is not critical")
+		}
+		var usage asn1.BitString
+		if _, err := asn1.Unmarshal(ku.Value, &usage); err != nil {
+			fail("key usage extension is invalid")
+		}
+		// keyCertSign is the bit 5 of the key usage
+		certSign = usage.At(5) == 1
+	}
+	if bc, ok := exts[oidExtBasicConstraints.String()]; ok {
+		var constraints gmBasicConstraints
+		if _, err := asn1.Unmarshal(bc.Value, &constraints); err != nil {
+			fail("basic constraints extension is invalid")
+		} else if certSign && (!bc.Critical || !constraints.IsCA) {
+			fail("basic constraints extension of a certificate which signs certificates must be critical and mark it as a CA")
+		}
+	} else if certSign {
+		fail("basic constraints extension is missing from a certificate which signs certificates")
+	}
+
+	if len(nonconformities) > 0 {
+		return &GMT0015Error{Nonconformities: nonconformities}
+	}
+	return nil
+}
+
+// checkGMPublicKey reports through fail the nonconformities of the subject
+// public key with algorithm alg and value pub
+func checkGMPublicKey(alg pkix.AlgorithmIdentifier, pub asn1.BitString, fail func(string, ...interface{})) {
+	var curve asn1.ObjectIdentifier
+	if !alg.Algorithm.Equal(oidPublicKeyECDSA) {
+		fail("public key algorithm %s is not EC (%s)", alg.Algorithm, oidPublicKeyECDSA)
+		return
+	}
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &curve); err != nil || !curve.Equal(oidSM2) {
+		fail("public key is not on the SM2 curve (%s)", oidSM2)
+		return
+	}
+	x, _ := elliptic.Unmarshal(SM2P256(), pub.RightAlign())
+	if x == nil {
+		fail("public key is not an uncompressed point of the SM2 curve")
+	}
+}
+
+// ValidateGMT0015Request checks, before a certificate is issued for the DER
+// encoded certificate signing request csrDER, the requirements of the GM/T 0015
+// certificate profile which depend on the request: it must be signed with SM3
+// with SM2, for an uncompressed SM2 public key, with a non empty subject name.
+// The other requirements depend on the CA, which must sign with SM2. If the
+// request does not conform, a *GMT0015Error listing all the nonconformities is
+// returned.
+func ValidateGMT0015Request(csrDER []byte) error {
+	var gmCSR gmCertificateRequest
+	rest, err := asn1.Unmarshal(csrDER, &gmCSR)
+	if err != nil {
+		return errors.Wrap(err, "Failed to decode certificate signing request")
+	}
+	if len(rest) > 0 {
+		return errors.New("Trailing data after the certificate signing request")
+	}
+	var nonconformities []string
+	fail := func(format string, args ...interface{}) {
+		nonconformities = append(nonconformities, fmt.Sprintf(format, args...))
+	}
+	if !gmCSR.SignatureAlgorithm.Algorithm.Equal(oidSignatureSM3WithSM2) {
+		fail("signature algorithm %s of the request is not SM3 with SM2 (%s)", gmCSR.SignatureAlgorithm.Algorithm, oidSignatureSM3WithSM2)
+	}
+	if len(gmCSR.TBSCSR.Subject.Bytes) == 0 {
+		fail("subject name is empty")
+	}
+	checkGMPublicKey(gmCSR.TBSCSR.PublicKey.Algorithm, gmCSR.TBSCSR.PublicKey.PublicKey, fail)
+	if len(nonconformities) > 0 {
+		return &GMT0015Error{Nonconformities: nonconformities}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	oidSM2                 = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301}
+	oidSignatureSM3WithSM2 = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 501}
+	oidPublicKeyEC         = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+)
+
+type testTBSCertificate struct {
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       *big.Int
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Issuer             asn1.RawValue
+	Validity           struct {
+		NotBefore, NotAfter time.Time
+	}
+	Subject   asn1.RawValue
+	PublicKey struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	Extensions []pkix.Extension `asn1:"optional,explicit,tag:3"`
+}
+
+type testCertificate struct {
+	TBSCertificate     asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+// createGMCertificate returns a DER encoded SM2 certificate issued by issuerKey
+// to key, with the extensions returned by exts
+func createGMCertificate(t *testing.T, issuer, subject string, issuerKey, key *ecdsa.PrivateKey, exts []pkix.Extension) []byte {
+	name := func(cn string) asn1.RawValue {
+		der, err := asn1.Marshal(pkix.Name{CommonName: cn}.ToRDNSequence())
+		FatalError(t, err, "Failed to marshal name")
+		return asn1.RawValue{FullBytes: der}
+	}
+	curve, err := asn1.Marshal(oidSM2)
+	FatalError(t, err, "Failed to marshal curve")
+	sigAlg := pkix.AlgorithmIdentifier{Algorithm: oidSignatureSM3WithSM2}
+	tbs := testTBSCertificate{
+		Version:            2,
+		SerialNumber:       big.NewInt(1234),
+		SignatureAlgorithm: sigAlg,
+		Issuer:             name(issuer),
+		Subject:            name(subject),
+		Extensions:         exts,
+	}
+	tbs.Validity.NotBefore = time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	tbs.Validity.NotAfter = time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	tbs.PublicKey.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPublicKeyEC, Parameters: asn1.RawValue{FullBytes: curve}}
+	point := elliptic.Marshal(key.Curve, key.X, key.Y)
+	tbs.PublicKey.PublicKey = asn1.BitString{Bytes: point, BitLength: len(point) * 8}
+	tbsDER, err := asn1.Marshal(tbs)
+	FatalError(t, err, "Failed to marshal certificate content")
+	sig, err := SM2Sign(issuerKey, tbsDER, nil)
+	FatalError(t, err, "Failed to sign certificate")
+	der, err := asn1.Marshal(testCertificate{
+		TBSCertificate:     asn1.RawValue{FullBytes: tbsDER},
+		SignatureAlgorithm: sigAlg,
+		SignatureValue:     asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	})
+	FatalError(t, err, "Failed to marshal certificate")
+	return der
+}
+
+func TestValidateGMT0015(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	key, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	ext := func(id asn1.ObjectIdentifier, critical bool, value interface{}) pkix.Extension {
+		der, err := asn1.Marshal(value)
+		FatalError(t, err, "Failed to marshal extension")
+		return pkix.Extension{Id: id, Critical: critical, Value: der}
+	}
+	ski := ext(asn1.ObjectIdentifier{2, 5, 29, 14}, false, []byte{1, 2, 3})
+	aki := ext(asn1.ObjectIdentifier{2, 5, 29, 35}, false, struct {
+		ID []byte `asn1:"optional,tag:0"`
+	}{ID: []byte{4, 5, 6}})
+	// digitalSignature, and keyCertSign for the CA
+	ku := ext(asn1.ObjectIdentifier{2, 5, 29, 15}, true, asn1.BitString{Bytes: []byte{0x80}, BitLength: 1})
+	caKU := ext(asn1.ObjectIdentifier{2, 5, 29, 15}, true, asn1.BitString{Bytes: []byte{0x84}, BitLength: 6})
+	bc := ext(asn1.ObjectIdentifier{2, 5, 29, 19}, true, struct {
+		IsCA bool `asn1:"optional"`
+	}{IsCA: true})
+
+	leaf := createGMCertificate(t, "ca", "leaf", caKey, key, []pkix.Extension{ski, aki, ku})
+	assert.NoError(t, ValidateGMT0015(&x509.Certificate{Raw: leaf}), "The leaf certificate should conform")
+	root := createGMCertificate(t, "ca", "ca", caKey, caKey, []pkix.Extension{ski, caKU, bc})
+	assert.NoError(t, ValidateGMT0015(&x509.Certificate{Raw: root}), "The self-issued CA certificate should conform")
+
+	tests := []struct {
+		name            string
+		cert            []byte
+		nonconformities int
+	}{
+		{"missing subject key identifier", createGMCertificate(t, "ca", "leaf", caKey, key, []pkix.Extension{aki, ku}), 1},
+		{"missing authority key identifier", createGMCertificate(t, "ca", "leaf", caKey, key, []pkix.Extension{ski, ku}), 1},
+		{"missing basic constraints", createGMCertificate(t, "ca", "ca", caKey, caKey, []pkix.Extension{ski, caKU}), 1},
+		{"no extensions", createGMCertificate(t, "ca", "leaf", caKey, key, nil), 3},
+	}
+	for _, test := range tests {
+		err := ValidateGMT0015(&x509.Certificate{Raw: test.cert})
+		if assert.Error(t, err, test.name) {
+			gmErr, ok := err.(*GMT0015Error)
+			if assert.True(t, ok, "The error should be a GMT0015Error") {
+				assert.Len(t, gmErr.Nonconformities, test.nonconformities, "%s: %s", test.name, err)
+			}
+		}
+	}
+
+	// An ECDSA certificate signed with SHA-256 uses neither SM2 nor SM3
+	cert, err := GetX509CertificateFromPEMFile(filepath.Join("testdata", "ec.pem"))
+	FatalError(t, err, "Failed to read certificate")
+	err = ValidateGMT0015(cert)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "SM3 with SM2")
+		assert.Contains(t, err.Error(), "SM2 curve")
+	}
+	assert.Error(t, ValidateGMT0015(nil))
+	assert.Error(t, ValidateGMT0015(&x509.Certificate{Raw: []byte("bad certificate")}))
+}
+
+func TestValidateGMT0015Request(t *testing.T) {
+	gmCSR, err := ioutil.ReadFile(filepath.Join("testdata", "sm2-csr.pem"))
+	FatalError(t, err, "Failed to read SM2 CSR")
+	block, _ := pem.Decode(gmCSR)
+	assert.NoError(t, ValidateGMT0015Request(block.Bytes), "The SM2 CSR should conform")
+
+	block, _ = pem.Decode(newTestCSR(t, "user1"))
+	err = ValidateGMT0015Request(block.Bytes)
+	if assert.Error(t, err) {
+		gmErr, ok := err.(*GMT0015Error)
+		if assert.True(t, ok, "The error should be a GMT0015Error") {
+			assert.Len(t, gmErr.Nonconformities, 2, "The signature algorithm and the public key should not conform: %s", err)
+		}
+	}
+	assert.Error(t, ValidateGMT0015Request([]byte("bad request")))
+}
@@ -0,0 +1,62 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"io"
+	"math/big"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/sw"
+	"github.com/hyperledger/fabric/bccsp/utils"
+	"github.com/pkg/errors"
+)
+
+// insecureKeyGenReader returns the InsecureKeyGenReader option of csp if
+// keys generated with csp must be derived from it, or nil otherwise. Only
+// keys generated with the SW provider are derived from the reader.
+func insecureKeyGenReader(csp bccsp.BCCSP) io.Reader {
+	if _, ok := baseCSP(csp).(*sw.CSP); !ok {
+		return nil
+	}
+	return getCSPOptions(csp).InsecureKeyGenReader
+}
+
+// insecureGenerateKey derives an ECDSA key for opts from r and imports it into csp
+func insecureGenerateKey(csp bccsp.BCCSP, r io.Reader, opts bccsp.KeyGenOpts) (bccsp.Key, error) {
+	var curve elliptic.Curve
+	switch opts.Algorithm() {
+	case bccsp.ECDSA, bccsp.ECDSAP256:
+		curve = elliptic.P256()
+	case bccsp.ECDSAP384:
+		curve = elliptic.P384()
+	default:
+		return nil, errors.Errorf("Deterministic key generation is not supported for algorithm '%s'", opts.Algorithm())
+	}
+	// Derive the private scalar in [1, N-1] from extra random bits, as in
+	// FIPS 186-4 B.4.1, so that it depends only on the bytes read from r
+	params := curve.Params()
+	b := make([]byte, params.BitSize/8+8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errors.Wrap(err, "Failed to read from the key generation reader")
+	}
+	one := big.NewInt(1)
+	d := new(big.Int).SetBytes(b)
+	d.Mod(d, new(big.Int).Sub(params.N, one))
+	d.Add(d, one)
+	priv := &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve}, D: d}
+	priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+
+	der, err := utils.PrivateKeyToDER(priv)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to convert ECDSA private key")
+	}
+	defer ZeroizeKeyBytes(der)
+	return csp.KeyImport(der, &bccsp.ECDSAPrivateKeyImportOpts{Temporary: opts.Ephemeral()})
+}
@@ -0,0 +1,51 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/cloudflare/cfssl/csr"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsecureKeyGenReader(t *testing.T) {
+	req := &csr.CertificateRequest{KeyRequest: csr.NewKeyRequest()}
+	seeded := func(seed int64) bccsp.BCCSP {
+		seededCSP, err := ConfigureCSP(csp, CSPOptions{InsecureKeyGenReader: rand.New(rand.NewSource(seed)), UnsafeAllowInsecureKeyGen: true})
+		FatalError(t, err, "Failed to enable deterministic key generation")
+		return seededCSP
+	}
+
+	_, err := ConfigureCSP(csp, CSPOptions{InsecureKeyGenReader: rand.New(rand.NewSource(1))})
+	assert.Error(t, err, "Deterministic key generation must be explicitly allowed")
+	key1, _, err := BCCSPKeyRequestGenerateWithEphemeral(req, csp, true)
+	FatalError(t, err, "Failed to generate key")
+	key2, _, err := BCCSPKeyRequestGenerateWithEphemeral(req, csp, true)
+	FatalError(t, err, "Failed to generate key")
+	assert.NotEqual(t, key1.SKI(), key2.SKI(), "Keys should be random unless deterministic generation is allowed")
+
+	var skis [][]byte
+	for i := 0; i < 2; i++ {
+		key, signer, err := BCCSPKeyRequestGenerateWithEphemeral(req, seeded(42), true)
+		FatalError(t, err, "Failed to generate key")
+		assert.NotNil(t, signer)
+		assert.True(t, key.Private())
+		skis = append(skis, key.SKI())
+	}
+	assert.Equal(t, skis[0], skis[1], "Keys generated from the same seed should be identical")
+
+	key, _, err := BCCSPKeyRequestGenerateWithEphemeral(req, seeded(43), true)
+	FatalError(t, err, "Failed to generate key")
+	assert.NotEqual(t, skis[0], key.SKI(), "Keys generated from different seeds should differ")
+
+	_, _, err = BCCSPKeyRequestGenerateWithEphemeral(&csr.CertificateRequest{KeyRequest: &csr.KeyRequest{A: "rsa", S: 2048}}, seeded(44), true)
+	assert.Error(t, err, "Deterministic RSA key generation is not supported")
+}
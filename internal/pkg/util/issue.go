@@ -0,0 +1,735 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/info"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/sw"
+	"github.com/pkg/errors"
+)
+
+// IssueCertificate signs the certificate request req with s and returns the
+// PEM encoded certificate along with its serial number
+func IssueCertificate(s signer.Signer, req signer.SignRequest) ([]byte, *big.Int, error) {
+	if s == nil {
+		return nil, nil, errors.New("Signer must be different from nil")
+	}
+	certPEM, err := s.Sign(req)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Certificate signing failure")
+	}
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Failed to parse the issued certificate")
+	}
+	return certPEM, cert.SerialNumber, nil
+}
+
+// IssueEphemeral generates a temporary key as specified by the key request of
+// req, issues a certificate for it with s and returns the PEM encoded
+// certificate and PKCS#8 private key. The key is only imported into csp as a
+// temporary key, so it is never written to its keystore; the caller is
+// responsible for protecting the returned private key. ECDSA P-256 and P-384
+// keys and Ed25519 keys are supported. SM2 keys are rejected, as the signers
+// can't sign SM2 certificate requests.
+//
+// The key is generated and exported in software, so csp must be the SW
+// provider: with PKCS11, the key would silently bypass the HSM, as the
+// provider imports software private keys into its software keystore.
+func IssueEphemeral(req *csr.CertificateRequest, s signer.Signer, csp bccsp.BCCSP) (certPEM, keyPEM []byte, err error) {
+	if req == nil {
+		return nil, nil, errors.New("Certificate request must be different from nil")
+	}
+	if s == nil {
+		return nil, nil, errors.New("Signer must be different from nil")
+	}
+	if csp == nil {
+		return nil, nil, errors.New("CSP was not initialized")
+	}
+	if _, ok := baseCSP(csp).(*sw.CSP); !ok {
+		return nil, nil, errors.New("Ephemeral keys are generated in software and can only be issued with the SW BCCSP provider")
+	}
+	if isSM2KeyRequest(req.KeyRequest) {
+		return nil, nil, errors.New("SM2 ephemeral keys can't be issued; SM2 certificate requests are not supported by the signer")
+	}
+	priv, err := generateExportableKey(req.KeyRequest, RandReader(csp))
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err = PrivateKeyToPEMWithFormat(priv, KeyFormatPKCS8, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			ZeroizeKeyBytes(keyPEM)
+			keyPEM = nil
+		}
+	}()
+	key, err := ImportBCCSPKeyFromPEMBytes(keyPEM, csp, true)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Failed to import the ephemeral key")
+	}
+	cspSigner, err := NewCryptoSigner(csp, key)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Failed initializing CryptoSigner")
+	}
+	csrPEM, err := csr.Generate(cspSigner, req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to generate the certificate request for the ephemeral key")
+	}
+	certPEM, _, err = IssueCertificate(s, signer.SignRequest{Request: string(csrPEM), Hosts: req.Hosts})
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// generateExportableKey generates a software private key as specified by kr,
+// which can be exported unlike the keys generated by a BCCSP provider. The key
+// is drawn from rng.
+func generateExportableKey(kr *csr.KeyRequest, rng io.Reader) (crypto.PrivateKey, error) {
+	algo, size := "ecdsa", 256
+	if kr != nil {
+		algo, size = kr.Algo(), kr.Size()
+	}
+	switch algo {
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch size {
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		default:
+			return nil, errors.Errorf("Invalid ECDSA key size: %d", size)
+		}
+		priv, err := ecdsa.GenerateKey(curve, rng)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate ECDSA key")
+		}
+		return priv, nil
+	case "ed25519":
+		if size != 0 && size != 256 {
+			return nil, errors.Errorf("Invalid Ed25519 key size: %d", size)
+		}
+		_, priv, err := ed25519.GenerateKey(rng)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to generate Ed25519 key")
+		}
+		return priv, nil
+	default:
+		return nil, errors.Errorf("Unsupported key algorithm for ephemeral keys: %s", algo)
+	}
+}
+
+// IssueCertificateDER signs req with s like IssueCertificate, and also returns
+// the DER encoding of the certificate, which is exactly the signed encoding
+// contained in the PEM block
+func IssueCertificateDER(s signer.Signer, req signer.SignRequest) (certPEM []byte, certDER []byte, serial *big.Int, err error) {
+	certPEM, serial, err = IssueCertificate(s, req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessage(err, "Failed to parse the issued certificate")
+	}
+	return certPEM, cert.Raw, serial, nil
+}
+
+// IssueCertificateWithChain signs req with s like IssueCertificate, and returns
+// the PEM encoded certificate followed by the CA chain, ordered leaf first. The
+// CA chain is read from chainFile, which must contain the PEM encoded signer
+// certificate followed by its issuers; if chainFile is empty, only the signer
+// certificate is appended.
+func IssueCertificateWithChain(s signer.Signer, req signer.SignRequest, chainFile string) ([]byte, *big.Int, error) {
+	var chain []*x509.Certificate
+	if chainFile != "" {
+		chainPEM, err := ReadFile(chainFile)
+		if err != nil {
+			return nil, nil, errors.WithMessage(err, "Failed to read the CA chain")
+		}
+		chain, err = GetX509CertificatesFromPEM(chainPEM)
+		if err != nil {
+			return nil, nil, errors.WithMessage(err, fmt.Sprintf("Invalid CA chain in '%s'", chainFile))
+		}
+		if len(chain) == 0 {
+			return nil, nil, errors.Errorf("No certificates found in the CA chain file '%s'", chainFile)
+		}
+	} else {
+		if s == nil {
+			return nil, nil, errors.New("Signer must be different from nil")
+		}
+		resp, err := s.Info(info.Req{Label: req.Label, Profile: req.Profile})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Failed to get the signer certificate")
+		}
+		caCert, err := GetX509CertificateFromPEM([]byte(resp.Certificate))
+		if err != nil {
+			return nil, nil, errors.WithMessage(err, "Invalid signer certificate")
+		}
+		chain = []*x509.Certificate{caCert}
+	}
+	certPEM, serial, err := IssueCertificate(s, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Failed to parse the issued certificate")
+	}
+	// Make sure that the chain starts with the issuer of the certificate
+	if err = cert.CheckSignatureFrom(chain[0]); err != nil {
+		return nil, nil, errors.Wrap(err, "The first certificate of the CA chain is not the issuer of the certificate")
+	}
+	// Re-encode the certificates so that all PEM blocks are encoded alike
+	bundle := CertificateToPEM(cert.Raw, nil)
+	for _, caCert := range chain {
+		bundle = append(bundle, CertificateToPEM(caCert.Raw, nil)...)
+	}
+	return bundle, serial, nil
+}
+
+// IssueCertificateWithSerialSource signs req with s like IssueCertificate,
+// using the next serial number of serials as the certificate's serial number.
+// The signing profile must be configured to accept client provided serial
+// numbers, otherwise the serial number is chosen by the signer.
+func IssueCertificateWithSerialSource(s signer.Signer, req signer.SignRequest, serials SerialSource) ([]byte, *big.Int, error) {
+	if serials != nil {
+		serial, err := serials.Next()
+		if err != nil {
+			return nil, nil, errors.WithMessage(err, "Failed to get the serial number of the certificate")
+		}
+		req.Serial = serial
+	}
+	return IssueCertificate(s, req)
+}
+
+// IssueCertificateWithSerial signs req with s like IssueCertificate, using
+// serial as the serial number of the certificate instead of one of serials. It
+// is meant for reissuing certificates with their original serial numbers, e.g.
+// when migrating to another CA, since serial numbers must never be reused
+// otherwise. The serial number must be positive and at most 20 octets long. It
+// must not be the serial number of a certificate of the CA in the certificate
+// database of s, if s has one, and it is reserved with serials, if not nil, so
+// that serials never returns it; at least one of them is required. The signing
+// profile of req must be configured to accept client provided serial numbers.
+func IssueCertificateWithSerial(s signer.Signer, req signer.SignRequest, serial *big.Int, serials SerialSource) ([]byte, *big.Int, error) {
+	if serial == nil || serial.Sign() <= 0 {
+		return nil, nil, errors.New("The serial number of the certificate must be positive")
+	}
+	if len(serial.Bytes()) > 20 {
+		return nil, nil, errors.Errorf("The serial number %s is longer than 20 octets", GetSerialAsHex(serial))
+	}
+	if s == nil {
+		return nil, nil, errors.New("Signer must be different from nil")
+	}
+	profile := s.Policy().Default
+	if p, ok := s.Policy().Profiles[req.Profile]; ok {
+		profile = p
+	}
+	if profile == nil || !profile.ClientProvidesSerialNumbers {
+		return nil, nil, errors.Errorf("The signing profile '%s' must be configured to accept client provided serial numbers", req.Profile)
+	}
+	dba := s.GetDBAccessor()
+	if dba == nil && serials == nil {
+		return nil, nil, errors.New("The uniqueness of the serial number can't be checked without a certificate database or a serial number source")
+	}
+	if dba != nil {
+		resp, err := s.Info(info.Req{Label: req.Label, Profile: req.Profile})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "Failed to get the signer certificate")
+		}
+		caCert, err := GetX509CertificateFromPEM([]byte(resp.Certificate))
+		if err != nil {
+			return nil, nil, errors.WithMessage(err, "Invalid signer certificate")
+		}
+		// The AKI of the issued certificates is the SKI of the CA certificate;
+		// the certificate database keys the records by the hex serial number
+		// and the AKI without leading zeros
+		aki := strings.TrimLeft(hex.EncodeToString(caCert.SubjectKeyId), "0")
+		records, err := dba.GetCertificate(GetSerialAsHex(serial), aki)
+		if err != nil {
+			return nil, nil, errors.WithMessage(err, "Failed to look up the serial number in the certificate database")
+		}
+		if len(records) > 0 {
+			return nil, nil, errors.Errorf("A certificate with serial number %s was already issued by the CA", GetSerialAsHex(serial))
+		}
+	}
+	if serials != nil {
+		err := serials.Reserve(serial)
+		if err != nil {
+			return nil, nil, errors.WithMessage(err, "Failed to reserve the serial number of the certificate")
+		}
+	}
+	log.Warningf("Issuing a certificate with the explicit serial number %s; serial numbers must not be reused, "+
+		"so certificates must only be issued with an explicit serial number when they are migrated", GetSerialAsHex(serial))
+	req.Serial = serial
+	return IssueCertificate(s, req)
+}
+
+// IssueIntermediate signs req with s like IssueCertificate, adding a critical
+// name constraints extension built from constraints to the certificate. The
+// signing profile of req must issue CA certificates and allow the
+// OIDExtNameConstraints extension. If constraints is nil or empty, no name
+// constraints extension is added.
+func IssueIntermediate(s signer.Signer, req signer.SignRequest, constraints *NameConstraints) ([]byte, *big.Int, error) {
+	if !constraints.empty() {
+		ext, err := constraints.extension()
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Extensions = append(append([]signer.Extension{}, req.Extensions...), ext)
+	}
+	return IssueCertificate(s, req)
+}
+
+// IssueCertificateWithUniqueSubject signs req with s like IssueCertificate,
+// unless registry holds an active certificate with the subject DN of the
+// certificate to issue, in which case ErrSubjectInUse is returned. The subject
+// DN is reserved in registry before the certificate is signed, so that
+// concurrent requests for the same DN can't both be issued a certificate, and
+// the issued certificate is added to registry; once it is revoked in
+// registry, a new certificate can be issued with the same subject DN. If the
+// certificate is issued but can't be added to registry, it is returned along
+// with the error, and its subject DN stays reserved.
+func IssueCertificateWithUniqueSubject(s signer.Signer, req signer.SignRequest, registry SubjectRegistry) ([]byte, *big.Int, error) {
+	if registry == nil {
+		return nil, nil, errors.New("Subject registry must be different from nil")
+	}
+	csrReq, err := ParseCSRPEM([]byte(req.Request))
+	if err != nil {
+		return nil, nil, err
+	}
+	subject := local.PopulateSubjectFromCSR(req.Subject, csrReq.Subject).String()
+
+	err = registry.Reserve(subject)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "Can't issue a certificate to '%s'", subject)
+	}
+	certPEM, serial, err := IssueCertificate(s, req)
+	if err != nil {
+		registry.Release(subject)
+		return nil, nil, err
+	}
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	if err == nil {
+		err = registry.Add(subject, serial, cert.NotAfter)
+	}
+	if err != nil {
+		return certPEM, serial, errors.WithMessagef(err, "Failed to add the certificate with serial number %s to the subject registry; the subject DN '%s' stays reserved",
+			GetSerialAsHex(serial), subject)
+	}
+	return certPEM, serial, nil
+}
+
+// SignBatch signs each of the PEM encoded CSRs in csrs with s using the signing
+// profile. The CSRs are signed sequentially with the same signer; a failure to
+// sign one CSR does not prevent the others from being signed. The i-th element
+// of the returned slices is the PEM encoded certificate or the error for the
+// i-th CSR.
+func SignBatch(csrs [][]byte, profile string, s signer.Signer) ([][]byte, []error) {
+	certs := make([][]byte, len(csrs))
+	errs := make([]error, len(csrs))
+	for i, csr := range csrs {
+		certs[i], _, errs[i] = IssueCertificate(s, signer.SignRequest{
+			Request: string(csr),
+			Profile: profile,
+		})
+	}
+	return certs, errs
+}
+
+// CrossSign issues a new certificate for the subject and public key of the
+// certificate in toBeSignedCertFile, signed by the CA of newSigner under the
+// signing profile named profile, which must allow issuing CA certificates if
+// the certificate is a CA certificate. newSigner must be a signer returned by
+// BccspBackedSigner or BccspBackedSignerWithTemporaryKey, and the
+// cross-signed certificate is recorded with its certificate database
+// accessor. The subject, subject key identifier, key usages and basic
+// constraints of the original certificate are preserved; the subject key
+// identifier is computed with csp if the original certificate has none. The
+// validity of the cross-signed certificate does not exceed that of the new
+// CA certificate. Its serial number is the next one of serials, or is drawn
+// from the random number generator of the CSP of newSigner if serials is nil;
+// see RandReader.
+func CrossSign(toBeSignedCertFile string, newSigner signer.Signer, profile string, csp bccsp.BCCSP, serials SerialSource) (certPEM []byte, err error) {
+	if csp == nil {
+		return nil, errors.New("CSP was not initialized")
+	}
+	bs, ok := newSigner.(*bccspSigner)
+	if !ok {
+		return nil, errors.Errorf("Signer of type %T can't cross-sign certificates", newSigner)
+	}
+	p, err := signer.Profile(bs, profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get the signing profile '%s'", profile)
+	}
+	cert, err := GetX509CertificateFromPEMFile(toBeSignedCertFile)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := bs.Certificate("", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get the signer certificate")
+	}
+	serial, err := bs.nextSerial(serials)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               cert.Subject,
+		NotBefore:             cert.NotBefore,
+		NotAfter:              cert.NotAfter,
+		KeyUsage:              cert.KeyUsage,
+		ExtKeyUsage:           cert.ExtKeyUsage,
+		UnknownExtKeyUsage:    cert.UnknownExtKeyUsage,
+		BasicConstraintsValid: cert.BasicConstraintsValid,
+		IsCA:                  cert.IsCA,
+		MaxPathLen:            cert.MaxPathLen,
+		MaxPathLenZero:        cert.MaxPathLenZero,
+		SubjectKeyId:          cert.SubjectKeyId,
+		DNSNames:              cert.DNSNames,
+		EmailAddresses:        cert.EmailAddresses,
+		IPAddresses:           cert.IPAddresses,
+		URIs:                  cert.URIs,
+	}
+	// Without a SKI, chains including the certificate can't be built reliably
+	if err = EnsureSKI(template, cert.PublicKey, csp); err != nil {
+		return nil, err
+	}
+	if template.NotBefore.Before(caCert.NotBefore) {
+		template.NotBefore = caCert.NotBefore
+	}
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	if !template.NotAfter.After(template.NotBefore) {
+		return nil, errors.Errorf("The validity of certificate '%s' does not overlap with the validity of the CA certificate '%s'",
+			toBeSignedCertFile, caCert.Subject.CommonName)
+	}
+	certPEM, err = bs.signTemplate(template, cert.PublicKey, p)
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to cross-sign certificate '%s'", toBeSignedCertFile))
+	}
+	return certPEM, nil
+}
+
+// Renew issues a new certificate with the subject, public key and extensions
+// of the certificate in oldCertFile, valid for newValidity from now, signed by
+// s under the signing profile named profile. All the extensions of the old
+// certificate, including its subject alternative names and certificate
+// policies, are copied as is; only the serial number and validity change.
+// The old certificate must have been issued by the CA of s, which must be a
+// signer returned by BccspBackedSigner, and the renewed certificate is
+// recorded with its certificate database accessor. newValidity must not
+// exceed the expiry of the profile, and the validity of the new certificate
+// does not exceed that of the CA certificate. The serial number of the new
+// certificate is the next one of serials, or is drawn from the random number
+// generator of the CSP of s if serials is nil; see RandReader.
+func Renew(oldCertFile string, s signer.Signer, profile string, newValidity time.Duration, serials SerialSource) (certPEM []byte, err error) {
+	bs, ok := s.(*bccspSigner)
+	if !ok {
+		return nil, errors.Errorf("Signer of type %T can't renew certificates", s)
+	}
+	if newValidity <= 0 {
+		return nil, errors.Errorf("Invalid validity %s for the renewed certificate", newValidity)
+	}
+	p, err := signer.Profile(bs, profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get the signing profile '%s'", profile)
+	}
+	if p.Expiry > 0 && newValidity > p.Expiry {
+		return nil, errors.Errorf("The validity %s of the renewed certificate exceeds the expiry %s of the signing profile",
+			newValidity, p.Expiry)
+	}
+	cert, err := GetX509CertificateFromPEMFile(oldCertFile)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := bs.Certificate("", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get the signer certificate")
+	}
+	if err = cert.CheckSignatureFrom(caCert); err != nil {
+		return nil, errors.Wrapf(err, "The certificate '%s' was not issued by the CA '%s'",
+			oldCertFile, caCert.Subject.CommonName)
+	}
+	serial, err := bs.nextSerial(serials)
+	if err != nil {
+		return nil, err
+	}
+	now := Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      cert.Subject,
+		NotBefore:    now,
+		NotAfter:     now.Add(newValidity),
+		IsCA:         cert.IsCA,
+		// The extensions override those which would be built from the template
+		ExtraExtensions: cert.Extensions,
+	}
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	if !template.NotAfter.After(template.NotBefore) {
+		return nil, errors.Errorf("The CA certificate '%s' has expired", caCert.Subject.CommonName)
+	}
+	certPEM, err = bs.signTemplate(template, cert.PublicKey, p)
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to renew certificate '%s'", oldCertFile))
+	}
+	return certPEM, nil
+}
+
+// ReSignAll re-issues each PEM encoded certificate in the files of certDir
+// with the ".pem" extension under the CA key of newSigner and the signing
+// profile named profile, e.g. to recover from the compromise of the previous
+// CA key. The subject, public key, validity and extensions of each
+// certificate are preserved, except for its authority key identifier, which
+// identifies the new CA key; the validity does not exceed that of the new CA
+// certificate and a new serial number is allocated from serials, or drawn
+// from the random number generator of the CSP of newSigner if serials is nil.
+// newSigner must be a signer returned by BccspBackedSigner, and the
+// re-issued certificates are recorded with its certificate database accessor.
+//
+// Every certificate is signed before any file is changed. The re-signed
+// certificates are then written next to the files with the ".new" suffix,
+// and the files are replaced, keeping the previous certificates in files with
+// the ".bak" suffix. Finally, the re-signed certificates are recorded. If
+// any certificate can't be re-issued, no file is changed and the returned map
+// holds the error for each file which could not be re-signed; if a file
+// can't be replaced or a certificate can't be recorded, the previous
+// certificates are restored from the ".bak" files.
+func ReSignAll(certDir string, newSigner signer.Signer, profile string, serials SerialSource) (map[string]error, error) {
+	bs, ok := newSigner.(*bccspSigner)
+	if !ok {
+		return nil, errors.Errorf("Signer of type %T can't re-sign certificates", newSigner)
+	}
+	p, err := signer.Profile(bs, profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get the signing profile '%s'", profile)
+	}
+	caCert, err := bs.Certificate("", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get the signer certificate")
+	}
+	files, err := filepath.Glob(filepath.Join(certDir, "*.pem"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list the certificates in '%s'", certDir)
+	}
+	// Check all the certificates before issuing any, so that a bad file
+	// doesn't leave issued certificates behind
+	failures := map[string]error{}
+	certs := make([]*x509.Certificate, len(files))
+	templates := make([]*x509.Certificate, len(files))
+	for i, file := range files {
+		certs[i], templates[i], err = reSignTemplate(file, bs, caCert, serials)
+		if err == nil && templates[i].IsCA && !p.CAConstraint.IsCA {
+			err = errors.New("The signing profile does not allow issuing CA certificates")
+		}
+		if err != nil {
+			log.Warningf("Failed to re-sign certificate '%s': %s", file, err)
+			failures[file] = err
+		}
+	}
+	if len(failures) > 0 {
+		return failures, errors.Errorf("Failed to re-sign %d of the %d certificates in '%s'; no certificate was replaced",
+			len(failures), len(files), certDir)
+	}
+
+	// Sign all the certificates, without recording them yet
+	newCerts := make([]*x509.Certificate, len(files))
+	newPEMs := make([][]byte, len(files))
+	for i, file := range files {
+		newPEMs[i], newCerts[i], err = bs.createCertificate(templates[i], certs[i].PublicKey, p)
+		if err != nil {
+			failures[file] = err
+			return failures, errors.Errorf("Failed to re-sign certificate '%s'; no certificate was replaced", file)
+		}
+	}
+
+	newFiles := make([]string, 0, len(files))
+	defer func() {
+		for _, file := range newFiles {
+			os.Remove(file)
+		}
+	}()
+	for i, file := range files {
+		if err = WriteFile(file+".new", newPEMs[i], 0644); err != nil {
+			failures[file] = err
+			return failures, errors.Errorf("Failed to write the re-signed certificate '%s'; no certificate was replaced", file)
+		}
+		newFiles = append(newFiles, file+".new")
+	}
+
+	// Replace the files, restoring the backed up ones if any can't be replaced
+	var backedUp []string
+	restore := func() {
+		for _, file := range backedUp {
+			if rerr := os.Rename(file+".bak", file); rerr != nil {
+				log.Errorf("Failed to restore certificate '%s' from '%s.bak': %s", file, file, rerr)
+			}
+		}
+	}
+	for _, file := range files {
+		if err = os.Rename(file, file+".bak"); err != nil {
+			restore()
+			return nil, errors.Wrapf(err, "Failed to back up certificate '%s'; the previous certificates were restored", file)
+		}
+		backedUp = append(backedUp, file)
+		if err = os.Rename(file+".new", file); err != nil {
+			restore()
+			return nil, errors.Wrapf(err, "Failed to replace certificate '%s'; the previous certificates were restored", file)
+		}
+	}
+	newFiles = nil
+
+	for i, file := range files {
+		if err = bs.recordCertificate(newCerts[i], newPEMs[i]); err != nil {
+			restore()
+			return nil, errors.WithMessage(err, fmt.Sprintf("Failed to record the re-signed certificate '%s'; the previous certificates were restored", file))
+		}
+	}
+	return failures, nil
+}
+
+// reSignTemplate returns the certificate in certFile along with the template
+// of a certificate for the same identity to be issued by bs, whose
+// certificate is caCert, with a serial number of serials
+func reSignTemplate(certFile string, bs *bccspSigner, caCert *x509.Certificate, serials SerialSource) (*x509.Certificate, *x509.Certificate, error) {
+	cert, err := GetX509CertificateFromPEMFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := bs.nextSerial(serials)
+	if err != nil {
+		return nil, nil, err
+	}
+	var exts []pkix.Extension
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidExtAuthorityKeyID) {
+			exts = append(exts, ext)
+		}
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      cert.Subject,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		IsCA:         cert.IsCA,
+		// The extensions override those which would be built from the template
+		ExtraExtensions: exts,
+	}
+	if template.NotBefore.Before(caCert.NotBefore) {
+		template.NotBefore = caCert.NotBefore
+	}
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	if !template.NotAfter.After(template.NotBefore) {
+		return nil, nil, errors.Errorf("The validity of the certificate does not overlap with the validity of the CA certificate '%s'",
+			caCert.Subject.CommonName)
+	}
+	return cert, template, nil
+}
+
+// signTemplate issues the certificate described by template for the public
+// key pub with the CA key of bs, under the CA constraint of profile. Like the
+// certificates which the cfssl signer issues from CSRs, the certificate is
+// recorded with the certificate database accessor of bs, if any.
+func (bs *bccspSigner) signTemplate(template *x509.Certificate, pub crypto.PublicKey, profile *config.SigningProfile) ([]byte, error) {
+	certPEM, cert, err := bs.createCertificate(template, pub, profile)
+	if err != nil {
+		return nil, err
+	}
+	if err = bs.recordCertificate(cert, certPEM); err != nil {
+		return nil, err
+	}
+	return certPEM, nil
+}
+
+// createCertificate issues the certificate described by template for the
+// public key pub with the CA key of bs, under the CA constraint of profile,
+// and returns it PEM encoded and parsed, without recording it
+func (bs *bccspSigner) createCertificate(template *x509.Certificate, pub crypto.PublicKey, profile *config.SigningProfile) ([]byte, *x509.Certificate, error) {
+	if template.IsCA && !profile.CAConstraint.IsCA {
+		return nil, nil, errors.New("The signing profile does not allow issuing CA certificates")
+	}
+	caCert, err := bs.Certificate("", "")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to get the signer certificate")
+	}
+	template.SignatureAlgorithm = bs.sigAlgo
+	der, err := x509.CreateCertificate(bs.rng, template, caCert, pub, bs.key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to sign certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to parse the signed certificate")
+	}
+	return CertificateToPEM(der, nil), cert, nil
+}
+
+// nextSerial returns the next serial number of serials, or a random serial
+// number drawn from the random number generator of the CSP of bs if serials
+// is nil
+func (bs *bccspSigner) nextSerial(serials SerialSource) (*big.Int, error) {
+	if serials == nil {
+		return randomSerialNumber(bs.rng)
+	}
+	serial, err := serials.Next()
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to get the serial number of the certificate")
+	}
+	return serial, nil
+}
+
+// recordCertificate records cert, whose PEM encoding is certPEM, with the
+// certificate database accessor of bs, if any
+func (bs *bccspSigner) recordCertificate(cert *x509.Certificate, certPEM []byte) error {
+	dba := bs.GetDBAccessor()
+	if dba == nil {
+		return nil
+	}
+	err := dba.InsertCertificate(certdb.CertificateRecord{
+		Serial: cert.SerialNumber.String(),
+		AKI:    hex.EncodeToString(cert.AuthorityKeyId),
+		Status: "good",
+		Expiry: cert.NotAfter,
+		PEM:    string(certPEM),
+	})
+	if err != nil {
+		return errors.Wrap(err, "Failed to record the signed certificate")
+	}
+	return nil
+}
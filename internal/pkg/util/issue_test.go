@@ -0,0 +1,727 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/signer"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCASigner(t *testing.T) signer.Signer {
+	s, err := BccspBackedSigner(filepath.Join("testdata", "ec.pem"), filepath.Join("testdata", "ec-key.pem"), nil, csp)
+	FatalError(t, err, "Failed to create CA signer")
+	return s
+}
+
+func newTestCSR(t *testing.T, cn string) []byte {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}}, priv)
+	FatalError(t, err, "Failed to create CSR")
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestIssueCertificate(t *testing.T) {
+	s := newTestCASigner(t)
+
+	certPEM, serial, err := IssueCertificate(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))})
+	assert.NoError(t, err)
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, serial.Cmp(cert.SerialNumber))
+	assert.Equal(t, "user1", cert.Subject.CommonName)
+
+	_, _, err = IssueCertificate(s, signer.SignRequest{Request: "badcsr"})
+	assert.Error(t, err)
+
+	_, _, err = IssueCertificate(nil, signer.SignRequest{})
+	assert.Error(t, err)
+}
+
+func TestIssueCertificateMixedSANs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixedsans")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	s := newTestCASigner(t)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	uri, err := url.Parse("spiffe://example.com/peer0")
+	FatalError(t, err, "Failed to parse URI")
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "peer0"},
+		DNSNames:       []string{"peer0.example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		EmailAddresses: []string{"admin@example.com"},
+		URIs:           []*url.URL{uri},
+	}, priv)
+	FatalError(t, err, "Failed to create CSR")
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+	hosts := []string{"peer0.example.com", "10.0.0.1", "admin@example.com", "spiffe://example.com/peer0"}
+
+	// The SANs come from the CSR, or from the hosts of the request, which
+	// override them
+	for _, req := range []signer.SignRequest{{Request: csrPEM}, {Request: csrPEM, Hosts: hosts}} {
+		certPEM, _, err := IssueCertificate(s, req)
+		FatalError(t, err, "Failed to issue certificate")
+		cert, err := GetX509CertificateFromPEM(certPEM)
+		FatalError(t, err, "Failed to parse certificate")
+		assert.Equal(t, []string{"peer0.example.com"}, cert.DNSNames)
+		if assert.Len(t, cert.IPAddresses, 1) {
+			assert.Equal(t, "10.0.0.1", cert.IPAddresses[0].String())
+		}
+		assert.Equal(t, []string{"admin@example.com"}, cert.EmailAddresses)
+		if assert.Len(t, cert.URIs, 1) {
+			assert.Equal(t, uri.String(), cert.URIs[0].String())
+		}
+
+		certFile := filepath.Join(dir, "cert.pem")
+		FatalError(t, ioutil.WriteFile(certFile, certPEM, 0644), "Failed to write certificate")
+		assert.NoError(t, CheckHostsInCert(certFile, hosts...), "All SANs should be found in the certificate")
+		assert.Error(t, CheckHostsInCert(certFile, "other@example.com"))
+	}
+}
+
+func TestIssueIntermediate(t *testing.T) {
+	policy := &config.Signing{Default: config.DefaultConfig()}
+	policy.Default.Usage = []string{"cert sign", "crl sign"}
+	policy.Default.CAConstraint = config.CAConstraint{IsCA: true}
+	policy.Default.ExtensionWhitelist = map[string]bool{OIDExtNameConstraints.String(): true}
+	s, err := BccspBackedSigner(filepath.Join("testdata", "ec.pem"), filepath.Join("testdata", "ec-key.pem"), policy, csp)
+	FatalError(t, err, "Failed to create CA signer")
+
+	_, ipNet, err := net.ParseCIDR("10.0.0.0/8")
+	FatalError(t, err, "Failed to parse CIDR")
+	constraints := &NameConstraints{
+		PermittedDNSDomains:     []string{".example.com"},
+		ExcludedDNSDomains:      []string{"bad.example.com"},
+		PermittedIPRanges:       []*net.IPNet{ipNet},
+		PermittedEmailAddresses: []string{"example.com"},
+	}
+	req := signer.SignRequest{Request: string(newTestCSR(t, "intermediate"))}
+	certPEM, _, err := IssueIntermediate(s, req, constraints)
+	FatalError(t, err, "Failed to issue intermediate CA certificate")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse certificate")
+	assert.True(t, cert.IsCA)
+	assert.True(t, cert.PermittedDNSDomainsCritical, "The name constraints extension should be critical")
+	assert.Equal(t, []string{".example.com"}, cert.PermittedDNSDomains)
+	assert.Equal(t, []string{"bad.example.com"}, cert.ExcludedDNSDomains)
+	if assert.Len(t, cert.PermittedIPRanges, 1) {
+		assert.Equal(t, "10.0.0.0/8", cert.PermittedIPRanges[0].String())
+	}
+	assert.Equal(t, []string{"example.com"}, cert.PermittedEmailAddresses)
+	assert.Empty(t, req.Extensions, "The request of the caller should not be modified")
+
+	// Without constraints, no name constraints extension is added
+	certPEM, _, err = IssueIntermediate(s, req, nil)
+	FatalError(t, err, "Failed to issue intermediate CA certificate")
+	cert, err = GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse certificate")
+	assert.Empty(t, cert.PermittedDNSDomains)
+
+	_, _, err = IssueIntermediate(s, req, &NameConstraints{PermittedDNSDomains: []string{""}})
+	assert.Error(t, err, "An empty DNS domain should be rejected")
+	// The default profile doesn't allow the name constraints extension
+	_, _, err = IssueIntermediate(newTestCASigner(t), req, constraints)
+	assert.Error(t, err)
+}
+
+func TestIssueOCSPSigner(t *testing.T) {
+	policy := &config.Signing{Default: config.DefaultConfig()}
+	policy.Default.ExtensionWhitelist = map[string]bool{
+		OIDExtExtKeyUsage.String(): true,
+		OIDExtOCSPNoCheck.String(): true,
+	}
+	s, err := BccspBackedSigner(filepath.Join("testdata", "ec.pem"), filepath.Join("testdata", "ec-key.pem"), policy, csp)
+	FatalError(t, err, "Failed to create CA signer")
+
+	req := &csr.CertificateRequest{CN: "ocsp-responder"}
+	bundle, err := IssueOCSPSigner(req, s)
+	FatalError(t, err, "Failed to issue OCSP signer certificate")
+	certBlock, rest := pem.Decode(bundle)
+	keyBlock, _ := pem.Decode(rest)
+	if certBlock == nil || keyBlock == nil {
+		t.Fatal("The OCSP signer certificate and key should be PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	FatalError(t, err, "Failed to parse certificate")
+	assert.Equal(t, "ocsp-responder", cert.Subject.CommonName)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}, cert.ExtKeyUsage)
+	noCheck := false
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(OIDExtOCSPNoCheck) {
+			noCheck = true
+			assert.False(t, ext.Critical)
+			assert.Equal(t, []byte{0x05, 0x00}, ext.Value, "The value of the nocheck extension should be NULL")
+		}
+	}
+	assert.True(t, noCheck, "The certificate should carry the OCSP nocheck extension")
+	key, _, err := GetPrivateKeyFromPEM(pem.EncodeToMemory(keyBlock))
+	FatalError(t, err, "Failed to parse the OCSP signer key")
+	assert.Equal(t, cert.PublicKey, &key.(*ecdsa.PrivateKey).PublicKey, "The key should match the certificate")
+
+	for _, algo := range []string{"gmsm2", "sm2"} {
+		_, err = IssueOCSPSigner(&csr.CertificateRequest{CN: "ocsp-responder", KeyRequest: &csr.KeyRequest{A: algo, S: 256}}, s)
+		if assert.Error(t, err, "SM2 keys should be rejected") {
+			assert.Contains(t, err.Error(), "SM2 OCSP signer certificates can't be issued", "The %s algorithm should be rejected as SM2", algo)
+		}
+	}
+	// The default profile doesn't allow the extensions
+	_, err = IssueOCSPSigner(req, newTestCASigner(t))
+	assert.Error(t, err)
+	_, err = IssueOCSPSigner(nil, s)
+	assert.Error(t, err)
+	_, err = IssueOCSPSigner(req, nil)
+	assert.Error(t, err)
+}
+
+func TestSignBatch(t *testing.T) {
+	s := newTestCASigner(t)
+
+	csrs := [][]byte{
+		newTestCSR(t, "user1"),
+		[]byte("badcsr"),
+		newTestCSR(t, "user2"),
+		nil,
+	}
+	certs, errs := SignBatch(csrs, "", s)
+	assert.Len(t, certs, len(csrs))
+	assert.Len(t, errs, len(csrs))
+
+	for i, cn := range map[int]string{0: "user1", 2: "user2"} {
+		assert.NoError(t, errs[i])
+		cert, err := GetX509CertificateFromPEM(certs[i])
+		if assert.NoError(t, err) {
+			assert.Equal(t, cn, cert.Subject.CommonName)
+		}
+	}
+	for _, i := range []int{1, 3} {
+		assert.Error(t, errs[i])
+		assert.Nil(t, certs[i])
+	}
+
+	_, errs = SignBatch(csrs[:1], "", nil)
+	assert.Error(t, errs[0])
+}
+
+// createTestRootCA creates a self-signed root CA and writes its certificate and
+// key to files in dir
+func createTestRootCA(t *testing.T, dir, name string) (certFile, keyFile string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte(name),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	FatalError(t, err, "Failed to create CA certificate")
+	keyPEM, err := PrivateKeyToPEM(priv, nil)
+	FatalError(t, err, "Failed to encode CA key")
+	certFile = filepath.Join(dir, name+"-cert.pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	FatalError(t, ioutil.WriteFile(certFile, CertificateToPEM(der, nil), 0644), "Failed to write CA certificate")
+	FatalError(t, ioutil.WriteFile(keyFile, keyPEM, 0600), "Failed to write CA key")
+	return certFile, keyFile
+}
+
+// recordingAccessor is a certdb.Accessor which records the inserted
+// certificates in memory
+type recordingAccessor struct {
+	certdb.Accessor
+	records []certdb.CertificateRecord
+}
+
+func (a *recordingAccessor) InsertCertificate(cr certdb.CertificateRecord) error {
+	a.records = append(a.records, cr)
+	return nil
+}
+
+func (a *recordingAccessor) GetCertificate(serial, aki string) ([]certdb.CertificateRecord, error) {
+	var records []certdb.CertificateRecord
+	for _, cr := range a.records {
+		// Like lib.CertDBAccessor, look the records up by the hex serial
+		// number and the AKI without leading zeros
+		crSerial, _ := new(big.Int).SetString(cr.Serial, 10)
+		if GetSerialAsHex(crSerial) == serial && strings.TrimLeft(cr.AKI, "0") == aki {
+			records = append(records, cr)
+		}
+	}
+	return records, nil
+}
+
+// failingAccessor records certificates until limit is reached, and fails to
+// record the others
+type failingAccessor struct {
+	recordingAccessor
+	limit int
+}
+
+func (a *failingAccessor) InsertCertificate(cr certdb.CertificateRecord) error {
+	if len(a.records) >= a.limit {
+		return errors.New("Failed to insert certificate")
+	}
+	return a.recordingAccessor.InsertCertificate(cr)
+}
+
+// newTestCAPolicy returns a signing policy whose "ca" profile allows issuing
+// CA certificates
+func newTestCAPolicy() *config.Signing {
+	caProfile := config.DefaultConfig()
+	caProfile.CAConstraint.IsCA = true
+	return &config.Signing{
+		Default:  config.DefaultConfig(),
+		Profiles: map[string]*config.SigningProfile{"ca": caProfile},
+	}
+}
+
+func TestCrossSign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crosssign")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	ksDir := filepath.Join(dir, "keystore")
+	fileCSP, err := factory.GetBCCSPFromOpts(&factory.FactoryOpts{ProviderName: "SW", SwOpts: &factory.SwOpts{
+		SecLevel: 256, HashFamily: "SHA2", FileKeystore: &factory.FileKeystoreOpts{KeyStorePath: ksDir}}})
+	FatalError(t, err, "Failed to initialize BCCSP")
+
+	oldRootFile, _ := createTestRootCA(t, dir, "oldroot")
+	newRootFile, newRootKeyFile := createTestRootCA(t, dir, "newroot")
+	s, err := BccspBackedSignerWithTemporaryKey(newRootFile, newRootKeyFile, newTestCAPolicy(), fileCSP)
+	FatalError(t, err, "Failed to create new root signer")
+	keys, err := ioutil.ReadDir(ksDir)
+	FatalError(t, err, "Failed to read keystore")
+	assert.Empty(t, keys, "The key of the new root should not be stored")
+	dba := &recordingAccessor{}
+	ls, _ := LocalSigner(s)
+	ls.SetDBAccessor(dba)
+
+	certPEM, err := CrossSign(oldRootFile, s, "ca", fileCSP, nil)
+	FatalError(t, err, "Failed to cross-sign certificate")
+	crossCert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse cross-signed certificate")
+	oldRoot, err := GetX509CertificateFromPEMFile(oldRootFile)
+	FatalError(t, err, "Failed to parse old root certificate")
+	newRoot, err := GetX509CertificateFromPEMFile(newRootFile)
+	FatalError(t, err, "Failed to parse new root certificate")
+
+	assert.Equal(t, oldRoot.RawSubject, crossCert.RawSubject)
+	assert.Equal(t, oldRoot.SubjectKeyId, crossCert.SubjectKeyId)
+	assert.Equal(t, newRoot.SubjectKeyId, crossCert.AuthorityKeyId)
+	assert.True(t, crossCert.IsCA)
+	if assert.Len(t, dba.records, 1, "The cross-signed certificate should be recorded") {
+		assert.Equal(t, crossCert.SerialNumber.String(), dba.records[0].Serial)
+		assert.Equal(t, string(certPEM), dba.records[0].PEM)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(newRoot)
+	_, err = crossCert.Verify(x509.VerifyOptions{Roots: roots})
+	assert.NoError(t, err, "Cross-signed certificate should chain to the new root")
+
+	// The profile must allow issuing CA certificates
+	_, err = CrossSign(oldRootFile, s, "", fileCSP, nil)
+	assert.Error(t, err)
+	assert.Len(t, dba.records, 1)
+
+	// A certificate whose validity does not overlap the new CA's cannot be cross-signed
+	_, err = CrossSign(filepath.Join("testdata", "ec.pem"), s, "ca", fileCSP, nil)
+	assert.Error(t, err)
+
+	_, err = CrossSign("doesnotexist.pem", s, "ca", fileCSP, nil)
+	assert.Error(t, err)
+	_, err = CrossSign(oldRootFile, nil, "ca", fileCSP, nil)
+	assert.Error(t, err)
+	_, err = CrossSign(oldRootFile, s, "ca", nil, nil)
+	assert.Error(t, err)
+}
+
+func TestIssueEphemeral(t *testing.T) {
+	s := newTestCASigner(t)
+	ksDir, err := ioutil.TempDir("", "keystore")
+	FatalError(t, err, "Failed to create keystore directory")
+	defer os.RemoveAll(ksDir)
+	fileCSP, err := factory.GetBCCSPFromOpts(&factory.FactoryOpts{ProviderName: "SW", SwOpts: &factory.SwOpts{
+		SecLevel: 256, HashFamily: "SHA2", FileKeystore: &factory.FileKeystoreOpts{KeyStorePath: ksDir}}})
+	FatalError(t, err, "Failed to initialize BCCSP")
+
+	for _, kr := range []*csr.KeyRequest{nil, {A: "ecdsa", S: 384}} {
+		req := &csr.CertificateRequest{CN: "workload1", Hosts: []string{"workload1.example.com"}, KeyRequest: kr}
+		certPEM, keyPEM, err := IssueEphemeral(req, s, fileCSP)
+		FatalError(t, err, "Failed to issue ephemeral certificate")
+		cert, err := GetX509CertificateFromPEM(certPEM)
+		FatalError(t, err, "Failed to parse the issued certificate")
+		assert.Equal(t, "workload1", cert.Subject.CommonName)
+		assert.Equal(t, []string{"workload1.example.com"}, cert.DNSNames)
+
+		priv, _, err := GetPrivateKeyFromPEM(keyPEM)
+		FatalError(t, err, "Failed to parse the returned private key")
+		assert.Equal(t, cert.PublicKey, priv.(*ecdsa.PrivateKey).Public(), "The private key should match the certificate")
+	}
+
+	files, err := ioutil.ReadDir(ksDir)
+	FatalError(t, err, "Failed to read keystore directory")
+	assert.Empty(t, files, "The ephemeral keys should not be stored in the keystore")
+
+	req := &csr.CertificateRequest{CN: "workload1"}
+	_, _, err = IssueEphemeral(&csr.CertificateRequest{CN: "workload1", KeyRequest: &csr.KeyRequest{A: "rsa", S: 2048}}, s, fileCSP)
+	assert.Error(t, err, "RSA ephemeral keys are not supported")
+	for _, algo := range []string{"gmsm2", "sm2"} {
+		_, _, err = IssueEphemeral(&csr.CertificateRequest{CN: "workload1", KeyRequest: &csr.KeyRequest{A: algo, S: 256}}, s, fileCSP)
+		if assert.Error(t, err, "SM2 ephemeral keys are not supported") {
+			assert.Contains(t, err.Error(), "SM2 ephemeral keys can't be issued", "The %s algorithm should be rejected as SM2", algo)
+		}
+	}
+	// A CSP other than the SW provider, such as PKCS11, must not be used
+	_, _, err = IssueEphemeral(req, s, struct{ bccsp.BCCSP }{fileCSP})
+	assert.Error(t, err, "Ephemeral keys should only be issued with the SW provider")
+	_, _, err = IssueEphemeral(req, nil, fileCSP)
+	assert.Error(t, err)
+	_, _, err = IssueEphemeral(req, s, nil)
+	assert.Error(t, err)
+	_, _, err = IssueEphemeral(nil, s, fileCSP)
+	assert.Error(t, err)
+}
+
+func TestIssueCertificateDER(t *testing.T) {
+	s := newTestCASigner(t)
+
+	certPEM, certDER, serial, err := IssueCertificateDER(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))})
+	FatalError(t, err, "Failed to issue certificate")
+	assert.Equal(t, certPEM, CertificateToPEM(certDER, nil), "The DER certificate must re-encode to the same PEM")
+	cert, err := x509.ParseCertificate(certDER)
+	FatalError(t, err, "Failed to parse DER certificate")
+	assert.Equal(t, serial, cert.SerialNumber)
+
+	_, _, _, err = IssueCertificateDER(nil, signer.SignRequest{})
+	assert.Error(t, err, "Issuing a certificate without a signer should fail")
+}
+
+func TestIssueCertificateWithChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuechain")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+
+	root := newTestChainCert(t, "chainroot", true, nil)
+	ica := newTestChainCert(t, "chainica", true, root)
+	icaFile := filepath.Join(dir, "ica-cert.pem")
+	icaKeyFile := filepath.Join(dir, "ica-key.pem")
+	chainFile := filepath.Join(dir, "ca-chain.pem")
+	keyPEM, err := PrivateKeyToPEM(ica.key, nil)
+	FatalError(t, err, "Failed to encode key")
+	FatalError(t, ioutil.WriteFile(icaFile, ica.pem, 0644), "Failed to write certificate")
+	FatalError(t, ioutil.WriteFile(icaKeyFile, keyPEM, 0600), "Failed to write key")
+	FatalError(t, ioutil.WriteFile(chainFile, chainPEM(ica, root), 0644), "Failed to write chain")
+	s, err := BccspBackedSigner(icaFile, icaKeyFile, nil, csp)
+	FatalError(t, err, "Failed to create CA signer")
+
+	bundle, serial, err := IssueCertificateWithChain(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))}, chainFile)
+	FatalError(t, err, "Failed to issue certificate with chain")
+	certs, err := GetX509CertificatesFromPEM(bundle)
+	FatalError(t, err, "Failed to parse bundle")
+	if assert.Len(t, certs, 3) {
+		assert.Equal(t, "user1", certs[0].Subject.CommonName)
+		assert.Equal(t, serial, certs[0].SerialNumber)
+		assert.Equal(t, "chainica", certs[1].Subject.CommonName)
+		assert.Equal(t, "chainroot", certs[2].Subject.CommonName)
+	}
+	assert.NoError(t, VerifyChainLinkage(bundle))
+
+	// Without a chain file, only the signer certificate is appended
+	bundle, _, err = IssueCertificateWithChain(s, signer.SignRequest{Request: string(newTestCSR(t, "user2"))}, "")
+	FatalError(t, err, "Failed to issue certificate with chain")
+	certs, err = GetX509CertificatesFromPEM(bundle)
+	FatalError(t, err, "Failed to parse bundle")
+	if assert.Len(t, certs, 2) {
+		assert.Equal(t, "user2", certs[0].Subject.CommonName)
+		assert.Equal(t, "chainica", certs[1].Subject.CommonName)
+	}
+
+	// The chain must start with the issuer of the certificate
+	FatalError(t, ioutil.WriteFile(chainFile, root.pem, 0644), "Failed to write chain")
+	_, _, err = IssueCertificateWithChain(s, signer.SignRequest{Request: string(newTestCSR(t, "user3"))}, chainFile)
+	assert.Error(t, err, "Chain which does not start with the issuer should fail")
+	_, _, err = IssueCertificateWithChain(s, signer.SignRequest{Request: string(newTestCSR(t, "user3"))}, filepath.Join(dir, "missing.pem"))
+	assert.Error(t, err, "Missing chain file should fail")
+}
+
+func TestRenew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renew")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+
+	caFile, caKeyFile := createTestRootCA(t, dir, "renewca")
+	longProfile := config.DefaultConfig()
+	longProfile.Expiry = 10 * 365 * 24 * time.Hour
+	policy := &config.Signing{
+		Default:  config.DefaultConfig(),
+		Profiles: map[string]*config.SigningProfile{"long": longProfile},
+	}
+	s, err := BccspBackedSigner(caFile, caKeyFile, policy, csp)
+	FatalError(t, err, "Failed to create CA signer")
+	dba := &recordingAccessor{}
+	ls, _ := LocalSigner(s)
+	ls.SetDBAccessor(dba)
+	oldPEM, _, err := IssueCertificate(s, signer.SignRequest{
+		Request: string(newTestCSR(t, "user1")),
+		Hosts:   []string{"peer0.example.com", "peer0.org1.example.com", "10.0.0.1", "admin@example.com"},
+	})
+	FatalError(t, err, "Failed to issue certificate")
+	oldFile := filepath.Join(dir, "old-cert.pem")
+	FatalError(t, ioutil.WriteFile(oldFile, oldPEM, 0644), "Failed to write certificate")
+	oldCert, err := GetX509CertificateFromPEM(oldPEM)
+	FatalError(t, err, "Failed to parse certificate")
+
+	dba.records = nil
+	certPEM, err := Renew(oldFile, s, "", 12*time.Hour, nil)
+	FatalError(t, err, "Failed to renew certificate")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse renewed certificate")
+	if assert.Len(t, dba.records, 1, "The renewed certificate should be recorded") {
+		assert.Equal(t, cert.SerialNumber.String(), dba.records[0].Serial)
+		assert.Equal(t, string(certPEM), dba.records[0].PEM)
+	}
+
+	assert.Equal(t, oldCert.RawSubject, cert.RawSubject)
+	assert.Equal(t, oldCert.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo)
+	assert.Equal(t, oldCert.Extensions, cert.Extensions, "The extensions should be preserved")
+	assert.Equal(t, []string{"peer0.example.com", "peer0.org1.example.com"}, cert.DNSNames)
+	if assert.Len(t, cert.IPAddresses, 1) {
+		assert.Equal(t, "10.0.0.1", cert.IPAddresses[0].String())
+	}
+	assert.Equal(t, []string{"admin@example.com"}, cert.EmailAddresses)
+	assert.NotEqual(t, 0, oldCert.SerialNumber.Cmp(cert.SerialNumber))
+	assert.WithinDuration(t, time.Now().Add(12*time.Hour), cert.NotAfter, time.Minute)
+	caCert, err := GetX509CertificateFromPEMFile(caFile)
+	FatalError(t, err, "Failed to parse CA certificate")
+	assert.NoError(t, cert.CheckSignatureFrom(caCert))
+
+	// The validity may not exceed the expiry of the profile
+	_, err = Renew(oldFile, s, "", 10*365*24*time.Hour, nil)
+	assert.Error(t, err)
+
+	// The validity does not exceed that of the CA
+	certPEM, err = Renew(oldFile, s, "long", 10*365*24*time.Hour, nil)
+	FatalError(t, err, "Failed to renew certificate")
+	cert, err = GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse renewed certificate")
+	assert.False(t, cert.NotAfter.After(caCert.NotAfter))
+
+	// Certificates of other CAs can't be renewed
+	otherFile, _ := createTestRootCA(t, dir, "otherca")
+	_, err = Renew(otherFile, s, "", time.Hour, nil)
+	assert.Error(t, err)
+	_, err = Renew(oldFile, s, "", 0, nil)
+	assert.Error(t, err)
+	_, err = Renew("doesnotexist.pem", s, "", time.Hour, nil)
+	assert.Error(t, err)
+	_, err = Renew(oldFile, nil, "", time.Hour, nil)
+	assert.Error(t, err)
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestRenewFrozenClock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "renewclock")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+
+	caFile, caKeyFile := createTestRootCA(t, dir, "clockca")
+	s, err := BccspBackedSigner(caFile, caKeyFile, nil, csp)
+	FatalError(t, err, "Failed to create CA signer")
+	oldPEM, _, err := IssueCertificate(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))})
+	FatalError(t, err, "Failed to issue certificate")
+	oldFile := filepath.Join(dir, "old-cert.pem")
+	FatalError(t, ioutil.WriteFile(oldFile, oldPEM, 0644), "Failed to write certificate")
+
+	// Certificates encode their validity with a precision of one second
+	frozen := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	SetClock(fixedClock(frozen))
+	defer SetClock(nil)
+	assert.Equal(t, frozen, Now())
+
+	certPEM, err := Renew(oldFile, s, "", 2*time.Hour, nil)
+	FatalError(t, err, "Failed to renew certificate")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse renewed certificate")
+	assert.Equal(t, frozen, cert.NotBefore)
+	assert.Equal(t, frozen.Add(2*time.Hour), cert.NotAfter)
+
+	SetClock(nil)
+	assert.WithinDuration(t, time.Now(), Now(), time.Minute, "A nil clock should restore the system time")
+}
+
+func TestReSignAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resign")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	certDir := filepath.Join(dir, "certs")
+	FatalError(t, os.Mkdir(certDir, 0755), "Failed to create certificate directory")
+
+	oldCAFile, oldCAKeyFile := createTestRootCA(t, dir, "oldca")
+	newCAFile, newCAKeyFile := createTestRootCA(t, dir, "newca")
+	oldSigner, err := BccspBackedSigner(oldCAFile, oldCAKeyFile, nil, csp)
+	FatalError(t, err, "Failed to create old CA signer")
+	newSigner, err := BccspBackedSigner(newCAFile, newCAKeyFile, nil, csp)
+	FatalError(t, err, "Failed to create new CA signer")
+	oldCerts := map[string]*x509.Certificate{}
+	for _, name := range []string{"user1", "user2"} {
+		certPEM, _, err := IssueCertificate(oldSigner, signer.SignRequest{
+			Request: string(newTestCSR(t, name)),
+			Hosts:   []string{name + ".example.com"},
+		})
+		FatalError(t, err, "Failed to issue certificate")
+		file := filepath.Join(certDir, name+".pem")
+		FatalError(t, ioutil.WriteFile(file, certPEM, 0644), "Failed to write certificate")
+		oldCerts[file], err = GetX509CertificateFromPEM(certPEM)
+		FatalError(t, err, "Failed to parse certificate")
+	}
+	badFile := filepath.Join(certDir, "bad.pem")
+	FatalError(t, ioutil.WriteFile(badFile, []byte("not a certificate"), 0644), "Failed to write file")
+	dba := &recordingAccessor{}
+	ls, _ := LocalSigner(newSigner)
+	ls.SetDBAccessor(dba)
+
+	// An invalid file prevents all the certificates from being replaced
+	failures, err := ReSignAll(certDir, newSigner, "", nil)
+	assert.Error(t, err)
+	if assert.Len(t, failures, 1, "Only the invalid file should fail") {
+		assert.Error(t, failures[badFile])
+	}
+	assert.Empty(t, dba.records, "No certificate should be issued")
+	for file, oldCert := range oldCerts {
+		cert, err := GetX509CertificateFromPEMFile(file)
+		FatalError(t, err, "Failed to parse certificate")
+		assert.Equal(t, oldCert.Raw, cert.Raw, "The certificate should not be replaced")
+	}
+	FatalError(t, os.Remove(badFile), "Failed to remove file")
+
+	// A certificate which can't be recorded restores the previous certificates
+	ls.SetDBAccessor(&failingAccessor{limit: 1})
+	_, err = ReSignAll(certDir, newSigner, "", nil)
+	assert.Error(t, err)
+	for file, oldCert := range oldCerts {
+		cert, err := GetX509CertificateFromPEMFile(file)
+		FatalError(t, err, "Failed to parse certificate")
+		assert.Equal(t, oldCert.Raw, cert.Raw, "The previous certificate should be restored")
+	}
+	leftovers, err := filepath.Glob(filepath.Join(certDir, "*.pem.*"))
+	FatalError(t, err, "Failed to list files")
+	assert.Empty(t, leftovers, "No .new or .bak file should be left")
+	ls.SetDBAccessor(dba)
+
+	failures, err = ReSignAll(certDir, newSigner, "", nil)
+	FatalError(t, err, "Failed to re-sign certificates")
+	assert.Empty(t, failures)
+	assert.Len(t, dba.records, len(oldCerts), "The re-signed certificates should be recorded")
+
+	newCA, err := GetX509CertificateFromPEMFile(newCAFile)
+	FatalError(t, err, "Failed to parse new CA certificate")
+	for file, oldCert := range oldCerts {
+		cert, err := GetX509CertificateFromPEMFile(file)
+		FatalError(t, err, "Failed to parse re-signed certificate")
+		assert.NoError(t, cert.CheckSignatureFrom(newCA), "The certificate should chain to the new CA")
+		assert.Equal(t, newCA.SubjectKeyId, cert.AuthorityKeyId)
+		assert.Equal(t, oldCert.RawSubject, cert.RawSubject)
+		assert.Equal(t, oldCert.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo)
+		assert.Equal(t, oldCert.DNSNames, cert.DNSNames)
+		assert.Equal(t, newCA.NotAfter, cert.NotAfter, "The validity should not exceed that of the new CA")
+		assert.NotEqual(t, 0, oldCert.SerialNumber.Cmp(cert.SerialNumber))
+		backup, err := GetX509CertificateFromPEMFile(file + ".bak")
+		FatalError(t, err, "Failed to parse backup certificate")
+		assert.Equal(t, oldCert.Raw, backup.Raw, "The previous certificate should be kept")
+	}
+	newFiles, err := filepath.Glob(filepath.Join(certDir, "*.new"))
+	FatalError(t, err, "Failed to list files")
+	assert.Empty(t, newFiles)
+
+	_, err = ReSignAll(certDir, nil, "", nil)
+	assert.Error(t, err)
+}
+
+func TestSignFromTemplateSerials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templateserials")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	certDir := filepath.Join(dir, "certs")
+	FatalError(t, os.Mkdir(certDir, 0755), "Failed to create certificate directory")
+
+	rng := bytes.NewReader(bytes.Repeat([]byte{0xAB}, 1024))
+	trngCSP, err := ConfigureCSP(&rngCSP{BCCSP: csp, rng: rng}, CSPOptions{UseCSPRNG: true})
+	FatalError(t, err, "Failed to configure CSP")
+	caFile, caKeyFile := createTestRootCA(t, dir, "serialca")
+	otherRootFile, _ := createTestRootCA(t, dir, "otherroot")
+	s, err := BccspBackedSigner(caFile, caKeyFile, newTestCAPolicy(), trngCSP)
+	FatalError(t, err, "Failed to create CA signer")
+	certPEM, _, err := IssueCertificate(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))})
+	FatalError(t, err, "Failed to issue certificate")
+	certFile := filepath.Join(certDir, "user1.pem")
+	FatalError(t, ioutil.WriteFile(certFile, certPEM, 0644), "Failed to write certificate")
+
+	// Without a serial number source, the serial number is drawn from the
+	// RNG of the CSP of the signer
+	certPEM, err = Renew(certFile, s, "", time.Hour, nil)
+	FatalError(t, err, "Failed to renew certificate")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse renewed certificate")
+	expected := new(big.Int).SetBytes(append([]byte{0x2B}, bytes.Repeat([]byte{0xAB}, 19)...))
+	assert.Equal(t, 0, expected.Cmp(cert.SerialNumber), "The serial number should be drawn from the RNG of the CSP")
+
+	// Otherwise the serial numbers are those of the source
+	serials, err := NewSequentialSerialSource(filepath.Join(dir, "serial"))
+	FatalError(t, err, "Failed to create sequential serial source")
+	certPEM, err = Renew(certFile, s, "", time.Hour, serials)
+	FatalError(t, err, "Failed to renew certificate")
+	cert, err = GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse renewed certificate")
+	assert.Equal(t, big.NewInt(1), cert.SerialNumber)
+
+	certPEM, err = CrossSign(otherRootFile, s, "ca", trngCSP, serials)
+	FatalError(t, err, "Failed to cross-sign certificate")
+	cert, err = GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse cross-signed certificate")
+	assert.Equal(t, big.NewInt(2), cert.SerialNumber)
+
+	_, err = ReSignAll(certDir, s, "", serials)
+	FatalError(t, err, "Failed to re-sign certificates")
+	cert, err = GetX509CertificateFromPEMFile(certFile)
+	FatalError(t, err, "Failed to parse re-signed certificate")
+	assert.Equal(t, big.NewInt(3), cert.SerialNumber)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// jwk is a JSON Web Key as specified by RFC 7517 and RFC 7518
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// jwkCurveNames are the JWK names of the supported elliptic curves
+var jwkCurveNames = map[elliptic.Curve]string{
+	elliptic.P256(): "P-256",
+	elliptic.P384(): "P-384",
+	elliptic.P521(): "P-521",
+}
+
+// CAPublicKeyJWK reads the PEM encoded certificate in certFile and returns its
+// public key as a JSON Web Key. ECDSA keys on the P-256, P-384 and P-521
+// curves and RSA keys are supported. The key ID is the base64url encoded
+// subject key identifier of the certificate, if it has one.
+func CAPublicKeyJWK(certFile string) ([]byte, error) {
+	cert, err := GetX509CertificateFromPEMFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	key := &jwk{Kid: base64.RawURLEncoding.EncodeToString(cert.SubjectKeyId)}
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		crv, ok := jwkCurveNames[pub.Curve]
+		if !ok {
+			return nil, errors.Errorf("Unsupported elliptic curve '%s' of certificate '%s'", pub.Curve.Params().Name, certFile)
+		}
+		// The coordinates are encoded with the full size of the curve
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = crv
+		key.X = base64.RawURLEncoding.EncodeToString(padBytes(pub.X, size))
+		key.Y = base64.RawURLEncoding.EncodeToString(padBytes(pub.Y, size))
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	default:
+		return nil, errors.Errorf("Unsupported public key type %T of certificate '%s'", cert.PublicKey, certFile)
+	}
+	jwkJSON, err := json.Marshal(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal JWK")
+	}
+	return jwkJSON, nil
+}
+
+// padBytes returns the big-endian encoding of n left-padded with zeros to size bytes
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
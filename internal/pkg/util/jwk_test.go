@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCAPublicKeyJWK(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwk")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "p384ca"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		SubjectKeyId: []byte{1, 2, 3},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &p384.PublicKey, p384)
+	FatalError(t, err, "Failed to create certificate")
+	p384File := filepath.Join(dir, "p384-cert.pem")
+	FatalError(t, ioutil.WriteFile(p384File, CertificateToPEM(der, nil), 0644), "Failed to write certificate")
+
+	decode := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		FatalError(t, err, "Failed to decode JWK field")
+		return new(big.Int).SetBytes(b)
+	}
+	tests := []struct {
+		certFile string
+		crv      string
+		size     int
+	}{
+		{filepath.Join("testdata", "ec.pem"), "P-256", 32},
+		{p384File, "P-384", 48},
+	}
+	for _, test := range tests {
+		jwkJSON, err := CAPublicKeyJWK(test.certFile)
+		FatalError(t, err, "Failed to get JWK")
+		var key map[string]string
+		FatalError(t, json.Unmarshal(jwkJSON, &key), "Failed to unmarshal JWK")
+		assert.Equal(t, "EC", key["kty"])
+		assert.Equal(t, test.crv, key["crv"])
+		x, err := base64.RawURLEncoding.DecodeString(key["x"])
+		FatalError(t, err, "Failed to decode x")
+		assert.Len(t, x, test.size, "The coordinates should have the size of the curve")
+
+		cert, err := GetX509CertificateFromPEMFile(test.certFile)
+		FatalError(t, err, "Failed to parse certificate")
+		pub := cert.PublicKey.(*ecdsa.PublicKey)
+		assert.Equal(t, 0, pub.X.Cmp(decode(key["x"])))
+		assert.Equal(t, 0, pub.Y.Cmp(decode(key["y"])))
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(cert.SubjectKeyId), key["kid"])
+	}
+
+	rsaFile := filepath.Join("..", "..", "..", "testdata", "rsa2048-1-cert.pem")
+	jwkJSON, err := CAPublicKeyJWK(rsaFile)
+	FatalError(t, err, "Failed to get JWK")
+	var key map[string]string
+	FatalError(t, json.Unmarshal(jwkJSON, &key), "Failed to unmarshal JWK")
+	assert.Equal(t, "RSA", key["kty"])
+	cert, err := GetX509CertificateFromPEMFile(rsaFile)
+	FatalError(t, err, "Failed to parse certificate")
+	pub := cert.PublicKey.(*rsa.PublicKey)
+	assert.Equal(t, 0, pub.N.Cmp(decode(key["n"])))
+	assert.Equal(t, int64(pub.E), decode(key["e"]).Int64())
+
+	_, err = CAPublicKeyJWK(filepath.Join("testdata", "ec-key.pem"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,239 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KDFPBKDF2SHA256 is PBKDF2 with HMAC-SHA256, the key derivation function of
+// the password of encrypted PEM private keys, as named in the KDF header of
+// the PEM block. SM4-CBC keys derived with PBKDF2-SM3, as written by GM
+// tools, are not supported: this build has no vetted SM4 implementation.
+const KDFPBKDF2SHA256 = "PBKDF2-SHA256"
+
+const (
+	dekInfoHeader = "DEK-Info"
+	kdfHeader     = "KDF"
+	// legacyKDF names the MD5 based key derivation of OpenSSL, used when an
+	// encrypted PEM block has no KDF header
+	legacyKDF = "legacy OpenSSL"
+	// pemKDFIterations is the number of iterations used to encrypt keys
+	pemKDFIterations = 10000
+	pemKDFSaltSize   = 16
+	// maxPEMKDFIterations bounds the work done to decrypt a key
+	maxPEMKDFIterations = 10000000
+)
+
+// pemCipher is a cipher of encrypted PEM private keys, in CBC mode, with the
+// key derivation function its key is derived with
+type pemCipher struct {
+	keySize  int
+	newBlock func(key []byte) (cipher.Block, error)
+	kdf      string
+}
+
+// pemCiphers are the supported ciphers of encrypted PEM private keys, as named
+// in the DEK-Info header of the PEM block
+var pemCiphers = map[string]pemCipher{
+	"AES-128-CBC": {16, aes.NewCipher, KDFPBKDF2SHA256},
+	"AES-192-CBC": {24, aes.NewCipher, KDFPBKDF2SHA256},
+	"AES-256-CBC": {32, aes.NewCipher, KDFPBKDF2SHA256},
+}
+
+// pemKDFHashes are the hash functions of the supported key derivation functions
+var pemKDFHashes = map[string]func() hash.Hash{
+	KDFPBKDF2SHA256: sha256.New,
+}
+
+// EncryptPEMPrivateKey encrypts the PEM encoded private key in raw with the key
+// derived from password, using the cipher cipherName: AES-128-CBC, AES-192-CBC
+// or AES-256-CBC, whose key is derived with PBKDF2-SHA256. The cipher and its IV are recorded in the
+// DEK-Info header of the returned PEM block, and the key derivation function,
+// its iterations and salt in its KDF header.
+func EncryptPEMPrivateKey(raw, password []byte, cipherName string) ([]byte, error) {
+	block, _, err := decodePEM(raw)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to decode the PEM-encoded private key")
+	}
+	if block.Headers[procTypeHeader] == encryptedHeader {
+		return nil, errors.New("The private key is already encrypted")
+	}
+	c, ok := pemCiphers[cipherName]
+	if !ok {
+		return nil, errors.Errorf("Unsupported cipher '%s'", cipherName)
+	}
+	if len(password) == 0 {
+		return nil, errors.New("The password must not be empty")
+	}
+	salt := make([]byte, pemKDFSaltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate salt")
+	}
+	key := pbkdf2(password, salt, pemKDFIterations, c.keySize, pemKDFHashes[c.kdf])
+	defer ZeroizeKeyBytes(key)
+	b, err := c.newBlock(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create %s cipher", cipherName)
+	}
+	iv := make([]byte, b.BlockSize())
+	if _, err = rand.Read(iv); err != nil {
+		return nil, errors.Wrap(err, "Failed to generate IV")
+	}
+	// PKCS#7 padding
+	pad := b.BlockSize() - len(block.Bytes)%b.BlockSize()
+	data := append(append([]byte{}, block.Bytes...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(b, iv).CryptBlocks(data, data)
+
+	headers := map[string]string{}
+	for k, v := range block.Headers {
+		headers[k] = v
+	}
+	headers[procTypeHeader] = encryptedHeader
+	headers[dekInfoHeader] = cipherName + "," + strings.ToUpper(hex.EncodeToString(iv))
+	headers[kdfHeader] = c.kdf + "," + strconv.Itoa(pemKDFIterations) + "," + strings.ToUpper(hex.EncodeToString(salt))
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Headers: headers, Bytes: data}), nil
+}
+
+// DecryptPEMPrivateKey decrypts the encrypted PEM private key in raw with the
+// key derived from password and returns it as an unencrypted PEM block. The
+// cipher is read from the DEK-Info header of the PEM block and the key
+// derivation function from its KDF header, as written by EncryptPEMPrivateKey:
+// AES-CBC keys are derived with PBKDF2-SHA256. AES-CBC keys without a KDF
+// header, as encrypted by OpenSSL, are also supported. Other combinations of
+// a key derivation function and a cipher, such as SM4-CBC with PBKDF2-SM3,
+// result in an error.
+func DecryptPEMPrivateKey(raw, password []byte) ([]byte, error) {
+	block, _, err := decodePEM(raw)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to decode the PEM-encoded private key")
+	}
+	if block.Headers[procTypeHeader] != encryptedHeader {
+		return nil, errors.New("The PEM private key is not encrypted")
+	}
+	dekInfo := strings.SplitN(block.Headers[dekInfoHeader], ",", 2)
+	if len(dekInfo) != 2 {
+		return nil, errors.Errorf("Invalid %s header '%s'", dekInfoHeader, block.Headers[dekInfoHeader])
+	}
+	cipherName := strings.ToUpper(strings.TrimSpace(dekInfo[0]))
+	iv, err := hex.DecodeString(strings.TrimSpace(dekInfo[1]))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid IV in the %s header", dekInfoHeader)
+	}
+	kdfParams := strings.Split(block.Headers[kdfHeader], ",")
+	kdf := strings.ToUpper(strings.TrimSpace(kdfParams[0]))
+	if kdf == "" {
+		kdf = legacyKDF
+	}
+	c, ok := pemCiphers[cipherName]
+	if !ok || (kdf != c.kdf && (kdf != legacyKDF || c.kdf != KDFPBKDF2SHA256)) {
+		return nil, errors.Errorf("Unsupported combination of the key derivation function '%s' and the cipher '%s'", kdf, cipherName)
+	}
+
+	var plain []byte
+	if kdf == legacyKDF {
+		plain, err = x509.DecryptPEMBlock(block, password)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to decrypt the private key")
+		}
+	} else {
+		plain, err = decryptPEMBlock(block.Bytes, password, c, kdfParams, iv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	headers := map[string]string{}
+	for k, v := range block.Headers {
+		if k != procTypeHeader && k != dekInfoHeader && k != kdfHeader {
+			headers[k] = v
+		}
+	}
+	defer ZeroizeKeyBytes(plain)
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Headers: headers, Bytes: plain}), nil
+}
+
+// decryptPEMBlock decrypts data with c, whose key is derived from password
+// with the parameters of the KDF header kdfParams
+func decryptPEMBlock(data, password []byte, c pemCipher, kdfParams []string, iv []byte) ([]byte, error) {
+	if len(kdfParams) != 3 {
+		return nil, errors.Errorf("Invalid %s header; expecting the key derivation function, iterations and salt", kdfHeader)
+	}
+	iterations, err := strconv.Atoi(strings.TrimSpace(kdfParams[1]))
+	if err != nil || iterations <= 0 || iterations > maxPEMKDFIterations {
+		return nil, errors.Errorf("Invalid iterations '%s' in the %s header; it must be between 1 and %d",
+			kdfParams[1], kdfHeader, maxPEMKDFIterations)
+	}
+	salt, err := hex.DecodeString(strings.TrimSpace(kdfParams[2]))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid salt in the %s header", kdfHeader)
+	}
+	key := pbkdf2(password, salt, iterations, c.keySize, pemKDFHashes[c.kdf])
+	defer ZeroizeKeyBytes(key)
+	b, err := c.newBlock(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create cipher")
+	}
+	if len(iv) != b.BlockSize() {
+		return nil, errors.Errorf("Invalid IV of %d bytes; it must be %d bytes", len(iv), b.BlockSize())
+	}
+	if len(data) == 0 || len(data)%b.BlockSize() != 0 {
+		return nil, errors.New("The encrypted private key is not a multiple of the block size")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(b, iv).CryptBlocks(plain, data)
+	// A wrong password most likely results in an invalid PKCS#7 padding
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > b.BlockSize() || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		ZeroizeKeyBytes(plain)
+		return nil, errors.New("Failed to decrypt the private key; the password may be incorrect")
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// pbkdf2 derives a key of keyLen bytes from password and salt with PBKDF2 of
+// RFC 8018, using HMAC with the hash function h
+func pbkdf2(password, salt []byte, iterations, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+	var key []byte
+	u := make([]byte, size)
+	t := make([]byte, size)
+	for i := uint32(1); len(key) < keyLen; i++ {
+		prf.Reset()
+		prf.Write(salt)
+		var ctr [4]byte
+		binary.BigEndian.PutUint32(ctr[:], i)
+		prf.Write(ctr[:])
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	ZeroizeKeyBytes(key[keyLen:])
+	return key[:keyLen]
+}
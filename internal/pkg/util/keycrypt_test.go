@@ -0,0 +1,103 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecryptPEMPrivateKey(t *testing.T) {
+	keyPEM, err := ioutil.ReadFile(filepath.Join("testdata", "ec-key.pem"))
+	FatalError(t, err, "Failed to read key")
+	key, _, err := GetPrivateKeyFromPEM(keyPEM)
+	FatalError(t, err, "Failed to parse key")
+
+	// ec-key-aes.pem was encrypted with AES-256-CBC by OpenSSL without a KDF
+	// header
+	encrypted, err := ioutil.ReadFile(filepath.Join("testdata", "ec-key-aes.pem"))
+	FatalError(t, err, "Failed to read encrypted key")
+	decrypted, err := DecryptPEMPrivateKey(encrypted, []byte("aespassword"))
+	if assert.NoError(t, err, "Failed to decrypt ec-key-aes.pem") {
+		decryptedKey, headers, err := GetPrivateKeyFromPEM(decrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, key, decryptedKey, "The key decrypted from ec-key-aes.pem should match")
+		assert.Empty(t, headers, "The encryption headers should be removed")
+	}
+	_, err = DecryptPEMPrivateKey(encrypted, []byte("wrongpassword"))
+	assert.Error(t, err, "Decrypting with a wrong password should fail")
+
+	// ec-key-sm4.pem was encrypted with SM4-CBC and a key derived with
+	// PBKDF2-SM3 by OpenSSL, which is not supported
+	encrypted, err = ioutil.ReadFile(filepath.Join("testdata", "ec-key-sm4.pem"))
+	FatalError(t, err, "Failed to read encrypted key")
+	_, err = DecryptPEMPrivateKey(encrypted, []byte("sm4password"))
+	if assert.Error(t, err, "Decrypting an SM4-CBC key should fail") {
+		assert.Contains(t, err.Error(), "Unsupported combination of the key derivation function 'PBKDF2-SM3' and the cipher 'SM4-CBC'")
+	}
+
+	for _, cipherName := range []string{"AES-128-CBC", "AES-256-CBC"} {
+		encrypted, err := EncryptPEMPrivateKey(keyPEM, []byte("password"), cipherName)
+		FatalError(t, err, "Failed to encrypt key with "+cipherName)
+		block, _ := pem.Decode(encrypted)
+		assert.True(t, strings.HasPrefix(block.Headers["DEK-Info"], cipherName+","))
+		decrypted, err := DecryptPEMPrivateKey(encrypted, []byte("password"))
+		if assert.NoError(t, err, "Failed to decrypt key encrypted with %s", cipherName) {
+			decryptedKey, _, err := GetPrivateKeyFromPEM(decrypted)
+			assert.NoError(t, err)
+			assert.Equal(t, key, decryptedKey)
+		}
+	}
+	for _, cipherName := range []string{"DES-CBC", "SM4-CBC"} {
+		_, err = EncryptPEMPrivateKey(keyPEM, []byte("password"), cipherName)
+		assert.Error(t, err, "Unsupported cipher %s should fail", cipherName)
+	}
+	_, err = DecryptPEMPrivateKey(keyPEM, []byte("password"))
+	assert.Error(t, err, "Decrypting an unencrypted key should fail")
+
+	aesPEM, err := EncryptPEMPrivateKey(keyPEM, []byte("password"), "AES-256-CBC")
+	FatalError(t, err, "Failed to encrypt key")
+	block, _ := pem.Decode(aesPEM)
+	kdf := block.Headers["KDF"]
+	for _, headers := range []map[string]string{
+		{"KDF": strings.Replace(kdf, "PBKDF2-SHA256", "PBKDF2-SM3", 1)},
+		{"DEK-Info": strings.Replace(block.Headers["DEK-Info"], "AES-256-CBC", "SM4-CBC", 1)},
+		{"DEK-Info": strings.Replace(block.Headers["DEK-Info"], "AES-256-CBC", "DES-CBC", 1)},
+	} {
+		tampered := &pem.Block{Type: block.Type, Headers: map[string]string{}, Bytes: block.Bytes}
+		for k, v := range block.Headers {
+			tampered.Headers[k] = v
+		}
+		for k, v := range headers {
+			tampered.Headers[k] = v
+		}
+		_, err = DecryptPEMPrivateKey(pem.EncodeToMemory(tampered), []byte("password"))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "Unsupported combination")
+		}
+	}
+}
+
+func TestImportBCCSPKeyFromEncryptedPEM(t *testing.T) {
+	plainKey, err := ImportBCCSPKeyFromPEM(filepath.Join("testdata", "ec-key.pem"), csp, true)
+	FatalError(t, err, "Failed to import key")
+	key, err := ImportBCCSPKeyFromEncryptedPEM(filepath.Join("testdata", "ec-key-aes.pem"), []byte("aespassword"), csp, true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, plainKey.SKI(), key.SKI())
+	}
+	_, err = ImportBCCSPKeyFromEncryptedPEM(filepath.Join("testdata", "ec-key-aes.pem"), []byte("wrongpassword"), csp, true)
+	assert.Error(t, err)
+	_, err = ImportBCCSPKeyFromEncryptedPEM(filepath.Join(os.TempDir(), "nonexistent.pem"), []byte("password"), csp, true)
+	assert.Error(t, err)
+}
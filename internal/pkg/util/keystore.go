@@ -0,0 +1,134 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/pkg/errors"
+)
+
+// privateKeySuffix is the suffix of the private key files in a SW file keystore
+const privateKeySuffix = "_sk"
+
+// listKeystorePrivateKeys returns the paths of the private key files in the
+// SW file keystore directory dir, in lexical order
+func listKeystorePrivateKeys(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read keystore directory '%s'", dir)
+	}
+	var files []string
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), privateKeySuffix) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, info.Name()))
+	}
+	return files, nil
+}
+
+// registeredKeystoreDir returns the directory of the SW file keystore
+// configured in opts, or "" if opts configures no file keystore or an
+// ephemeral one
+func registeredKeystoreDir(opts *factory.FactoryOpts) string {
+	dir, err := getKeystoreDir(opts)
+	if err != nil || opts.SwOpts.Ephemeral {
+		return ""
+	}
+	return dir
+}
+
+// keystoreEmpty returns true if csp has a SW file keystore whose directory
+// does not exist or holds no private key
+func keystoreEmpty(csp bccsp.BCCSP) bool {
+	dir := getCSPOptions(csp).keystoreDir
+	if dir == "" {
+		return false
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return true
+	}
+	files, err := listKeystorePrivateKeys(dir)
+	return err == nil && len(files) == 0
+}
+
+// getKeystoreDir returns the directory of the SW file keystore configured in opts
+func getKeystoreDir(opts *factory.FactoryOpts) (string, error) {
+	if opts == nil || opts.SwOpts == nil {
+		return "", errors.New("No SW BCCSP options were provided")
+	}
+	if opts.SwOpts.FileKeystore == nil || opts.SwOpts.FileKeystore.KeyStorePath == "" {
+		return "", errors.New("The SW BCCSP options do not configure a file keystore")
+	}
+	return opts.SwOpts.FileKeystore.KeyStorePath, nil
+}
+
+// CheckKeystoreIntegrity scans the SW file keystore configured in opts and
+// returns the hex encoded SKIs which are shared by more than one private key
+// file. The SKI of each key is computed from the key itself rather than taken
+// from the file name, so that keys stored under the wrong name are detected.
+// Files which cannot be parsed as private keys are skipped.
+func CheckKeystoreIntegrity(opts *factory.FactoryOpts) ([]string, error) {
+	dir, err := getKeystoreDir(opts)
+	if err != nil {
+		return nil, err
+	}
+	files, err := listKeystorePrivateKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Compute the SKIs with an ephemeral CSP so that nothing is written to the keystore
+	swOpts := &factory.SwOpts{
+		HashFamily: opts.SwOpts.HashFamily,
+		SecLevel:   opts.SwOpts.SecLevel,
+		Ephemeral:  true,
+	}
+	if swOpts.HashFamily == "" {
+		swOpts.HashFamily = "SHA2"
+	}
+	if swOpts.SecLevel == 0 {
+		swOpts.SecLevel = 256
+	}
+	csp, err := factory.GetBCCSPFromOpts(&factory.FactoryOpts{ProviderName: "SW", SwOpts: swOpts})
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to initialize BCCSP to check keystore")
+	}
+	return findDuplicateSKIs(files, csp), nil
+}
+
+// findDuplicateSKIs returns the SKIs, in lexical order, shared by more than
+// one of the private key files
+func findDuplicateSKIs(files []string, csp bccsp.BCCSP) []string {
+	keyFiles := map[string][]string{}
+	for _, file := range files {
+		key, err := ImportBCCSPKeyFromPEM(file, csp, true)
+		if err != nil {
+			log.Warningf("Skipping keystore file '%s': %s", file, err)
+			continue
+		}
+		ski := hex.EncodeToString(key.SKI())
+		keyFiles[ski] = append(keyFiles[ski], file)
+	}
+	var dups []string
+	for ski, files := range keyFiles {
+		if len(files) > 1 {
+			log.Warningf("SKI %s is shared by keystore files %s", ski, strings.Join(files, ", "))
+			dups = append(dups, ski)
+		}
+	}
+	sort.Strings(dups)
+	return dups
+}
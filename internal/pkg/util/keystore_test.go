@@ -0,0 +1,60 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfssl/csr"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckKeystoreIntegrity(t *testing.T) {
+	ksDir, err := ioutil.TempDir("", "keystore")
+	FatalError(t, err, "Failed to create keystore directory")
+	defer os.RemoveAll(ksDir)
+	opts := factory.GetDefaultOpts()
+	opts.SwOpts.FileKeystore = &factory.FileKeystoreOpts{KeyStorePath: ksDir}
+	opts.SwOpts.Ephemeral = false
+	fileCSP, err := factory.GetBCCSPFromOpts(opts)
+	FatalError(t, err, "Failed to initialize BCCSP")
+
+	key, err := ImportBCCSPKeyFromPEM(filepath.Join("testdata", "ec-key.pem"), fileCSP, false)
+	FatalError(t, err, "Failed to import key")
+	_, _, err = BCCSPKeyRequestGenerate(&csr.CertificateRequest{KeyRequest: csr.NewKeyRequest()}, fileCSP)
+	FatalError(t, err, "Failed to generate key")
+
+	dups, err := CheckKeystoreIntegrity(opts)
+	assert.NoError(t, err)
+	assert.Empty(t, dups)
+
+	// Store a copy of the imported key under another name
+	ski := hex.EncodeToString(key.SKI())
+	keyPEM, err := ioutil.ReadFile(filepath.Join(ksDir, ski+"_sk"))
+	FatalError(t, err, "Failed to read key from keystore")
+	err = ioutil.WriteFile(filepath.Join(ksDir, "0123456789abcdef_sk"), keyPEM, 0600)
+	FatalError(t, err, "Failed to write key to keystore")
+	// Files which are not private keys are ignored
+	err = ioutil.WriteFile(filepath.Join(ksDir, "garbage_sk"), []byte("garbage"), 0600)
+	FatalError(t, err, "Failed to write garbage to keystore")
+
+	dups, err = CheckKeystoreIntegrity(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ski}, dups)
+
+	_, err = CheckKeystoreIntegrity(&factory.FactoryOpts{ProviderName: "SW", SwOpts: &factory.SwOpts{}})
+	assert.Error(t, err, "Keystore check without a file keystore should fail")
+	opts.SwOpts.FileKeystore.KeyStorePath = filepath.Join(ksDir, "missing")
+	_, err = CheckKeystoreIntegrity(opts)
+	assert.Error(t, err, "Keystore check of a missing directory should fail")
+}
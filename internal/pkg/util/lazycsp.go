@@ -0,0 +1,145 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"hash"
+	"sync"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
+)
+
+// LazyCSP is a BCCSP which is initialized from its options on the first key,
+// hash or signing operation rather than when it is created, so that startup
+// is not slowed down by a CSP which is not used right away. It is safe for
+// concurrent use; the CSP is initialized only once.
+type LazyCSP struct {
+	opts    *factory.FactoryOpts
+	homeDir string
+	once    sync.Once
+	csp     bccsp.BCCSP
+	err     error
+}
+
+// NewLazyCSP returns a LazyCSP which initializes the BCCSP configured by opts
+// on first use, as GetBCCSP does. opts should have been configured by
+// ConfigureBCCSP.
+func NewLazyCSP(opts *factory.FactoryOpts, homeDir string) *LazyCSP {
+	return &LazyCSP{opts: opts, homeDir: homeDir}
+}
+
+// Init initializes the BCCSP if it is not initialized yet and returns it, or
+// the error which occurred when initializing it. The initialization is only
+// attempted once, so all callers get the same error if it failed.
+func (l *LazyCSP) Init() (bccsp.BCCSP, error) {
+	l.once.Do(func() {
+		l.csp, l.err = GetBCCSP(l.opts, l.homeDir)
+	})
+	return l.csp, l.err
+}
+
+// CheckHealth initializes the BCCSP if it is not initialized yet and checks
+// that it is operational with CheckCSPHealth. It can be used by health checks
+// to force the initialization of the BCCSP.
+func (l *LazyCSP) CheckHealth() error {
+	csp, err := l.Init()
+	if err != nil {
+		return err
+	}
+	return CheckCSPHealth(csp)
+}
+
+// KeyGen generates a key using opts
+func (l *LazyCSP) KeyGen(opts bccsp.KeyGenOpts) (bccsp.Key, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.KeyGen(opts)
+}
+
+// KeyDeriv derives a key from k using opts
+func (l *LazyCSP) KeyDeriv(k bccsp.Key, opts bccsp.KeyDerivOpts) (bccsp.Key, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.KeyDeriv(k, opts)
+}
+
+// KeyImport imports a key from its raw representation using opts
+func (l *LazyCSP) KeyImport(raw interface{}, opts bccsp.KeyImportOpts) (bccsp.Key, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.KeyImport(raw, opts)
+}
+
+// GetKey returns the key this CSP associates to the Subject Key Identifier ski
+func (l *LazyCSP) GetKey(ski []byte) (bccsp.Key, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.GetKey(ski)
+}
+
+// Hash hashes msg using opts
+func (l *LazyCSP) Hash(msg []byte, opts bccsp.HashOpts) ([]byte, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.Hash(msg, opts)
+}
+
+// GetHash returns an instance of hash.Hash using opts
+func (l *LazyCSP) GetHash(opts bccsp.HashOpts) (hash.Hash, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.GetHash(opts)
+}
+
+// Sign signs digest using key k
+func (l *LazyCSP) Sign(k bccsp.Key, digest []byte, opts bccsp.SignerOpts) ([]byte, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.Sign(k, digest, opts)
+}
+
+// Verify verifies signature against key k and digest
+func (l *LazyCSP) Verify(k bccsp.Key, signature, digest []byte, opts bccsp.SignerOpts) (bool, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return false, err
+	}
+	return csp.Verify(k, signature, digest, opts)
+}
+
+// Encrypt encrypts plaintext using key k
+func (l *LazyCSP) Encrypt(k bccsp.Key, plaintext []byte, opts bccsp.EncrypterOpts) ([]byte, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.Encrypt(k, plaintext, opts)
+}
+
+// Decrypt decrypts ciphertext using key k
+func (l *LazyCSP) Decrypt(k bccsp.Key, ciphertext []byte, opts bccsp.DecrypterOpts) ([]byte, error) {
+	csp, err := l.Init()
+	if err != nil {
+		return nil, err
+	}
+	return csp.Decrypt(k, ciphertext, opts)
+}
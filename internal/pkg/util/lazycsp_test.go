@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cfssl/csr"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazyCSP(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "lazycsp")
+	FatalError(t, err, "Failed to create temporary directory")
+	defer os.RemoveAll(tmpDir)
+	ksPath := filepath.Join(tmpDir, "keystore")
+	opts := &factory.FactoryOpts{
+		ProviderName: "SW",
+		SwOpts: &factory.SwOpts{
+			HashFamily:   "SHA2",
+			SecLevel:     256,
+			FileKeystore: &factory.FileKeystoreOpts{KeyStorePath: ksPath},
+		},
+	}
+
+	// The SW provider creates its keystore when it is initialized
+	lazy := NewLazyCSP(opts, tmpDir)
+	_, err = os.Stat(ksPath)
+	assert.True(t, os.IsNotExist(err), "The CSP should not be initialized when it is created")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := BCCSPKeyRequestGenerate(&csr.CertificateRequest{KeyRequest: csr.NewKeyRequest()}, lazy)
+			assert.NoError(t, err, "Failed to generate key with lazily initialized CSP")
+		}()
+	}
+	wg.Wait()
+	_, err = os.Stat(ksPath)
+	assert.NoError(t, err, "The CSP should be initialized on first use")
+	csp1, err := lazy.Init()
+	FatalError(t, err, "Failed to initialize CSP")
+	csp2, err := lazy.Init()
+	FatalError(t, err, "Failed to initialize CSP")
+	assert.True(t, csp1 == csp2, "The CSP should only be initialized once")
+	assert.NoError(t, lazy.CheckHealth())
+
+	// Errors of the deferred initialization are returned to every caller
+	lazy = NewLazyCSP(&factory.FactoryOpts{ProviderName: "GM"}, tmpDir)
+	_, err = lazy.Hash([]byte("message"), &bccsp.SHA256Opts{})
+	if assert.Error(t, err, "Using a CSP which fails to initialize should fail") {
+		assert.Contains(t, err.Error(), "The BCCSP provider 'GM' is not available in this build")
+	}
+	_, err = lazy.GetKey([]byte("ski"))
+	assert.Error(t, err)
+	assert.Error(t, lazy.CheckHealth())
+}
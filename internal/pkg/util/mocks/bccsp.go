@@ -29,8 +29,12 @@ type BCCSP struct {
 }
 
 // KeyGen generates a key using opts.
-func (*BCCSP) KeyGen(opts bccsp.KeyGenOpts) (k bccsp.Key, err error) {
-	panic("implement me")
+func (m *BCCSP) KeyGen(opts bccsp.KeyGenOpts) (k bccsp.Key, err error) {
+	args := m.Called(opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(bccsp.Key), args.Error(1)
 }
 
 // KeyDeriv derives a key from k using opts.
@@ -51,8 +55,12 @@ func (m *BCCSP) KeyImport(raw interface{}, opts bccsp.KeyImportOpts) (k bccsp.Ke
 
 // GetKey returns the key this CSP associates to
 // the Subject Key Identifier ski.
-func (*BCCSP) GetKey(ski []byte) (k bccsp.Key, err error) {
-	panic("implement me")
+func (m *BCCSP) GetKey(ski []byte) (k bccsp.Key, err error) {
+	args := m.Called(ski)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(bccsp.Key), args.Error(1)
 }
 
 // Hash hashes messages msg using options opts.
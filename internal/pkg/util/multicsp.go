@@ -0,0 +1,132 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"hash"
+	"sync"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/pkg/errors"
+)
+
+// MultiCSP is a BCCSP which searches the keys in the keystores of an ordered
+// list of CSPs, so that GetSignerFromCert can find a private key in any of
+// them. The operations on a key found by GetKey are delegated to the CSP which
+// holds it; all other operations, such as key generation and import, are
+// delegated to the first CSP.
+type MultiCSP struct {
+	csps   []bccsp.BCCSP
+	mutex  sync.RWMutex
+	owners map[string]bccsp.BCCSP
+}
+
+// NewMultiCSP returns a MultiCSP searching the keystores of csps in order
+func NewMultiCSP(csps ...bccsp.BCCSP) (*MultiCSP, error) {
+	if len(csps) == 0 {
+		return nil, errors.New("At least one CSP is required")
+	}
+	for i, csp := range csps {
+		if csp == nil {
+			return nil, errors.Errorf("CSP %d was not initialized", i)
+		}
+	}
+	return &MultiCSP{csps: csps, owners: map[string]bccsp.BCCSP{}}, nil
+}
+
+// GetKey returns the key associated to the Subject Key Identifier ski by the
+// first CSP which holds its private key, or else by the first CSP which holds
+// its public key. The error of the last CSP is returned if none holds it.
+func (m *MultiCSP) GetKey(ski []byte) (bccsp.Key, error) {
+	var pubKey bccsp.Key
+	var pubOwner bccsp.BCCSP
+	var err error
+	for _, csp := range m.csps {
+		var key bccsp.Key
+		key, err = csp.GetKey(ski)
+		if err != nil {
+			continue
+		}
+		if key.Private() {
+			m.setOwner(ski, csp)
+			return key, nil
+		}
+		if pubKey == nil {
+			pubKey, pubOwner = key, csp
+		}
+	}
+	if pubKey != nil {
+		m.setOwner(ski, pubOwner)
+		return pubKey, nil
+	}
+	return nil, err
+}
+
+// setOwner records that the key with the Subject Key Identifier ski is held by csp
+func (m *MultiCSP) setOwner(ski []byte, csp bccsp.BCCSP) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.owners[string(ski)] = csp
+}
+
+// owner returns the CSP which holds k, or the first CSP if k was not found
+// by GetKey
+func (m *MultiCSP) owner(k bccsp.Key) bccsp.BCCSP {
+	if k != nil {
+		m.mutex.RLock()
+		defer m.mutex.RUnlock()
+		if csp, ok := m.owners[string(k.SKI())]; ok {
+			return csp
+		}
+	}
+	return m.csps[0]
+}
+
+// KeyGen generates a key using opts with the first CSP
+func (m *MultiCSP) KeyGen(opts bccsp.KeyGenOpts) (bccsp.Key, error) {
+	return m.csps[0].KeyGen(opts)
+}
+
+// KeyDeriv derives a key from k using opts with the CSP which holds k
+func (m *MultiCSP) KeyDeriv(k bccsp.Key, opts bccsp.KeyDerivOpts) (bccsp.Key, error) {
+	return m.owner(k).KeyDeriv(k, opts)
+}
+
+// KeyImport imports a key from its raw representation using opts with the first CSP
+func (m *MultiCSP) KeyImport(raw interface{}, opts bccsp.KeyImportOpts) (bccsp.Key, error) {
+	return m.csps[0].KeyImport(raw, opts)
+}
+
+// Hash hashes msg using opts with the first CSP
+func (m *MultiCSP) Hash(msg []byte, opts bccsp.HashOpts) ([]byte, error) {
+	return m.csps[0].Hash(msg, opts)
+}
+
+// GetHash returns an instance of hash.Hash using opts from the first CSP
+func (m *MultiCSP) GetHash(opts bccsp.HashOpts) (hash.Hash, error) {
+	return m.csps[0].GetHash(opts)
+}
+
+// Sign signs digest using key k with the CSP which holds k
+func (m *MultiCSP) Sign(k bccsp.Key, digest []byte, opts bccsp.SignerOpts) ([]byte, error) {
+	return m.owner(k).Sign(k, digest, opts)
+}
+
+// Verify verifies signature against key k and digest with the CSP which holds k
+func (m *MultiCSP) Verify(k bccsp.Key, signature, digest []byte, opts bccsp.SignerOpts) (bool, error) {
+	return m.owner(k).Verify(k, signature, digest, opts)
+}
+
+// Encrypt encrypts plaintext using key k with the CSP which holds k
+func (m *MultiCSP) Encrypt(k bccsp.Key, plaintext []byte, opts bccsp.EncrypterOpts) ([]byte, error) {
+	return m.owner(k).Encrypt(k, plaintext, opts)
+}
+
+// Decrypt decrypts ciphertext using key k with the CSP which holds k
+func (m *MultiCSP) Decrypt(k bccsp.Key, ciphertext []byte, opts bccsp.DecrypterOpts) ([]byte, error) {
+	return m.owner(k).Decrypt(k, ciphertext, opts)
+}
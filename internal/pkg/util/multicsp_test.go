@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfssl/csr"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiCSP(t *testing.T) {
+	newFileCSP := func() (bccsp.BCCSP, string) {
+		ksDir, err := ioutil.TempDir("", "keystore")
+		FatalError(t, err, "Failed to create keystore directory")
+		fileCSP, err := factory.GetBCCSPFromOpts(&factory.FactoryOpts{ProviderName: "SW", SwOpts: &factory.SwOpts{
+			SecLevel: 256, HashFamily: "SHA2", FileKeystore: &factory.FileKeystoreOpts{KeyStorePath: ksDir}}})
+		FatalError(t, err, "Failed to initialize BCCSP")
+		return fileCSP, ksDir
+	}
+	csp1, ksDir1 := newFileCSP()
+	defer os.RemoveAll(ksDir1)
+	csp2, ksDir2 := newFileCSP()
+	defer os.RemoveAll(ksDir2)
+
+	key1, _, err := BCCSPKeyRequestGenerate(&csr.CertificateRequest{KeyRequest: csr.NewKeyRequest()}, csp1)
+	FatalError(t, err, "Failed to generate key in the first keystore")
+	key2, err := ImportBCCSPKeyFromPEM(filepath.Join("testdata", "ec-key.pem"), csp2, false)
+	FatalError(t, err, "Failed to import key into the second keystore")
+
+	multi, err := NewMultiCSP(csp1, csp2)
+	FatalError(t, err, "Failed to create MultiCSP")
+	for _, key := range []bccsp.Key{key1, key2} {
+		found, err := multi.GetKey(key.SKI())
+		if assert.NoError(t, err, "The key should be found in one of the keystores") {
+			assert.True(t, found.Private())
+			assert.Equal(t, key.SKI(), found.SKI())
+		}
+	}
+	_, err = multi.GetKey([]byte("unknown"))
+	assert.Error(t, err, "A key in no keystore should not be found")
+
+	// The private key of the certificate is only in the second keystore
+	certPEM, err := ioutil.ReadFile(filepath.Join("testdata", "ec.pem"))
+	FatalError(t, err, "Failed to read certificate")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse certificate")
+	_, _, err = GetSignerFromCert(cert, csp1)
+	assert.Error(t, err, "The private key should not be in the first keystore")
+	key, signer, err := GetSignerFromCert(cert, multi)
+	FatalError(t, err, "Failed to get signer through MultiCSP")
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	FatalError(t, err, "Failed to sign through MultiCSP")
+	valid, err := multi.Verify(key, sig, digest[:], nil)
+	assert.NoError(t, err)
+	assert.True(t, valid, "The signature should be valid")
+
+	_, err = NewMultiCSP()
+	assert.Error(t, err)
+	_, err = NewMultiCSP(csp1, nil)
+	assert.Error(t, err)
+}
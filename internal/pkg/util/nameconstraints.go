@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"net"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/pkg/errors"
+)
+
+// OIDExtNameConstraints is the object identifier of the name constraints
+// extension; it must be in the allowed extensions of the signing profile
+// used to issue constrained intermediate CA certificates
+var OIDExtNameConstraints = asn1.ObjectIdentifier{2, 5, 29, 30}
+
+// NameConstraints are the names which the certificates issued by an
+// intermediate CA are restricted to, as specified by RFC 5280 section 4.2.1.10.
+// DNS domains are matched as in crypto/x509: "example.com" matches the domain
+// and its subdomains, while ".example.com" only matches its subdomains.
+type NameConstraints struct {
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedIPRanges       []*net.IPNet
+	ExcludedIPRanges        []*net.IPNet
+	PermittedEmailAddresses []string
+	ExcludedEmailAddresses  []string
+}
+
+// generalSubtree is the ASN.1 GeneralSubtree structure; minimum and maximum
+// are never encoded since RFC 5280 requires their default values
+type generalSubtree struct {
+	Base asn1.RawValue
+}
+
+type nameConstraints struct {
+	Permitted []generalSubtree `asn1:"optional,tag:0"`
+	Excluded  []generalSubtree `asn1:"optional,tag:1"`
+}
+
+// GeneralName tags of the names supported in name constraints and subject
+// alternative names
+const (
+	generalNameEmail = 1
+	generalNameDNS   = 2
+	generalNameURI   = 6
+	generalNameIP    = 7
+)
+
+// empty returns true if nc contains no constraint
+func (nc *NameConstraints) empty() bool {
+	return nc == nil || len(nc.PermittedDNSDomains)+len(nc.ExcludedDNSDomains)+
+		len(nc.PermittedIPRanges)+len(nc.ExcludedIPRanges)+
+		len(nc.PermittedEmailAddresses)+len(nc.ExcludedEmailAddresses) == 0
+}
+
+// extension returns the critical name constraints extension for nc
+func (nc *NameConstraints) extension() (signer.Extension, error) {
+	permitted, err := generalSubtrees(nc.PermittedDNSDomains, nc.PermittedIPRanges, nc.PermittedEmailAddresses)
+	if err != nil {
+		return signer.Extension{}, errors.WithMessage(err, "Invalid permitted name constraint")
+	}
+	excluded, err := generalSubtrees(nc.ExcludedDNSDomains, nc.ExcludedIPRanges, nc.ExcludedEmailAddresses)
+	if err != nil {
+		return signer.Extension{}, errors.WithMessage(err, "Invalid excluded name constraint")
+	}
+	value, err := asn1.Marshal(nameConstraints{Permitted: permitted, Excluded: excluded})
+	if err != nil {
+		return signer.Extension{}, errors.Wrap(err, "Failed to marshal the name constraints extension")
+	}
+	// RFC 5280 requires the name constraints extension to be critical
+	return signer.Extension{
+		ID:       config.OID(OIDExtNameConstraints),
+		Critical: true,
+		Value:    hex.EncodeToString(value),
+	}, nil
+}
+
+func generalSubtrees(domains []string, ranges []*net.IPNet, emails []string) ([]generalSubtree, error) {
+	var subtrees []generalSubtree
+	for _, domain := range domains {
+		if domain == "" {
+			return nil, errors.New("DNS domain must not be empty")
+		}
+		subtrees = append(subtrees, newGeneralSubtree(generalNameDNS, []byte(domain)))
+	}
+	for _, ipNet := range ranges {
+		if ipNet == nil {
+			return nil, errors.New("IP range must be different from nil")
+		}
+		ip := ipNet.IP
+		if len(ipNet.Mask) == net.IPv4len {
+			ip = ip.To4()
+		}
+		if ip == nil || len(ip) != len(ipNet.Mask) {
+			return nil, errors.Errorf("IP range '%s' has a mask which doesn't match its address", ipNet)
+		}
+		subtrees = append(subtrees, newGeneralSubtree(generalNameIP, append(append([]byte{}, ip...), ipNet.Mask...)))
+	}
+	for _, email := range emails {
+		if email == "" {
+			return nil, errors.New("Email address must not be empty")
+		}
+		subtrees = append(subtrees, newGeneralSubtree(generalNameEmail, []byte(email)))
+	}
+	return subtrees, nil
+}
+
+func newGeneralSubtree(tag int, value []byte) generalSubtree {
+	return generalSubtree{Base: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, Bytes: value}}
+}
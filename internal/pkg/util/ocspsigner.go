@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/hex"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/pkg/errors"
+)
+
+var (
+	// OIDExtExtKeyUsage is the object identifier of the extended key usage
+	// extension; it must be in the allowed extensions of the signing profile
+	// used to issue OCSP signer certificates
+	OIDExtExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+	// OIDExtOCSPNoCheck is the object identifier of the id-pkix-ocsp-nocheck
+	// extension of RFC 6960; it must be in the allowed extensions of the
+	// signing profile used to issue OCSP signer certificates
+	OIDExtOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+	oidExtKeyUsageOCSPSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 9}
+)
+
+// ocspNoCheckValue is the value of the id-pkix-ocsp-nocheck extension, which
+// is an ASN.1 NULL
+var ocspNoCheckValue = []byte{0x05, 0x00}
+
+// IssueOCSPSigner generates a key as specified by the key request of req and
+// issues with caSigner a certificate for it which can sign the OCSP responses
+// of a delegated OCSP responder on behalf of the CA: its only extended key
+// usage is id-kp-OCSPSigning, and it carries the id-pkix-ocsp-nocheck
+// extension, so that OCSP clients don't check its own revocation status. The
+// signing profile of caSigner must allow the OIDExtExtKeyUsage and
+// OIDExtOCSPNoCheck extensions. The returned PEM holds the certificate
+// followed by its PKCS#8 private key; the caller is responsible for
+// protecting the private key. ECDSA P-256 and P-384 keys and Ed25519 keys are
+// supported. SM2 keys are rejected, as the signers can't sign SM2 certificate
+// requests.
+func IssueOCSPSigner(req *csr.CertificateRequest, caSigner signer.Signer) ([]byte, error) {
+	if req == nil {
+		return nil, errors.New("Certificate request must be different from nil")
+	}
+	if caSigner == nil {
+		return nil, errors.New("Signer must be different from nil")
+	}
+	if isSM2KeyRequest(req.KeyRequest) {
+		return nil, errors.New("SM2 OCSP signer certificates can't be issued; SM2 certificate requests are not supported by the signer")
+	}
+	priv, err := generateExportableKey(req.KeyRequest, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := PrivateKeyToPEMWithFormat(priv, KeyFormatPKCS8, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroizeKeyBytes(keyPEM)
+	csrPEM, err := csr.Generate(priv.(crypto.Signer), req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate the certificate request for the OCSP signer key")
+	}
+	eku, err := asn1.Marshal([]asn1.ObjectIdentifier{oidExtKeyUsageOCSPSigning})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal the extended key usage extension")
+	}
+	certPEM, _, err := IssueCertificate(caSigner, signer.SignRequest{
+		Request: string(csrPEM),
+		Hosts:   req.Hosts,
+		Extensions: []signer.Extension{
+			{ID: config.OID(OIDExtExtKeyUsage), Value: hex.EncodeToString(eku)},
+			{ID: config.OID(OIDExtOCSPNoCheck), Value: hex.EncodeToString(ocspNoCheckValue)},
+		},
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to issue the OCSP signer certificate")
+	}
+	return append(certPEM, keyPEM...), nil
+}
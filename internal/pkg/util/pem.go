@@ -0,0 +1,282 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	certPemType     = "CERTIFICATE"
+	ecKeyPemType    = "EC PRIVATE KEY"
+	rsaKeyPemType   = "RSA PRIVATE KEY"
+	pkcs8KeyPemType = "PRIVATE KEY"
+	pubKeyPemType   = "PUBLIC KEY"
+	procTypeHeader  = "Proc-Type"
+	encryptedHeader = "4,ENCRYPTED"
+)
+
+// utf8BOM is the byte order mark which some editors, e.g. on Windows, write
+// at the start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// normalizePEM returns raw without a leading UTF-8 byte order mark and with
+// CRLF line endings replaced by LF. raw is copied, and copied is true, only if
+// it has CRLF line endings.
+func normalizePEM(raw []byte) (normalized []byte, copied bool) {
+	raw = bytes.TrimPrefix(raw, utf8BOM)
+	if !bytes.Contains(raw, []byte("\r\n")) {
+		return raw, false
+	}
+	return bytes.Replace(raw, []byte("\r\n"), []byte("\n"), -1), true
+}
+
+// decodePEM normalizes raw with normalizePEM and decodes its first PEM block.
+// The returned error tells whether raw has no PEM block at all or a malformed
+// one, e.g. with invalid base64 content.
+func decodePEM(raw []byte) (*pem.Block, []byte, error) {
+	raw, _ = normalizePEM(raw)
+	block, rest := pem.Decode(raw)
+	if block == nil {
+		if !bytes.Contains(raw, []byte("-----BEGIN ")) {
+			return nil, raw, errors.New("No PEM block was found")
+		}
+		return nil, raw, errors.New("The PEM block is malformed")
+	}
+	return block, rest, nil
+}
+
+// PrivateKeyToPEM converts an ECDSA or RSA private key to PEM format.
+// The optional headers are emitted in the PEM block.
+func PrivateKeyToPEM(key crypto.PrivateKey, headers map[string]string) ([]byte, error) {
+	if headers[procTypeHeader] == encryptedHeader {
+		return nil, errors.New("Cannot emit an encrypted PEM header for an unencrypted private key")
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k == nil {
+			return nil, errors.New("Invalid ECDSA private key. It must be different from nil")
+		}
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to marshal ECDSA private key")
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: ecKeyPemType, Headers: headers, Bytes: der}), nil
+	case *rsa.PrivateKey:
+		if k == nil {
+			return nil, errors.New("Invalid RSA private key. It must be different from nil")
+		}
+		der := x509.MarshalPKCS1PrivateKey(k)
+		return pem.EncodeToMemory(&pem.Block{Type: rsaKeyPemType, Headers: headers, Bytes: der}), nil
+	default:
+		return nil, errors.Errorf("Invalid key type %T; expecting ECDSA or RSA private key", key)
+	}
+}
+
+// KeyFormat is the encoding of a private key exported to PEM format
+type KeyFormat string
+
+const (
+	// KeyFormatPKCS8 is the PKCS#8 "PRIVATE KEY" format, supported for all
+	// key types. It is the default format.
+	KeyFormatPKCS8 KeyFormat = "pkcs8"
+	// KeyFormatSEC1 is the legacy SEC1 "EC PRIVATE KEY" format for ECDSA keys
+	KeyFormatSEC1 KeyFormat = "sec1"
+	// KeyFormatPKCS1 is the legacy PKCS#1 "RSA PRIVATE KEY" format for RSA keys
+	KeyFormatPKCS1 KeyFormat = "pkcs1"
+	// KeyFormatGM is the GM "PRIVATE KEY" format for SM2 keys
+	KeyFormatGM KeyFormat = "gm"
+)
+
+// PrivateKeyToPEMWithFormat converts an ECDSA, RSA or Ed25519 private key to
+// PEM format using the key format specified by format, or PKCS#8 if format is
+// empty. An error is returned if the key format is not compatible with the
+// type of the key. The optional headers are emitted in the PEM block.
+func PrivateKeyToPEMWithFormat(key crypto.PrivateKey, format KeyFormat, headers map[string]string) ([]byte, error) {
+	if headers[procTypeHeader] == encryptedHeader {
+		return nil, errors.New("Cannot emit an encrypted PEM header for an unencrypted private key")
+	}
+	if format == "" {
+		format = KeyFormatPKCS8
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k == nil {
+			return nil, errors.New("Invalid ECDSA private key. It must be different from nil")
+		}
+	case *rsa.PrivateKey:
+		if k == nil {
+			return nil, errors.New("Invalid RSA private key. It must be different from nil")
+		}
+	case ed25519.PrivateKey:
+	default:
+		return nil, errors.Errorf("Invalid key type %T; expecting ECDSA, RSA or Ed25519 private key", key)
+	}
+	switch format {
+	case KeyFormatPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to marshal private key to PKCS#8")
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: pkcs8KeyPemType, Headers: headers, Bytes: der}), nil
+	case KeyFormatSEC1:
+		if _, ok := key.(*ecdsa.PrivateKey); !ok {
+			return nil, errors.Errorf("Key format '%s' is only supported for ECDSA keys, not %T", format, key)
+		}
+		return PrivateKeyToPEM(key, headers)
+	case KeyFormatPKCS1:
+		if _, ok := key.(*rsa.PrivateKey); !ok {
+			return nil, errors.Errorf("Key format '%s' is only supported for RSA keys, not %T", format, key)
+		}
+		return PrivateKeyToPEM(key, headers)
+	case KeyFormatGM:
+		// No SM2 key type is supported, so no key can be exported in the GM format
+		return nil, errors.Errorf("Key format '%s' is only supported for SM2 keys, not %T", format, key)
+	default:
+		return nil, errors.Errorf("Unsupported key format '%s'", format)
+	}
+}
+
+// CertificateToPEM converts a DER encoded certificate to PEM format.
+// The optional headers are emitted in the PEM block.
+func CertificateToPEM(der []byte, headers map[string]string) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: certPemType, Headers: headers, Bytes: der})
+}
+
+// ExtractPublicKeyPEM reads the PEM encoded certificate in certFile and returns
+// its subject public key as a PKIX "PUBLIC KEY" PEM block
+func ExtractPublicKeyPEM(certFile string) ([]byte, error) {
+	certPEM, err := ReadFile(certFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read certificate file '%s'", certFile)
+	}
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to parse certificate file '%s'", certFile))
+	}
+	der, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to marshal the public key of certificate file '%s'", certFile)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pubKeyPemType, Bytes: der}), nil
+}
+
+// PEMKind is the kind of content of a PEM file
+type PEMKind int
+
+const (
+	// PEMKindUnknown means that the PEM file holds no certificate, private
+	// key or certificate signing request
+	PEMKindUnknown PEMKind = iota
+	// PEMKindCertificate means that the PEM file holds certificates
+	PEMKindCertificate
+	// PEMKindPrivateKey means that the PEM file holds a private key
+	PEMKindPrivateKey
+	// PEMKindCSR means that the PEM file holds a certificate signing request
+	PEMKindCSR
+)
+
+func (k PEMKind) String() string {
+	switch k {
+	case PEMKindCertificate:
+		return "certificate"
+	case PEMKindPrivateKey:
+		return "private key"
+	case PEMKindCSR:
+		return "certificate signing request"
+	}
+	return "unknown"
+}
+
+// pemKinds are the kinds of the PEM block types, including the block types
+// used for SM2 keys by GM tools
+var pemKinds = map[string]PEMKind{
+	"CERTIFICATE":             PEMKindCertificate,
+	"X509 CERTIFICATE":        PEMKindCertificate,
+	"TRUSTED CERTIFICATE":     PEMKindCertificate,
+	"PRIVATE KEY":             PEMKindPrivateKey,
+	"ENCRYPTED PRIVATE KEY":   PEMKindPrivateKey,
+	"EC PRIVATE KEY":          PEMKindPrivateKey,
+	"RSA PRIVATE KEY":         PEMKindPrivateKey,
+	"SM2 PRIVATE KEY":         PEMKindPrivateKey,
+	"CERTIFICATE REQUEST":     PEMKindCSR,
+	"NEW CERTIFICATE REQUEST": PEMKindCSR,
+}
+
+// ClassifyPEM returns the kind of the content of the PEM file path, which is
+// the kind of its first PEM block, ignoring EC parameters blocks. Only the
+// block types are inspected; the content of the blocks is not parsed.
+// PEMKindUnknown is returned if the file holds no PEM block of a known type.
+func ClassifyPEM(path string) (PEMKind, error) {
+	raw, err := ReadFile(path)
+	if err != nil {
+		return PEMKindUnknown, errors.Wrapf(err, "Failed to read PEM file '%s'", path)
+	}
+	raw, _ = normalizePEM(raw)
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			return PEMKindUnknown, nil
+		}
+		if block.Type == "EC PARAMETERS" {
+			continue
+		}
+		return pemKinds[block.Type], nil
+	}
+}
+
+// GetPrivateKeyFromPEM parses an unencrypted ECDSA or RSA private key in PEM
+// format and returns it along with the headers of its PEM block
+func GetPrivateKeyFromPEM(raw []byte) (crypto.PrivateKey, map[string]string, error) {
+	block, _, err := decodePEM(raw)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Failed to decode the PEM-encoded private key")
+	}
+	if block.Headers[procTypeHeader] == encryptedHeader {
+		return nil, nil, errors.New("Encrypted PEM private keys are not supported")
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, block.Headers, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, block.Headers, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed parsing private key")
+	}
+	switch key.(type) {
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+		return key, block.Headers, nil
+	default:
+		return nil, nil, errors.New("Invalid private key type in PKCS#8 wrapping")
+	}
+}
+
+// GetX509CertificateFromPEMWithHeaders gets an X509 certificate from bytes in
+// PEM format along with the headers of its PEM block
+func GetX509CertificateFromPEMWithHeaders(cert []byte) (*x509.Certificate, map[string]string, error) {
+	block, _, err := decodePEM(cert)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "Failed to PEM decode certificate")
+	}
+	x509Cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Error parsing certificate")
+	}
+	return x509Cert, block.Headers, nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"encoding/asn1"
+	"sort"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+)
+
+// profileKeyTypes are the key types of the certificate requests accepted by
+// the signing profiles; cfssl signing profiles can't restrict them further
+var profileKeyTypes = []string{"ecdsa", "rsa"}
+
+// ProfileInfo describes the constraints of a signing profile
+type ProfileInfo struct {
+	// Name is the name of the profile, or "default" for the default profile
+	Name string
+	// KeyTypes are the key types of the requests the profile can sign
+	KeyTypes []string
+	// Usages are the key usages and extended key usages of issued certificates
+	Usages []string
+	// MaxValidity is the validity of issued certificates
+	MaxValidity time.Duration
+	// IsCA is true if the profile issues CA certificates
+	IsCA bool
+	// MaxPathLen is the path length constraint of issued CA certificates,
+	// or -1 if they have none
+	MaxPathLen int
+	// AllowedExtensions are the OIDs of the extensions requests may contain
+	AllowedExtensions []string
+}
+
+// Profiles returns the constraints of the default profile and of the named
+// signing profiles of policy, ordered by name
+func Profiles(policy *config.Signing) []ProfileInfo {
+	if policy == nil {
+		return nil
+	}
+	var infos []ProfileInfo
+	if policy.Default != nil {
+		infos = append(infos, newProfileInfo("default", policy.Default))
+	}
+	for name, profile := range policy.Profiles {
+		if profile != nil {
+			infos = append(infos, newProfileInfo(name, profile))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func newProfileInfo(name string, profile *config.SigningProfile) ProfileInfo {
+	info := ProfileInfo{
+		Name:        name,
+		KeyTypes:    append([]string(nil), profileKeyTypes...),
+		Usages:      append([]string(nil), profile.Usage...),
+		MaxValidity: profile.Expiry,
+		IsCA:        profile.CAConstraint.IsCA,
+		MaxPathLen:  -1,
+	}
+	if info.IsCA && (profile.CAConstraint.MaxPathLen > 0 || profile.CAConstraint.MaxPathLenZero) {
+		info.MaxPathLen = profile.CAConstraint.MaxPathLen
+	}
+	for _, oid := range profile.AllowedExtensions {
+		info.AllowedExtensions = append(info.AllowedExtensions, asn1.ObjectIdentifier(oid).String())
+	}
+	return info
+}
@@ -0,0 +1,65 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfiles(t *testing.T) {
+	policy := &config.Signing{
+		Default: &config.SigningProfile{
+			Usage:  []string{"digital signature"},
+			Expiry: 8760 * time.Hour,
+		},
+		Profiles: map[string]*config.SigningProfile{
+			"tls": {
+				Usage:  []string{"signing", "key encipherment", "server auth", "client auth"},
+				Expiry: 720 * time.Hour,
+			},
+			"ca": {
+				Usage:             []string{"cert sign", "crl sign"},
+				Expiry:            43800 * time.Hour,
+				CAConstraint:      config.CAConstraint{IsCA: true, MaxPathLen: 0, MaxPathLenZero: true},
+				AllowedExtensions: []config.OID{{1, 2, 3, 4, 5, 6, 7, 8, 1}},
+			},
+			"nil": nil,
+		},
+	}
+	infos := Profiles(policy)
+	if assert.Len(t, infos, 3) {
+		assert.Equal(t, ProfileInfo{
+			Name:              "ca",
+			KeyTypes:          []string{"ecdsa", "rsa"},
+			Usages:            []string{"cert sign", "crl sign"},
+			MaxValidity:       43800 * time.Hour,
+			IsCA:              true,
+			MaxPathLen:        0,
+			AllowedExtensions: []string{"1.2.3.4.5.6.7.8.1"},
+		}, infos[0])
+		assert.Equal(t, "default", infos[1].Name)
+		assert.Equal(t, []string{"digital signature"}, infos[1].Usages)
+		assert.Equal(t, 8760*time.Hour, infos[1].MaxValidity)
+		assert.False(t, infos[1].IsCA)
+		assert.Equal(t, -1, infos[1].MaxPathLen)
+		assert.Equal(t, "tls", infos[2].Name)
+		assert.Equal(t, []string{"signing", "key encipherment", "server auth", "client auth"}, infos[2].Usages)
+		assert.Equal(t, 720*time.Hour, infos[2].MaxValidity)
+		assert.Empty(t, infos[2].AllowedExtensions)
+	}
+
+	// The reported details are copies of the policy
+	infos[0].Usages[0] = "modified"
+	assert.Equal(t, "cert sign", policy.Profiles["ca"].Usage[0])
+
+	assert.Nil(t, Profiles(nil))
+}
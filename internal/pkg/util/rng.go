@@ -0,0 +1,42 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/rand"
+	"io"
+
+	"github.com/hyperledger/fabric/bccsp"
+)
+
+// RNGProvider is implemented by a BCCSP which exposes its random number
+// generator, such as the true random number generator of an HSM. None of the
+// SW, PKCS11 and PLUGIN providers of BCCSP expose their generator, so a CSP
+// must be wrapped in a type implementing RNGProvider to be used as a source,
+// with the UseCSPRNG option set by ConfigureCSP.
+type RNGProvider interface {
+	// RNG returns the random number generator of the CSP
+	RNG() io.Reader
+}
+
+// RandReader returns the random number generator from which serial numbers and
+// software keys, such as ephemeral and Ed25519 keys, are drawn for csp: the
+// generator of csp if its UseCSPRNG option is set and it implements
+// RNGProvider, or crypto/rand otherwise. The ECDSA and RSA keys generated
+// with the KeyGen method of csp are not drawn from it: BCCSP providers draw
+// them from their own generator, which is crypto/rand for the SW provider.
+func RandReader(csp bccsp.BCCSP) io.Reader {
+	if !getCSPOptions(csp).UseCSPRNG {
+		return rand.Reader
+	}
+	if p, ok := baseCSP(csp).(RNGProvider); ok {
+		if rng := p.RNG(); rng != nil {
+			return rng
+		}
+	}
+	return rand.Reader
+}
@@ -0,0 +1,65 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"math/big"
+	"testing"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/stretchr/testify/assert"
+)
+
+// rngCSP is a CSP exposing a random number generator
+type rngCSP struct {
+	bccsp.BCCSP
+	rng io.Reader
+}
+
+func (c *rngCSP) RNG() io.Reader {
+	return c.rng
+}
+
+func TestRandReader(t *testing.T) {
+	rng := bytes.NewReader(bytes.Repeat([]byte{0xAB}, 40))
+	rngOpts := CSPOptions{UseCSPRNG: true}
+	trngCSP, err := ConfigureCSP(&rngCSP{BCCSP: csp, rng: rng}, rngOpts)
+	FatalError(t, err, "Failed to configure CSP")
+	assert.Equal(t, rng, RandReader(trngCSP))
+
+	serial, err := (&RandomSerialSource{Rand: RandReader(trngCSP)}).Next()
+	FatalError(t, err, "Failed to generate serial number")
+	// The first octet is made positive and nonzero
+	expected := new(big.Int).SetBytes(append([]byte{0x2B}, bytes.Repeat([]byte{0xAB}, 19)...))
+	assert.Equal(t, 0, expected.Cmp(serial), "The serial number should be drawn from the RNG of the CSP")
+	assert.Equal(t, 20, rng.Len(), "The serial number should consume 20 octets of the RNG")
+
+	serial, err = (&RandomSerialSource{Rand: RandReader(trngCSP)}).Next()
+	FatalError(t, err, "Failed to generate serial number")
+	assert.Equal(t, 0, expected.Cmp(serial))
+	_, err = (&RandomSerialSource{Rand: RandReader(trngCSP)}).Next()
+	assert.Error(t, err, "An exhausted RNG should fail")
+
+	// The RNG of the CSP is only used if the option is set
+	unconfigured, err := ConfigureCSP(&rngCSP{BCCSP: csp, rng: rng}, CSPOptions{})
+	FatalError(t, err, "Failed to configure CSP")
+	assert.Equal(t, rand.Reader, RandReader(unconfigured))
+
+	// CSPs which don't expose a RNG fall back to crypto/rand
+	swCSP, err := ConfigureCSP(csp, rngOpts)
+	FatalError(t, err, "Failed to configure CSP")
+	assert.Equal(t, rand.Reader, RandReader(swCSP))
+	serial1, err := (&RandomSerialSource{Rand: RandReader(swCSP)}).Next()
+	FatalError(t, err, "Failed to generate serial number")
+	serial2, err := NewRandomSerialSource().Next()
+	FatalError(t, err, "Failed to generate serial number")
+	assert.NotEqual(t, 0, serial1.Cmp(serial2))
+}
@@ -0,0 +1,184 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SerialSource is a source of certificate serial numbers
+type SerialSource interface {
+	// Next returns the serial number to use for the next issued certificate
+	Next() (*big.Int, error)
+	// Reserve returns an error if serial may already have been returned by
+	// Next, and otherwise makes sure that Next never returns it
+	Reserve(serial *big.Int) error
+}
+
+// RandomSerialSource returns random serial numbers of 20 octets, which is
+// the maximum allowed by RFC 5280
+type RandomSerialSource struct {
+	// Rand is the random number generator from which the serial numbers are
+	// drawn; crypto/rand if nil
+	Rand     io.Reader
+	mutex    sync.Mutex
+	reserved map[string]bool
+}
+
+// NewRandomSerialSource returns a new random serial number source
+func NewRandomSerialSource() *RandomSerialSource {
+	return &RandomSerialSource{reserved: map[string]bool{}}
+}
+
+// Next returns a new random serial number which was not reserved
+func (rs *RandomSerialSource) Next() (*big.Int, error) {
+	for {
+		serial, err := randomSerialNumber(rs.Rand)
+		if err != nil {
+			return nil, err
+		}
+		rs.mutex.Lock()
+		reserved := rs.reserved[serial.String()]
+		rs.mutex.Unlock()
+		if !reserved {
+			return serial, nil
+		}
+	}
+}
+
+// Reserve makes sure that Next never returns serial. An error is returned if
+// serial was already reserved. The random serial numbers returned by Next are
+// not recorded, as the probability that Next returned a given serial number
+// is negligible; the serial numbers already issued are found in the
+// certificate database instead.
+func (rs *RandomSerialSource) Reserve(serial *big.Int) error {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+	if rs.reserved == nil {
+		rs.reserved = map[string]bool{}
+	}
+	if rs.reserved[serial.String()] {
+		return errors.Errorf("Serial number %s was already reserved", GetSerialAsHex(serial))
+	}
+	rs.reserved[serial.String()] = true
+	return nil
+}
+
+// SequentialSerialSource returns monotonically increasing serial numbers.
+// The last serial number returned is persisted in a file before it is
+// returned, so that serial numbers are never reused across restarts.
+// The file must not be shared by multiple sources.
+type SequentialSerialSource struct {
+	mutex sync.Mutex
+	file  string
+	last  *big.Int
+}
+
+// NewSequentialSerialSource returns a new sequential serial number source
+// which persists the last serial number in file. If file exists, it must
+// contain the hex encoding of the last serial number issued; otherwise
+// the first serial number returned is 1.
+func NewSequentialSerialSource(file string) (*SequentialSerialSource, error) {
+	last := big.NewInt(0)
+	buf, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "Failed to read serial number file '%s'", file)
+	}
+	if err == nil {
+		str := strings.TrimSpace(string(buf))
+		if _, ok := last.SetString(str, 16); !ok || last.Sign() < 0 {
+			return nil, errors.Errorf("Invalid serial number '%s' in file '%s'", str, file)
+		}
+	}
+	return &SequentialSerialSource{file: file, last: last}, nil
+}
+
+// Next returns the serial number following the last serial number returned
+func (ss *SequentialSerialSource) Next() (*big.Int, error) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	next := new(big.Int).Add(ss.last, big.NewInt(1))
+	err := ss.store(next)
+	if err != nil {
+		return nil, err
+	}
+	ss.last = next
+	return new(big.Int).Set(next), nil
+}
+
+// Reserve makes sure that serial is never returned by Next; the serial numbers
+// between the last serial number returned and serial are skipped. An error is
+// returned if serial is not greater than the last serial number returned.
+func (ss *SequentialSerialSource) Reserve(serial *big.Int) error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if serial.Cmp(ss.last) <= 0 {
+		return errors.Errorf("Serial number %s may already have been issued; the last sequential serial number is %s",
+			GetSerialAsHex(serial), GetSerialAsHex(ss.last))
+	}
+	err := ss.store(serial)
+	if err != nil {
+		return err
+	}
+	ss.last = new(big.Int).Set(serial)
+	return nil
+}
+
+// store atomically replaces the content of the serial number file with serial
+func (ss *SequentialSerialSource) store(serial *big.Int) error {
+	dir := filepath.Dir(ss.file)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create directory '%s'", dir)
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(ss.file))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create temporary serial number file in '%s'", dir)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(GetSerialAsHex(serial) + "\n")
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to write serial number file '%s'", tmp.Name())
+	}
+	err = os.Rename(tmp.Name(), ss.file)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to store serial number in file '%s'", ss.file)
+	}
+	return nil
+}
+
+// randomSerialNumber returns a random certificate serial number of 20 octets
+// drawn from rng, or crypto/rand if rng is nil
+func randomSerialNumber(rng io.Reader) (*big.Int, error) {
+	if rng == nil {
+		rng = rand.Reader
+	}
+	serialNumber := make([]byte, 20)
+	_, err := io.ReadFull(rng, serialNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate serial number")
+	}
+	// Make sure the serial number is positive and does not start with a zero octet
+	serialNumber[0] &= 0x7F
+	serialNumber[0] |= 0x01
+	return new(big.Int).SetBytes(serialNumber), nil
+}
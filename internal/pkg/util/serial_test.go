@@ -0,0 +1,202 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRandomSerialSource(t *testing.T) {
+	rs := NewRandomSerialSource()
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		serial, err := rs.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, serial.Sign())
+		assert.True(t, serial.BitLen() <= 159)
+		assert.False(t, seen[serial.String()], "Duplicate random serial number")
+		seen[serial.String()] = true
+	}
+}
+
+func TestSequentialSerialSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "serial")
+
+	ss, err := NewSequentialSerialSource(file)
+	FatalError(t, err, "Failed to create sequential serial source")
+	for i := int64(1); i <= 3; i++ {
+		serial, err := ss.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, big.NewInt(i), serial)
+	}
+	buf, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", strings.TrimSpace(string(buf)))
+
+	// A new source continues from the persisted serial number
+	ss, err = NewSequentialSerialSource(file)
+	FatalError(t, err, "Failed to create sequential serial source")
+	serial, err := ss.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(4), serial)
+
+	err = ioutil.WriteFile(file, []byte("ff\n"), 0644)
+	FatalError(t, err, "Failed to write serial file")
+	ss, err = NewSequentialSerialSource(file)
+	FatalError(t, err, "Failed to create sequential serial source")
+	serial, err = ss.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(256), serial)
+
+	err = ioutil.WriteFile(file, []byte("not a serial"), 0644)
+	FatalError(t, err, "Failed to write serial file")
+	_, err = NewSequentialSerialSource(file)
+	assert.Error(t, err)
+}
+
+func TestSequentialSerialSourceConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	ss, err := NewSequentialSerialSource(filepath.Join(dir, "serial"))
+	FatalError(t, err, "Failed to create sequential serial source")
+
+	const workers, perWorker = 20, 10
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	seen := map[int64]bool{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				serial, err := ss.Next()
+				assert.NoError(t, err)
+				mutex.Lock()
+				assert.False(t, seen[serial.Int64()], "Duplicate sequential serial number %d", serial.Int64())
+				seen[serial.Int64()] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, seen, workers*perWorker)
+	for i := int64(1); i <= workers*perWorker; i++ {
+		assert.True(t, seen[i], "Missing sequential serial number %d", i)
+	}
+}
+
+func TestIssueCertificateWithSerialSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	ss, err := NewSequentialSerialSource(filepath.Join(dir, "serial"))
+	FatalError(t, err, "Failed to create sequential serial source")
+
+	policy := &config.Signing{Default: config.DefaultConfig()}
+	policy.Default.ClientProvidesSerialNumbers = true
+	s, err := BccspBackedSigner(filepath.Join("testdata", "ec.pem"), filepath.Join("testdata", "ec-key.pem"), policy, csp)
+	FatalError(t, err, "Failed to create CA signer")
+
+	for i := int64(1); i <= 2; i++ {
+		certPEM, serial, err := IssueCertificateWithSerialSource(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))}, ss)
+		assert.NoError(t, err)
+		assert.Equal(t, big.NewInt(i), serial)
+		cert, err := GetX509CertificateFromPEM(certPEM)
+		assert.NoError(t, err)
+		assert.Equal(t, big.NewInt(i), cert.SerialNumber)
+	}
+
+	_, serial, err := IssueCertificateWithSerialSource(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))}, NewRandomSerialSource())
+	assert.NoError(t, err)
+	assert.True(t, serial.BitLen() > 64)
+}
+
+func TestIssueCertificateWithSerial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	ss, err := NewSequentialSerialSource(filepath.Join(dir, "serial"))
+	FatalError(t, err, "Failed to create sequential serial source")
+
+	policy := &config.Signing{Default: config.DefaultConfig()}
+	policy.Default.ClientProvidesSerialNumbers = true
+	s, err := BccspBackedSigner(filepath.Join("testdata", "ec.pem"), filepath.Join("testdata", "ec-key.pem"), policy, csp)
+	FatalError(t, err, "Failed to create CA signer")
+	req := signer.SignRequest{Request: string(newTestCSR(t, "user1"))}
+
+	_, serial, err := IssueCertificateWithSerialSource(s, req, ss)
+	FatalError(t, err, "Failed to issue certificate")
+	assert.Equal(t, big.NewInt(1), serial)
+
+	fixed := big.NewInt(0x1234)
+	certPEM, serial, err := IssueCertificateWithSerial(s, req, fixed, ss)
+	FatalError(t, err, "Failed to issue certificate with a fixed serial number")
+	assert.Equal(t, fixed, serial)
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse certificate")
+	assert.Equal(t, fixed, cert.SerialNumber)
+
+	// The sequential source doesn't return the reserved serial number
+	_, serial, err = IssueCertificateWithSerialSource(s, req, ss)
+	FatalError(t, err, "Failed to issue certificate")
+	assert.Equal(t, big.NewInt(0x1235), serial)
+	_, _, err = IssueCertificateWithSerial(s, req, big.NewInt(2), ss)
+	assert.Error(t, err, "A serial number which may have been issued should be rejected")
+
+	// Random serial numbers are not recorded, so the certificate database
+	// is checked
+	dba := &recordingAccessor{}
+	ls, _ := LocalSigner(s)
+	ls.SetDBAccessor(dba)
+	_, serial, err = IssueCertificateWithSerial(s, req, big.NewInt(2), NewRandomSerialSource())
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(2), serial)
+	assert.Len(t, dba.records, 1)
+	_, _, err = IssueCertificateWithSerial(s, req, big.NewInt(2), NewRandomSerialSource())
+	assert.Error(t, err, "A serial number in the certificate database should be rejected")
+	_, serial, err = IssueCertificateWithSerial(s, req, big.NewInt(3), nil)
+	assert.NoError(t, err, "The certificate database is enough to check the serial number")
+	assert.Equal(t, big.NewInt(3), serial)
+
+	for _, invalid := range []*big.Int{nil, big.NewInt(0), big.NewInt(-1), new(big.Int).Lsh(big.NewInt(1), 160)} {
+		_, _, err = IssueCertificateWithSerial(s, req, invalid, ss)
+		assert.Error(t, err, "Serial number %v should be rejected", invalid)
+	}
+	ls.SetDBAccessor(nil)
+	_, _, err = IssueCertificateWithSerial(s, req, big.NewInt(0x9999), nil)
+	assert.Error(t, err, "The serial number can't be checked without a database or a source")
+	_, _, err = IssueCertificateWithSerial(newTestCASigner(t), req, big.NewInt(0x5678), nil)
+	assert.Error(t, err, "A profile which doesn't accept client provided serial numbers should be rejected")
+}
+
+func TestRandomSerialSourceReserve(t *testing.T) {
+	rs := NewRandomSerialSource()
+	serial, err := rs.Next()
+	FatalError(t, err, "Failed to get a random serial number")
+	assert.NoError(t, rs.Reserve(big.NewInt(0x1234)))
+	assert.Error(t, rs.Reserve(big.NewInt(0x1234)), "A serial number can only be reserved once")
+	var zero RandomSerialSource
+	assert.NoError(t, zero.Reserve(serial), "The zero value should be usable")
+	next, err := zero.Next()
+	FatalError(t, err, "Failed to get a random serial number")
+	assert.NotEqual(t, serial, next)
+}
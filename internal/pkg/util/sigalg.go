@@ -0,0 +1,240 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/pkg/errors"
+)
+
+var hashNames = map[crypto.Hash]string{
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+}
+
+// DefaultDeprecatedSignatureAlgorithms are the signature algorithms which are
+// flagged by CheckCertSignatureAlgorithm unless SetDeprecatedSignatureAlgorithms
+// is called
+var DefaultDeprecatedSignatureAlgorithms = []x509.SignatureAlgorithm{
+	x509.MD2WithRSA,
+	x509.MD5WithRSA,
+	x509.SHA1WithRSA,
+	x509.DSAWithSHA1,
+	x509.ECDSAWithSHA1,
+}
+
+var deprecatedSigAlgs = struct {
+	sync.RWMutex
+	algs   []x509.SignatureAlgorithm
+	reject bool
+}{algs: DefaultDeprecatedSignatureAlgorithms}
+
+// SetDeprecatedSignatureAlgorithms sets the signature algorithms which are
+// flagged when a certificate is loaded. If reject is false, which is the
+// default, a warning is logged for a certificate signed with one of algs;
+// otherwise the certificate is rejected.
+func SetDeprecatedSignatureAlgorithms(algs []x509.SignatureAlgorithm, reject bool) {
+	deprecatedSigAlgs.Lock()
+	defer deprecatedSigAlgs.Unlock()
+	deprecatedSigAlgs.algs = append([]x509.SignatureAlgorithm(nil), algs...)
+	deprecatedSigAlgs.reject = reject
+}
+
+// ParseSignatureAlgorithm returns the X509 signature algorithm named name
+// (e.g. "SHA1-RSA" or "ECDSA-SHA1"). The name is case insensitive.
+func ParseSignatureAlgorithm(name string) (x509.SignatureAlgorithm, error) {
+	for alg := x509.MD2WithRSA; alg <= x509.PureEd25519; alg++ {
+		if strings.EqualFold(alg.String(), name) {
+			return alg, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, errors.Errorf("Unknown signature algorithm '%s'", name)
+}
+
+// CheckCertSignatureAlgorithm checks whether cert is signed with a deprecated
+// signature algorithm. A warning is logged, or an error is returned if
+// deprecated signature algorithms are rejected.
+func CheckCertSignatureAlgorithm(cert *x509.Certificate) error {
+	deprecatedSigAlgs.RLock()
+	defer deprecatedSigAlgs.RUnlock()
+	for _, alg := range deprecatedSigAlgs.algs {
+		if cert.SignatureAlgorithm != alg {
+			continue
+		}
+		if deprecatedSigAlgs.reject {
+			return errors.Errorf("Certificate '%s' is signed with deprecated signature algorithm %s",
+				cert.Subject.CommonName, alg)
+		}
+		log.Warningf("Certificate '%s' is signed with deprecated signature algorithm %s",
+			cert.Subject.CommonName, alg)
+		return nil
+	}
+	return nil
+}
+
+// signatureAlgorithmKeyType returns the type of the keys which sign with alg,
+// or x509.UnknownPublicKeyAlgorithm if alg is unknown
+func signatureAlgorithmKeyType(alg x509.SignatureAlgorithm) x509.PublicKeyAlgorithm {
+	switch alg {
+	case x509.ECDSAWithSHA1, x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return x509.ECDSA
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return x509.RSA
+	case x509.DSAWithSHA1, x509.DSAWithSHA256:
+		return x509.DSA
+	case x509.PureEd25519:
+		return x509.Ed25519
+	}
+	return x509.UnknownPublicKeyAlgorithm
+}
+
+// CheckKeySignatureAlgorithm returns an error if the private key of pub can't
+// sign with the signature algorithm alg, e.g. an ECDSA key asked to sign with
+// SHA256-RSA
+func CheckKeySignatureAlgorithm(pub crypto.PublicKey, alg x509.SignatureAlgorithm) error {
+	keyType, err := publicKeyType(pub)
+	if err != nil {
+		return err
+	}
+	algKeyType := signatureAlgorithmKeyType(alg)
+	if algKeyType == x509.UnknownPublicKeyAlgorithm {
+		return errors.Errorf("Unknown signature algorithm %s for the %s key", alg, keyType)
+	}
+	if algKeyType != keyType {
+		return errors.Errorf("Keys of type %s can't sign with signature algorithm %s, which requires a key of type %s", keyType, alg, algKeyType)
+	}
+	return nil
+}
+
+// publicKeyType returns the type of the public key pub
+func publicKeyType(pub crypto.PublicKey) (x509.PublicKeyAlgorithm, error) {
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return x509.ECDSA, nil
+	case *rsa.PublicKey:
+		return x509.RSA, nil
+	case ed25519.PublicKey:
+		return x509.Ed25519, nil
+	}
+	return x509.UnknownPublicKeyAlgorithm, errors.Errorf("Unsupported key type %T; must be ECDSA, RSA or Ed25519", pub)
+}
+
+// keyAlgorithmName returns the type of the public key pub followed by the
+// name of its curve, if it is an ECDSA key
+func keyAlgorithmName(pub crypto.PublicKey) (string, error) {
+	keyType, err := publicKeyType(pub)
+	if err != nil {
+		return "", err
+	}
+	if ecPub, ok := pub.(*ecdsa.PublicKey); ok {
+		return keyType.String() + " " + ecPub.Curve.Params().Name, nil
+	}
+	return keyType.String(), nil
+}
+
+// CheckKeyAlgorithmChange returns an error if the algorithm of the public key
+// newPub differs from that of oldPub, e.g. when the key of a certificate is
+// replaced by a key of another type or, for ECDSA keys, on another curve
+func CheckKeyAlgorithmChange(oldPub, newPub crypto.PublicKey) error {
+	oldAlg, err := keyAlgorithmName(oldPub)
+	if err != nil {
+		return err
+	}
+	newAlg, err := keyAlgorithmName(newPub)
+	if err != nil {
+		return err
+	}
+	if oldAlg != newAlg {
+		return errors.Errorf("The key algorithm would change from %s to %s", oldAlg, newAlg)
+	}
+	return nil
+}
+
+// ParseHashAlgorithm returns the hash algorithm named name, which is one of
+// SHA256, SHA384 or SHA512. The name is case insensitive and may contain a
+// dash (e.g. "sha-384").
+func ParseHashAlgorithm(name string) (crypto.Hash, error) {
+	n := strings.ToUpper(strings.Replace(name, "-", "", -1))
+	for hash, hashName := range hashNames {
+		if n == hashName {
+			return hash, nil
+		}
+	}
+	return 0, errors.Errorf("Unsupported hash algorithm '%s'; must be one of SHA256, SHA384 or SHA512", name)
+}
+
+// HashAlgorithmName returns the name of hash as accepted by ParseHashAlgorithm
+func HashAlgorithmName(hash crypto.Hash) string {
+	if name, ok := hashNames[hash]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// minECDSAHash returns the weakest hash algorithm which matches the security
+// strength of curve
+func minECDSAHash(curve elliptic.Curve) (crypto.Hash, error) {
+	switch curve {
+	case elliptic.P256():
+		return crypto.SHA256, nil
+	case elliptic.P384():
+		return crypto.SHA384, nil
+	case elliptic.P521():
+		return crypto.SHA512, nil
+	}
+	return 0, errors.Errorf("Unsupported elliptic curve '%s'", curve.Params().Name)
+}
+
+// SignatureAlgorithmForHash returns the X509 signature algorithm which signs
+// with the private key of pub using hash. An error is returned if hash is not
+// compatible with the key, including when it is weaker than the security
+// strength of an ECDSA key's curve (e.g. SHA256 with a P-384 key).
+func SignatureAlgorithmForHash(pub crypto.PublicKey, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+	if _, ok := hashNames[hash]; !ok {
+		return x509.UnknownSignatureAlgorithm, errors.Errorf("Unsupported hash algorithm %d", hash)
+	}
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		minHash, err := minECDSAHash(pub.Curve)
+		if err != nil {
+			return x509.UnknownSignatureAlgorithm, err
+		}
+		if hash.Size() < minHash.Size() {
+			return x509.UnknownSignatureAlgorithm, errors.Errorf("Hash algorithm %s is too weak for a %s key; must be at least %s",
+				HashAlgorithmName(hash), pub.Curve.Params().Name, HashAlgorithmName(minHash))
+		}
+		switch hash {
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		default:
+			return x509.ECDSAWithSHA512, nil
+		}
+	case *rsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		default:
+			return x509.SHA512WithRSA, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, errors.Errorf("The hash algorithm cannot be chosen for keys of type %T", pub)
+}
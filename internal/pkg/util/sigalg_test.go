@@ -0,0 +1,172 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHashAlgorithm(t *testing.T) {
+	for name, hash := range map[string]crypto.Hash{
+		"SHA256":  crypto.SHA256,
+		"sha384":  crypto.SHA384,
+		"SHA-512": crypto.SHA512,
+	} {
+		h, err := ParseHashAlgorithm(name)
+		assert.NoError(t, err)
+		assert.Equal(t, hash, h)
+	}
+	_, err := ParseHashAlgorithm("SHA1")
+	assert.Error(t, err, "SHA1 should not be supported")
+	assert.Equal(t, "SHA384", HashAlgorithmName(crypto.SHA384))
+}
+
+func TestSignatureAlgorithmForHash(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	FatalError(t, err, "Failed to generate RSA key")
+
+	sigAlgo, err := SignatureAlgorithmForHash(&p384.PublicKey, crypto.SHA384)
+	assert.NoError(t, err)
+	assert.Equal(t, x509.ECDSAWithSHA384, sigAlgo)
+	sigAlgo, err = SignatureAlgorithmForHash(&p256.PublicKey, crypto.SHA384)
+	assert.NoError(t, err)
+	assert.Equal(t, x509.ECDSAWithSHA384, sigAlgo)
+	sigAlgo, err = SignatureAlgorithmForHash(&rsaKey.PublicKey, crypto.SHA512)
+	assert.NoError(t, err)
+	assert.Equal(t, x509.SHA512WithRSA, sigAlgo)
+
+	_, err = SignatureAlgorithmForHash(&p384.PublicKey, crypto.SHA256)
+	assert.Error(t, err, "SHA256 should be too weak for a P-384 key")
+	_, err = SignatureAlgorithmForHash(&p256.PublicKey, crypto.SHA1)
+	assert.Error(t, err, "SHA1 should not be supported")
+	// Keys for which the hash algorithm is fixed or unsupported are rejected
+	_, err = SignatureAlgorithmForHash(&dsa.PublicKey{}, crypto.SHA256)
+	assert.Error(t, err, "Overriding the hash of a DSA key should fail")
+}
+
+func TestCheckKeySignatureAlgorithm(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	FatalError(t, err, "Failed to generate RSA key")
+
+	assert.NoError(t, CheckKeySignatureAlgorithm(&ecKey.PublicKey, x509.ECDSAWithSHA256))
+	assert.NoError(t, CheckKeySignatureAlgorithm(&ecKey.PublicKey, x509.ECDSAWithSHA384))
+	assert.NoError(t, CheckKeySignatureAlgorithm(&rsaKey.PublicKey, x509.SHA256WithRSA))
+	assert.NoError(t, CheckKeySignatureAlgorithm(&rsaKey.PublicKey, x509.SHA384WithRSAPSS))
+
+	err = CheckKeySignatureAlgorithm(&ecKey.PublicKey, x509.SHA256WithRSA)
+	if assert.Error(t, err, "An ECDSA key can't sign with an RSA signature algorithm") {
+		assert.Contains(t, err.Error(), "Keys of type ECDSA can't sign with signature algorithm SHA256-RSA")
+	}
+	err = CheckKeySignatureAlgorithm(&rsaKey.PublicKey, x509.ECDSAWithSHA256)
+	assert.Error(t, err, "An RSA key can't sign with an ECDSA signature algorithm")
+	err = CheckKeySignatureAlgorithm(&ecKey.PublicKey, x509.PureEd25519)
+	assert.Error(t, err, "An ECDSA key can't sign with Ed25519")
+	err = CheckKeySignatureAlgorithm(&ecKey.PublicKey, x509.UnknownSignatureAlgorithm)
+	assert.Error(t, err, "An unknown signature algorithm should be rejected")
+	err = CheckKeySignatureAlgorithm(&dsa.PublicKey{}, x509.DSAWithSHA256)
+	assert.Error(t, err, "DSA keys are not supported")
+}
+
+func TestCheckKeyAlgorithmChange(t *testing.T) {
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	otherP256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	FatalError(t, err, "Failed to generate RSA key")
+
+	assert.NoError(t, CheckKeyAlgorithmChange(&p256Key.PublicKey, &otherP256Key.PublicKey))
+	err = CheckKeyAlgorithmChange(&p384Key.PublicKey, &p256Key.PublicKey)
+	if assert.Error(t, err, "Changing the curve of an ECDSA key should be rejected") {
+		assert.Contains(t, err.Error(), "The key algorithm would change from ECDSA P-384 to ECDSA P-256")
+	}
+	err = CheckKeyAlgorithmChange(&rsaKey.PublicKey, &p256Key.PublicKey)
+	assert.Error(t, err, "Changing the key type should be rejected")
+	err = CheckKeyAlgorithmChange(&dsa.PublicKey{}, &p256Key.PublicKey)
+	assert.Error(t, err, "DSA keys are not supported")
+}
+
+func TestBccspBackedSignerWithHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sigalg")
+	FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(dir)
+	certFile, keyFile := createTestRootCA(t, dir, "hashroot")
+
+	policy := &config.Signing{Default: config.DefaultConfig()}
+	s, err := BccspBackedSignerWithHash(certFile, keyFile, policy, csp, crypto.SHA384)
+	FatalError(t, err, "Failed to create signer")
+	certPEM, _, err := IssueCertificate(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))})
+	FatalError(t, err, "Failed to issue certificate")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse certificate")
+	assert.Equal(t, x509.ECDSAWithSHA384, cert.SignatureAlgorithm)
+
+	// The default hash algorithm of a P-256 key is SHA256
+	s, err = BccspBackedSignerWithHash(certFile, keyFile, policy, csp, 0)
+	FatalError(t, err, "Failed to create signer")
+	certPEM, _, err = IssueCertificate(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))})
+	FatalError(t, err, "Failed to issue certificate")
+	cert, err = GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse certificate")
+	assert.Equal(t, x509.ECDSAWithSHA256, cert.SignatureAlgorithm)
+
+	_, err = BccspBackedSignerWithHash(certFile, keyFile, policy, csp, crypto.SHA1)
+	assert.Error(t, err, "Signer with SHA1 should fail")
+}
+
+func TestCheckCertSignatureAlgorithm(t *testing.T) {
+	defer SetDeprecatedSignatureAlgorithms(DefaultDeprecatedSignatureAlgorithms, false)
+	sha1PEM, err := ioutil.ReadFile(filepath.Join("testdata", "sha1.pem"))
+	FatalError(t, err, "Failed to read certificate")
+
+	// A SHA-1 signed certificate is only flagged with a warning by default
+	cert, err := GetX509CertificateFromPEM(sha1PEM)
+	FatalError(t, err, "A SHA-1 signed certificate should load by default")
+	assert.Equal(t, x509.ECDSAWithSHA1, cert.SignatureAlgorithm)
+
+	SetDeprecatedSignatureAlgorithms(DefaultDeprecatedSignatureAlgorithms, true)
+	_, err = GetX509CertificateFromPEM(sha1PEM)
+	if assert.Error(t, err, "Loading a SHA-1 signed certificate should fail when deprecated algorithms are rejected") {
+		assert.Contains(t, err.Error(), "deprecated signature algorithm ECDSA-SHA1")
+	}
+	_, err = GetX509CertificatesFromPEM(sha1PEM)
+	assert.Error(t, err, "Loading a SHA-1 signed certificate should fail when deprecated algorithms are rejected")
+	_, err = GetX509CertificateFromPEMFile(filepath.Join("testdata", "ec.pem"))
+	assert.NoError(t, err, "A SHA-256 signed certificate should not be rejected")
+
+	alg, err := ParseSignatureAlgorithm("sha256-rsa")
+	FatalError(t, err, "Failed to parse signature algorithm")
+	SetDeprecatedSignatureAlgorithms([]x509.SignatureAlgorithm{alg}, true)
+	_, err = GetX509CertificateFromPEM(sha1PEM)
+	assert.NoError(t, err, "SHA-1 should not be rejected when it is not in the deny-list")
+
+	_, err = ParseSignatureAlgorithm("bogus")
+	assert.Error(t, err, "Parsing an unknown signature algorithm should fail")
+}
@@ -0,0 +1,163 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"io"
+
+	"github.com/hyperledger/fabric/bccsp"
+	cspsigner "github.com/hyperledger/fabric/bccsp/signer"
+	"github.com/hyperledger/fabric/bccsp/utils"
+	"github.com/pkg/errors"
+)
+
+// lowSSigner is a crypto.Signer which makes sure that ECDSA signatures
+// are in low-S form, regardless of the BCCSP provider that produced them
+type lowSSigner struct {
+	crypto.Signer
+}
+
+// NewCryptoSigner returns a BCCSP backed crypto.Signer for key. ECDSA
+// signatures produced by the signer are normalized to low-S form;
+// signatures made with any other key type are returned unchanged.
+func NewCryptoSigner(csp bccsp.BCCSP, key bccsp.Key) (crypto.Signer, error) {
+	if k, ok := key.(*signerKey); ok {
+		return &lowSSigner{Signer: k.signer}, nil
+	}
+	signer, err := cspsigner.New(csp, key)
+	if err != nil {
+		return nil, err
+	}
+	return &lowSSigner{Signer: signer}, nil
+}
+
+// Sign signs digest and normalizes the signature if the key is an ECDSA key
+func (s *lowSSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	sig, err := s.Signer.Sign(rand, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := s.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return sig, nil
+	}
+	return NormalizeECDSASignature(pub, sig)
+}
+
+// NormalizeECDSASignature returns the ASN.1 encoded ECDSA signature sig in
+// low-S form, that is with S <= N/2 where N is the order of the curve of pub
+func NormalizeECDSASignature(pub *ecdsa.PublicKey, sig []byte) ([]byte, error) {
+	if pub == nil {
+		return nil, errors.New("ECDSA public key must be different from nil")
+	}
+	lowS, err := utils.SignatureToLowS(pub, sig)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to normalize ECDSA signature")
+	}
+	return lowS, nil
+}
+
+// signerKey is a bccsp.Key for the private key of an external crypto.Signer.
+// The private key is not held by the BCCSP, so it can't be exported and
+// can only be used for signing through NewCryptoSigner.
+type signerKey struct {
+	signer crypto.Signer
+	pub    *signerPublicKey
+}
+
+// signerPublicKey is the bccsp.Key for the public key of a signerKey
+type signerPublicKey struct {
+	pub crypto.PublicKey
+	ski []byte
+}
+
+// WrapSigner returns a bccsp.Key for the private key of signer, which must
+// be an ECDSA, RSA or Ed25519 signer. NewCryptoSigner returns signer for the key;
+// Bytes returns an error because the private key is not in the BCCSP.
+func WrapSigner(signer crypto.Signer) (bccsp.Key, error) {
+	if signer == nil {
+		return nil, errors.New("Signer must be different from nil")
+	}
+	var raw []byte
+	switch pub := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		raw = elliptic.Marshal(pub.Curve, pub.X, pub.Y)
+	case *rsa.PublicKey:
+		raw = x509.MarshalPKCS1PublicKey(pub)
+	case ed25519.PublicKey:
+		raw = pub
+	default:
+		return nil, errors.Errorf("Unsupported public key type %T; must be ECDSA, RSA or Ed25519", pub)
+	}
+	// Compute the SKI the same way as the SW BCCSP provider
+	hash := sha256.Sum256(raw)
+	return &signerKey{
+		signer: signer,
+		pub:    &signerPublicKey{pub: signer.Public(), ski: hash[:]},
+	}, nil
+}
+
+// Bytes returns an error because the private key is not in the BCCSP
+func (k *signerKey) Bytes() ([]byte, error) {
+	return nil, errors.New("Not supported: the private key of a wrapped signer is not in the BCCSP")
+}
+
+// SKI returns the subject key identifier of the key
+func (k *signerKey) SKI() []byte {
+	return k.pub.SKI()
+}
+
+// Symmetric returns false
+func (k *signerKey) Symmetric() bool {
+	return false
+}
+
+// Private returns true
+func (k *signerKey) Private() bool {
+	return true
+}
+
+// PublicKey returns the public key of the signer
+func (k *signerKey) PublicKey() (bccsp.Key, error) {
+	return k.pub, nil
+}
+
+// Bytes returns the DER encoded public key
+func (k *signerPublicKey) Bytes() ([]byte, error) {
+	raw, err := x509.MarshalPKIXPublicKey(k.pub)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal public key")
+	}
+	return raw, nil
+}
+
+// SKI returns the subject key identifier of the key
+func (k *signerPublicKey) SKI() []byte {
+	return append([]byte(nil), k.ski...)
+}
+
+// Symmetric returns false
+func (k *signerPublicKey) Symmetric() bool {
+	return false
+}
+
+// Private returns false
+func (k *signerPublicKey) Private() bool {
+	return false
+}
+
+// PublicKey returns the key itself
+func (k *signerPublicKey) PublicKey() (bccsp.Key, error) {
+	return k, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertLowS(t *testing.T, pub *ecdsa.PublicKey, sig []byte) {
+	_, s, err := utils.UnmarshalECDSASignature(sig)
+	assert.NoError(t, err)
+	halfOrder := new(big.Int).Rsh(pub.Params().N, 1)
+	assert.True(t, s.Cmp(halfOrder) <= 0, "Signature S value is not in low-S form")
+}
+
+func verifyECDSA(pub *ecdsa.PublicKey, digest, sig []byte) bool {
+	r, s, err := utils.UnmarshalECDSASignature(sig)
+	if err != nil {
+		return false
+	}
+	return ecdsa.Verify(pub, digest, r, s)
+}
+
+func TestNewCryptoSignerLowS(t *testing.T) {
+	key, err := csp.KeyGen(&bccsp.ECDSAP256KeyGenOpts{Temporary: true})
+	assert.NoError(t, err)
+	signer, err := NewCryptoSigner(csp, key)
+	assert.NoError(t, err)
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	assert.True(t, ok)
+
+	for i := 0; i < 100; i++ {
+		digest := sha256.Sum256([]byte{byte(i)})
+		sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		assert.NoError(t, err)
+		assertLowS(t, pub, sig)
+		assert.True(t, verifyECDSA(pub, digest[:], sig))
+	}
+
+	_, err = NewCryptoSigner(nil, key)
+	assert.Error(t, err)
+}
+
+func TestNormalizeECDSASignature(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		digest := sha256.Sum256([]byte{byte(i)})
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+		assert.NoError(t, err)
+		sig, err := utils.MarshalECDSASignature(r, s)
+		assert.NoError(t, err)
+		lowS, err := NormalizeECDSASignature(&priv.PublicKey, sig)
+		assert.NoError(t, err)
+		assertLowS(t, &priv.PublicKey, lowS)
+		assert.True(t, verifyECDSA(&priv.PublicKey, digest[:], lowS))
+	}
+
+	_, err = NormalizeECDSASignature(&priv.PublicKey, []byte("not a signature"))
+	assert.Error(t, err)
+	_, err = NormalizeECDSASignature(nil, nil)
+	assert.Error(t, err)
+}
+
+func TestWrapSigner(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate ECDSA key")
+
+	key, err := WrapSigner(priv)
+	FatalError(t, err, "Failed to wrap signer")
+	assert.True(t, key.Private())
+	assert.False(t, key.Symmetric())
+	_, err = key.Bytes()
+	assert.Error(t, err, "Exporting the private key of a wrapped signer should fail")
+
+	// The SKI matches the SKI of the public key imported into the BCCSP
+	pubKey, err := csp.KeyImport(&priv.PublicKey, &bccsp.ECDSAGoPublicKeyImportOpts{Temporary: true})
+	FatalError(t, err, "Failed to import public key")
+	assert.Equal(t, pubKey.SKI(), key.SKI())
+	pub, err := key.PublicKey()
+	FatalError(t, err, "Failed to get public key")
+	assert.False(t, pub.Private())
+	assert.Equal(t, key.SKI(), pub.SKI())
+	der, err := pub.Bytes()
+	FatalError(t, err, "Failed to marshal public key")
+	pubDER, err := pubKey.Bytes()
+	FatalError(t, err, "Failed to marshal public key")
+	assert.Equal(t, pubDER, der)
+
+	// Build a cfssl signer with the wrapped key and issue a certificate
+	cryptoSigner, err := NewCryptoSigner(csp, key)
+	FatalError(t, err, "Failed to create crypto signer")
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "wrapped"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, cryptoSigner.Public(), cryptoSigner)
+	FatalError(t, err, "Failed to create CA certificate")
+	caCert, err := x509.ParseCertificate(caDER)
+	FatalError(t, err, "Failed to parse CA certificate")
+	s, err := local.NewSigner(cryptoSigner, caCert, x509.ECDSAWithSHA256, nil)
+	FatalError(t, err, "Failed to create cfssl signer")
+	certPEM, _, err := IssueCertificate(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))})
+	FatalError(t, err, "Failed to issue certificate with wrapped signer")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse issued certificate")
+	assert.NoError(t, cert.CheckSignatureFrom(caCert))
+
+	_, err = WrapSigner(nil)
+	assert.Error(t, err, "Wrapping a nil signer should fail")
+}
@@ -0,0 +1,323 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/pkg/errors"
+)
+
+// SM2DefaultUserID is the user identity of GM/T 0009-2012 used to compute the
+// Z value of SM2 signatures when the signer doesn't specify one
+var SM2DefaultUserID = []byte("1234567812345678")
+
+// SM2KeyGenOpts are the options to generate an SM2 key. The software BCCSP
+// provider can't generate SM2 keys; a GM provider must recognize these
+// options, e.g. by their algorithm, to generate SM2 keys for fabric-ca.
+type SM2KeyGenOpts struct {
+	Temporary bool
+}
+
+// Algorithm returns the key generation algorithm identifier
+func (opts *SM2KeyGenOpts) Algorithm() string {
+	return "SM2"
+}
+
+// Ephemeral returns true if the key to generate has to be ephemeral
+func (opts *SM2KeyGenOpts) Ephemeral() bool {
+	return opts.Temporary
+}
+
+// isSM2KeyRequest returns true if kr requests an SM2 key: its algorithm is
+// "gmsm2", as in the key requests of the configuration, or "sm2"
+func isSM2KeyRequest(kr *csr.KeyRequest) bool {
+	return kr != nil && (strings.EqualFold(kr.Algo(), "gmsm2") || strings.EqualFold(kr.Algo(), "sm2"))
+}
+
+var (
+	sm2Once  sync.Once
+	sm2Curve sm2P256Curve
+)
+
+// SM2P256 returns the sm2p256v1 elliptic curve of GB/T 32918.5-2017. Its
+// scalar multiplications are constant time; see sm2P256Curve.
+func SM2P256() elliptic.Curve {
+	sm2Once.Do(func() {
+		params := &elliptic.CurveParams{Name: "SM2-P-256", BitSize: 256}
+		params.P, _ = new(big.Int).SetString("FFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF00000000FFFFFFFFFFFFFFFF", 16)
+		params.N, _ = new(big.Int).SetString("FFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFF7203DF6B21C6052B53BBF40939D54123", 16)
+		params.B, _ = new(big.Int).SetString("28E9FA9E9D9F5E344D5A9E4BCF6509A7F39789F515AB8F92DDBCBD414D940E93", 16)
+		params.Gx, _ = new(big.Int).SetString("32C4AE2C1F1981195F9904466A39C9948FE30BBFF2660BE1715A4589334C74C7", 16)
+		params.Gy, _ = new(big.Int).SetString("BC3736A2F4F6779C59BDCEE36B692153D0A9877CC62A474002DF32E52139F0A0", 16)
+		sm2Curve = newSM2P256Curve(params)
+	})
+	return sm2Curve
+}
+
+// sm2Signature is the ASN.1 encoding of an SM2 signature of GM/T 0009-2012
+type sm2Signature struct {
+	R, S *big.Int
+}
+
+// SM2Sign returns the DER encoded SM2 signature of msg by priv, which must be
+// a key on the SM2P256 curve. The message is hashed with SM3 along with the Z
+// value of the signer computed from userID; if userID is empty,
+// SM2DefaultUserID is used. The verifier must use the same user ID.
+func SM2Sign(priv *ecdsa.PrivateKey, msg, userID []byte) ([]byte, error) {
+	if priv == nil || !isSM2Curve(priv.Curve) {
+		return nil, errors.New("The private key is not an SM2 key")
+	}
+	e, err := sm2Digest(&priv.PublicKey, msg, userID)
+	if err != nil {
+		return nil, err
+	}
+	n := priv.Curve.Params().N
+	for {
+		k, err := randFieldElement(n)
+		if err != nil {
+			return nil, err
+		}
+		x1, _ := priv.Curve.ScalarBaseMult(padBytes(k, 32))
+		// r = (e + x1) mod n, and neither r nor r + k may be 0 mod n
+		r := new(big.Int).Add(e, x1)
+		r.Mod(r, n)
+		if r.Sign() == 0 || new(big.Int).Add(r, k).Cmp(n) == 0 {
+			continue
+		}
+		// s = (1 + d)^-1 * (k - r*d) mod n, computed as
+		// ((k - r*d) * b) * ((1 + d) * b)^-1 with a random b so that the
+		// variable time modular inversion doesn't depend on the private key
+		b, err := randFieldElement(n)
+		if err != nil {
+			return nil, err
+		}
+		s := new(big.Int).Mul(r, priv.D)
+		s.Sub(k, s)
+		s.Mul(s, b)
+		dInv := new(big.Int).Add(priv.D, big.NewInt(1))
+		dInv.Mul(dInv, b)
+		dInv.Mod(dInv, n)
+		dInv.ModInverse(dInv, n)
+		s.Mul(s, dInv)
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+		sig, err := asn1.Marshal(sm2Signature{R: r, S: s})
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to marshal SM2 signature")
+		}
+		return sig, nil
+	}
+}
+
+// SM2Verify returns true if sig is a valid DER encoded SM2 signature of msg
+// by the private key of pub, computed with the user ID userID. If userID is
+// empty, SM2DefaultUserID is used.
+func SM2Verify(pub *ecdsa.PublicKey, msg, sig, userID []byte) bool {
+	if pub == nil || !isSM2Curve(pub.Curve) {
+		return false
+	}
+	e, err := sm2Digest(pub, msg, userID)
+	if err != nil {
+		return false
+	}
+	return sm2VerifyDigest(pub, e, sig)
+}
+
+// SM2Digest returns the digest signed by SM2 signatures of msg by the private
+// key of pub with the user ID userID, that is the SM3 hash of the Z value of
+// the signer followed by msg. If userID is empty, SM2DefaultUserID is used.
+func SM2Digest(pub *ecdsa.PublicKey, msg, userID []byte) ([]byte, error) {
+	if pub == nil || !isSM2Curve(pub.Curve) {
+		return nil, errors.New("The public key is not an SM2 key")
+	}
+	e, err := sm2Digest(pub, msg, userID)
+	if err != nil {
+		return nil, err
+	}
+	return padBytes(e, sm3Size), nil
+}
+
+// BatchVerifySM2 verifies the DER encoded SM2 signatures sigs of the digests
+// computed by SM2Digest, the i-th signature being verified with the i-th
+// public key. The signatures are verified by a pool of at most one worker per
+// CPU, or by the caller for a single signature. The i-th result is true if the
+// i-th signature is valid. An error is returned if the lengths of the slices
+// differ or if a public key is not an SM2 key.
+func BatchVerifySM2(pubs []*ecdsa.PublicKey, digests, sigs [][]byte) ([]bool, error) {
+	if len(pubs) != len(digests) || len(pubs) != len(sigs) {
+		return nil, errors.Errorf("The numbers of public keys (%d), digests (%d) and signatures (%d) differ",
+			len(pubs), len(digests), len(sigs))
+	}
+	for i, pub := range pubs {
+		if !IsSM2PublicKey(pub) {
+			return nil, errors.Errorf("The public key at index %d is not an SM2 key", i)
+		}
+	}
+	results := make([]bool, len(sigs))
+	verify := func(i int) {
+		if len(digests[i]) == sm3Size {
+			results[i] = sm2VerifyDigest(pubs[i], new(big.Int).SetBytes(digests[i]), sigs[i])
+		}
+	}
+	workers := runtime.NumCPU()
+	if workers > len(sigs) {
+		workers = len(sigs)
+	}
+	if workers <= 1 {
+		for i := range sigs {
+			verify(i)
+		}
+		return results, nil
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				verify(i)
+			}
+		}()
+	}
+	for i := range sigs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results, nil
+}
+
+// sm2VerifyDigest returns true if sig is a valid DER encoded SM2 signature of
+// the digest e by the private key of pub
+func sm2VerifyDigest(pub *ecdsa.PublicKey, e *big.Int, sig []byte) bool {
+	var rs sm2Signature
+	rest, err := asn1.Unmarshal(sig, &rs)
+	if err != nil || len(rest) > 0 || rs.R == nil || rs.S == nil {
+		return false
+	}
+	n := pub.Curve.Params().N
+	if rs.R.Sign() <= 0 || rs.S.Sign() <= 0 || rs.R.Cmp(n) >= 0 || rs.S.Cmp(n) >= 0 {
+		return false
+	}
+	t := new(big.Int).Add(rs.R, rs.S)
+	t.Mod(t, n)
+	if t.Sign() == 0 {
+		return false
+	}
+	x1, y1 := pub.Curve.ScalarBaseMult(rs.S.Bytes())
+	x2, y2 := pub.Curve.ScalarMult(pub.X, pub.Y, t.Bytes())
+	x, _ := pub.Curve.Add(x1, y1, x2, y2)
+	x.Add(x, e)
+	x.Mod(x, n)
+	return x.Cmp(rs.R) == 0
+}
+
+// sm2Digest returns e = SM3(Z || msg) as an integer, where Z is the hash of
+// the user ID, the curve parameters and the public key of the signer
+func sm2Digest(pub *ecdsa.PublicKey, msg, userID []byte) (*big.Int, error) {
+	if len(userID) == 0 {
+		userID = SM2DefaultUserID
+	}
+	return sm2DigestWithID(pub, msg, userID)
+}
+
+// sm2DigestWithID is sm2Digest without the default user ID, so that Z can be
+// computed for an empty user ID
+func sm2DigestWithID(pub *ecdsa.PublicKey, msg, userID []byte) (*big.Int, error) {
+	z, err := sm2Z(pub, userID)
+	if err != nil {
+		return nil, err
+	}
+	h := newSM3()
+	h.Write(z)
+	h.Write(msg)
+	return new(big.Int).SetBytes(h.Sum(nil)), nil
+}
+
+// sm2Z returns the Z value of the owner of pub: the SM3 hash of userID, the
+// curve parameters and pub
+func sm2Z(pub *ecdsa.PublicKey, userID []byte) ([]byte, error) {
+	// The length of the user ID is encoded in bits on two bytes
+	if len(userID) >= 1<<13 {
+		return nil, errors.Errorf("The SM2 user ID is too long: %d bytes", len(userID))
+	}
+	params := pub.Curve.Params()
+	size := (params.BitSize + 7) / 8
+	a := new(big.Int).Sub(params.P, big.NewInt(3))
+
+	h := newSM3()
+	bitLen := len(userID) * 8
+	h.Write([]byte{byte(bitLen >> 8), byte(bitLen)})
+	h.Write(userID)
+	for _, v := range []*big.Int{a, params.B, params.Gx, params.Gy, pub.X, pub.Y} {
+		h.Write(padBytes(v, size))
+	}
+	return h.Sum(nil), nil
+}
+
+// randFieldElement returns a random integer in [1, n-1]
+func randFieldElement(n *big.Int) (*big.Int, error) {
+	k, err := rand.Int(rand.Reader, new(big.Int).Sub(n, big.NewInt(1)))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate random number")
+	}
+	return k.Add(k, big.NewInt(1)), nil
+}
+
+func isSM2Curve(curve elliptic.Curve) bool {
+	return curve != nil && curve.Params() == SM2P256().Params()
+}
+
+// IsSM2PublicKey returns true if pub is an ECDSA public key on the SM2 curve,
+// which is how SM2 public keys are represented
+func IsSM2PublicKey(pub crypto.PublicKey) bool {
+	key, ok := pub.(*ecdsa.PublicKey)
+	return ok && key != nil && isSM2Curve(key.Curve)
+}
+
+// sm2PublicKeyInfo is the ASN.1 structure of a PKIX encoded public key
+type sm2PublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// parseSM2PublicKey parses the DER encoded PKIX public key der, which must be
+// an uncompressed point of the SM2 curve
+func parseSM2PublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	var info sm2PublicKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode public key")
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("Trailing data after the public key")
+	}
+	var problem string
+	checkGMPublicKey(info.Algorithm, info.PublicKey, func(format string, args ...interface{}) {
+		problem = fmt.Sprintf(format, args...)
+	})
+	if problem != "" {
+		return nil, errors.Errorf("Invalid SM2 public key: %s", problem)
+	}
+	x, y := elliptic.Unmarshal(SM2P256(), info.PublicKey.RightAlign())
+	return &ecdsa.PublicKey{Curve: SM2P256(), X: x, Y: y}, nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSM2UserID(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate SM2 key")
+	msg := []byte("message digest")
+	userID := []byte("ALICE123@YAHOO.COM")
+
+	sig, err := SM2Sign(priv, msg, userID)
+	FatalError(t, err, "Failed to sign with a custom user ID")
+	assert.True(t, SM2Verify(&priv.PublicKey, msg, sig, userID), "The signature should be valid with the same user ID")
+	assert.False(t, SM2Verify(&priv.PublicKey, msg, sig, []byte("BILL456@YAHOO.COM")), "The signature should be invalid with another user ID")
+	assert.False(t, SM2Verify(&priv.PublicKey, msg, sig, nil), "The signature should be invalid with the default user ID")
+	assert.False(t, SM2Verify(&priv.PublicKey, []byte("other message"), sig, userID))
+
+	sig, err = SM2Sign(priv, msg, nil)
+	FatalError(t, err, "Failed to sign with the default user ID")
+	assert.True(t, SM2Verify(&priv.PublicKey, msg, sig, nil))
+	assert.True(t, SM2Verify(&priv.PublicKey, msg, sig, SM2DefaultUserID))
+	assert.False(t, SM2Verify(&priv.PublicKey, msg, sig, userID))
+
+	_, err = SM2Sign(priv, msg, make([]byte, 1<<13))
+	assert.Error(t, err, "A user ID too long for its length to be encoded should be rejected")
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate P-256 key")
+	_, err = SM2Sign(p256Key, msg, nil)
+	assert.Error(t, err, "A key which is not on the SM2 curve should be rejected")
+}
+
+// The signatures were generated with OpenSSL 3.0, which must be given the
+// default user ID explicitly:
+//
+//	openssl dgst -sm3 -sign key.pem -sigopt distid:<user ID>
+func TestSM2VerifyOpenSSL(t *testing.T) {
+	pubHex := "0bd99c336eaf0bf0710de36425e2e291b80597bf91e066190e441d960415fb51" +
+		"d92039e03ee862bb3d29dcda3c7f50264fc283bacdbd27601a7d83d297e7e3b4"
+	pubBytes, err := hex.DecodeString(pubHex)
+	FatalError(t, err, "Failed to decode public key")
+	pub := &ecdsa.PublicKey{
+		Curve: SM2P256(),
+		X:     new(big.Int).SetBytes(pubBytes[:32]),
+		Y:     new(big.Int).SetBytes(pubBytes[32:]),
+	}
+	msg := []byte("message digest")
+	tests := []struct {
+		userID []byte
+		sig    string
+	}{
+		{
+			userID: []byte("ALICE123@YAHOO.COM"),
+			sig: "304402203d91dbacc09787308d4ef8f600756b4490f6f97851df15881e6d3d89f7e87882" +
+				"02203959597424d26f15cd0f0ae931b750a6d28c2bf00c5dd1dc9c707f7a82f8489d",
+		},
+		{
+			userID: nil,
+			sig: "3046022100cf01055542aec57f8187311c6654ac719fcb1678b4a7a51d798e7e8db80cd2e1" +
+				"022100862cbe341d419ace60fb001a4fb989a230066b6513fffe1e61f96802bf8e4aa4",
+		},
+	}
+	for _, test := range tests {
+		sig, err := hex.DecodeString(test.sig)
+		FatalError(t, err, "Failed to decode signature")
+		assert.True(t, SM2Verify(pub, msg, sig, test.userID), "The OpenSSL signature should be valid with user ID '%s'", test.userID)
+		assert.False(t, SM2Verify(pub, msg, sig, []byte("1234567812345679")))
+	}
+	assert.False(t, SM2Verify(pub, msg, []byte("bad signature"), nil))
+}
+
+func TestBatchVerifySM2(t *testing.T) {
+	var pubs []*ecdsa.PublicKey
+	var digests, sigs [][]byte
+	var expected []bool
+	for i := 0; i < 8; i++ {
+		priv, err := ecdsa.GenerateKey(SM2P256(), rand.Reader)
+		FatalError(t, err, "Failed to generate SM2 key")
+		msg := []byte{byte(i)}
+		sig, err := SM2Sign(priv, msg, nil)
+		FatalError(t, err, "Failed to sign")
+		digest, err := SM2Digest(&priv.PublicKey, msg, nil)
+		FatalError(t, err, "Failed to compute digest")
+		valid := true
+		switch i {
+		case 1:
+			// Signature of another message
+			digest, err = SM2Digest(&priv.PublicKey, []byte("other message"), nil)
+			FatalError(t, err, "Failed to compute digest")
+			valid = false
+		case 3:
+			// Digest computed with another user ID
+			digest, err = SM2Digest(&priv.PublicKey, msg, []byte("ALICE123@YAHOO.COM"))
+			FatalError(t, err, "Failed to compute digest")
+			valid = false
+		case 4:
+			sig = []byte("bad signature")
+			valid = false
+		case 6:
+			// Signature verified with the key of the previous item
+			pubs = append(pubs, pubs[5])
+			digests = append(digests, digest)
+			sigs = append(sigs, sig)
+			expected = append(expected, false)
+			continue
+		}
+		pubs = append(pubs, &priv.PublicKey)
+		digests = append(digests, digest)
+		sigs = append(sigs, sig)
+		expected = append(expected, valid)
+	}
+	// A digest that is not an SM3 digest
+	pubs = append(pubs, pubs[0])
+	digests = append(digests, digests[0][1:])
+	sigs = append(sigs, sigs[0])
+	expected = append(expected, false)
+
+	results, err := BatchVerifySM2(pubs, digests, sigs)
+	FatalError(t, err, "Failed to batch verify signatures")
+	assert.Equal(t, expected, results)
+
+	// A single signature is verified without a worker pool
+	results, err = BatchVerifySM2(pubs[:1], digests[:1], sigs[:1])
+	FatalError(t, err, "Failed to batch verify a signature")
+	assert.Equal(t, []bool{true}, results)
+
+	results, err = BatchVerifySM2(nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+	_, err = BatchVerifySM2(pubs, digests[1:], sigs)
+	assert.Error(t, err, "Slices of different lengths should be rejected")
+
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	FatalError(t, err, "Failed to generate P-256 key")
+	for _, pub := range []*ecdsa.PublicKey{&p256Key.PublicKey, nil} {
+		_, err = BatchVerifySM2(append(pubs[:1:1], pub), digests[:2], sigs[:2])
+		assert.Error(t, err, "A public key that is not an SM2 key should be rejected")
+	}
+}
+
+func TestSM2P256MatchesGeneric(t *testing.T) {
+	curve := SM2P256()
+	generic := curve.Params()
+	n := generic.N
+	for i := 0; i < 20; i++ {
+		k, err := rand.Int(rand.Reader, n)
+		FatalError(t, err, "Failed to generate scalar")
+		x1, y1 := curve.ScalarBaseMult(k.Bytes())
+		x2, y2 := generic.ScalarBaseMult(k.Bytes())
+		assert.Equal(t, x2, x1, "ScalarBaseMult differs from the generic implementation")
+		assert.Equal(t, y2, y1, "ScalarBaseMult differs from the generic implementation")
+
+		k2, err := rand.Int(rand.Reader, n)
+		FatalError(t, err, "Failed to generate scalar")
+		x3, y3 := curve.ScalarMult(x1, y1, k2.Bytes())
+		x4, y4 := generic.ScalarMult(x1, y1, k2.Bytes())
+		assert.Equal(t, x4, x3, "ScalarMult differs from the generic implementation")
+		assert.Equal(t, y4, y3, "ScalarMult differs from the generic implementation")
+
+		x5, y5 := curve.Add(x1, y1, x3, y3)
+		x6, y6 := generic.Add(x1, y1, x3, y3)
+		assert.Equal(t, x6, x5, "Add differs from the generic implementation")
+		assert.Equal(t, y6, y5, "Add differs from the generic implementation")
+		assert.True(t, curve.IsOnCurve(x5, y5))
+
+		x7, y7 := curve.Double(x1, y1)
+		x8, y8 := curve.Add(x1, y1, x1, y1)
+		x9, y9 := generic.Double(x1, y1)
+		assert.Equal(t, x9, x7, "Double differs from the generic implementation")
+		assert.Equal(t, y9, y7, "Double differs from the generic implementation")
+		assert.Equal(t, x7, x8, "Adding a point to itself should double it")
+		assert.Equal(t, y7, y8, "Adding a point to itself should double it")
+	}
+
+	// The point at infinity is (0, 0)
+	x, y := curve.ScalarBaseMult(n.Bytes())
+	assert.Equal(t, 0, x.Sign()+y.Sign(), "[N]G should be the point at infinity")
+	x, y = curve.ScalarBaseMult(nil)
+	assert.Equal(t, 0, x.Sign()+y.Sign(), "[0]G should be the point at infinity")
+	negY := new(big.Int).Sub(generic.P, generic.Gy)
+	x, y = curve.Add(generic.Gx, generic.Gy, generic.Gx, negY)
+	assert.Equal(t, 0, x.Sign()+y.Sign(), "G - G should be the point at infinity")
+	x, y = curve.Add(generic.Gx, generic.Gy, new(big.Int), new(big.Int))
+	assert.Equal(t, generic.Gx, x, "G + O should be G")
+	assert.Equal(t, generic.Gy, y, "G + O should be G")
+
+	// Scalars longer than 32 bytes are reduced mod N
+	long := new(big.Int).Add(new(big.Int).Lsh(n, 8), big.NewInt(5))
+	x, y = curve.ScalarBaseMult(long.Bytes())
+	x2, y2 := generic.ScalarBaseMult(long.Bytes())
+	assert.Equal(t, x2, x)
+	assert.Equal(t, y2, y)
+}
@@ -0,0 +1,368 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"math/bits"
+)
+
+// sm2P256Curve implements the SM2 curve with constant time arithmetic: field
+// elements are four 64 bit limbs in Montgomery form, points use the complete
+// projective formulas for a = -3 of Renes, Costello and Batina ("Complete
+// addition formulas for prime order elliptic curves", 2015), and scalar
+// multiplication uses a fixed 4 bit window with masked table lookups. Only
+// the conversions from and to big.Int, done on public coordinates, are not
+// constant time.
+type sm2P256Curve struct {
+	*elliptic.CurveParams
+}
+
+// sm2Element is an element of the SM2 field in Montgomery form, least
+// significant limb first
+type sm2Element [4]uint64
+
+// sm2Point is a point in projective coordinates; Z = 0 is the point at
+// infinity
+type sm2Point struct {
+	x, y, z sm2Element
+}
+
+var (
+	sm2FieldP = sm2Element{0xFFFFFFFFFFFFFFFF, 0xFFFFFFFF00000000, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFEFFFFFFFF}
+	// sm2PMinus2 is the exponent of the inversion by Fermat's little theorem
+	sm2PMinus2 = sm2Element{0xFFFFFFFFFFFFFFFD, 0xFFFFFFFF00000000, 0xFFFFFFFFFFFFFFFF, 0xFFFFFFFEFFFFFFFF}
+	sm2PBig    *big.Int
+	sm2MontB   sm2Element
+	sm2MontOne sm2Element
+)
+
+func newSM2P256Curve(params *elliptic.CurveParams) sm2P256Curve {
+	sm2PBig = params.P
+	sm2MontB = sm2ElementFromBig(params.B)
+	sm2MontOne = sm2ElementFromBig(big.NewInt(1))
+	return sm2P256Curve{params}
+}
+
+func (c sm2P256Curve) Params() *elliptic.CurveParams {
+	return c.CurveParams
+}
+
+func (c sm2P256Curve) IsOnCurve(x, y *big.Int) bool {
+	return c.CurveParams.IsOnCurve(x, y)
+}
+
+func (c sm2P256Curve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	var r sm2Point
+	p1, p2 := sm2PointFromAffine(x1, y1), sm2PointFromAffine(x2, y2)
+	r.add(&p1, &p2)
+	return r.affine()
+}
+
+func (c sm2P256Curve) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	var r sm2Point
+	p := sm2PointFromAffine(x1, y1)
+	r.double(&p)
+	return r.affine()
+}
+
+func (c sm2P256Curve) ScalarMult(x1, y1 *big.Int, k []byte) (*big.Int, *big.Int) {
+	p := sm2PointFromAffine(x1, y1)
+	var r sm2Point
+	r.scalarMult(&p, c.scalar(k))
+	return r.affine()
+}
+
+func (c sm2P256Curve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.ScalarMult(c.Gx, c.Gy, k)
+}
+
+// scalar returns k as 32 big endian bytes; longer scalars are reduced mod N
+func (c sm2P256Curve) scalar(k []byte) [32]byte {
+	var s [32]byte
+	if len(k) > len(s) {
+		k = new(big.Int).Mod(new(big.Int).SetBytes(k), c.N).Bytes()
+	}
+	copy(s[len(s)-len(k):], k)
+	return s
+}
+
+// sm2ElementFromBig returns x·R mod p; x is public
+func sm2ElementFromBig(x *big.Int) sm2Element {
+	v := new(big.Int).Lsh(x, 256)
+	var buf [32]byte
+	b := v.Mod(v, sm2PBig).Bytes()
+	copy(buf[32-len(b):], b)
+	var e sm2Element
+	for i := range e {
+		for j := 0; j < 8; j++ {
+			e[i] |= uint64(buf[31-8*i-j]) << (8 * uint(j))
+		}
+	}
+	return e
+}
+
+// toBig returns the integer represented by e
+func (e *sm2Element) toBig() *big.Int {
+	var one, plain sm2Element
+	one[0] = 1
+	plain.mul(e, &one)
+	var buf [32]byte
+	for i, w := range plain {
+		for j := 0; j < 8; j++ {
+			buf[31-8*i-j] = byte(w >> (8 * uint(j)))
+		}
+	}
+	return new(big.Int).SetBytes(buf[:])
+}
+
+// mul sets z = x·y·R⁻¹ mod p. As p ≡ -1 mod 2⁶⁴, -p⁻¹ mod 2⁶⁴ is 1 and the
+// Montgomery factor of each step is the lowest limb.
+func (z *sm2Element) mul(x, y *sm2Element) {
+	var t [6]uint64
+	for i := 0; i < 4; i++ {
+		var c uint64
+		for j := 0; j < 4; j++ {
+			hi, lo := bits.Mul64(x[j], y[i])
+			var cc uint64
+			lo, cc = bits.Add64(lo, t[j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			hi += cc
+			t[j], c = lo, hi
+		}
+		var cc uint64
+		t[4], cc = bits.Add64(t[4], c, 0)
+		t[5] = cc
+
+		m := t[0]
+		hi, lo := bits.Mul64(m, sm2FieldP[0])
+		_, cc = bits.Add64(lo, t[0], 0)
+		c = hi + cc
+		for j := 1; j < 4; j++ {
+			hi, lo = bits.Mul64(m, sm2FieldP[j])
+			lo, cc = bits.Add64(lo, t[j], 0)
+			hi += cc
+			lo, cc = bits.Add64(lo, c, 0)
+			hi += cc
+			t[j-1], c = lo, hi
+		}
+		t[3], cc = bits.Add64(t[4], c, 0)
+		t[4] = t[5] + cc
+		t[5] = 0
+	}
+	z.reduce(t[0], t[1], t[2], t[3], t[4])
+}
+
+// reduce sets z to the 257 bit value t4:t3:t2:t1:t0, which is below 2p,
+// minus p if it is at least p
+func (z *sm2Element) reduce(t0, t1, t2, t3, t4 uint64) {
+	var b uint64
+	var s sm2Element
+	s[0], b = bits.Sub64(t0, sm2FieldP[0], 0)
+	s[1], b = bits.Sub64(t1, sm2FieldP[1], b)
+	s[2], b = bits.Sub64(t2, sm2FieldP[2], b)
+	s[3], b = bits.Sub64(t3, sm2FieldP[3], b)
+	_, b = bits.Sub64(t4, 0, b)
+	// b is 1 if t < p, in which case t is kept
+	mask := -b
+	z[0] = t0&mask | s[0]&^mask
+	z[1] = t1&mask | s[1]&^mask
+	z[2] = t2&mask | s[2]&^mask
+	z[3] = t3&mask | s[3]&^mask
+}
+
+func (z *sm2Element) add(x, y *sm2Element) {
+	var t [4]uint64
+	var c uint64
+	t[0], c = bits.Add64(x[0], y[0], 0)
+	t[1], c = bits.Add64(x[1], y[1], c)
+	t[2], c = bits.Add64(x[2], y[2], c)
+	t[3], c = bits.Add64(x[3], y[3], c)
+	z.reduce(t[0], t[1], t[2], t[3], c)
+}
+
+func (z *sm2Element) sub(x, y *sm2Element) {
+	var t sm2Element
+	var b uint64
+	t[0], b = bits.Sub64(x[0], y[0], 0)
+	t[1], b = bits.Sub64(x[1], y[1], b)
+	t[2], b = bits.Sub64(x[2], y[2], b)
+	t[3], b = bits.Sub64(x[3], y[3], b)
+	// Add p back if the subtraction borrowed
+	mask := -b
+	var c uint64
+	z[0], c = bits.Add64(t[0], sm2FieldP[0]&mask, 0)
+	z[1], c = bits.Add64(t[1], sm2FieldP[1]&mask, c)
+	z[2], c = bits.Add64(t[2], sm2FieldP[2]&mask, c)
+	z[3], _ = bits.Add64(t[3], sm2FieldP[3]&mask, c)
+}
+
+// invert sets z = x⁻¹ = x^(p-2); the exponent is public so the square and
+// multiply is constant time. The inverse of 0 is 0.
+func (z *sm2Element) invert(x *sm2Element) {
+	r := sm2MontOne
+	for i := 255; i >= 0; i-- {
+		r.mul(&r, &r)
+		if sm2PMinus2[i/64]>>(uint(i)%64)&1 == 1 {
+			r.mul(&r, x)
+		}
+	}
+	*z = r
+}
+
+// sm2PointFromAffine returns the projective point (x, y, 1); (0, 0) is the
+// point at infinity, as for elliptic.CurveParams
+func sm2PointFromAffine(x, y *big.Int) sm2Point {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return sm2Point{y: sm2MontOne}
+	}
+	return sm2Point{x: sm2ElementFromBig(x), y: sm2ElementFromBig(y), z: sm2MontOne}
+}
+
+// affine returns the affine coordinates of p, or (0, 0) for the point at
+// infinity
+func (p *sm2Point) affine() (*big.Int, *big.Int) {
+	var zInv, x, y sm2Element
+	zInv.invert(&p.z)
+	x.mul(&p.x, &zInv)
+	y.mul(&p.y, &zInv)
+	return x.toBig(), y.toBig()
+}
+
+// add sets r = p1 + p2 with algorithm 4 of Renes, Costello and Batina
+func (r *sm2Point) add(p1, p2 *sm2Point) {
+	var t0, t1, t2, t3, t4, x3, y3, z3 sm2Element
+	t0.mul(&p1.x, &p2.x)
+	t1.mul(&p1.y, &p2.y)
+	t2.mul(&p1.z, &p2.z)
+	t3.add(&p1.x, &p1.y)
+	t4.add(&p2.x, &p2.y)
+	t3.mul(&t3, &t4)
+	t4.add(&t0, &t1)
+	t3.sub(&t3, &t4)
+	t4.add(&p1.y, &p1.z)
+	x3.add(&p2.y, &p2.z)
+	t4.mul(&t4, &x3)
+	x3.add(&t1, &t2)
+	t4.sub(&t4, &x3)
+	x3.add(&p1.x, &p1.z)
+	y3.add(&p2.x, &p2.z)
+	x3.mul(&x3, &y3)
+	y3.add(&t0, &t2)
+	y3.sub(&x3, &y3)
+	z3.mul(&sm2MontB, &t2)
+	x3.sub(&y3, &z3)
+	z3.add(&x3, &x3)
+	x3.add(&x3, &z3)
+	z3.sub(&t1, &x3)
+	x3.add(&t1, &x3)
+	y3.mul(&sm2MontB, &y3)
+	t1.add(&t2, &t2)
+	t2.add(&t1, &t2)
+	y3.sub(&y3, &t2)
+	y3.sub(&y3, &t0)
+	t1.add(&y3, &y3)
+	y3.add(&t1, &y3)
+	t1.add(&t0, &t0)
+	t0.add(&t1, &t0)
+	t0.sub(&t0, &t2)
+	t1.mul(&t4, &y3)
+	t2.mul(&t0, &y3)
+	y3.mul(&x3, &z3)
+	y3.add(&y3, &t2)
+	x3.mul(&t3, &x3)
+	x3.sub(&x3, &t1)
+	z3.mul(&t4, &z3)
+	t1.mul(&t3, &t0)
+	z3.add(&z3, &t1)
+	r.x, r.y, r.z = x3, y3, z3
+}
+
+// double sets r = 2p with algorithm 6 of Renes, Costello and Batina
+func (r *sm2Point) double(p *sm2Point) {
+	var t0, t1, t2, t3, x3, y3, z3 sm2Element
+	t0.mul(&p.x, &p.x)
+	t1.mul(&p.y, &p.y)
+	t2.mul(&p.z, &p.z)
+	t3.mul(&p.x, &p.y)
+	t3.add(&t3, &t3)
+	z3.mul(&p.x, &p.z)
+	z3.add(&z3, &z3)
+	y3.mul(&sm2MontB, &t2)
+	y3.sub(&y3, &z3)
+	x3.add(&y3, &y3)
+	y3.add(&x3, &y3)
+	x3.sub(&t1, &y3)
+	y3.add(&t1, &y3)
+	y3.mul(&x3, &y3)
+	x3.mul(&x3, &t3)
+	t3.add(&t2, &t2)
+	t2.add(&t2, &t3)
+	z3.mul(&sm2MontB, &z3)
+	z3.sub(&z3, &t2)
+	z3.sub(&z3, &t0)
+	t3.add(&z3, &z3)
+	z3.add(&z3, &t3)
+	t3.add(&t0, &t0)
+	t0.add(&t3, &t0)
+	t0.sub(&t0, &t2)
+	t0.mul(&t0, &z3)
+	y3.add(&y3, &t0)
+	t0.mul(&p.y, &p.z)
+	t0.add(&t0, &t0)
+	z3.mul(&t0, &z3)
+	x3.sub(&x3, &z3)
+	z3.mul(&t0, &t1)
+	z3.add(&z3, &z3)
+	z3.add(&z3, &z3)
+	r.x, r.y, r.z = x3, y3, z3
+}
+
+// scalarMult sets r = [k]p with a fixed window of 4 bits; the table entry of
+// each window is selected by scanning the whole table
+func (r *sm2Point) scalarMult(p *sm2Point, k [32]byte) {
+	var table [16]sm2Point
+	table[0] = sm2Point{y: sm2MontOne}
+	table[1] = *p
+	for i := 2; i < 16; i++ {
+		if i%2 == 0 {
+			table[i].double(&table[i/2])
+		} else {
+			table[i].add(&table[i-1], p)
+		}
+	}
+	acc := sm2Point{y: sm2MontOne}
+	var t sm2Point
+	for i := 0; i < 64; i++ {
+		if i > 0 {
+			acc.double(&acc)
+			acc.double(&acc)
+			acc.double(&acc)
+			acc.double(&acc)
+		}
+		w := k[i/2] >> (4 * uint(1-i%2)) & 0xf
+		t.selectFrom(&table, w)
+		acc.add(&acc, &t)
+	}
+	*r = acc
+}
+
+// selectFrom sets r to table[w] without a secret dependent memory access
+func (r *sm2Point) selectFrom(table *[16]sm2Point, w byte) {
+	*r = sm2Point{}
+	for i := range table {
+		// mask is all ones if i == w
+		mask := uint64(0) - uint64((uint32(byte(i)^w)-1)>>31)
+		for j := 0; j < 4; j++ {
+			r.x[j] |= table[i].x[j] & mask
+			r.y[j] |= table[i].y[j] & mask
+			r.z[j] |= table[i].z[j] & mask
+		}
+	}
+}
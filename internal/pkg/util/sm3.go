@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+	"math/bits"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SM3 is the identifier of the SM3 hash algorithm of GB/T 32905-2016
+	SM3 = "SM3"
+
+	sm3Size      = 32
+	sm3BlockSize = 64
+)
+
+// SM3Opts are the options to compute SM3 hashes with a BCCSP provider which
+// supports them
+type SM3Opts struct{}
+
+// Algorithm returns the hash algorithm identifier
+func (opts *SM3Opts) Algorithm() string {
+	return SM3
+}
+
+// HMACSM3 returns the HMAC-SM3 of data keyed with key. The SM3 hash function
+// of csp is used if it provides one; otherwise, as no BCCSP provider in this
+// build does, the HMAC is computed with a software implementation of SM3.
+func HMACSM3(key, data []byte, csp bccsp.BCCSP) ([]byte, error) {
+	if csp == nil {
+		return nil, errors.New("CSP was not initialized")
+	}
+	newHash := newSM3
+	if _, err := csp.GetHash(&SM3Opts{}); err == nil {
+		newHash = func() hash.Hash {
+			h, _ := csp.GetHash(&SM3Opts{})
+			return h
+		}
+	}
+	mac := hmac.New(newHash, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+var sm3IV = [8]uint32{
+	0x7380166f, 0x4914b2b9, 0x172442d7, 0xda8a0600,
+	0xa96f30bc, 0x163138aa, 0xe38dee4d, 0xb0fb0e4e,
+}
+
+// sm3Digest is a software implementation of the SM3 hash function
+type sm3Digest struct {
+	h   [8]uint32
+	buf [sm3BlockSize]byte
+	n   int
+	len uint64
+}
+
+// newSM3 returns a new hash.Hash computing the SM3 checksum
+func newSM3() hash.Hash {
+	d := new(sm3Digest)
+	d.Reset()
+	return d
+}
+
+func (d *sm3Digest) Size() int { return sm3Size }
+
+func (d *sm3Digest) BlockSize() int { return sm3BlockSize }
+
+func (d *sm3Digest) Reset() {
+	d.h = sm3IV
+	d.n = 0
+	d.len = 0
+}
+
+func (d *sm3Digest) Write(p []byte) (int, error) {
+	n := len(p)
+	d.len += uint64(n)
+	if d.n > 0 {
+		c := copy(d.buf[d.n:], p)
+		d.n += c
+		p = p[c:]
+		if d.n < sm3BlockSize {
+			return n, nil
+		}
+		d.block(d.buf[:])
+		d.n = 0
+	}
+	for len(p) >= sm3BlockSize {
+		d.block(p[:sm3BlockSize])
+		p = p[sm3BlockSize:]
+	}
+	d.n = copy(d.buf[:], p)
+	return n, nil
+}
+
+func (d *sm3Digest) Sum(in []byte) []byte {
+	// Pad a copy so that the caller can keep writing
+	c := *d
+	bitLen := c.len << 3
+	var pad [sm3BlockSize + 8]byte
+	pad[0] = 0x80
+	padLen := sm3BlockSize - (c.n+9)%sm3BlockSize
+	if padLen == sm3BlockSize {
+		padLen = 0
+	}
+	padLen++
+	binary.BigEndian.PutUint64(pad[padLen:], bitLen)
+	c.Write(pad[:padLen+8])
+
+	var out [sm3Size]byte
+	for i, v := range c.h {
+		binary.BigEndian.PutUint32(out[4*i:], v)
+	}
+	return append(in, out[:]...)
+}
+
+// block runs the SM3 compression function on a 64 byte block
+func (d *sm3Digest) block(p []byte) {
+	var w [68]uint32
+	for i := 0; i < 16; i++ {
+		w[i] = binary.BigEndian.Uint32(p[4*i:])
+	}
+	for i := 16; i < 68; i++ {
+		x := w[i-16] ^ w[i-9] ^ bits.RotateLeft32(w[i-3], 15)
+		w[i] = x ^ bits.RotateLeft32(x, 15) ^ bits.RotateLeft32(x, 23) ^ bits.RotateLeft32(w[i-13], 7) ^ w[i-6]
+	}
+	a, b, c, dd, e, f, g, h := d.h[0], d.h[1], d.h[2], d.h[3], d.h[4], d.h[5], d.h[6], d.h[7]
+	for j := 0; j < 64; j++ {
+		var t, ff, gg uint32
+		if j < 16 {
+			t = 0x79cc4519
+			ff = a ^ b ^ c
+			gg = e ^ f ^ g
+		} else {
+			t = 0x7a879d8a
+			ff = (a & b) | (a & c) | (b & c)
+			gg = (e & f) | (^e & g)
+		}
+		ss1 := bits.RotateLeft32(bits.RotateLeft32(a, 12)+e+bits.RotateLeft32(t, j%32), 7)
+		ss2 := ss1 ^ bits.RotateLeft32(a, 12)
+		tt1 := ff + dd + ss2 + (w[j] ^ w[j+4])
+		tt2 := gg + h + ss1 + w[j]
+		dd = c
+		c = bits.RotateLeft32(b, 9)
+		b = a
+		a = tt1
+		h = g
+		g = bits.RotateLeft32(f, 19)
+		f = e
+		e = tt2 ^ bits.RotateLeft32(tt2, 9) ^ bits.RotateLeft32(tt2, 17)
+	}
+	d.h[0] ^= a
+	d.h[1] ^= b
+	d.h[2] ^= c
+	d.h[3] ^= dd
+	d.h[4] ^= e
+	d.h[5] ^= f
+	d.h[6] ^= g
+	d.h[7] ^= h
+}
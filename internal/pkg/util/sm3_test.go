@@ -0,0 +1,54 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMACSM3(t *testing.T) {
+	tests := []struct {
+		key  []byte
+		data []byte
+		mac  string
+	}{
+		{
+			key:  bytes.Repeat([]byte{0x0b}, 20),
+			data: []byte("Hi There"),
+			mac:  "51b00d1fb49832bfb01c3ce27848e59f871d9ba938dc563b338ca964755cce70",
+		},
+		{
+			key:  []byte("Jefe"),
+			data: []byte("what do ya want for nothing?"),
+			mac:  "2e87f1d16862e6d964b50a5200bf2b10b764faa9680a296a2405f24bec39f882",
+		},
+		{
+			// The key is longer than the SM3 block size, so it is hashed first
+			key:  bytes.Repeat([]byte{0xaa}, 131),
+			data: []byte("Test Using Larger Than Block-Size Key - Hash Key First"),
+			mac:  "b4fd844e13342002f0b2e0690ea7741f1497d993a70494cea601e657bedf67a0",
+		},
+		{
+			key:  []byte("key"),
+			data: nil,
+			mac:  "4deb29b9be17bd4fd2aca21f908885b9f849bc61e8fbd101e04fd9987528d4df",
+		},
+	}
+	for _, test := range tests {
+		mac, err := HMACSM3(test.key, test.data, csp)
+		FatalError(t, err, "Failed to compute HMAC-SM3")
+		assert.Equal(t, test.mac, hex.EncodeToString(mac))
+	}
+
+	_, err := HMACSM3([]byte("key"), []byte("data"), nil)
+	assert.Error(t, err, "Computing HMAC-SM3 without a CSP should fail")
+}
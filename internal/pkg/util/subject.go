@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SubjectRegistry records the subject DNs of issued certificates, so that
+// IssueCertificateWithUniqueSubject can reject a second active certificate
+// with the same subject DN
+type SubjectRegistry interface {
+	// Active returns true if a certificate which is neither revoked nor
+	// expired was issued to the subject DN
+	Active(subject string) (bool, error)
+	// Reserve reserves the subject DN for a certificate about to be issued,
+	// unless it is active or already reserved, in which case ErrSubjectInUse
+	// is returned. The check and the reservation are atomic, so that only
+	// one of concurrent requests for a subject DN is issued a certificate.
+	Reserve(subject string) error
+	// Release cancels the reservation of the subject DN, for which no
+	// certificate was issued
+	Release(subject string)
+	// Add records that the certificate with the serial number, valid until
+	// notAfter, was issued to the subject DN, ending its reservation
+	Add(subject string, serial *big.Int, notAfter time.Time) error
+	// Revoke records that the certificate with the serial number was revoked
+	Revoke(serial *big.Int) error
+}
+
+// subjectCert is a certificate recorded by a MemorySubjectRegistry
+type subjectCert struct {
+	subject  string
+	notAfter time.Time
+	revoked  bool
+}
+
+// MemorySubjectRegistry is a SubjectRegistry which keeps the certificates in
+// memory
+type MemorySubjectRegistry struct {
+	mutex    sync.RWMutex
+	certs    map[string]*subjectCert
+	reserved map[string]bool
+}
+
+// NewMemorySubjectRegistry returns a new empty MemorySubjectRegistry
+func NewMemorySubjectRegistry() *MemorySubjectRegistry {
+	return &MemorySubjectRegistry{certs: map[string]*subjectCert{}, reserved: map[string]bool{}}
+}
+
+// Active returns true if a certificate which is neither revoked nor expired
+// was issued to the subject DN
+func (r *MemorySubjectRegistry) Active(subject string) (bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.active(subject), nil
+}
+
+// active is Active with the mutex held
+func (r *MemorySubjectRegistry) active(subject string) bool {
+	now := Now()
+	for _, cert := range r.certs {
+		if cert.subject == subject && !cert.revoked && now.Before(cert.notAfter) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reserve reserves the subject DN for a certificate about to be issued,
+// unless it is active or already reserved, in which case ErrSubjectInUse is
+// returned
+func (r *MemorySubjectRegistry) Reserve(subject string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.reserved[subject] || r.active(subject) {
+		return ErrSubjectInUse
+	}
+	r.reserved[subject] = true
+	return nil
+}
+
+// Release cancels the reservation of the subject DN
+func (r *MemorySubjectRegistry) Release(subject string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.reserved, subject)
+}
+
+// Add records that the certificate with the serial number, valid until
+// notAfter, was issued to the subject DN, ending its reservation
+func (r *MemorySubjectRegistry) Add(subject string, serial *big.Int, notAfter time.Time) error {
+	if serial == nil {
+		return errors.New("Serial number must be different from nil")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certs[serial.String()] = &subjectCert{subject: subject, notAfter: notAfter}
+	delete(r.reserved, subject)
+	return nil
+}
+
+// Revoke records that the certificate with the serial number was revoked
+func (r *MemorySubjectRegistry) Revoke(serial *big.Int) error {
+	if serial == nil {
+		return errors.New("Serial number must be different from nil")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	cert, ok := r.certs[serial.String()]
+	if !ok {
+		return errors.Errorf("No certificate with serial number %s was issued", GetSerialAsHex(serial))
+	}
+	cert.revoked = true
+	return nil
+}
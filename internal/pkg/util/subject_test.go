@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/signer"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssueCertificateWithUniqueSubject(t *testing.T) {
+	s := newTestCASigner(t)
+	registry := NewMemorySubjectRegistry()
+
+	_, serial, err := IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))}, registry)
+	FatalError(t, err, "Failed to issue certificate")
+	active, err := registry.Active("CN=user1")
+	assert.NoError(t, err)
+	assert.True(t, active, "The issued certificate should be active")
+
+	// A second certificate with the same subject DN is rejected
+	_, _, err = IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))}, registry)
+	if assert.Error(t, err, "Issuing a duplicate subject DN should fail") {
+		assert.True(t, errors.Is(err, ErrSubjectInUse))
+		assert.Contains(t, err.Error(), "CN=user1")
+	}
+	// The subject of the request overrides that of the CSR
+	_, _, err = IssueCertificateWithUniqueSubject(s, signer.SignRequest{
+		Request: string(newTestCSR(t, "user1")),
+		Subject: &signer.Subject{CN: "user2"},
+	}, registry)
+	assert.NoError(t, err, "Another subject DN should be issued")
+
+	// The subject DN can be reissued once its certificate is revoked
+	FatalError(t, registry.Revoke(serial), "Failed to revoke certificate")
+	certPEM, _, err := IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))}, registry)
+	FatalError(t, err, "Failed to reissue certificate after revocation")
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse certificate")
+	assert.Equal(t, "user1", cert.Subject.CommonName)
+
+	assert.Error(t, registry.Revoke(new(big.Int).Add(serial, big.NewInt(1))), "An unknown serial number can't be revoked")
+	_, _, err = IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: string(newTestCSR(t, "user3"))}, nil)
+	assert.Error(t, err)
+	_, _, err = IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: "badcsr"}, registry)
+	assert.Error(t, err)
+
+	// The subject DN is released if the certificate can't be signed
+	_, _, err = IssueCertificateWithUniqueSubject(failingSigner{s}, signer.SignRequest{Request: string(newTestCSR(t, "user4"))}, registry)
+	assert.Error(t, err)
+	_, _, err = IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: string(newTestCSR(t, "user4"))}, registry)
+	assert.NoError(t, err, "The subject DN should be released after a signing failure")
+}
+
+// failingSigner is a signer which fails to sign certificates
+type failingSigner struct {
+	signer.Signer
+}
+
+func (s failingSigner) Sign(req signer.SignRequest) ([]byte, error) {
+	return nil, errors.New("Failed to sign certificate")
+}
+
+// failingAddRegistry is a subject registry which fails to add certificates
+type failingAddRegistry struct {
+	*MemorySubjectRegistry
+}
+
+func (r *failingAddRegistry) Add(subject string, serial *big.Int, notAfter time.Time) error {
+	return errors.New("Failed to add certificate")
+}
+
+func TestIssueCertificateWithUniqueSubjectAddFailure(t *testing.T) {
+	s := newTestCASigner(t)
+	registry := &failingAddRegistry{NewMemorySubjectRegistry()}
+	certPEM, serial, err := IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))}, registry)
+	assert.Error(t, err)
+	assert.NotNil(t, certPEM, "The issued certificate should be returned")
+	assert.NotNil(t, serial)
+
+	// The subject DN stays reserved, so no other certificate is issued to it
+	_, _, err = IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: string(newTestCSR(t, "user1"))}, registry)
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, ErrSubjectInUse))
+	}
+}
+
+func TestIssueCertificateWithUniqueSubjectConcurrent(t *testing.T) {
+	s := newTestCASigner(t)
+	registry := NewMemorySubjectRegistry()
+	csrPEM := string(newTestCSR(t, "user1"))
+	var issued, inUse int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := IssueCertificateWithUniqueSubject(s, signer.SignRequest{Request: csrPEM}, registry)
+			if err == nil {
+				atomic.AddInt32(&issued, 1)
+			} else if errors.Is(err, ErrSubjectInUse) {
+				atomic.AddInt32(&inUse, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), issued, "Only one certificate should be issued to the subject DN")
+	assert.Equal(t, int32(9), inUse)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util
+
+import (
+	"crypto"
+	"math/big"
+
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/pkg/errors"
+)
+
+// TimestampClient requests time-stamp tokens from a time-stamping authority,
+// such as an RFC 3161 TSA
+type TimestampClient interface {
+	// Timestamp returns a time-stamp token over digest, which was computed
+	// with hash
+	Timestamp(digest []byte, hash crypto.Hash) ([]byte, error)
+}
+
+// IssueCertificateWithTimestamp signs req with s like IssueCertificate, and
+// returns a time-stamp token over the SHA256 fingerprint of the DER encoded
+// certificate, requested from tsa. The token binds the issuance time to the
+// certificate and should be stored along with it.
+func IssueCertificateWithTimestamp(s signer.Signer, req signer.SignRequest, tsa TimestampClient) (certPEM []byte, serial *big.Int, token []byte, err error) {
+	if tsa == nil {
+		return nil, nil, nil, errors.New("Timestamp client must be different from nil")
+	}
+	certPEM, serial, err = IssueCertificate(s, req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessage(err, "Failed to parse the issued certificate")
+	}
+	digest := crypto.SHA256.New()
+	digest.Write(cert.Raw)
+	token, err = tsa.Timestamp(digest.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, nil, nil, errors.WithMessage(err, "Failed to timestamp the issued certificate")
+	}
+	if len(token) == 0 {
+		return nil, nil, nil, errors.New("The time-stamping authority returned an empty token")
+	}
+	return certPEM, serial, token, nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package util_test
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/cloudflare/cfssl/signer"
+	. "github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockTSA struct {
+	digest []byte
+	hash   crypto.Hash
+	token  []byte
+	err    error
+}
+
+func (m *mockTSA) Timestamp(digest []byte, hash crypto.Hash) ([]byte, error) {
+	m.digest = digest
+	m.hash = hash
+	return m.token, m.err
+}
+
+func TestIssueCertificateWithTimestamp(t *testing.T) {
+	s := newTestCASigner(t)
+	req := signer.SignRequest{Request: string(newTestCSR(t, "user1"))}
+
+	tsa := &mockTSA{token: []byte("token")}
+	certPEM, serial, token, err := IssueCertificateWithTimestamp(s, req, tsa)
+	FatalError(t, err, "Failed to issue certificate with timestamp")
+	assert.Equal(t, []byte("token"), token)
+	cert, err := GetX509CertificateFromPEM(certPEM)
+	FatalError(t, err, "Failed to parse issued certificate")
+	assert.Equal(t, cert.SerialNumber, serial)
+	fingerprint := sha256.Sum256(cert.Raw)
+	assert.Equal(t, fingerprint[:], tsa.digest)
+	assert.Equal(t, crypto.SHA256, tsa.hash)
+
+	_, _, _, err = IssueCertificateWithTimestamp(s, req, &mockTSA{err: errors.New("TSA unavailable")})
+	if assert.Error(t, err, "Issuance should fail if the TSA fails") {
+		assert.Contains(t, err.Error(), "TSA unavailable")
+	}
+	_, _, _, err = IssueCertificateWithTimestamp(s, req, &mockTSA{})
+	assert.Error(t, err, "Issuance should fail if the TSA returns an empty token")
+	_, _, _, err = IssueCertificateWithTimestamp(s, req, nil)
+	assert.Error(t, err, "Issuance should fail without a TSA client")
+}
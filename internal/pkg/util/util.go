@@ -53,6 +53,12 @@ var (
 	rnd = mrand.NewSource(time.Now().UnixNano())
 	// ErrNotImplemented used to return errors for functions not implemented
 	ErrNotImplemented = errors.New("NOT YET IMPLEMENTED")
+	// ErrKeystoreEmpty is returned when a private key is looked up in a keystore
+	// which does not exist or holds no private key, e.g. on first boot
+	ErrKeystoreEmpty = errors.New("The keystore is empty")
+	// ErrSubjectInUse is returned when a certificate would be issued with the
+	// subject DN of an active certificate
+	ErrSubjectInUse = errors.New("An active certificate with the same subject DN exists")
 )
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -123,7 +129,15 @@ func ReadFile(file string) ([]byte, error) {
 	return ioutil.ReadFile(file)
 }
 
-// WriteFile writes a file
+// writeData writes buf to f; tests replace it to simulate an interrupted write
+var writeData = func(f *os.File, buf []byte) error {
+	_, err := f.Write(buf)
+	return err
+}
+
+// WriteFile writes a file atomically: buf is written to a temporary file in
+// the directory of file, which then replaces file, so that an interrupted
+// write never leaves a partial file behind
 func WriteFile(file string, buf []byte, perm os.FileMode) error {
 	dir := path.Dir(file)
 	// Create the directory if it doesn't exist
@@ -133,7 +147,28 @@ func WriteFile(file string, buf []byte, perm os.FileMode) error {
 			return errors.Wrapf(err, "Failed to create directory '%s' for file '%s'", dir, file)
 		}
 	}
-	return ioutil.WriteFile(file, buf, perm)
+	tmp, err := ioutil.TempFile(dir, "."+path.Base(file)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create temporary file for file '%s'", file)
+	}
+	err = writeData(tmp, buf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrapf(err, "Failed to write file '%s'", file)
+	}
+	return nil
 }
 
 // FileExists checks to see if a file exists
@@ -340,9 +375,9 @@ func DecodeToken(token string) (*x509.Certificate, string, string, error) {
 
 //GetECPrivateKey get *ecdsa.PrivateKey from key pem
 func GetECPrivateKey(raw []byte) (*ecdsa.PrivateKey, error) {
-	decoded, _ := pem.Decode(raw)
-	if decoded == nil {
-		return nil, errors.New("Failed to decode the PEM-encoded ECDSA key")
+	decoded, _, err := decodePEM(raw)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to decode the PEM-encoded ECDSA key")
 	}
 	ECprivKey, err := x509.ParseECPrivateKey(decoded.Bytes)
 	if err == nil {
@@ -364,9 +399,9 @@ func GetECPrivateKey(raw []byte) (*ecdsa.PrivateKey, error) {
 
 //GetRSAPrivateKey get *rsa.PrivateKey from key pem
 func GetRSAPrivateKey(raw []byte) (*rsa.PrivateKey, error) {
-	decoded, _ := pem.Decode(raw)
-	if decoded == nil {
-		return nil, errors.New("Failed to decode the PEM-encoded RSA key")
+	decoded, _, err := decodePEM(raw)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to decode the PEM-encoded RSA key")
 	}
 	RSAprivKey, err := x509.ParsePKCS1PrivateKey(decoded.Bytes)
 	if err == nil {
@@ -502,20 +537,24 @@ func GetX509CertificateFromPEMFile(file string) (*x509.Certificate, error) {
 
 // GetX509CertificateFromPEM get an X509 certificate from bytes in PEM format
 func GetX509CertificateFromPEM(cert []byte) (*x509.Certificate, error) {
-	block, _ := pem.Decode(cert)
-	if block == nil {
-		return nil, errors.New("Failed to PEM decode certificate")
+	block, _, err := decodePEM(cert)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to PEM decode certificate")
 	}
 	x509Cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error parsing certificate")
 	}
+	err = CheckCertSignatureAlgorithm(x509Cert)
+	if err != nil {
+		return nil, err
+	}
 	return x509Cert, nil
 }
 
 // GetX509CertificatesFromPEM returns X509 certificates from bytes in PEM format
 func GetX509CertificatesFromPEM(pemBytes []byte) ([]*x509.Certificate, error) {
-	chain := pemBytes
+	chain, _ := normalizePEM(pemBytes)
 	var certs []*x509.Certificate
 	for len(chain) > 0 {
 		var block *pem.Block
@@ -528,6 +567,10 @@ func GetX509CertificatesFromPEM(pemBytes []byte) ([]*x509.Certificate, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "Error parsing certificate")
 		}
+		err = CheckCertSignatureAlgorithm(cert)
+		if err != nil {
+			return nil, err
+		}
 		certs = append(certs, cert)
 	}
 	return certs, nil
@@ -728,11 +771,15 @@ func CheckHostsInCert(certFile string, hosts ...string) error {
 		return errors.Wrap(err, "Failed to get certificate")
 	}
 
-	// combine DNSNames and IPAddresses from cert
-	sans := cert.DNSNames
+	// combine the DNS names, IP addresses, email addresses and URIs from cert
+	sans := append([]string{}, cert.DNSNames...)
 	for _, ip := range cert.IPAddresses {
 		sans = append(sans, ip.String())
 	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
 	for _, host := range hosts {
 		if !containsString(sans, host) {
 			return errors.Errorf("Host '%s' was not found in the certificate in file '%s'", host, certFile)
@@ -13,6 +13,7 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -415,6 +416,36 @@ func TestWriteFile(t *testing.T) {
 	assert.Error(t, err, "Should fail to create 'test' directory as the parent directory is read only")
 }
 
+func TestWriteFileInterrupted(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "writefileinterrupted")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err.Error())
+	}
+	defer os.RemoveAll(testdir)
+	file := filepath.Join(testdir, "cert.pem")
+	err = WriteFile(file, []byte("old certificate"), 0644)
+	assert.NoError(t, err)
+
+	// Simulate a crash after half of the data was written
+	defer func(f func(*os.File, []byte) error) { writeData = f }(writeData)
+	writeData = func(f *os.File, buf []byte) error {
+		f.Write(buf[:len(buf)/2])
+		return fmt.Errorf("interrupted")
+	}
+	err = WriteFile(file, []byte("new certificate"), 0644)
+	assert.Error(t, err, "An interrupted write should fail")
+	buf, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "old certificate", string(buf), "An interrupted write should not modify the file")
+	err = WriteFile(filepath.Join(testdir, "key.pem"), []byte("new key"), 0600)
+	assert.Error(t, err, "An interrupted write should fail")
+	files, err := ioutil.ReadDir(testdir)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1, "An interrupted write should not leave a partial file") {
+		assert.Equal(t, "cert.pem", files[0].Name())
+	}
+}
+
 func TestStrContained(t *testing.T) {
 	strs := []string{"one", "two", "three"}
 	str := "one"
@@ -909,3 +940,49 @@ func TestListContains(t *testing.T) {
 	found = ListContains(list, "*")
 	assert.Equal(t, found, false)
 }
+
+func TestSM3Vectors(t *testing.T) {
+	pattern := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i % 251)
+		}
+		return b
+	}
+	tests := []struct {
+		msg    []byte
+		digest string
+	}{
+		// The examples of GB/T 32905-2016
+		{[]byte("abc"), "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0"},
+		{bytes.Repeat([]byte("abcd"), 16), "debe9ff92275b8a138604889c18e5a4d6fdb70e5387e5765293dcba39c0c5732"},
+		// Lengths around the padding boundaries, checked with OpenSSL
+		{pattern(0), "1ab21d8355cfa17f8e61194831e81a8f22bec8c728fefb747ed035eb5082aa2b"},
+		{pattern(55), "a79cf9dcee3404abf7f769698201647fd9d3ff61d629d0f58bb4b5579a427db8"},
+		{pattern(56), "62f7363b15f4de76dd925c493b9d6d00d4ba0ef2a1f334c1d0f13b293aeb40d1"},
+		{pattern(63), "6165e4cbb15cde01c6226e0015a47f710f8f8e1f2c296700033bb34d9212109c"},
+		{pattern(64), "93566f236d157aae078d1ddb5cebdbba1520b5142e22a8915564345ba2ae1d63"},
+		{pattern(65), "c886e6814be748285a10b28ae62ddacd85db830cd2cf3a2bfa2f729c15f63618"},
+		{pattern(119), "8f3ea392a89a7119982d6634660db1a95f35d68267a2235e3255998a857f4fbf"},
+		{pattern(1000), "b38fc481302b502c3f2f6608d060c47c5b6bd8fd65e148b7cd3af4988245f48a"},
+		{bytes.Repeat([]byte("a"), 1000000), "c8aaf89429554029e231941a2acc0ad61ff2a5acd8fadd25847a3a732b3b02c3"},
+	}
+	for _, test := range tests {
+		h := newSM3()
+		h.Write(test.msg)
+		assert.Equal(t, test.digest, hex.EncodeToString(h.Sum(nil)), "Wrong SM3 digest of %d bytes", len(test.msg))
+
+		// Writing in chunks of odd sizes and calling Sum midway must not
+		// change the digest
+		h.Reset()
+		for i := 0; i < len(test.msg); i += 37 {
+			end := i + 37
+			if end > len(test.msg) {
+				end = len(test.msg)
+			}
+			h.Write(test.msg[i:end])
+			h.Sum(nil)
+		}
+		assert.Equal(t, test.digest, hex.EncodeToString(h.Sum(nil)), "Wrong SM3 digest of %d bytes written in chunks", len(test.msg))
+	}
+}
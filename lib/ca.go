@@ -8,6 +8,7 @@ package lib
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/rsa"
@@ -15,10 +16,13 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,7 +32,6 @@ import (
 	"github.com/cloudflare/cfssl/initca"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/cloudflare/cfssl/signer"
-	cflocalsigner "github.com/cloudflare/cfssl/signer/local"
 	"github.com/hyperledger/fabric-ca/internal/pkg/api"
 	"github.com/hyperledger/fabric-ca/internal/pkg/util"
 	"github.com/hyperledger/fabric-ca/lib/attr"
@@ -65,6 +68,11 @@ var (
 	defaultIntermediateCACertificateExpiration = parseDuration("43800h")
 	// Default issued certificate expiration is 1 year (in hours).
 	defaultIssuedCertificateExpiration = parseDuration("8760h")
+	// Default backdating of the NotBefore time of issued certificates, which
+	// tolerates clock skew between the CA and verifiers
+	defaultCertificateBackdate = parseDuration("5m")
+	// Maximum backdating of the NotBefore time of issued certificates
+	maxCertificateBackdate = parseDuration("24h")
 )
 
 // CA represents a certificate authority which signs, issues and revokes certificates
@@ -87,6 +95,14 @@ type CA struct {
 	registry user.Registry
 	// The signer used for enrollment
 	enrollSigner signer.Signer
+	// Enrollment signers which sign with a hash algorithm other than the
+	// default, keyed by hash algorithm
+	hashSigners map[crypto.Hash]signer.Signer
+	// The source of the serial numbers of issued certificates; if nil, the
+	// serial numbers are chosen by the enrollment signer
+	serialSource util.SerialSource
+	// The cache of processed CSRs used to reject resubmitted CSRs
+	csrCache CSRCache
 	// Idemix issuer
 	issuer idemix.Issuer
 	// The options to use in verifying a signature in token-based authentication
@@ -156,6 +172,14 @@ func (ca *CA) init(renew bool) (err error) {
 	if err != nil {
 		return err
 	}
+	cspOpts, err := ca.cspOptions()
+	if err != nil {
+		return err
+	}
+	ca.csp, err = util.ConfigureCSP(ca.csp, cspOpts)
+	if err != nil {
+		return err
+	}
 
 	// Initialize key materials
 	err = ca.initKeyMaterial(renew)
@@ -177,6 +201,10 @@ func (ca *CA) init(renew bool) (err error) {
 	if err != nil {
 		return err
 	}
+	// Initialize the cache of processed CSRs if replay protection is enabled
+	if ca.Config.CSRReplay.Window > 0 && ca.csrCache == nil {
+		ca.csrCache = NewMemoryCSRCache()
+	}
 	// Create the attribute manager
 	ca.attrMgr = attrmgr.New()
 	log.Debug("CA initialization successful")
@@ -223,7 +251,7 @@ func (ca *CA) initKeyMaterial(renew bool) error {
 		// If key file does not exist but certFile does, key file is probably
 		// stored by BCCSP, so check for that now.
 		if certFileExists {
-			_, _, _, err = util.GetSignerFromCertFile(certFile, ca.csp)
+			err = util.AssertCAKeyMatchesCert(certFile, ca.csp)
 			if err != nil {
 				return errors.WithMessage(err, fmt.Sprintf("Failed to find private key for certificate in '%s'", certFile))
 			}
@@ -429,6 +457,9 @@ func (ca *CA) initConfig() (err error) {
 	if cfg.CA.Chainfile == "" {
 		cfg.CA.Chainfile = "ca-chain.pem"
 	}
+	if cfg.Serial.File == "" {
+		cfg.Serial.File = "serial"
+	}
 	if cfg.CSR.CA == nil {
 		cfg.CSR.CA = &cfcsr.CAConfig{}
 	}
@@ -456,10 +487,18 @@ func (ca *CA) initConfig() (err error) {
 		defaultIssuedCertificateExpiration,
 		false)
 	cs.Profiles["tls"] = tlsProfile
+	initSigningProfileBackdate("default", cs.Default)
+	for name, sp := range cs.Profiles {
+		initSigningProfileBackdate(name, sp)
+	}
 	err = ca.checkConfigLevels()
 	if err != nil {
 		return err
 	}
+	err = ca.checkProfileConstraints()
+	if err != nil {
+		return err
+	}
 	// Set log level if debug is true
 	if ca.server != nil && ca.server.Config != nil && ca.server.Config.Debug {
 		log.Level = log.LevelDebug
@@ -607,6 +646,9 @@ func (ca *CA) initDB(metrics *db.Metrics) error {
 	if ca.enrollSigner != nil {
 		ca.enrollSigner.SetDBAccessor(ca.certDBAccessor)
 	}
+	for _, s := range ca.hashSigners {
+		s.SetDBAccessor(ca.certDBAccessor)
+	}
 
 	// Initialize user registry to either use DB or LDAP
 	err = ca.initUserRegistry()
@@ -714,6 +756,15 @@ func (ca *CA) initEnrollmentSigner() (err error) {
 		policy.Default.CAConstraint.IsCA = true
 	}
 
+	err = ca.initSerialSource(policy)
+	if err != nil {
+		return errors.WithMessage(err, "Failed initializing enrollment signer")
+	}
+	err = ca.initAIA(policy)
+	if err != nil {
+		return errors.WithMessage(err, "Failed initializing enrollment signer")
+	}
+
 	// Make sure the policy reflects the new remote
 	parentServerURL := ca.Config.Intermediate.ParentServer.URL
 	if parentServerURL != "" {
@@ -723,16 +774,177 @@ func (ca *CA) initEnrollmentSigner() (err error) {
 		}
 	}
 
-	ca.enrollSigner, err = util.BccspBackedSigner(c.CA.Certfile, c.CA.Keyfile, policy, ca.csp)
+	enrollSigner, err := util.BccspBackedSigner(c.CA.Certfile, c.CA.Keyfile, policy, ca.csp)
 	if err != nil {
 		return err
 	}
+	// Fail if the hash family of the CSP can't be used with the CA key
+	caCert, err := signerCertificate(enrollSigner)
+	if err != nil {
+		return errors.WithMessage(err, "Failed initializing enrollment signer")
+	}
+	err = util.CheckHashFamily(c.CSP, caCert.PublicKey)
+	if err != nil {
+		return errors.WithMessage(err, "Failed initializing enrollment signer")
+	}
+
+	ca.enrollSigner = enrollSigner
 	ca.enrollSigner.SetDBAccessor(ca.certDBAccessor)
+	ca.hashSigners = nil
 
 	// Successful enrollment
 	return nil
 }
 
+// initAIA sets the OCSP and issuer URLs of the AIA configuration in the
+// signing profiles of policy which do not set their own
+func (ca *CA) initAIA(policy *config.Signing) error {
+	cfg := &ca.Config.AIA
+	for _, u := range append([]string{cfg.OCSPURL}, cfg.IssuerURLs...) {
+		if u == "" {
+			continue
+		}
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return errors.Errorf("Invalid AIA URL '%s'; must be an absolute URL", u)
+		}
+	}
+	profiles := []*config.SigningProfile{policy.Default}
+	for _, profile := range policy.Profiles {
+		profiles = append(profiles, profile)
+	}
+	for _, profile := range profiles {
+		if profile == nil {
+			continue
+		}
+		if profile.OCSP == "" {
+			profile.OCSP = cfg.OCSPURL
+		}
+		if len(profile.IssuerURL) == 0 {
+			profile.IssuerURL = cfg.IssuerURLs
+		}
+	}
+	return nil
+}
+
+// cspOptions returns the options of the key operations performed with the CSP
+// of the CA, according to the CA configuration
+func (ca *CA) cspOptions() (util.CSPOptions, error) {
+	curves, err := util.ParseECDSACurves(ca.Config.CA.AllowedCurves)
+	if err != nil {
+		return util.CSPOptions{}, errors.WithMessage(err, "Invalid allowed curves of the CA configuration")
+	}
+	return util.CSPOptions{
+		DisableKeyFileFallback: ca.Config.CA.DisableKeyFileFallback,
+		AllowedECDSACurves:     curves,
+		UseCSPRNG:              ca.Config.CA.UseCSPRNG,
+	}, nil
+}
+
+// initSerialSource initializes the source of the serial numbers of the
+// certificates issued by the CA according to the serial configuration
+func (ca *CA) initSerialSource(policy *config.Signing) error {
+	cfg := &ca.Config.Serial
+	var serials util.SerialSource
+	switch strings.ToLower(cfg.Source) {
+	case "", "random":
+		if !ca.Config.CA.UseCSPRNG {
+			// The signer draws the serial numbers from crypto/rand
+			ca.serialSource = nil
+			return nil
+		}
+		serials = &util.RandomSerialSource{Rand: util.RandReader(ca.csp)}
+	case "sequential":
+		var err error
+		serials, err = util.NewSequentialSerialSource(cfg.File)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Using sequential serial numbers stored in '%s'", cfg.File)
+	default:
+		return errors.Errorf("Invalid serial number source '%s'; must be 'random' or 'sequential'", cfg.Source)
+	}
+	// The serial numbers are provided with the sign requests
+	if policy.Default != nil {
+		policy.Default.ClientProvidesSerialNumbers = true
+	}
+	for _, profile := range policy.Profiles {
+		profile.ClientProvidesSerialNumbers = true
+	}
+	ca.serialSource = serials
+	return nil
+}
+
+// SetCSRCache sets the cache of processed CSRs used to reject CSRs which are
+// resubmitted within the configured replay window. By default, an in-memory
+// cache is used.
+func (ca *CA) SetCSRCache(cache CSRCache) {
+	ca.csrCache = cache
+}
+
+// issueCertificate signs req with the enrollment signer of the CA, using the
+// configured serial number source. If hash is not zero, the certificate is
+// signed using hash instead of the default hash algorithm for the CA key.
+func (ca *CA) issueCertificate(req signer.SignRequest, hash crypto.Hash) ([]byte, *big.Int, error) {
+	s, err := ca.getEnrollSigner(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cert []byte
+	var serial *big.Int
+	if ca.serialSource == nil {
+		cert, serial, err = util.IssueCertificate(s, req)
+	} else {
+		cert, serial, err = util.IssueCertificateWithSerialSource(s, req, ca.serialSource)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, serial, nil
+}
+
+// checkProfileConstraints returns an error if the profile constraints can't
+// be enforced. The GM/T 0015 certificate profile requires certificates to be
+// signed with SM2, but the enrollment signer can't load an SM2 CA certificate.
+func (ca *CA) checkProfileConstraints() error {
+	for profile, constraints := range ca.Config.ProfileConstraints {
+		if constraints.GMT0015 {
+			return errors.Errorf("The signing profile '%s' requires the GM/T 0015 certificate profile, which requires an SM2 CA, but SM2 CAs are not supported",
+				profile)
+		}
+	}
+	return nil
+}
+
+// getEnrollSigner returns the enrollment signer which signs certificates
+// using hash, or the default enrollment signer if hash is zero
+func (ca *CA) getEnrollSigner(hash crypto.Hash) (signer.Signer, error) {
+	if hash == 0 {
+		return ca.enrollSigner, nil
+	}
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	if s, ok := ca.hashSigners[hash]; ok {
+		return s, nil
+	}
+	localSigner, ok := util.LocalSigner(ca.enrollSigner)
+	if !ok {
+		return nil, errors.New("The signature hash algorithm can only be chosen for a local enrollment signer")
+	}
+	c := ca.Config
+	s, err := util.BccspBackedSignerWithHash(c.CA.Certfile, c.CA.Keyfile, localSigner.Policy(), ca.csp, hash)
+	if err != nil {
+		return nil, caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "Cannot sign with hash algorithm %s: %s",
+			util.HashAlgorithmName(hash), err)
+	}
+	s.SetDBAccessor(ca.certDBAccessor)
+	if ca.hashSigners == nil {
+		ca.hashSigners = map[crypto.Hash]signer.Signer{}
+	}
+	ca.hashSigners[hash] = s
+	return s, nil
+}
+
 // loadUsersTable adds the configured users to the table if not already found
 func (ca *CA) loadUsersTable() error {
 	log.Debug("Loading identity table")
@@ -885,6 +1097,7 @@ func (ca *CA) makeFileNamesAbsolute() error {
 		&ca.Config.CA.Certfile,
 		&ca.Config.CA.Keyfile,
 		&ca.Config.CA.Chainfile,
+		&ca.Config.Serial.File,
 	}
 	err := util.MakeFileNamesAbsolute(fields, ca.HomeDir)
 	if err != nil {
@@ -1037,22 +1250,27 @@ func (ca *CA) validateCertAndKey(certFile string, keyFile string) error {
 // Returns expiration of the CA certificate
 func (ca *CA) getCACertExpiry() (time.Time, error) {
 	var caexpiry time.Time
-	signer, ok := ca.enrollSigner.(*cflocalsigner.Signer)
-	if ok {
-		cacert, err := signer.Certificate("", "ca")
-		if err != nil {
-			log.Errorf("Failed to get CA certificate for CA %s: %s", ca.Config.CA.Name, err)
-			return caexpiry, err
-		} else if cacert != nil {
-			caexpiry = cacert.NotAfter
-		}
-	} else {
-		log.Errorf("Not expected condition as the enrollSigner can only be cfssl/signer/local/Signer")
-		return caexpiry, errors.New("Unexpected error while getting CA certificate expiration")
+	cacert, err := signerCertificate(ca.enrollSigner)
+	if err != nil {
+		log.Errorf("Failed to get CA certificate for CA %s: %s", ca.Config.CA.Name, err)
+		return caexpiry, err
+	} else if cacert != nil {
+		caexpiry = cacert.NotAfter
 	}
 	return caexpiry, nil
 }
 
+// signerCertificate returns the CA certificate loaded by the enrollment
+// signer s, so that it does not need to be read from the CA cert file again
+func signerCertificate(s signer.Signer) (*x509.Certificate, error) {
+	localSigner, ok := util.LocalSigner(s)
+	if !ok {
+		log.Errorf("Not expected condition as the enrollSigner can only be cfssl/signer/local/Signer")
+		return nil, errors.New("Unexpected error while getting CA certificate")
+	}
+	return localSigner.Certificate("", "ca")
+}
+
 func canSignCRL(cert *x509.Certificate) bool {
 	return cert.KeyUsage&x509.KeyUsageCRLSign != 0
 }
@@ -1061,7 +1279,7 @@ func validateDates(cert *x509.Certificate) error {
 	log.Debug("Check CA certificate for valid dates")
 
 	notAfter := cert.NotAfter
-	currentTime := time.Now().UTC()
+	currentTime := util.Now().UTC()
 
 	if currentTime.After(notAfter) {
 		return errors.New("Certificate provided has expired")
@@ -1220,7 +1438,7 @@ func writeFile(file string, buf []byte, perm os.FileMode) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(file, buf, perm)
+	return util.WriteFile(file, buf, perm)
 }
 
 func affiliationPath(name, parent string) string {
@@ -1257,10 +1475,21 @@ func initSigningProfile(spp **config.SigningProfile, expiry time.Duration, isCA
 	sp.ExtensionWhitelist[attrmgr.AttrOIDString] = true
 }
 
-type wallClock struct{}
-
-func (wc wallClock) Now() time.Time {
-	return time.Now()
+// initSigningProfileBackdate sets the backdating of the NotBefore time of the
+// certificates issued with the signing profile to the default if it is not
+// set, and clamps it to the maximum
+func initSigningProfileBackdate(name string, sp *config.SigningProfile) {
+	if sp == nil {
+		return
+	}
+	switch {
+	case sp.Backdate <= 0:
+		sp.Backdate = defaultCertificateBackdate
+	case sp.Backdate > maxCertificateBackdate:
+		log.Warningf("The backdate '%s' of signing profile '%s' exceeds the maximum of '%s'; using the maximum",
+			sp.Backdate, name, maxCertificateBackdate)
+		sp.Backdate = maxCertificateBackdate
+	}
 }
 
 func getMigrator(driverName string, tx cadb.FabricCATx, curLevels, srvLevels *dbutil.Levels) (cadb.Migrator, error) {
@@ -7,20 +7,32 @@ SPDX-License-Identifier: Apache-2.0
 package lib
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/cloudflare/cfssl/config"
 	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/signer"
 	"github.com/hyperledger/fabric-ca/internal/pkg/api"
 	"github.com/hyperledger/fabric-ca/internal/pkg/util"
 	"github.com/hyperledger/fabric-ca/lib/mocks"
 	"github.com/hyperledger/fabric-ca/lib/server/db/sqlite"
 	dbutil "github.com/hyperledger/fabric-ca/lib/server/db/util"
+	"github.com/hyperledger/fabric/bccsp"
 	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
 	"github.com/stretchr/testify/assert"
 )
@@ -461,6 +473,198 @@ func TestCAinitEnrollmentSigner(t *testing.T) {
 	CAclean(ca, t)
 }
 
+func TestCAHashFamily(t *testing.T) {
+	testDirClean(t)
+	cfg = CAConfig{}
+	ca, err := newCA(configFile, &cfg, &srv, true)
+	util.FatalError(t, err, "newCA FAILED")
+	defer CAclean(ca, t)
+
+	// The default SHA2 hash family matches the ECDSA CA key
+	assert.NoError(t, ca.initEnrollmentSigner())
+
+	cfg.CSP.SwOpts.HashFamily = "GMSM3"
+	err = ca.initEnrollmentSigner()
+	if assert.Error(t, err, "A hash family not matching the CA key should fail") {
+		assert.Contains(t, err.Error(), "hash family 'GMSM3' is not compatible with the ECDSA key")
+	}
+}
+
+func TestCASequentialSerial(t *testing.T) {
+	testDirClean(t)
+	serialDir, err := ioutil.TempDir("", "serial")
+	util.FatalError(t, err, "Failed to create temp directory")
+	defer os.RemoveAll(serialDir)
+
+	cfg = CAConfig{}
+	cfg.Serial.Source = "sequential"
+	cfg.Serial.File = filepath.Join(serialDir, "serial")
+	ca, err := newCA(configFile, &cfg, &srv, true)
+	util.FatalError(t, err, "newCA FAILED")
+	defer CAclean(ca, t)
+	assert.NotNil(t, ca.serialSource)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	for i := int64(1); i <= 2; i++ {
+		csrReq := createTestCSR(t, key)
+		csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrReq.Raw})
+		_, serial, err := ca.issueCertificate(signer.SignRequest{Request: string(csrPEM)}, 0)
+		if assert.NoError(t, err) {
+			assert.Equal(t, big.NewInt(i), serial)
+		}
+	}
+
+	cfg.Serial.Source = "bogus"
+	err = ca.initEnrollmentSigner()
+	assert.Error(t, err, "Invalid serial number source should fail")
+}
+
+// trngCSP is a CSP exposing a random number generator
+type trngCSP struct {
+	bccsp.BCCSP
+	rng io.Reader
+}
+
+func (c *trngCSP) RNG() io.Reader {
+	return c.rng
+}
+
+func TestCACSPRNGSerial(t *testing.T) {
+	testDirClean(t)
+	cfg = CAConfig{}
+	cfg.CA.UseCSPRNG = true
+	ca, err := newCA(configFile, &cfg, &srv, true)
+	util.FatalError(t, err, "newCA FAILED")
+	defer CAclean(ca, t)
+	assert.IsType(t, &util.RandomSerialSource{}, ca.serialSource)
+
+	// The serial numbers are drawn from the RNG of the CSP
+	ca.csp, err = util.ConfigureCSP(&trngCSP{BCCSP: ca.csp, rng: bytes.NewReader(bytes.Repeat([]byte{0x5A}, 20))}, util.CSPOptions{UseCSPRNG: true})
+	util.FatalError(t, err, "Failed to configure CSP")
+	util.FatalError(t, ca.initSerialSource(ca.Config.Signing), "Failed to initialize serial number source")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: createTestCSR(t, key).Raw})
+	_, serial, err := ca.issueCertificate(signer.SignRequest{Request: string(csrPEM)}, 0)
+	if assert.NoError(t, err) {
+		// The first octet is made positive and nonzero
+		assert.Equal(t, new(big.Int).SetBytes(append([]byte{0x5B}, bytes.Repeat([]byte{0x5A}, 19)...)), serial)
+	}
+}
+
+func TestCAGMT0015Profile(t *testing.T) {
+	testDirClean(t)
+	cfg = CAConfig{}
+	cfg.ProfileConstraints = map[string]ProfileConstraints{"default": {GMT0015: true}}
+	_, err := newCA(configFile, &cfg, &srv, true)
+	if assert.Error(t, err, "A CA should fail to start if GM/T 0015 is required") {
+		assert.Contains(t, err.Error(), "SM2 CAs are not supported")
+	}
+
+	testDirClean(t)
+	cfg = CAConfig{}
+	cfg.ProfileConstraints = map[string]ProfileConstraints{"tls": {KeyAlgos: []string{"ecdsa"}}}
+	ca, err := newCA(configFile, &cfg, &srv, true)
+	util.FatalError(t, err, "A CA should start if GM/T 0015 is not required")
+	CAclean(ca, t)
+}
+
+func TestCAAIA(t *testing.T) {
+	testDirClean(t)
+	cfg = CAConfig{}
+	cfg.AIA.OCSPURL = "http://ocsp.example.com"
+	cfg.AIA.IssuerURLs = []string{"http://ca.example.com/ca-cert.pem"}
+	ca, err := newCA(configFile, &cfg, &srv, true)
+	util.FatalError(t, err, "newCA FAILED")
+	defer CAclean(ca, t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	csrReq := createTestCSR(t, key)
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrReq.Raw})
+	certPEM, _, err := ca.issueCertificate(signer.SignRequest{Request: string(csrPEM)}, 0)
+	util.FatalError(t, err, "Failed to issue certificate")
+	cert, err := util.GetX509CertificateFromPEM(certPEM)
+	util.FatalError(t, err, "Failed to parse certificate")
+	assert.Equal(t, []string{"http://ocsp.example.com"}, cert.OCSPServer)
+	assert.Equal(t, []string{"http://ca.example.com/ca-cert.pem"}, cert.IssuingCertificateURL)
+
+	cfg.AIA.OCSPURL = "ocsp.example.com"
+	err = ca.initEnrollmentSigner()
+	assert.Error(t, err, "A relative OCSP URL should fail")
+}
+
+func TestCAIssueCertificateWithHash(t *testing.T) {
+	testDirClean(t)
+	cfg = CAConfig{}
+	ca, err := newCA(configFile, &cfg, &srv, true)
+	util.FatalError(t, err, "newCA FAILED")
+	defer CAclean(ca, t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	csrReq := createTestCSR(t, key)
+	req := signer.SignRequest{
+		Request: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrReq.Raw})),
+	}
+	for hash, sigAlgo := range map[crypto.Hash]x509.SignatureAlgorithm{
+		0:             x509.ECDSAWithSHA256,
+		crypto.SHA384: x509.ECDSAWithSHA384,
+		crypto.SHA512: x509.ECDSAWithSHA512,
+	} {
+		certPEM, _, err := ca.issueCertificate(req, hash)
+		if assert.NoError(t, err) {
+			cert, err := util.GetX509CertificateFromPEM(certPEM)
+			util.FatalError(t, err, "Failed to parse certificate")
+			assert.Equal(t, sigAlgo, cert.SignatureAlgorithm)
+		}
+	}
+	assert.Len(t, ca.hashSigners, 2)
+
+	_, _, err = ca.issueCertificate(req, crypto.SHA1)
+	assert.Error(t, err, "Issuing a certificate with SHA1 should fail")
+}
+
+func TestCABackdate(t *testing.T) {
+	testDirClean(t)
+	cfg = CAConfig{}
+	cfg.Signing = &config.Signing{
+		Default: &config.SigningProfile{Backdate: 10 * time.Minute},
+		Profiles: map[string]*config.SigningProfile{
+			"long": {Usage: []string{"digital signature"}, Backdate: 48 * time.Hour},
+		},
+	}
+	ca, err := newCA(configFile, &cfg, &srv, true)
+	util.FatalError(t, err, "newCA FAILED")
+	defer CAclean(ca, t)
+	assert.Equal(t, 10*time.Minute, cfg.Signing.Default.Backdate)
+	assert.Equal(t, defaultCertificateBackdate, cfg.Signing.Profiles["tls"].Backdate)
+	assert.Equal(t, maxCertificateBackdate, cfg.Signing.Profiles["long"].Backdate)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	csrReq := createTestCSR(t, key)
+	req := signer.SignRequest{
+		Request: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrReq.Raw})),
+	}
+	for profile, backdate := range map[string]time.Duration{
+		"":     10 * time.Minute,
+		"tls":  defaultCertificateBackdate,
+		"long": maxCertificateBackdate,
+	} {
+		req.Profile = profile
+		now := time.Now()
+		certPEM, _, err := ca.issueCertificate(req, 0)
+		if assert.NoError(t, err) {
+			cert, err := util.GetX509CertificateFromPEM(certPEM)
+			util.FatalError(t, err, "Failed to parse certificate")
+			// The issuance time is rounded to the minute
+			assert.WithinDuration(t, now.Add(-backdate), cert.NotBefore, time.Minute)
+		}
+	}
+}
+
 func TestCADBinit(t *testing.T) {
 	orgwd, err := os.Getwd()
 	if err != nil {
@@ -751,3 +955,15 @@ func testDirClean(t *testing.T) {
 	}
 	os.Remove(configFile)
 }
+
+func TestCACSPOptions(t *testing.T) {
+	ca := &CA{Config: &CAConfig{CA: CAInfo{DisableKeyFileFallback: true, AllowedCurves: []string{"P-384"}}}}
+	opts, err := ca.cspOptions()
+	assert.NoError(t, err)
+	assert.True(t, opts.DisableKeyFileFallback)
+	assert.Equal(t, []elliptic.Curve{elliptic.P384()}, opts.AllowedECDSACurves)
+
+	ca.Config.CA.AllowedCurves = []string{"secp256k1"}
+	_, err = ca.cspOptions()
+	assert.Error(t, err, "An unsupported curve should be rejected")
+}
@@ -95,6 +95,12 @@ type CAConfig struct {
 	Intermediate IntermediateCA
 	CRL          CRLConfig
 	Idemix       idemix.Config
+	Serial       SerialConfig
+	CSRReplay    CSRReplayConfig
+	AIA          AIAConfig
+	// Constraints enforced when issuing certificates with a signing profile,
+	// keyed by profile name; the default profile is named "default"
+	ProfileConstraints map[string]ProfileConstraints
 }
 
 // CfgOptions is a CA configuration that allows for setting different options
@@ -120,6 +126,15 @@ type CAInfo struct {
 	Keyfile   string `help:"PEM-encoded CA key file"`
 	Certfile  string `def:"ca-cert.pem" help:"PEM-encoded CA certificate file"`
 	Chainfile string `def:"ca-chain.pem" help:"PEM-encoded CA chain file"`
+	// Do not import the CA key from the key file if it is not found in the
+	// BCCSP keystore, e.g. when the key must be held by an HSM
+	DisableKeyFileFallback bool `help:"Do not import the CA key from the key file if it is not found in the BCCSP keystore"`
+	// The curves of the ECDSA private keys which can be imported; P-256,
+	// P-384 and P-521 if empty
+	AllowedCurves []string `help:"Curves of the ECDSA private keys which can be imported; one or more of: P-256, P-384, P-521 (default: all)"`
+	// Draw serial numbers and software keys from the random number generator
+	// of the BCCSP provider if it exposes one; see util.RNGProvider
+	UseCSPRNG bool `help:"Draw serial numbers and software keys from the random number generator of the BCCSP provider if it exposes one"`
 }
 
 // CAConfigDB is the database part of the server's config
@@ -177,6 +192,45 @@ type CRLConfig struct {
 	Expiry time.Duration `def:"24h" help:"Expiration for the CRL generated by the gencrl request"`
 }
 
+// SerialConfig contains configuration options used to choose the serial
+// numbers of the certificates issued by the CA
+type SerialConfig struct {
+	// The source of the serial numbers: "random" (the default) or "sequential"
+	Source string `def:"random" help:"Source of the serial numbers of issued certificates; one of: random, sequential"`
+	// The file in which the last sequential serial number is stored
+	File string `def:"serial" help:"File in which the last issued serial number is stored when the serial number source is sequential"`
+}
+
+// CSRReplayConfig contains configuration options used to reject CSRs which
+// have already been processed by the CA
+type CSRReplayConfig struct {
+	// CSRs resubmitted within this duration after they were processed are
+	// rejected; replay protection is disabled if zero
+	Window time.Duration `def:"0s" help:"Duration within which a CSR which was already processed is rejected; disabled if zero"`
+}
+
+// AIAConfig contains the URLs embedded in the Authority Information Access
+// extension of the certificates issued by the CA. They apply to all signing
+// profiles which do not set their own OCSP or issuer URLs.
+type AIAConfig struct {
+	// The URL of the OCSP responder of the CA
+	OCSPURL string `help:"URL of the OCSP responder embedded in the AIA extension of issued certificates"`
+	// The URLs from which the CA certificate can be retrieved
+	IssuerURLs []string `help:"A list of comma-separated URLs of the CA certificate embedded in the AIA extension of issued certificates"`
+}
+
+// ProfileConstraints contains constraints enforced when issuing certificates
+// with a signing profile which are not supported by the cfssl signing profile
+type ProfileConstraints struct {
+	// The key algorithms (e.g. "ecdsa" or "rsa") allowed in CSRs signed with
+	// the profile. All key algorithms are allowed if empty.
+	KeyAlgos []string
+	// If true, the certificates issued with the profile must conform to the
+	// GM/T 0015 certificate profile, which requires an SM2 CA. SM2 CAs are not
+	// supported, so the CA fails to start if it is set.
+	GMT0015 bool
+}
+
 func (cc CAConfigIdentity) String() string {
 	return util.StructToString(&cc)
 }
@@ -19,7 +19,9 @@ import (
 	"time"
 
 	"github.com/cloudflare/cfssl/certdb"
+	"github.com/cloudflare/cfssl/config"
 	"github.com/cloudflare/cfssl/log"
+	"github.com/cloudflare/cfssl/signer"
 	"github.com/hyperledger/fabric-ca/internal/pkg/util"
 	"github.com/hyperledger/fabric-ca/lib/mocks"
 	"github.com/hyperledger/fabric-ca/lib/server/certificaterequest"
@@ -152,6 +154,39 @@ func TestGetCertificatesDB(t *testing.T) {
 	assert.Equal(t, "expire1", certs[0].Subject.CommonName)
 }
 
+func TestIssueCertificateWithSerialCertDB(t *testing.T) {
+	os.RemoveAll("issueSerialTest")
+	defer os.RemoveAll("issueSerialTest")
+
+	srv := &Server{
+		levels: &dbutil.Levels{Affiliation: 1, Identity: 1, Certificate: 1},
+	}
+	ca, err := newCA("issueSerialTest/config.yaml", &CAConfig{}, srv, false)
+	util.FatalError(t, err, "Failed to get CA")
+
+	policy := &config.Signing{Default: config.DefaultConfig()}
+	policy.Default.ClientProvidesSerialNumbers = true
+	s, err := util.BccspBackedSigner(ca.Config.CA.Certfile, ca.Config.CA.Keyfile, policy, ca.csp)
+	util.FatalError(t, err, "Failed to create CA signer")
+	s.SetDBAccessor(ca.certDBAccessor)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "migrated"}}, key)
+	util.FatalError(t, err, "Failed to create CSR")
+	req := signer.SignRequest{Request: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))}
+
+	serial := big.NewInt(0x1234)
+	_, _, err = util.IssueCertificateWithSerial(s, req, serial, nil)
+	util.FatalError(t, err, "Failed to issue certificate with a fixed serial number")
+	_, _, err = util.IssueCertificateWithSerial(s, req, serial, nil)
+	if assert.Error(t, err, "A serial number in the certificate database should be rejected") {
+		assert.Contains(t, err.Error(), "already issued")
+	}
+	_, _, err = util.IssueCertificateWithSerial(s, req, serial, util.NewRandomSerialSource())
+	assert.Error(t, err, "A serial number in the certificate database should be rejected with any source")
+}
+
 func readRows(rows *sqlx.Rows) ([]*x509.Certificate, error) {
 	var certs []*x509.Certificate
 
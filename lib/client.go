@@ -35,7 +35,6 @@ import (
 	"github.com/hyperledger/fabric-ca/lib/streamer"
 	"github.com/hyperledger/fabric-ca/lib/tls"
 	"github.com/hyperledger/fabric/bccsp"
-	cspsigner "github.com/hyperledger/fabric/bccsp/signer"
 	"github.com/hyperledger/fabric/idemix"
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
@@ -259,7 +258,7 @@ func (c *Client) generateCSPSigner(cr *csr.CertificateRequest, key bccsp.Key) (c
 
 	// use existing key
 	log.Debugf("generating signer with existing key: %s", hex.EncodeToString(key.SKI()))
-	cspSigner, err := cspsigner.New(c.csp, key)
+	cspSigner, err := util.NewCryptoSigner(c.csp, key)
 	if err != nil {
 		return nil, nil, errors.WithMessage(err, "Failed initializing CryptoSigner")
 	}
@@ -319,6 +318,7 @@ func (c *Client) handleX509Enroll(req *api.EnrollmentRequest) (*EnrollmentRespon
 	reqNet := &api.EnrollmentRequestNet{
 		CAName:   req.CAName,
 		AttrReqs: req.AttrReqs,
+		SigHash:  req.SigHash,
 	}
 
 	if req.CSR != nil {
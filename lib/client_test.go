@@ -793,6 +793,22 @@ func testReenroll(c *Client, t *testing.T) {
 	if err != nil {
 		t.Errorf("testReenroll: failed Store: %s", err)
 	}
+
+	// changing the key algorithm is rejected unless explicitly allowed
+	p384CSR := &api.CSRInfo{
+		KeyRequest: &api.KeyRequest{
+			Algo: "ecdsa",
+			Size: 384,
+		},
+	}
+	_, err = id.Reenroll(&api.ReenrollmentRequest{CSR: p384CSR})
+	if err == nil {
+		t.Error("testReenroll: reenroll with another key algorithm should have failed")
+	}
+	_, err = id.Reenroll(&api.ReenrollmentRequest{CSR: p384CSR, AllowAlgorithmChange: true})
+	if err != nil {
+		t.Errorf("testReenroll: failed reenroll with an allowed key algorithm change: %s", err)
+	}
 }
 
 func testRevocation(c *Client, t *testing.T, user string, withPriv, ecertOnly bool) {
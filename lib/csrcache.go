@@ -0,0 +1,68 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric-ca/internal/pkg/util"
+)
+
+// CSRCache records the CSRs for which a CA issues certificates so that a CSR
+// which is resubmitted can be rejected
+type CSRCache interface {
+	// Reserve records the CSR whose hash is csrHash and returns true, unless
+	// it was already recorded within window, in which case it returns false.
+	// The check and the record are atomic, so that only one of concurrent
+	// submissions of a CSR can be processed.
+	Reserve(csrHash string, window time.Duration) bool
+	// Release forgets the CSR whose hash is csrHash, which was reserved but
+	// for which no certificate was issued
+	Release(csrHash string)
+}
+
+// memCSRCache is the default in-memory CSRCache. Expired CSRs are forgotten
+// when they are looked up, and by a sweep of the whole cache at most once per
+// window.
+type memCSRCache struct {
+	mutex     sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryCSRCache returns a CSRCache which keeps the CSRs in memory
+func NewMemoryCSRCache() CSRCache {
+	return &memCSRCache{seen: map[string]time.Time{}, lastSweep: util.Now()}
+}
+
+func (c *memCSRCache) Reserve(csrHash string, window time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := util.Now()
+	if added, ok := c.seen[csrHash]; ok && now.Sub(added) < window {
+		return false
+	}
+	c.seen[csrHash] = now
+	if now.Sub(c.lastSweep) < window {
+		return true
+	}
+	// Forget the CSRs which were added outside of the window
+	for hash, added := range c.seen {
+		if now.Sub(added) >= window {
+			delete(c.seen, hash)
+		}
+	}
+	c.lastSweep = now
+	return true
+}
+
+func (c *memCSRCache) Release(csrHash string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.seen, csrHash)
+}
@@ -157,9 +157,17 @@ func (i *Identity) Reenroll(req *api.ReenrollmentRequest) (*EnrollmentResponse,
 		}
 	}
 
+	if !req.AllowAlgorithmChange {
+		err = i.checkKeyAlgorithmChange(csrPEM)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	reqNet := &api.ReenrollmentRequestNet{
 		CAName:   req.CAName,
 		AttrReqs: req.AttrReqs,
+		SigHash:  req.SigHash,
 	}
 
 	// Get the body of the request
@@ -182,6 +190,24 @@ func (i *Identity) Reenroll(req *api.ReenrollmentRequest) (*EnrollmentResponse,
 	return i.client.newEnrollmentResponse(&result, i.GetName(), key)
 }
 
+// checkKeyAlgorithmChange returns an error if the algorithm of the key of the
+// CSR in csrPEM differs from that of the enrollment certificate
+func (i *Identity) checkKeyAlgorithmChange(csrPEM []byte) error {
+	ecert := i.GetECert()
+	if ecert == nil {
+		return nil
+	}
+	csr, err := util.ParseCSRPEM(csrPEM)
+	if err != nil {
+		return err
+	}
+	err = util.CheckKeyAlgorithmChange(ecert.GetX509Cert().PublicKey, csr.PublicKey)
+	if err != nil {
+		return errors.WithMessage(err, "Reenrollment would change the key algorithm of the identity; set AllowAlgorithmChange to allow it")
+	}
+	return nil
+}
+
 // Revoke the identity associated with 'id'
 func (i *Identity) Revoke(req *api.RevocationRequest) (*api.RevocationResponse, error) {
 	log.Debugf("Entering identity.Revoke %+v", req)
@@ -41,6 +41,7 @@ import (
 	"github.com/hyperledger/fabric-ca/lib/server/operations"
 	stls "github.com/hyperledger/fabric-ca/lib/tls"
 	"github.com/hyperledger/fabric-lib-go/healthz"
+	"github.com/hyperledger/fabric/bccsp"
 	"github.com/hyperledger/fabric/common/metrics"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
@@ -85,6 +86,8 @@ type Server struct {
 	mux *gmux.Router
 	// listener for this server
 	listener net.Listener
+	// TLS certificate of the listener, if TLS is enabled
+	tlsCert *stls.ReloadableCertificate
 	// An error which occurs when serving
 	serveError error
 	// caMap is a list of CAs by name
@@ -196,6 +199,11 @@ func (s *Server) Start() (err error) {
 		return nil
 	}
 
+	err = s.Operations.RegisterChecker("bccsp", &cspHealthChecker{csp: s.csp})
+	if err != nil {
+		return err
+	}
+
 	for _, ca := range s.caMap {
 		startNonceSweeper(ca)
 	}
@@ -649,10 +657,13 @@ func (s *Server) listenAndServe() (err error) {
 			}
 		}
 
-		cer, err := util.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile, s.csp)
+		cer, err := stls.NewReloadableCertificate(c.TLS.CertFile, c.TLS.KeyFile, s.csp)
 		if err != nil {
 			return err
 		}
+		s.mutex.Lock()
+		s.tlsCert = cer
+		s.mutex.Unlock()
 
 		if c.TLS.ClientAuth.Type == "" {
 			c.TLS.ClientAuth.Type = defaultClientAuth
@@ -674,12 +685,12 @@ func (s *Server) listenAndServe() (err error) {
 		}
 
 		config := &tls.Config{
-			Certificates: []tls.Certificate{*cer},
-			ClientAuth:   clientAuth,
-			ClientCAs:    certPool,
-			MinVersion:   tls.VersionTLS12,
-			MaxVersion:   tls.VersionTLS13,
-			CipherSuites: stls.DefaultCipherSuites,
+			GetCertificate: cer.GetCertificate,
+			ClientAuth:     clientAuth,
+			ClientCAs:      certPool,
+			MinVersion:     tls.VersionTLS12,
+			MaxVersion:     tls.VersionTLS13,
+			CipherSuites:   stls.DefaultCipherSuites,
 		}
 
 		listener, err = tls.Listen("tcp", addr, config)
@@ -739,11 +750,35 @@ func (s *Server) serve() error {
 	return s.serveError
 }
 
+// ReloadTLSCertificate reloads the TLS certificate and key files of the
+// server configuration, so that a renewed certificate is used by subsequent
+// TLS handshakes without restarting the server. fabric-ca-server start calls
+// it when the process receives SIGHUP.
+func (s *Server) ReloadTLSCertificate() error {
+	s.mutex.Lock()
+	cer := s.tlsCert
+	s.mutex.Unlock()
+	if cer == nil {
+		return errors.New("TLS is not enabled on the server listener")
+	}
+	return cer.Reload(s.Config.TLS.CertFile, s.Config.TLS.KeyFile)
+}
+
 // HealthCheck pings the database to determine if it is reachable
 func (s *Server) HealthCheck(ctx context.Context) error {
 	return s.db.PingContext(ctx)
 }
 
+// cspHealthChecker checks that the server's BCCSP is able to generate keys and sign
+type cspHealthChecker struct {
+	csp bccsp.BCCSP
+}
+
+// HealthCheck generates an ephemeral key and signs with it
+func (c *cspHealthChecker) HealthCheck(ctx context.Context) error {
+	return util.CheckCSPHealth(c.csp)
+}
+
 // checkAndEnableProfiling checks for FABRIC_CA_SERVER_PROFILE_PORT env variable
 // if it is set, starts listening for profiling requests at the port specified
 // by the environment variable
@@ -887,13 +922,13 @@ func (s *Server) autoGenerateTLSCertificateKey() error {
 	}
 
 	// Use default CA to get back signed TLS certificate
-	cert, err := s.CA.enrollSigner.Sign(req)
+	cert, _, err := s.CA.issueCertificate(req, 0)
 	if err != nil {
 		return fmt.Errorf("Failed to generate TLS certificate: %s", err)
 	}
 
 	// Write the TLS certificate to the file system
-	err = ioutil.WriteFile(s.Config.TLS.CertFile, cert, 0644)
+	err = util.WriteFile(s.Config.TLS.CertFile, cert, 0644)
 	if err != nil {
 		return fmt.Errorf("Failed to write TLS certificate: %s", err)
 	}
@@ -32,8 +32,10 @@ type Config struct {
 	RevocationPublicKeyfile  string `def:"IssuerRevocationPublicKey" skip:"true" help:"Name of the file that contains Idemix issuer revocation public key"`
 	RevocationPrivateKeyfile string `def:"IssuerRevocationPrivateKey" skip:"true" help:"Name of the file that contains Idemix issuer revocation private key"`
 	RHPoolSize               int    `def:"100" help:"Specifies revocation handle pool size"`
+	RHAllocation             string `def:"sequential" help:"Revocation handle allocation strategy; one of: sequential, random"`
 	NonceExpiration          string `def:"15s" help:"Duration after which a nonce expires"`
 	NonceSweepInterval       string `def:"15m" help:"Interval at which expired nonces are deleted"`
+	CRIValidity              string `help:"Duration for which a CRI is valid; a new CRI is created when it expires. By default, a CRI is valid until the epoch changes"`
 }
 
 // InitConfig initializes Idemix configuration
@@ -45,6 +47,9 @@ func (c *Config) init(homeDir string) error {
 	if c.RHPoolSize == 0 {
 		c.RHPoolSize = DefaultRevocationHandlePoolSize
 	}
+	if c.RHAllocation == "" {
+		c.RHAllocation = SequentialHandleAllocation
+	}
 	if c.NonceExpiration == "" {
 		c.NonceExpiration = DefaultNonceExpiration
 	}
@@ -38,6 +38,11 @@ WHERE (revocation_handle = ?);`
 SELECT %s FROM credentials
 WHERE (status = 'revoked');`
 
+	// SelectUnrevokedCredentialSQL is the SQL for getting credentials which are not revoked
+	SelectUnrevokedCredentialSQL = `
+SELECT %s FROM credentials
+WHERE (status != 'revoked');`
+
 	// UpdateRevokeCredentialSQL is the SQL for updating status of a credential to revoked
 	UpdateRevokeCredentialSQL = `
 UPDATE credentials
@@ -79,6 +84,8 @@ type CredDBAccessor interface {
 	GetCredentialsByID(id string) ([]CredRecord, error)
 	// GetRevokedCredentials returns revoked credentials
 	GetRevokedCredentials() ([]CredRecord, error)
+	// GetUnrevokedCredentials returns credentials which are not revoked
+	GetUnrevokedCredentials() ([]CredRecord, error)
 }
 
 // CredentialAccessor implements IdemixCredDBAccessor interface
@@ -173,6 +180,20 @@ func (ac *CredentialAccessor) GetRevokedCredentials() ([]CredRecord, error) {
 	return crs, nil
 }
 
+// GetUnrevokedCredentials returns credentials which are not revoked
+func (ac *CredentialAccessor) GetUnrevokedCredentials() ([]CredRecord, error) {
+	err := ac.checkDB()
+	if err != nil {
+		return nil, err
+	}
+	crs := []CredRecord{}
+	err = ac.db.Select("GetUnrevokedCredentials", &crs, fmt.Sprintf(ac.db.Rebind(SelectUnrevokedCredentialSQL), sqlstruct.Columns(CredRecord{})))
+	if err != nil {
+		return crs, errors.Wrap(err, "Failed to get unrevoked credentials from datastore")
+	}
+	return crs, nil
+}
+
 func (ac *CredentialAccessor) checkDB() error {
 	if ac.db == nil || reflect.ValueOf(ac.db).IsNil() {
 		return errors.New("Database is not set")
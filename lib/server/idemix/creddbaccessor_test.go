@@ -171,6 +171,24 @@ func TestGetRevokedCredentials(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestGetUnrevokedCredentials(t *testing.T) {
+	db := new(dmocks.FabricCADB)
+	db.On("Rebind", SelectUnrevokedCredentialSQL).Return(SelectUnrevokedCredentialSQL)
+	q := fmt.Sprintf(SelectUnrevokedCredentialSQL, sqlstruct.Columns(CredRecord{}))
+	cr := []CredRecord{}
+	db.On("Select", "GetUnrevokedCredentials", &cr, q).Return(nil)
+	accessor := NewCredentialAccessor(db, 1)
+	_, err := accessor.GetUnrevokedCredentials()
+	assert.NoError(t, err)
+
+	db = new(dmocks.FabricCADB)
+	db.On("Rebind", SelectUnrevokedCredentialSQL).Return(SelectUnrevokedCredentialSQL)
+	db.On("Select", "GetUnrevokedCredentials", &cr, q).Return(errors.New("Failed to get unrevoked credentials"))
+	accessor = NewCredentialAccessor(db, 1)
+	_, err = accessor.GetUnrevokedCredentials()
+	assert.Error(t, err)
+}
+
 func getCredSelectFunc(t *testing.T, isError bool) func(string, interface{}, string, ...interface{}) error {
 	return func(funcName string, dest interface{}, query string, args ...interface{}) error {
 		crs := dest.(*[]CredRecord)
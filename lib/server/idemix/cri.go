@@ -7,9 +7,14 @@ SPDX-License-Identifier: Apache-2.0
 package idemix
 
 import (
+	"crypto/ecdsa"
+	"fmt"
+	"time"
+
 	proto "github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric-ca/internal/pkg/api"
 	"github.com/hyperledger/fabric-ca/internal/pkg/util"
+	"github.com/hyperledger/fabric/idemix"
 	"github.com/pkg/errors"
 )
 
@@ -26,7 +31,7 @@ func (ch *CRIRequestHandler) HandleRequest() (*api.GetCRIResponse, error) {
 		return nil, err
 	}
 
-	cri, err := ch.Issuer.RevocationAuthority().CreateCRI()
+	cri, expiry, err := ch.Issuer.RevocationAuthority().GetCachedCRI()
 	if err != nil {
 		return nil, err
 	}
@@ -38,5 +43,26 @@ func (ch *CRIRequestHandler) HandleRequest() (*api.GetCRIResponse, error) {
 	res := api.GetCRIResponse{
 		CRI: b64CriBytes,
 	}
+	if !expiry.IsZero() {
+		res.Expiry = expiry.Format(time.RFC3339)
+	}
 	return &res, nil
 }
+
+// VerifyCRI verifies that cri was signed by the revocation authority whose
+// public key is raPub, as returned by RevocationAuthority.PublicKey. The
+// signature covers the epoch, the epoch public key and the revocation
+// algorithm of the CRI.
+func VerifyCRI(cri *idemix.CredentialRevocationInformation, raPub *ecdsa.PublicKey) error {
+	if cri == nil {
+		return errors.New("CRI must be different from nil")
+	}
+	if raPub == nil {
+		return errors.New("Revocation authority public key must be different from nil")
+	}
+	err := idemix.VerifyEpochPK(raPub, cri.EpochPk, cri.EpochPkSig, int(cri.Epoch), idemix.RevocationAlgorithm(cri.RevocationAlg))
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("The CRI of epoch %d was not signed by the revocation authority", cri.Epoch))
+	}
+	return nil
+}
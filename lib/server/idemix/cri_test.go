@@ -9,7 +9,10 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	fp256bn "github.com/hyperledger/fabric-amcl/amcl/FP256BN"
 	. "github.com/hyperledger/fabric-ca/lib/server/idemix"
@@ -32,7 +35,7 @@ func TestCreateCRIError(t *testing.T) {
 	ctx.On("TokenAuthentication").Return("", nil)
 	issuer := new(mocks.MyIssuer)
 	ra := new(mocks.RevocationAuthority)
-	ra.On("CreateCRI").Return(nil, errors.New("Failed to create CRI"))
+	ra.On("GetCachedCRI").Return(nil, time.Time{}, errors.New("Failed to create CRI"))
 	issuer.On("RevocationAuthority").Return(ra)
 	handler := CRIRequestHandler{Ctx: ctx, Issuer: issuer}
 	_, err := handler.HandleRequest()
@@ -44,7 +47,7 @@ func TestGetCRIMarshalError(t *testing.T) {
 	ctx.On("TokenAuthentication").Return("", nil)
 	issuer := new(mocks.MyIssuer)
 	ra := new(mocks.RevocationAuthority)
-	ra.On("CreateCRI").Return(nil, nil)
+	ra.On("GetCachedCRI").Return(nil, time.Time{}, nil)
 	issuer.On("RevocationAuthority").Return(ra)
 	handler := CRIRequestHandler{Ctx: ctx, Issuer: issuer}
 	_, err := handler.HandleRequest()
@@ -68,9 +71,43 @@ func TestGetCRI(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create CRI: %s", err.Error())
 	}
-	ra.On("CreateCRI").Return(cri, nil)
+	expiry := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ra.On("GetCachedCRI").Return(cri, expiry, nil)
 	issuer.On("RevocationAuthority").Return(ra)
 	handler := CRIRequestHandler{Ctx: ctx, Issuer: issuer}
-	_, err = handler.HandleRequest()
+	resp, err := handler.HandleRequest()
 	assert.NoError(t, err)
+	assert.Equal(t, "2020-01-01T00:00:00Z", resp.Expiry)
+}
+
+func TestVerifyCRI(t *testing.T) {
+	homeDir, err := ioutil.TempDir(".", "verifycritest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err.Error())
+	}
+	defer os.RemoveAll(homeDir)
+	db := new(mocks.FabricCADB)
+	ra := getRevocationAuthority(t, "GetRAInfo", homeDir, db, nil, 0, false, false, getSelectFuncForCreateCRI(t, true, false))
+	cri, err := ra.CreateCRI()
+	if err != nil {
+		t.Fatalf("Failed to create CRI: %s", err.Error())
+	}
+	assert.NoError(t, VerifyCRI(cri, ra.PublicKey()), "A CRI created by the revocation authority should be valid")
+
+	otherKey, err := idemix.GenerateLongTermRevocationKey()
+	if err != nil {
+		t.Fatalf("Failed to generate revocation key: %s", err.Error())
+	}
+	assert.Error(t, VerifyCRI(cri, &otherKey.PublicKey), "A CRI should not be valid for another revocation authority")
+
+	tampered := *cri
+	tampered.Epoch = cri.Epoch + 1
+	assert.Error(t, VerifyCRI(&tampered, ra.PublicKey()), "A CRI with a tampered epoch should not be valid")
+	tampered = *cri
+	tampered.EpochPkSig = append([]byte{}, cri.EpochPkSig...)
+	tampered.EpochPkSig[len(tampered.EpochPkSig)-1] ^= 0xff
+	assert.Error(t, VerifyCRI(&tampered, ra.PublicKey()), "A CRI with a tampered signature should not be valid")
+
+	assert.Error(t, VerifyCRI(nil, ra.PublicKey()))
+	assert.Error(t, VerifyCRI(cri, nil))
 }
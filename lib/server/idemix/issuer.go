@@ -13,7 +13,6 @@ import (
 	"reflect"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/cloudflare/cfssl/log"
 	proto "github.com/golang/protobuf/proto"
@@ -124,8 +123,12 @@ func (i *issuer) Init(renew bool, db db.FabricCADB, levels *dbutil.Levels) error
 	if err != nil {
 		return err
 	}
+	err = CheckRevocationAuthority(i.rc)
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("Self-check of the revocation authority of issuer '%s' failed", i.Name()))
+	}
 	log.Debugf("Intializing nonce manager for issuer '%s'", i.Name())
-	i.nm, err = NewNonceManager(i, &wallClock{}, levels.Nonce)
+	i.nm, err = NewNonceManager(i, util.GetClock(), levels.Nonce)
 	if err != nil {
 		return err
 	}
@@ -359,9 +362,3 @@ func IsToken(token string) bool {
 	}
 	return false
 }
-
-type wallClock struct{}
-
-func (wc wallClock) Now() time.Time {
-	return time.Now()
-}
@@ -16,6 +16,8 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/hyperledger/fabric-amcl/amcl"
+	fp256bn "github.com/hyperledger/fabric-amcl/amcl/FP256BN"
 	"github.com/hyperledger/fabric-ca/internal/pkg/util"
 	"github.com/hyperledger/fabric-ca/lib"
 	dbutil "github.com/hyperledger/fabric-ca/lib/server/db/util"
@@ -27,6 +29,7 @@ import (
 	"github.com/kisielk/sqlstruct"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestNewIssuer(t *testing.T) {
@@ -416,6 +419,14 @@ func getIssuer(t *testing.T, testDir string, getranderror, newIssuerKeyerror boo
 		t.Fatalf("Failed to generate key: %s", err.Error())
 	}
 	lib.On("GenerateLongTermRevocationKey").Return(key, nil)
+	lib.On("CreateCRI", mock.Anything, mock.Anything, 1, idemix.ALG_NO_REVOCATION, rnd).Return(
+		func(key *ecdsa.PrivateKey, handles []*fp256bn.BIG, epoch int, alg idemix.RevocationAlgorithm, rng *amcl.RAND) *idemix.CredentialRevocationInformation {
+			cri, err := idemix.CreateCRI(key, handles, epoch, alg, rng)
+			if err != nil {
+				t.Fatalf("Failed to create CRI: %s", err.Error())
+			}
+			return cri
+		}, nil)
 
 	cfg := &Config{
 		RHPoolSize:         100,
@@ -437,6 +448,9 @@ func getIssuer(t *testing.T, testDir string, getranderror, newIssuerKeyerror boo
 	result := new(dmocks.Result)
 	result.On("RowsAffected").Return(int64(1), nil)
 	db.On("NamedExec", "AddRAInfo", InsertRAInfo, &rcinfo).Return(result, nil)
+	db.On("Rebind", SelectRevokedCredentialSQL).Return(SelectRevokedCredentialSQL)
+	revokedCreds := []CredRecord{}
+	db.On("Select", "GetRevokedCredentials", &revokedCreds, fmt.Sprintf(SelectRevokedCredentialSQL, sqlstruct.Columns(CredRecord{}))).Return(nil)
 
 	return db, issuer
 }
@@ -11,7 +11,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/hyperledger/fabric-ca/internal/pkg/util"
 	"github.com/hyperledger/fabric-ca/lib/server/db"
 	dbutil "github.com/hyperledger/fabric-ca/lib/server/db/util"
 	"github.com/stretchr/testify/assert"
@@ -99,7 +101,7 @@ func TestIssuerPublicKey(t *testing.T) {
 	_, err = issuer.IssuerPublicKey()
 	assert.Error(t, err, "issuer.IssuerCredential() should return an error as it should fail to marshal issuer public key")
 }
-func TestWallClock(t *testing.T) {
-	clock := wallClock{}
-	assert.NotNil(t, clock.Now())
+func TestNonceManagerClock(t *testing.T) {
+	clock := util.GetClock()
+	assert.WithinDuration(t, time.Now(), clock.Now(), time.Minute)
 }
@@ -11,6 +11,7 @@ import FP256BN "github.com/hyperledger/fabric-amcl/amcl/FP256BN"
 import ecdsa "crypto/ecdsa"
 import idemix "github.com/hyperledger/fabric/idemix"
 import mock "github.com/stretchr/testify/mock"
+import time "time"
 
 // RevocationAuthority is an autogenerated mock type for the RevocationAuthority type
 type RevocationAuthority struct {
@@ -61,6 +62,36 @@ func (_m *RevocationAuthority) Epoch() (int, error) {
 	return r0, r1
 }
 
+// GetCachedCRI provides a mock function with given fields:
+func (_m *RevocationAuthority) GetCachedCRI() (*idemix.CredentialRevocationInformation, time.Time, error) {
+	ret := _m.Called()
+
+	var r0 *idemix.CredentialRevocationInformation
+	if rf, ok := ret.Get(0).(func() *idemix.CredentialRevocationInformation); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*idemix.CredentialRevocationInformation)
+		}
+	}
+
+	var r1 time.Time
+	if rf, ok := ret.Get(1).(func() time.Time); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Get(1).(time.Time)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func() error); ok {
+		r2 = rf()
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
 // GetNewRevocationHandle provides a mock function with given fields:
 func (_m *RevocationAuthority) GetNewRevocationHandle() (*FP256BN.BIG, error) {
 	ret := _m.Called()
@@ -84,6 +115,29 @@ func (_m *RevocationAuthority) GetNewRevocationHandle() (*FP256BN.BIG, error) {
 	return r0, r1
 }
 
+// ListIssuedHandles provides a mock function with given fields:
+func (_m *RevocationAuthority) ListIssuedHandles() ([]*FP256BN.BIG, error) {
+	ret := _m.Called()
+
+	var r0 []*FP256BN.BIG
+	if rf, ok := ret.Get(0).(func() []*FP256BN.BIG); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*FP256BN.BIG)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // PublicKey provides a mock function with given fields:
 func (_m *RevocationAuthority) PublicKey() *ecdsa.PublicKey {
 	ret := _m.Called()
@@ -39,6 +39,46 @@ func TestGetUnRevokedHandles(t *testing.T) {
 	assert.Equal(t, 99, len(unrevokedHandles))
 }
 
+// unrevokedCredDBAccessor is a credential DB accessor which returns the
+// unrevoked credentials with the given handles
+type unrevokedCredDBAccessor struct {
+	CredDBAccessor
+	handles []int
+}
+
+func (a *unrevokedCredDBAccessor) GetUnrevokedCredentials() ([]CredRecord, error) {
+	creds := []CredRecord{}
+	for _, h := range a.handles {
+		creds = append(creds, CredRecord{RevocationHandle: util.B64Encode(idemix.BigToBytes(fp256bn.NewBIGint(h)))})
+	}
+	return creds, nil
+}
+
+func TestGetUnRevokedRandomHandles(t *testing.T) {
+	accessor := &unrevokedCredDBAccessor{handles: []int{1 << 20, 1 << 21}}
+	ra := &revocationAuthority{issuer: &issuer{name: "ca1", homeDir: ".", cfg: &Config{}, credDBAccessor: accessor}}
+	a := &randomHandleAllocator{ra: ra, allocated: map[string]bool{
+		HandleToString(fp256bn.NewBIGint(1 << 21)): true,
+		HandleToString(fp256bn.NewBIGint(1 << 22)): true,
+		HandleToString(fp256bn.NewBIGint(1 << 23)): true,
+	}}
+	revokedCreds := []CredRecord{{RevocationHandle: util.B64Encode(idemix.BigToBytes(fp256bn.NewBIGint(1 << 23)))}}
+
+	// The handles are the ones of the stored credentials and the ones allocated
+	// since the issuer started, which are far beyond the pool, less the revoked ones
+	unrevokedHandles, err := ra.getUnRevokedRandomHandles(a, revokedCreds)
+	assert.NoError(t, err)
+	handles := []string{}
+	for _, rh := range unrevokedHandles {
+		handles = append(handles, HandleToString(rh))
+	}
+	assert.Equal(t, []string{
+		HandleToString(fp256bn.NewBIGint(1 << 20)),
+		HandleToString(fp256bn.NewBIGint(1 << 21)),
+		HandleToString(fp256bn.NewBIGint(1 << 22)),
+	}, handles)
+}
+
 func TestDoTransactionNilDB(t *testing.T) {
 	f := func(tx db.FabricCATx, args ...interface{}) (interface{}, error) {
 		return nil, nil
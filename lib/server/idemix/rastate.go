@@ -0,0 +1,197 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package idemix
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/cloudflare/cfssl/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	// UpdateRAInfo is the SQL for replacing the revocation authority info
+	UpdateRAInfo = "UPDATE revocation_authority_info SET epoch = ?, next_handle = ?, lasthandle_in_pool = ?, level = ? WHERE (epoch = ?)"
+	// raStateVersion is the version of the format of the exported revocation
+	// authority state
+	raStateVersion = 1
+	// raStateKeySize is the size of the AES-256 key encrypting the private
+	// revocation key in the exported state
+	raStateKeySize = 32
+)
+
+// raState is the persistent state of a revocation authority, exported for
+// backup. Nonces and cached CRIs are not part of it, as they are only valid
+// while the server is running.
+type raState struct {
+	Version int                     `json:"version"`
+	Info    RevocationAuthorityInfo `json:"info"`
+	// PublicKey is the PEM encoded revocation public key
+	PublicKey []byte `json:"publickey"`
+	// PrivateKey is the PEM encoded revocation private key, encrypted with
+	// AES-GCM; the random nonce is prepended to the ciphertext
+	PrivateKey []byte `json:"privatekey"`
+}
+
+// additionalData returns the data authenticated along with the encrypted
+// private key, so that the public part of the state can't be altered either
+func (s *raState) additionalData() []byte {
+	return append([]byte(fmt.Sprintf("%d:%d:%d:%d:%d:", s.Version, s.Info.Epoch, s.Info.NextRevocationHandle,
+		s.Info.LastHandleInPool, s.Info.Level)), s.PublicKey...)
+}
+
+// ExportRAState returns the persistent state of the revocation authority of
+// the issuer, that is its revocation key and the revocation authority info
+// record in the database, for disaster recovery. The private revocation key is
+// encrypted with key, which must be an AES-256 key. The issuer should not
+// allocate revocation handles while the state is exported, as the handles
+// allocated afterwards would be allocated again by a restored issuer.
+func ExportRAState(issuer MyIssuer, key []byte) ([]byte, error) {
+	aead, err := newRAStateCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	rk := NewRevocationKey(issuer.Config().RevocationPublicKeyfile, issuer.Config().RevocationPrivateKeyfile, issuer.IdemixLib())
+	err = rk.Load()
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to load revocation key of issuer '%s'", issuer.Name()))
+	}
+	ra := &revocationAuthority{issuer: issuer, db: issuer.DB()}
+	info, err := ra.getRAInfoFromDB()
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to get revocation authority info of issuer '%s'", issuer.Name()))
+	}
+	if info.Epoch == 0 {
+		return nil, errors.Errorf("The revocation authority of issuer '%s' is not initialized", issuer.Name())
+	}
+	pemPK, pemPubKey, err := EncodeKeys(rk.GetKey(), &rk.GetKey().PublicKey)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to encode revocation key")
+	}
+
+	state := &raState{
+		Version:   raStateVersion,
+		Info:      *info,
+		PublicKey: pemPubKey,
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate nonce")
+	}
+	state.PrivateKey = aead.Seal(nonce, nonce, pemPK, state.additionalData())
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal revocation authority state")
+	}
+	return stateBytes, nil
+}
+
+// ImportRAState restores the revocation authority state exported by
+// ExportRAState in the issuer: the revocation key files are overwritten and the
+// revocation authority info record is replaced, so that a revocation authority
+// created afterwards continues allocating revocation handles where the exported
+// one was. key must be the key the state was exported with.
+func ImportRAState(issuer MyIssuer, stateBytes, key []byte) error {
+	aead, err := newRAStateCipher(key)
+	if err != nil {
+		return err
+	}
+	state := &raState{}
+	err = json.Unmarshal(stateBytes, state)
+	if err != nil {
+		return errors.Wrap(err, "Failed to unmarshal revocation authority state")
+	}
+	if state.Version != raStateVersion {
+		return errors.Errorf("Unsupported revocation authority state version %d", state.Version)
+	}
+	if state.Info.Epoch <= 0 || state.Info.NextRevocationHandle <= 0 ||
+		state.Info.LastHandleInPool < state.Info.NextRevocationHandle {
+		return errors.New("The revocation authority state contains invalid revocation authority info")
+	}
+	if len(state.PrivateKey) < aead.NonceSize() {
+		return errors.New("The encrypted revocation private key is too short")
+	}
+	nonce, ciphertext := state.PrivateKey[:aead.NonceSize()], state.PrivateKey[aead.NonceSize():]
+	pemPK, err := aead.Open(nil, nonce, ciphertext, state.additionalData())
+	if err != nil {
+		return errors.Wrap(err, "Failed to decrypt revocation private key; the key is wrong or the state was altered")
+	}
+	pk, pubKey, err := DecodeKeys(pemPK, state.PublicKey)
+	if err != nil {
+		return errors.WithMessage(err, "Failed to decode revocation key")
+	}
+	if pk.X.Cmp(pubKey.X) != 0 || pk.Y.Cmp(pubKey.Y) != 0 {
+		return errors.New("The revocation public key does not match the private key")
+	}
+
+	rk := NewRevocationKey(issuer.Config().RevocationPublicKeyfile, issuer.Config().RevocationPrivateKeyfile, issuer.IdemixLib())
+	rk.SetKey(pk)
+	err = rk.Store()
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("Failed to store revocation key of issuer '%s'", issuer.Name()))
+	}
+
+	ra := &revocationAuthority{issuer: issuer, db: issuer.DB()}
+	current, err := ra.getRAInfoFromDB()
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("Failed to get revocation authority info of issuer '%s'", issuer.Name()))
+	}
+	if current.Epoch == 0 {
+		err = ra.addRAInfoToDB(&state.Info)
+	} else {
+		err = ra.replaceRAInfo(current, &state.Info)
+	}
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("Failed to restore revocation authority info of issuer '%s'", issuer.Name()))
+	}
+	log.Infof("Restored the revocation authority state of issuer '%s' at epoch %d", issuer.Name(), state.Info.Epoch)
+	return nil
+}
+
+// replaceRAInfo replaces the revocation authority info record current with info
+func (ra *revocationAuthority) replaceRAInfo(current, info *RevocationAuthorityInfo) error {
+	query, args, err := sqlx.In(UpdateRAInfo, info.Epoch, info.NextRevocationHandle, info.LastHandleInPool,
+		info.Level, current.Epoch)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to construct query '%s'", UpdateRAInfo)
+	}
+	res, err := ra.db.Exec("ReplaceRAInfo", ra.db.Rebind(query), args...)
+	if err != nil {
+		return errors.Wrap(err, "Failed to update revocation authority info")
+	}
+	numRowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get number of rows affected")
+	}
+	if numRowsAffected != 1 {
+		return errors.Errorf("Expected to affect 1 entry in revocation authority info table but affected %d",
+			numRowsAffected)
+	}
+	return nil
+}
+
+func newRAStateCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != raStateKeySize {
+		return nil, errors.Errorf("The revocation authority state key must be %d bytes long", raStateKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create AES cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create AES-GCM cipher")
+	}
+	return aead, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package idemix_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fp256bn "github.com/hyperledger/fabric-amcl/amcl/FP256BN"
+	cadb "github.com/hyperledger/fabric-ca/lib/server/db"
+	. "github.com/hyperledger/fabric-ca/lib/server/idemix"
+	"github.com/hyperledger/fabric-ca/lib/server/idemix/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportRAState(t *testing.T) {
+	testDir, err := ioutil.TempDir(".", "rastatetest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err.Error())
+	}
+	defer os.RemoveAll(testDir)
+	var dbs []*cadb.DB
+	defer func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}()
+	newIssuer := func(name string) *mocks.MyIssuer {
+		homeDir := filepath.Join(testDir, name)
+		err := os.MkdirAll(homeDir, 0755)
+		if err != nil {
+			t.Fatalf("Failed to create home directory: %s", err.Error())
+		}
+		db := getSqliteDB(t, homeDir)
+		dbs = append(dbs, db)
+		return getSqliteIssuer(t, homeDir, db, NewLib(), &Config{RHPoolSize: 3})
+	}
+	newRA := func(issuer MyIssuer) RevocationAuthority {
+		ra, err := NewRevocationAuthority(issuer, 1)
+		if err != nil {
+			t.Fatalf("Failed to create revocation authority: %s", err.Error())
+		}
+		return ra
+	}
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	issuer := newIssuer("exported")
+	_, err = ExportRAState(issuer, key)
+	assert.Error(t, err, "Exporting the state of an uninitialized revocation authority should fail")
+	ra := newRA(issuer)
+	// Allocating the last handle of the pool starts a new epoch
+	for i := 0; i < 4; i++ {
+		_, err = ra.GetNewRevocationHandle()
+		if err != nil {
+			t.Fatalf("Failed to get new revocation handle: %s", err.Error())
+		}
+	}
+	epoch, err := ra.Epoch()
+	if err != nil {
+		t.Fatalf("Failed to get epoch: %s", err.Error())
+	}
+	_, err = ExportRAState(issuer, key[:16])
+	assert.Error(t, err, "A key which is not an AES-256 key should be rejected")
+	state, err := ExportRAState(issuer, key)
+	if err != nil {
+		t.Fatalf("Failed to export revocation authority state: %s", err.Error())
+	}
+	assert.NotContains(t, string(state), "PRIVATE KEY", "The private key should be encrypted")
+
+	// Restore in a server whose revocation authority was initialized and in an
+	// empty server
+	restoredIssuer := newIssuer("initialized")
+	newRA(restoredIssuer)
+	for _, target := range []*mocks.MyIssuer{restoredIssuer, newIssuer("empty")} {
+		err = ImportRAState(target, state, key)
+		if err != nil {
+			t.Fatalf("Failed to import revocation authority state: %s", err.Error())
+		}
+		restored := newRA(target)
+		assert.Equal(t, ra.PublicKey(), restored.PublicKey(), "The revocation key should be restored")
+		restoredEpoch, err := restored.Epoch()
+		if assert.NoError(t, err) {
+			assert.Equal(t, epoch, restoredEpoch)
+		}
+		rh, err := restored.GetNewRevocationHandle()
+		if assert.NoError(t, err) {
+			assert.Equal(t, HandleToString(fp256bn.NewBIGint(5)), HandleToString(rh),
+				"The restored revocation authority should continue allocating handles")
+		}
+		assert.NoError(t, CheckRevocationAuthority(restored))
+	}
+
+	target := newIssuer("failures")
+	otherKey := bytes.Repeat([]byte{0x24}, 32)
+	assert.Error(t, ImportRAState(target, state, otherKey), "Importing with another key should fail")
+	var tampered map[string]interface{}
+	err = json.Unmarshal(state, &tampered)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal state: %s", err.Error())
+	}
+	tampered["info"].(map[string]interface{})["NextRevocationHandle"] = 1
+	tamperedState, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatalf("Failed to marshal state: %s", err.Error())
+	}
+	assert.Error(t, ImportRAState(target, tamperedState, key), "Importing an altered state should fail")
+	assert.Error(t, ImportRAState(target, []byte("not a state"), key))
+}
@@ -7,9 +7,11 @@ SPDX-License-Identifier: Apache-2.0
 package idemix
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/cloudflare/cfssl/log"
 	fp256bn "github.com/hyperledger/fabric-amcl/amcl/FP256BN"
@@ -39,6 +41,10 @@ type RevocationAuthority interface {
 	// GetNewRevocationHandle returns new revocation handle, which is required to
 	// create a new Idemix credential
 	GetNewRevocationHandle() (*fp256bn.BIG, error)
+	// ListIssuedHandles returns the revocation handles allocated by
+	// GetNewRevocationHandle in the current epoch, in the order they were allocated,
+	// if the handles are allocated sequentially
+	ListIssuedHandles() ([]*fp256bn.BIG, error)
 	// CreateCRI returns latest credential revocation information (CRI). CRI contains
 	// information that allows a prover to create a proof that the revocation handle associated
 	// with his credential is not revoked and by the verifier to verify the non-revocation
@@ -46,6 +52,11 @@ type RevocationAuthority interface {
 	// does not match the version of the CRI that prover used to create non-revocation proof.
 	// The version of the CRI is specified by the Epoch value associated with the CRI.
 	CreateCRI() (*idemix.CredentialRevocationInformation, error)
+	// GetCachedCRI returns the latest CRI like CreateCRI, along with the time after
+	// which it expires. The CRI is cached until it expires or the epoch changes. The
+	// expiry is the zero time if the CRI validity is not configured, in which case
+	// the CRI is valid until the epoch changes.
+	GetCachedCRI() (*idemix.CredentialRevocationInformation, time.Time, error)
 	// Epoch returns epoch value of the latest CRI
 	Epoch() (int, error)
 	// PublicKey returns revocation authority's public key
@@ -63,10 +74,14 @@ type RevocationAuthorityInfo struct {
 
 // revocationAuthority implements RevocationComponent interface
 type revocationAuthority struct {
-	issuer     MyIssuer
-	key        RevocationKey
-	db         db.FabricCADB
-	currentCRI *idemix.CredentialRevocationInformation
+	issuer      MyIssuer
+	key         RevocationKey
+	db          db.FabricCADB
+	allocator   handleAllocator
+	criValidity time.Duration
+	criMutex    sync.Mutex
+	currentCRI  *idemix.CredentialRevocationInformation
+	criExpiry   time.Time
 }
 
 // NewRevocationAuthority constructor for revocation authority
@@ -77,6 +92,19 @@ func NewRevocationAuthority(issuer MyIssuer, level int) (RevocationAuthority, er
 	}
 	var err error
 
+	if issuer.Config().CRIValidity != "" {
+		ra.criValidity, err = time.ParseDuration(issuer.Config().CRIValidity)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse idemix.crivalidity config option while initializing revocation authority for issuer '%s'",
+				issuer.Name())
+		}
+	}
+
+	ra.allocator, err = newHandleAllocator(ra, issuer.Config().RHAllocation)
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to initialize revocation authority for issuer '%s'", issuer.Name()))
+	}
+
 	err = ra.initKeyMaterial(false)
 	if err != nil {
 		return nil, err
@@ -108,6 +136,22 @@ func NewRevocationAuthority(issuer MyIssuer, level int) (RevocationAuthority, er
 	return ra, nil
 }
 
+// CheckRevocationAuthority creates the CRI of the current epoch with ra and
+// verifies it against the public key of ra, so that a misconfigured revocation
+// authority is detected at startup rather than when a client gets the CRI. The
+// CRI is cached by ra.
+func CheckRevocationAuthority(ra RevocationAuthority) error {
+	cri, err := ra.CreateCRI()
+	if err != nil {
+		return errors.WithMessage(err, "Revocation authority failed to create a CRI")
+	}
+	err = VerifyCRI(cri, ra.PublicKey())
+	if err != nil {
+		return errors.WithMessage(err, "Revocation authority created a CRI which can't be verified with its public key")
+	}
+	return nil
+}
+
 func (ra *revocationAuthority) initKeyMaterial(renew bool) error {
 	log.Debug("Initialize Idemix issuer revocation key material")
 	revocationPubKey := ra.issuer.Config().RevocationPublicKeyfile
@@ -151,38 +195,84 @@ func (ra *revocationAuthority) initKeyMaterial(renew bool) error {
 // does not match the version of the CRI that prover used to create non-revocation proof.
 // The version of the CRI is specified by the Epoch value associated with the CRI.
 func (ra *revocationAuthority) CreateCRI() (*idemix.CredentialRevocationInformation, error) {
+	cri, _, err := ra.GetCachedCRI()
+	return cri, err
+}
+
+// GetCachedCRI returns the latest CRI like CreateCRI, along with the time after
+// which it expires. The CRI is cached until it expires or the epoch changes. The
+// expiry is the zero time if the CRI validity is not configured, in which case
+// the CRI is valid until the epoch changes.
+func (ra *revocationAuthority) GetCachedCRI() (*idemix.CredentialRevocationInformation, time.Time, error) {
 	info, err := ra.getRAInfoFromDB()
 	if err != nil {
-		return nil, errors.WithMessage(err, "Failed to get revocation authority info from datastore")
+		return nil, time.Time{}, errors.WithMessage(err, "Failed to get revocation authority info from datastore")
 	}
-	if ra.currentCRI != nil && ra.currentCRI.Epoch == int64(info.Epoch) {
-		return ra.currentCRI, nil
+	ra.criMutex.Lock()
+	defer ra.criMutex.Unlock()
+	now := util.Now()
+	if ra.currentCRI != nil && ra.currentCRI.Epoch == int64(info.Epoch) &&
+		(ra.criExpiry.IsZero() || now.Before(ra.criExpiry)) {
+		return ra.currentCRI, ra.criExpiry, nil
 	}
 
 	revokedCreds, err := ra.issuer.CredDBAccessor().GetRevokedCredentials()
 	if err != nil {
-		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to get revoked credentials while generating CRI for issuer: '%s'", ra.issuer.Name()))
+		return nil, time.Time{}, errors.WithMessage(err, fmt.Sprintf("Failed to get revoked credentials while generating CRI for issuer: '%s'", ra.issuer.Name()))
 	}
 
-	unrevokedHandles := ra.getUnRevokedHandles(info, revokedCreds)
+	var unrevokedHandles []*fp256bn.BIG
+	if a, ok := ra.allocator.(*randomHandleAllocator); ok {
+		unrevokedHandles, err = ra.getUnRevokedRandomHandles(a, revokedCreds)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	} else {
+		unrevokedHandles = ra.getUnRevokedHandles(info, revokedCreds)
+	}
 
 	alg := idemix.ALG_NO_REVOCATION
 	cri, err := ra.issuer.IdemixLib().CreateCRI(ra.key.GetKey(), unrevokedHandles, info.Epoch, alg, ra.issuer.IdemixRand())
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 	ra.currentCRI = cri
-	return ra.currentCRI, nil
+	ra.criExpiry = time.Time{}
+	if ra.criValidity > 0 {
+		ra.criExpiry = now.Add(ra.criValidity).UTC()
+	}
+	return ra.currentCRI, ra.criExpiry, nil
 }
 
-// GetNewRevocationHandle returns a new revocation handle
+// GetNewRevocationHandle returns a new revocation handle allocated with the
+// configured allocation strategy
 func (ra *revocationAuthority) GetNewRevocationHandle() (*fp256bn.BIG, error) {
-	h, err := ra.getNextRevocationHandle()
+	return ra.allocator.Allocate()
+}
+
+// ListIssuedHandles returns the revocation handles allocated by
+// GetNewRevocationHandle in the current epoch, in the order they were allocated.
+// The handles of an epoch are taken from a pool of consecutive handles of the
+// configured pool size, which ends with the last handle in pool; the handles
+// allocated so far are the ones of the pool below the next revocation handle.
+// The handles can't be listed if they are allocated randomly.
+func (ra *revocationAuthority) ListIssuedHandles() ([]*fp256bn.BIG, error) {
+	if _, ok := ra.allocator.(*sequentialHandleAllocator); !ok {
+		return nil, errors.New("Revocation handles can only be listed if they are allocated sequentially")
+	}
+	info, err := ra.getRAInfoFromDB()
 	if err != nil {
-		return nil, err
+		return nil, errors.WithMessage(err, "Failed to get revocation authority info from datastore")
+	}
+	first := info.LastHandleInPool - ra.issuer.Config().RHPoolSize + 1
+	if first < 1 {
+		first = 1
 	}
-	rh := fp256bn.NewBIGint(h)
-	return rh, err
+	handles := []*fp256bn.BIG{}
+	for h := first; h < info.NextRevocationHandle; h++ {
+		handles = append(handles, fp256bn.NewBIGint(h))
+	}
+	return handles, nil
 }
 
 // Epoch returns epoch value of the latest CRI
@@ -201,33 +291,80 @@ func (ra *revocationAuthority) PublicKey() *ecdsa.PublicKey {
 
 func (ra *revocationAuthority) getUnRevokedHandles(info *RevocationAuthorityInfo, revokedCreds []CredRecord) []*fp256bn.BIG {
 	log.Debugf("RA '%s' is getting revoked revocation handles for epoch %d", ra.issuer.Name(), info.Epoch)
-	isRevokedHandle := func(rh *fp256bn.BIG) bool {
-		for i := 0; i <= len(revokedCreds)-1; i++ {
-			rrhBytes, err := util.B64Decode(revokedCreds[i].RevocationHandle)
-			if err != nil {
-				log.Debugf("Failed to Base64 decode revocation handle '%s': %s", revokedCreds[i].RevocationHandle, err.Error())
-				return false
-			}
-			rhBytes := idemix.BigToBytes(rh)
-			if bytes.Compare(rhBytes, rrhBytes) == 0 {
-				return true
-			}
-		}
-		return false
-	}
+	revokedHandles := credentialHandles(revokedCreds)
 	validHandles := []*fp256bn.BIG{}
 	for i := 1; i <= info.LastHandleInPool; i = i + 1 {
 		validHandles = append(validHandles, fp256bn.NewBIGint(i))
 	}
 	for i := len(validHandles) - 1; i >= 0; i-- {
-		isrevoked := isRevokedHandle(validHandles[i])
-		if isrevoked {
+		if revokedHandles[HandleToString(validHandles[i])] {
 			validHandles = append(validHandles[:i], validHandles[i+1:]...)
 		}
 	}
 	return validHandles
 }
 
+// getUnRevokedRandomHandles returns the unrevoked handles allocated by the
+// random allocator a. As random handles are not taken from the pool, they are
+// the handles of the stored credentials which are not revoked, along with the
+// handles allocated since the issuer started whose credential may not be
+// stored yet.
+func (ra *revocationAuthority) getUnRevokedRandomHandles(a *randomHandleAllocator, revokedCreds []CredRecord) ([]*fp256bn.BIG, error) {
+	log.Debugf("RA '%s' is getting unrevoked random revocation handles", ra.issuer.Name())
+	creds, err := ra.issuer.CredDBAccessor().GetUnrevokedCredentials()
+	if err != nil {
+		return nil, errors.WithMessage(err, fmt.Sprintf("Failed to get unrevoked credentials while generating CRI for issuer: '%s'", ra.issuer.Name()))
+	}
+	handles := credentialHandles(creds)
+	for _, h := range a.handles() {
+		handles[h] = true
+	}
+	for h := range credentialHandles(revokedCreds) {
+		delete(handles, h)
+	}
+	keys := make([]string, 0, len(handles))
+	for h := range handles {
+		keys = append(keys, h)
+	}
+	sort.Strings(keys)
+	validHandles := []*fp256bn.BIG{}
+	for _, h := range keys {
+		rh, err := StringToHandle(h)
+		if err != nil {
+			log.Debugf("Skipping invalid revocation handle: %s", err)
+			continue
+		}
+		validHandles = append(validHandles, rh)
+	}
+	return validHandles, nil
+}
+
+// credentialHandles returns the set of the revocation handles of creds, in the
+// form returned by HandleToString
+func credentialHandles(creds []CredRecord) map[string]bool {
+	handles := map[string]bool{}
+	for _, cred := range creds {
+		rhBytes, err := util.B64Decode(cred.RevocationHandle)
+		if err != nil {
+			log.Debugf("Failed to Base64 decode revocation handle '%s': %s", cred.RevocationHandle, err.Error())
+			continue
+		}
+		if len(rhBytes) != idemix.FieldBytes {
+			log.Debugf("Revocation handle '%s' has an invalid length of %d bytes", cred.RevocationHandle, len(rhBytes))
+			continue
+		}
+		handles[HandleToString(fp256bn.FromBytes(rhBytes))] = true
+	}
+	return handles
+}
+
+// invalidateCRI discards the cached CRI, so that the next CRI is created anew
+func (ra *revocationAuthority) invalidateCRI() {
+	ra.criMutex.Lock()
+	defer ra.criMutex.Unlock()
+	ra.currentCRI = nil
+}
+
 func (ra *revocationAuthority) getRAInfoFromDB() (*RevocationAuthorityInfo, error) {
 	rcinfos := []RevocationAuthorityInfo{}
 	err := ra.db.Select("GetRAInfo", &rcinfos, SelectRAInfo)
@@ -12,10 +12,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	fp256bn "github.com/hyperledger/fabric-amcl/amcl/FP256BN"
 	"github.com/hyperledger/fabric-ca/internal/pkg/util"
+	cadb "github.com/hyperledger/fabric-ca/lib/server/db"
+	"github.com/hyperledger/fabric-ca/lib/server/db/sqlite"
 	. "github.com/hyperledger/fabric-ca/lib/server/idemix"
 	"github.com/hyperledger/fabric-ca/lib/server/idemix/mocks"
 	dmocks "github.com/hyperledger/fabric-ca/lib/server/idemix/mocks"
@@ -492,6 +496,146 @@ func TestGetNewRevocationHandleLastHandle(t *testing.T) {
 		"Expected next revocation handle to be 100")
 }
 
+func TestListIssuedHandles(t *testing.T) {
+	homeDir, err := ioutil.TempDir(".", "listrhtest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err.Error())
+	}
+	defer os.RemoveAll(homeDir)
+	db := getSqliteDB(t, homeDir)
+	defer db.Close()
+
+	issuer, _, _ := setupForInsertTests(t, homeDir)
+	issuer.On("DB").Return(db)
+	issuer.On("Config").Return(&Config{RHPoolSize: 3,
+		RevocationPublicKeyfile:  path.Join(homeDir, DefaultRevocationPublicKeyFile),
+		RevocationPrivateKeyfile: path.Join(homeDir, "msp/keystore", DefaultRevocationPrivateKeyFile)})
+	ra, err := NewRevocationAuthority(issuer, 1)
+	if err != nil {
+		t.Fatalf("Failed to create revocation authority: %s", err.Error())
+	}
+
+	handles, err := ra.ListIssuedHandles()
+	assert.NoError(t, err)
+	assert.Empty(t, handles, "No handle should be listed before one is allocated")
+
+	allocate := func() *fp256bn.BIG {
+		rh, err := ra.GetNewRevocationHandle()
+		if err != nil {
+			t.Fatalf("Failed to get new revocation handle: %s", err.Error())
+		}
+		return rh
+	}
+	allocated := []*fp256bn.BIG{allocate(), allocate()}
+	handles, err = ra.ListIssuedHandles()
+	assert.NoError(t, err)
+	if assert.Len(t, handles, len(allocated)) {
+		for i := range allocated {
+			assert.Equal(t, idemix.BigToBytes(allocated[i]), idemix.BigToBytes(handles[i]))
+		}
+	}
+
+	// Allocating the last handle of the pool starts a new epoch
+	allocate()
+	epoch, err := ra.Epoch()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, epoch)
+	handles, err = ra.ListIssuedHandles()
+	assert.NoError(t, err)
+	assert.Empty(t, handles, "No handle should be listed at the beginning of a new epoch")
+
+	rh := allocate()
+	handles, err = ra.ListIssuedHandles()
+	assert.NoError(t, err)
+	if assert.Len(t, handles, 1) {
+		assert.Equal(t, idemix.BigToBytes(fp256bn.NewBIGint(4)), idemix.BigToBytes(rh))
+		assert.Equal(t, idemix.BigToBytes(rh), idemix.BigToBytes(handles[0]))
+	}
+}
+
+func TestGetCachedCRIExpiry(t *testing.T) {
+	homeDir, err := ioutil.TempDir(".", "cachedcritest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err.Error())
+	}
+	defer os.RemoveAll(homeDir)
+	db := getSqliteDB(t, homeDir)
+	defer db.Close()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := new(mocks.Clock)
+	clock.On("Now").Return(func() time.Time { return now })
+	util.SetClock(clock)
+	defer util.SetClock(nil)
+
+	cfg := &Config{RHPoolSize: 100, CRIValidity: "1h"}
+	issuer := getSqliteIssuer(t, homeDir, db, NewLib(), cfg)
+	ra, err := NewRevocationAuthority(issuer, 1)
+	if err != nil {
+		t.Fatalf("Failed to create revocation authority: %s", err.Error())
+	}
+
+	cri, expiry, err := ra.GetCachedCRI()
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), expiry)
+	assert.NoError(t, VerifyCRI(cri, ra.PublicKey()))
+
+	// The CRI is cached until it expires
+	now = now.Add(30 * time.Minute)
+	cached, cachedExpiry, err := ra.GetCachedCRI()
+	assert.NoError(t, err)
+	assert.True(t, cri == cached, "The cached CRI should be returned before it expires")
+	assert.Equal(t, expiry, cachedExpiry)
+
+	now = now.Add(time.Hour)
+	renewed, renewedExpiry, err := ra.GetCachedCRI()
+	assert.NoError(t, err)
+	assert.False(t, cri == renewed, "A new CRI should be created once the cached one expires")
+	assert.Equal(t, now.Add(time.Hour), renewedExpiry)
+	assert.Equal(t, cri.Epoch, renewed.Epoch)
+
+	cfg.CRIValidity = "bad"
+	_, err = NewRevocationAuthority(issuer, 1)
+	assert.Error(t, err, "An invalid CRI validity should be rejected")
+}
+
+func TestCheckRevocationAuthority(t *testing.T) {
+	homeDir, err := ioutil.TempDir(".", "racheck")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err.Error())
+	}
+	defer os.RemoveAll(homeDir)
+	db := new(dmocks.FabricCADB)
+	ra := getRevocationAuthority(t, "GetRAInfo", homeDir, db, nil, 0, false, false, getSelectFuncForCreateCRI(t, true, false))
+	assert.NoError(t, CheckRevocationAuthority(ra), "A correctly configured revocation authority should pass the check")
+
+	// The CRI is signed with a key which doesn't match the public key
+	signingKey, err := idemix.GenerateLongTermRevocationKey()
+	if err != nil {
+		t.Fatalf("Failed to generate revocation key: %s", err.Error())
+	}
+	otherKey, err := idemix.GenerateLongTermRevocationKey()
+	if err != nil {
+		t.Fatalf("Failed to generate revocation key: %s", err.Error())
+	}
+	rnd, err := idemix.GetRand()
+	if err != nil {
+		t.Fatalf("Failed generate random number: %s", err.Error())
+	}
+	cri, err := idemix.CreateCRI(signingKey, []*fp256bn.BIG{}, 1, idemix.ALG_NO_REVOCATION, rnd)
+	if err != nil {
+		t.Fatalf("Failed to create CRI: %s", err.Error())
+	}
+	mismatched := new(mocks.RevocationAuthority)
+	mismatched.On("CreateCRI").Return(cri, nil)
+	mismatched.On("PublicKey").Return(&otherKey.PublicKey)
+	assert.Error(t, CheckRevocationAuthority(mismatched), "A revocation authority with a mismatched key should fail the check")
+
+	failing := new(mocks.RevocationAuthority)
+	failing.On("CreateCRI").Return(nil, errors.New("Failed to create CRI"))
+	assert.Error(t, CheckRevocationAuthority(failing))
+}
+
 func TestGetEpoch(t *testing.T) {
 	homeDir, err := ioutil.TempDir(".", "getepochtest")
 	if err != nil {
@@ -783,3 +927,39 @@ func getTxSelectFunc(t *testing.T, rcs *[]RevocationAuthorityInfo, nextRH int, i
 		return nil
 	}
 }
+
+func getSqliteDB(t *testing.T, homeDir string) *cadb.DB {
+	sqliteDB := sqlite.NewDB(filepath.Join(homeDir, "fabric-ca-server.db"), "", nil)
+	err := sqliteDB.Connect()
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %s", err.Error())
+	}
+	db, err := sqliteDB.Create()
+	if err != nil {
+		t.Fatalf("Failed to create database: %s", err.Error())
+	}
+	return db
+}
+
+// getSqliteIssuer returns an issuer using db and lib, with the revocation key
+// files of cfg in homeDir
+func getSqliteIssuer(t *testing.T, homeDir string, db *cadb.DB, lib Lib, cfg *Config) *mocks.MyIssuer {
+	issuer := new(mocks.MyIssuer)
+	issuer.On("Name").Return("ca1")
+	issuer.On("HomeDir").Return(homeDir)
+	issuer.On("IdemixLib").Return(lib)
+	issuer.On("DB").Return(db)
+	cfg.RevocationPublicKeyfile = path.Join(homeDir, DefaultRevocationPublicKeyFile)
+	cfg.RevocationPrivateKeyfile = path.Join(homeDir, "msp/keystore", DefaultRevocationPrivateKeyFile)
+	issuer.On("Config").Return(cfg)
+	rnd, err := idemix.GetRand()
+	if err != nil {
+		t.Fatalf("Failed generate random number: %s", err.Error())
+	}
+	issuer.On("IdemixRand").Return(rnd)
+	credDBAccessor := new(mocks.CredDBAccessor)
+	credDBAccessor.On("GetRevokedCredentials").Return([]CredRecord{}, nil)
+	credDBAccessor.On("GetUnrevokedCredentials").Return([]CredRecord{}, nil)
+	issuer.On("CredDBAccessor").Return(credDBAccessor)
+	return issuer
+}
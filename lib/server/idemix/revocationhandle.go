@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package idemix
+
+import (
+	"encoding/hex"
+	"sync"
+
+	fp256bn "github.com/hyperledger/fabric-amcl/amcl/FP256BN"
+	"github.com/hyperledger/fabric/idemix"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SequentialHandleAllocation is the revocation handle allocation strategy
+	// which allocates the handles of the pool in order. It is the default.
+	SequentialHandleAllocation = "sequential"
+	// RandomHandleAllocation is the revocation handle allocation strategy which
+	// allocates random handles, so that the handles don't reveal the number of
+	// credentials issued
+	RandomHandleAllocation = "random"
+)
+
+// handleAllocator is a revocation handle allocation strategy
+type handleAllocator interface {
+	// Allocate returns a new revocation handle
+	Allocate() (*fp256bn.BIG, error)
+}
+
+// sequentialHandleAllocator allocates the next handle of the pool of the
+// revocation authority
+type sequentialHandleAllocator struct {
+	ra *revocationAuthority
+}
+
+func (a *sequentialHandleAllocator) Allocate() (*fp256bn.BIG, error) {
+	h, err := a.ra.getNextRevocationHandle()
+	if err != nil {
+		return nil, err
+	}
+	return fp256bn.NewBIGint(h), nil
+}
+
+// randomHandleAllocator allocates handles drawn uniformly from the non zero
+// elements of the FP256BN group. The handle counter of the revocation authority
+// is still advanced, so that a new epoch starts when the pool is exhausted. The
+// handles allocated since the issuer started are tracked so that none is
+// allocated twice; as the handles are 256 bits long, a collision with a handle
+// allocated before a restart is negligibly likely. Since the handles are not
+// taken from the pool, the CRI is built from the handles of the stored
+// credentials and the tracked handles, and is recreated after each allocation.
+type randomHandleAllocator struct {
+	ra        *revocationAuthority
+	mutex     sync.Mutex
+	allocated map[string]bool
+}
+
+func (a *randomHandleAllocator) Allocate() (*fp256bn.BIG, error) {
+	_, err := a.ra.getNextRevocationHandle()
+	if err != nil {
+		return nil, err
+	}
+	rh, err := a.allocate()
+	if err != nil {
+		return nil, err
+	}
+	a.ra.invalidateCRI()
+	return rh, nil
+}
+
+func (a *randomHandleAllocator) allocate() (*fp256bn.BIG, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for {
+		rh, err := a.ra.issuer.IdemixLib().RandModOrder(a.ra.issuer.IdemixRand())
+		if err != nil {
+			return nil, errors.WithMessage(err, "Failed to generate random revocation handle")
+		}
+		key := HandleToString(rh)
+		if fp256bn.Comp(rh, fp256bn.NewBIGint(0)) == 0 || a.allocated[key] {
+			continue
+		}
+		a.allocated[key] = true
+		return rh, nil
+	}
+}
+
+// handles returns the handles allocated since the issuer started
+func (a *randomHandleAllocator) handles() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	handles := make([]string, 0, len(a.allocated))
+	for h := range a.allocated {
+		handles = append(handles, h)
+	}
+	return handles
+}
+
+// newHandleAllocator returns the revocation handle allocator of ra for strategy
+func newHandleAllocator(ra *revocationAuthority, strategy string) (handleAllocator, error) {
+	switch strategy {
+	case "", SequentialHandleAllocation:
+		return &sequentialHandleAllocator{ra: ra}, nil
+	case RandomHandleAllocation:
+		return &randomHandleAllocator{ra: ra, allocated: map[string]bool{}}, nil
+	default:
+		return nil, errors.Errorf("Invalid revocation handle allocation strategy '%s'; must be '%s' or '%s'",
+			strategy, SequentialHandleAllocation, RandomHandleAllocation)
+	}
+}
+
+// HandleToString returns the canonical form of the revocation handle rh, which
+// is the lower case hex encoding of its big-endian representation on
+// idemix.FieldBytes bytes. An empty string is returned if rh is nil.
+func HandleToString(rh *fp256bn.BIG) string {
+	if rh == nil {
+		return ""
+	}
+	return hex.EncodeToString(idemix.BigToBytes(rh))
+}
+
+// StringToHandle returns the revocation handle whose canonical form, as
+// returned by HandleToString, is s. Upper case hex digits are accepted. The
+// handle must be lower than the order of the FP256BN group.
+func StringToHandle(s string) (*fp256bn.BIG, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Revocation handle '%s' is not hex encoded", s)
+	}
+	if len(b) != idemix.FieldBytes {
+		return nil, errors.Errorf("Revocation handle '%s' must be %d bytes long, but is %d bytes long", s, idemix.FieldBytes, len(b))
+	}
+	rh := fp256bn.FromBytes(b)
+	if fp256bn.Comp(rh, fp256bn.NewBIGints(fp256bn.CURVE_Order)) >= 0 {
+		return nil, errors.Errorf("Revocation handle '%s' is not lower than the group order", s)
+	}
+	return rh, nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package idemix_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	fp256bn "github.com/hyperledger/fabric-amcl/amcl/FP256BN"
+	. "github.com/hyperledger/fabric-ca/lib/server/idemix"
+	"github.com/hyperledger/fabric-ca/lib/server/idemix/mocks"
+	"github.com/hyperledger/fabric/idemix"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandleStringRoundTrip(t *testing.T) {
+	orderHex := HandleToString(fp256bn.NewBIGints(fp256bn.CURVE_Order))
+	order, ok := new(big.Int).SetString(orderHex, 16)
+	if !ok {
+		t.Fatalf("Failed to parse group order '%s'", orderHex)
+	}
+	hexOf := func(n *big.Int) string {
+		return fmt.Sprintf("%064x", n)
+	}
+	orderMinusOne := new(big.Int).Sub(order, big.NewInt(1))
+	orderMinusOneBytes, err := hex.DecodeString(hexOf(orderMinusOne))
+	if err != nil {
+		t.Fatalf("Failed to decode '%s'", hexOf(orderMinusOne))
+	}
+
+	handles := []*fp256bn.BIG{
+		fp256bn.NewBIGint(0),
+		fp256bn.NewBIGint(1),
+		fp256bn.NewBIGint(1000),
+		fp256bn.NewBIGint(1<<31 - 1),
+		fp256bn.FromBytes(orderMinusOneBytes),
+	}
+	for _, rh := range handles {
+		s := HandleToString(rh)
+		assert.Len(t, s, 2*idemix.FieldBytes)
+		assert.Equal(t, strings.ToLower(s), s, "The canonical form should be lower case")
+		decoded, err := StringToHandle(s)
+		if assert.NoError(t, err, "Failed to decode handle '%s'", s) {
+			assert.Equal(t, 0, fp256bn.Comp(rh, decoded), "Handle '%s' should round trip", s)
+		}
+		decoded, err = StringToHandle(strings.ToUpper(s))
+		if assert.NoError(t, err) {
+			assert.Equal(t, 0, fp256bn.Comp(rh, decoded))
+		}
+	}
+	assert.Equal(t, strings.Repeat("0", 63)+"1", HandleToString(fp256bn.NewBIGint(1)))
+	assert.Equal(t, hexOf(orderMinusOne), HandleToString(handles[len(handles)-1]))
+	assert.Empty(t, HandleToString(nil))
+
+	invalid := []string{
+		orderHex,
+		hexOf(new(big.Int).Add(order, big.NewInt(1))),
+		strings.Repeat("f", 2*idemix.FieldBytes),
+		"01",
+		strings.Repeat("0", 2*idemix.FieldBytes+2),
+		strings.Repeat("0", 2*idemix.FieldBytes-1) + "g",
+		"",
+	}
+	for _, s := range invalid {
+		_, err := StringToHandle(s)
+		assert.Error(t, err, "Handle '%s' should be rejected", s)
+	}
+}
+
+func TestRevocationHandleAllocation(t *testing.T) {
+	homeDir, err := ioutil.TempDir(".", "rhallocationtest")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err.Error())
+	}
+	defer os.RemoveAll(homeDir)
+
+	var dbs []io.Closer
+	defer func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}()
+	newRA := func(strategy string, lib Lib) RevocationAuthority {
+		db := getSqliteDB(t, homeDir)
+		dbs = append(dbs, db)
+		issuer := getSqliteIssuer(t, homeDir, db, lib, &Config{RHPoolSize: 100, RHAllocation: strategy})
+		ra, err := NewRevocationAuthority(issuer, 1)
+		if err != nil {
+			t.Fatalf("Failed to create revocation authority: %s", err.Error())
+		}
+		return ra
+	}
+
+	// Sequential handles are allocated in order from 1
+	ra := newRA(SequentialHandleAllocation, NewLib())
+	for i := 1; i <= 3; i++ {
+		rh, err := ra.GetNewRevocationHandle()
+		if assert.NoError(t, err) {
+			assert.Equal(t, HandleToString(fp256bn.NewBIGint(i)), HandleToString(rh))
+		}
+	}
+
+	// Random handles are unique, non zero and lower than the group order
+	ra = newRA(RandomHandleAllocation, NewLib())
+	order := fp256bn.NewBIGints(fp256bn.CURVE_Order)
+	handles := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		rh, err := ra.GetNewRevocationHandle()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.False(t, handles[HandleToString(rh)], "Random handle '%s' was allocated twice", HandleToString(rh))
+		handles[HandleToString(rh)] = true
+		assert.NotEqual(t, 0, fp256bn.Comp(rh, fp256bn.NewBIGint(0)))
+		assert.Equal(t, -1, fp256bn.Comp(rh, order))
+	}
+	assert.Len(t, handles, 50)
+	// The handle counter is still advanced, but the handles can't be listed
+	epoch, err := ra.Epoch()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, epoch)
+	_, err = ra.ListIssuedHandles()
+	assert.Error(t, err)
+	// The CRI is built from the random handles rather than the pool
+	_, err = ra.CreateCRI()
+	assert.NoError(t, err)
+
+	// A random handle which was already allocated is drawn again
+	lib := new(mocks.Lib)
+	revocationKey, err := idemix.GenerateLongTermRevocationKey()
+	if err != nil {
+		t.Fatalf("Failed to generate revocation key: %s", err.Error())
+	}
+	lib.On("GenerateLongTermRevocationKey").Return(revocationKey, nil)
+	lib.On("RandModOrder", mock.Anything).Return(fp256bn.NewBIGint(7), nil).Twice()
+	lib.On("RandModOrder", mock.Anything).Return(fp256bn.NewBIGint(0), nil).Once()
+	lib.On("RandModOrder", mock.Anything).Return(fp256bn.NewBIGint(9), nil).Once()
+	ra = newRA(RandomHandleAllocation, lib)
+	for _, expected := range []int{7, 9} {
+		rh, err := ra.GetNewRevocationHandle()
+		if assert.NoError(t, err) {
+			assert.Equal(t, HandleToString(fp256bn.NewBIGint(expected)), HandleToString(rh))
+		}
+	}
+	lib.AssertNumberOfCalls(t, "RandModOrder", 4)
+
+	db := getSqliteDB(t, homeDir)
+	dbs = append(dbs, db)
+	_, err = NewRevocationAuthority(getSqliteIssuer(t, homeDir, db, NewLib(), &Config{RHAllocation: "bad"}), 1)
+	assert.Error(t, err, "An invalid allocation strategy should be rejected")
+}
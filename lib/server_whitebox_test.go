@@ -193,6 +193,14 @@ func TestServerHealthCheck(t *testing.T) {
 	assert.EqualError(t, err, "sql: database is closed")
 }
 
+func TestCSPHealthCheck(t *testing.T) {
+	checker := &cspHealthChecker{csp: util.GetDefaultBCCSP()}
+	assert.NoError(t, checker.HealthCheck(context.Background()))
+
+	checker = &cspHealthChecker{}
+	assert.Error(t, checker.HealthCheck(context.Background()))
+}
+
 func TestCORS(t *testing.T) {
 	tests := []struct {
 		cors         CORS
@@ -52,6 +52,9 @@ type ServerConfig struct {
 	CAcount int `def:"0" help:"Number of non-default CA instances"`
 	// Size limit of an acceptable CRL in bytes
 	CRLSizeLimit int `def:"512000" help:"Size limit of an acceptable CRL in bytes"`
+	// Size limit of an acceptable CSR in bytes; the body of enroll and
+	// reenroll requests is limited to twice this size
+	CSRSizeLimit int `def:"1048576" help:"Size limit of an acceptable CSR in bytes"`
 	// CompMode1_3 determines if to run in comptability for version 1.3
 	CompMode1_3 bool `skip:"true"`
 	// Metrics contains the configuration for provider and statsd
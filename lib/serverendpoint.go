@@ -27,6 +27,9 @@ type serverEndpoint struct {
 	Handler func(ctx *serverRequestContextImpl) (interface{}, error)
 	// Server which hosts this endpoint
 	Server *Server
+	// maxBodySize, if not nil, returns the size limit of the request body in
+	// bytes; a larger body is rejected without being read in full
+	maxBodySize func() int64
 }
 
 // ServeHTTP encapsulates the call to underlying Handlers to handle the request
@@ -7,9 +7,13 @@ SPDX-License-Identifier: Apache-2.0
 package lib
 
 import (
+	"crypto"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/asn1"
+	"encoding/hex"
 	"encoding/pem"
+	"strings"
 	"time"
 
 	"github.com/cloudflare/cfssl/config"
@@ -25,6 +29,9 @@ import (
 )
 
 const (
+	// Name of the default signing profile in the profile constraints
+	defaultProfileName = "default"
+
 	commonNameLength             = 64
 	serialNumberLength           = 64
 	countryNameLength            = 2
@@ -48,21 +55,23 @@ var (
 
 func newEnrollEndpoint(s *Server) *serverEndpoint {
 	return &serverEndpoint{
-		Path:      "enroll",
-		Methods:   []string{"POST"},
-		Handler:   enrollHandler,
-		Server:    s,
-		successRC: 201,
+		Path:        "enroll",
+		Methods:     []string{"POST"},
+		Handler:     enrollHandler,
+		Server:      s,
+		successRC:   201,
+		maxBodySize: s.enrollBodySizeLimit,
 	}
 }
 
 func newReenrollEndpoint(s *Server) *serverEndpoint {
 	return &serverEndpoint{
-		Path:      "reenroll",
-		Methods:   []string{"POST"},
-		Handler:   reenrollHandler,
-		Server:    s,
-		successRC: 201,
+		Path:        "reenroll",
+		Methods:     []string{"POST"},
+		Handler:     reenrollHandler,
+		Server:      s,
+		successRC:   201,
+		maxBodySize: s.enrollBodySizeLimit,
 	}
 }
 
@@ -93,6 +102,22 @@ func reenrollHandler(ctx *serverRequestContextImpl) (interface{}, error) {
 	return handleEnroll(ctx, id)
 }
 
+// certificateValidity returns the validity period of a certificate signed now
+// with profile. The current time is read from the util clock and passed to the
+// signer, which would otherwise read the system time for the start date.
+func certificateValidity(profile *config.SigningProfile) (notBefore, notAfter time.Time) {
+	now := util.Now().Round(time.Minute)
+	notBefore = profile.NotBefore
+	if notBefore.IsZero() {
+		backdate := profile.Backdate
+		if backdate == 0 {
+			backdate = defaultCertificateBackdate
+		}
+		notBefore = now.Add(-backdate)
+	}
+	return notBefore.UTC(), now.Add(profile.Expiry).UTC()
+}
+
 // Handle the common processing for enroll and reenroll
 func handleEnroll(ctx *serverRequestContextImpl, id string) (interface{}, error) {
 	var req api.EnrollmentRequestNet
@@ -112,7 +137,7 @@ func handleEnroll(ctx *serverRequestContextImpl, id string) (interface{}, error)
 		ca.Config.Signing.Profiles != nil && ca.Config.Signing.Profiles[req.Profile] != nil {
 		profile = ca.Config.Signing.Profiles[req.Profile]
 	}
-	req.NotAfter = time.Now().Round(time.Minute).Add(profile.Expiry).UTC()
+	req.NotBefore, req.NotAfter = certificateValidity(profile)
 
 	caexpiry, err := ca.getCACertExpiry()
 	if err != nil {
@@ -143,11 +168,27 @@ func handleEnroll(ctx *serverRequestContextImpl, id string) (interface{}, error)
 		log.Debugf("Adding attribute extension to CSR: %+v", ext)
 		req.Extensions = append(req.Extensions, *ext)
 	}
+	// Get the hash algorithm with which to sign the certificate, if requested
+	var hash crypto.Hash
+	if req.SigHash != "" {
+		hash, err = util.ParseHashAlgorithm(req.SigHash)
+		if err != nil {
+			return nil, caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "Invalid signature hash algorithm: %s", err)
+		}
+	}
+	// Reserve the CSR so that it is rejected if it is submitted again, even
+	// concurrently; it is released if no certificate is issued for it
+	csrHash, err := reserveCSR(req.Request, ca)
+	if err != nil {
+		return nil, err
+	}
 	// Sign the certificate
-	cert, err := ca.enrollSigner.Sign(req.SignRequest)
+	cert, serial, err := ca.issueCertificate(req.SignRequest, hash)
 	if err != nil {
-		return nil, errors.WithMessage(err, "Certificate signing failure")
+		releaseCSR(csrHash, ca)
+		return nil, err
 	}
+	log.Debugf("Issued certificate with serial number %s to '%s'", util.GetSerialAsHex(serial), id)
 	// Add server info to the response
 	resp := &api.EnrollmentResponseNet{
 		Cert: util.B64Encode(cert),
@@ -169,6 +210,12 @@ func handleEnroll(ctx *serverRequestContextImpl, id string) (interface{}, error)
 // as specified in RFC 3280, page 103.
 // Set the OU fields of the request.
 func processSignRequest(id string, req *signer.SignRequest, ca *CA, ctx *serverRequestContextImpl) error {
+	// Reject oversized requests before doing any parsing; the size of the
+	// request body was already bounded when it was read
+	err := util.CheckCSRSize([]byte(req.Request), ca.csrSizeLimit())
+	if err != nil {
+		return caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "Invalid CSR: %s", err)
+	}
 	// Decode and parse the request into a CSR so we can make checks
 	block, _ := pem.Decode([]byte(req.Request))
 	if block == nil {
@@ -178,14 +225,26 @@ func processSignRequest(id string, req *signer.SignRequest, ca *CA, ctx *serverR
 		return cferr.Wrap(cferr.CSRError,
 			cferr.BadRequest, errors.New("not a certificate or csr"))
 	}
-	csrReq, err := x509.ParseCertificateRequest(block.Bytes)
+	csrReq, err := util.ParseCSR(block.Bytes)
 	if err != nil {
 		return err
 	}
+	err = util.CheckParsedCSRSignatureAlgorithm(csrReq)
+	if err != nil {
+		return caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "Invalid CSR: %s", err)
+	}
+	err = util.VerifyParsedCSRPOP(csrReq)
+	if err != nil {
+		return caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "Invalid CSR: %s", err)
+	}
 	log.Debugf("Processing sign request: id=%s, CommonName=%s, Subject=%+v", id, csrReq.Subject.CommonName, req.Subject)
 	if (req.Subject != nil && req.Subject.CN != id) || csrReq.Subject.CommonName != id {
 		return caerrors.NewHTTPErr(403, caerrors.ErrCNInvalidEnroll, "The CSR subject common name must equal the enrollment ID")
 	}
+	err = checkCSRKeyAlgo(csrReq, ca, req.Profile)
+	if err != nil {
+		return err
+	}
 	isForCACert, err := isRequestForCASigningCert(csrReq, ca, req.Profile)
 	if err != nil {
 		return err
@@ -197,6 +256,10 @@ func processSignRequest(id string, req *signer.SignRequest, ca *CA, ctx *serverR
 		if err != nil {
 			return caerrors.NewAuthorizationErr(caerrors.ErrInvokerMissAttr, "Enrolled failed: %s", err)
 		}
+		err = checkCAPathLen(csrReq, ca, req.Profile)
+		if err != nil {
+			return err
+		}
 	}
 	// Check the CSR input length
 	err = csrInputLengthCheck(csrReq)
@@ -213,6 +276,61 @@ func processSignRequest(id string, req *signer.SignRequest, ca *CA, ctx *serverR
 	return nil
 }
 
+// reserveCSR reserves the PEM encoded CSR csrPEM in the CSR cache of the CA,
+// and rejects it if the CA has already processed it within the configured
+// replay window. The returned hash must be passed to releaseCSR if no
+// certificate is issued for the CSR; it is empty if replay protection is
+// disabled.
+func reserveCSR(csrPEM string, ca *CA) (string, error) {
+	window := ca.Config.CSRReplay.Window
+	if window <= 0 || ca.csrCache == nil {
+		return "", nil
+	}
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil {
+		return "", caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "CSR Decode failed")
+	}
+	csrHash := csrReplayHash(block.Bytes)
+	if !ca.csrCache.Reserve(csrHash, window) {
+		return "", caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "CSR already processed")
+	}
+	return csrHash, nil
+}
+
+// releaseCSR releases the CSR whose hash was returned by reserveCSR, so that
+// it can be submitted again
+func releaseCSR(csrHash string, ca *CA) {
+	if csrHash != "" {
+		ca.csrCache.Release(csrHash)
+	}
+}
+
+// csrReplayHash returns the key of the DER encoded CSR csrDER in the CSR cache
+func csrReplayHash(csrDER []byte) string {
+	hash := sha256.Sum256(csrDER)
+	return hex.EncodeToString(hash[:])
+}
+
+// enrollBodySizeLimit returns the size limit of the body of enroll and reenroll
+// requests in bytes, so that the memory used to read a request is bounded
+// before its CSR is checked against the CSR size limit. It leaves room for
+// the JSON encoding of a CSR of the maximum size and the other request fields.
+func (s *Server) enrollBodySizeLimit() int64 {
+	limit := util.DefaultCSRSizeLimit
+	if s.Config != nil && s.Config.CSRSizeLimit > 0 {
+		limit = s.Config.CSRSizeLimit
+	}
+	return 2 * int64(limit)
+}
+
+// csrSizeLimit returns the size limit of an acceptable CSR in bytes
+func (ca *CA) csrSizeLimit() int {
+	if ca.server == nil || ca.server.Config == nil {
+		return util.DefaultCSRSizeLimit
+	}
+	return ca.server.Config.CSRSizeLimit
+}
+
 // Check to see if this is a request for a CA signing certificate.
 // This can occur if the profile or the CSR has the IsCA bit set.
 // See the X.509 BasicConstraints extension (RFC 5280, 4.2.1.9).
@@ -248,6 +366,69 @@ func isRequestForCASigningCert(csrReq *x509.CertificateRequest, ca *CA, profile
 	return false, nil
 }
 
+// Check that the path length constraint of the CA certificate requested by the
+// CSR and signing profile does not exceed the one of the CA certificate
+func checkCAPathLen(csrReq *x509.CertificateRequest, ca *CA, profile string) error {
+	maxPathLen, maxPathLenZero := -1, false
+	sp := getSigningProfile(ca, profile)
+	if sp != nil && sp.CAConstraint.IsCA {
+		// The signing profile determines the path length constraint
+		maxPathLen, maxPathLenZero = sp.CAConstraint.MaxPathLen, sp.CAConstraint.MaxPathLenZero
+	} else {
+		for _, val := range csrReq.Extensions {
+			if val.Id.Equal(basicConstraintsOID) {
+				var constraints csr.BasicConstraints
+				_, err := asn1.Unmarshal(val.Value, &constraints)
+				if err != nil {
+					return caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "Failed parsing CSR constraints: %s", err)
+				}
+				maxPathLen, maxPathLenZero = constraints.MaxPathLen, constraints.MaxPathLen == 0
+			}
+		}
+	}
+	caCert, err := signerCertificate(ca.enrollSigner)
+	if err != nil {
+		return caerrors.NewHTTPErr(500, caerrors.ErrGetCACert, "Failed to get CA certificate: %s", err)
+	}
+	err = util.CheckCAPathLen(caCert, maxPathLen, maxPathLenZero)
+	if err != nil {
+		return caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "Cannot issue CA certificate: %s", err)
+	}
+	return nil
+}
+
+// Check that the key algorithm of the CSR is allowed by the constraints
+// configured for the signing profile
+func checkCSRKeyAlgo(csrReq *x509.CertificateRequest, ca *CA, profile string) error {
+	if profile == "" {
+		profile = defaultProfileName
+	}
+	constraints, ok := ca.Config.ProfileConstraints[profile]
+	if !ok || len(constraints.KeyAlgos) == 0 {
+		return nil
+	}
+	algo := csrKeyAlgo(csrReq)
+	for _, allowed := range constraints.KeyAlgos {
+		if strings.EqualFold(allowed, algo) {
+			return nil
+		}
+	}
+	return caerrors.NewHTTPErr(400, caerrors.ErrBadCSR, "The key algorithm '%s' of the CSR is not allowed by profile '%s'; allowed key algorithms are %v",
+		algo, profile, constraints.KeyAlgos)
+}
+
+// Returns the key algorithm name of the CSR as used in key requests
+func csrKeyAlgo(csrReq *x509.CertificateRequest) string {
+	switch csrReq.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		return "ecdsa"
+	case x509.RSA:
+		return "rsa"
+	default:
+		return strings.ToLower(csrReq.PublicKeyAlgorithm.String())
+	}
+}
+
 func getSigningProfile(ca *CA, profile string) *config.SigningProfile {
 	if profile == "" {
 		return ca.Config.Signing.Default
@@ -7,9 +7,24 @@ SPDX-License-Identifier: Apache-2.0
 package lib
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
 	"github.com/hyperledger/fabric-ca/internal/pkg/api"
 	"github.com/hyperledger/fabric-ca/internal/pkg/util"
 	dbuser "github.com/hyperledger/fabric-ca/lib/server/user"
@@ -170,3 +185,278 @@ func TestPasswordLimit(t *testing.T) {
 	util.FatalError(t, err, "Failed to get 'user1' from database")
 	assert.Equal(t, 0, user1.GetFailedLoginAttempts())
 }
+
+func TestCheckCSRKeyAlgo(t *testing.T) {
+	ca := &CA{Config: &CAConfig{
+		ProfileConstraints: map[string]ProfileConstraints{
+			"tls":     {KeyAlgos: []string{"ecdsa"}},
+			"default": {KeyAlgos: []string{"RSA"}},
+		},
+	}}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate ECDSA key")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	util.FatalError(t, err, "Failed to generate RSA key")
+	ecCSR := createTestCSR(t, ecKey)
+	rsaCSR := createTestCSR(t, rsaKey)
+
+	assert.NoError(t, checkCSRKeyAlgo(ecCSR, ca, "tls"))
+	err = checkCSRKeyAlgo(rsaCSR, ca, "tls")
+	if assert.Error(t, err, "RSA CSR should be rejected by the 'tls' profile") {
+		assert.Contains(t, err.Error(), "The key algorithm 'rsa' of the CSR is not allowed by profile 'tls'")
+	}
+
+	assert.NoError(t, checkCSRKeyAlgo(rsaCSR, ca, ""))
+	err = checkCSRKeyAlgo(ecCSR, ca, "")
+	assert.Error(t, err, "ECDSA CSR should be rejected by the default profile")
+
+	// Profiles without constraints allow all key algorithms
+	assert.NoError(t, checkCSRKeyAlgo(ecCSR, ca, "ca"))
+	assert.NoError(t, checkCSRKeyAlgo(rsaCSR, ca, "ca"))
+}
+
+func TestProcessSignRequestCSRSizeLimit(t *testing.T) {
+	ca := &CA{server: &Server{Config: &ServerConfig{CSRSizeLimit: 1024}}}
+	// The oversized request is not even PEM, so it must be rejected before parsing
+	req := &signer.SignRequest{Request: strings.Repeat("A", 1025)}
+	err := processSignRequest("admin", req, ca, nil)
+	if assert.Error(t, err, "Oversized CSR should be rejected") {
+		assert.Contains(t, err.Error(), "CSR too large")
+	}
+
+	req.Request = strings.Repeat("A", 1024)
+	err = processSignRequest("admin", req, ca, nil)
+	if assert.Error(t, err, "Garbage CSR should be rejected") {
+		assert.Contains(t, err.Error(), "CSR Decode failed")
+	}
+}
+
+func TestEnrollBodySizeLimit(t *testing.T) {
+	cleanTestSlateSE(t)
+	defer cleanTestSlateSE(t)
+
+	srv := TestGetRootServer(t)
+	srv.Config.CSRSizeLimit = 4096
+	err := srv.Start()
+	util.FatalError(t, err, "Failed to start server")
+	defer srv.Stop()
+
+	post := func(endpoint string, body []byte) error {
+		client := getTestClient(rootPort)
+		req, err := client.newPost(endpoint, body)
+		util.FatalError(t, err, "Failed to create post request")
+		req.SetBasicAuth("admin", "adminpw")
+		return client.SendReq(req, nil)
+	}
+	// The body is rejected as it is read, before it is decoded
+	oversized := []byte(`{"certificate_request":"` + strings.Repeat("A", 2*4096) + `"}`)
+	for _, endpoint := range []string{"enroll", "reenroll"} {
+		err = post(endpoint, oversized)
+		if assert.Error(t, err, "An oversized %s request should be rejected", endpoint) {
+			assert.Contains(t, err.Error(), "Request body too large")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	reqNet := &api.EnrollmentRequestNet{}
+	reqNet.SignRequest.Request = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: createTestCSR(t, key).Raw}))
+	body, err := util.Marshal(reqNet, "SignRequest")
+	util.FatalError(t, err, "Failed to marshal enroll request")
+	assert.NoError(t, post("enroll", body), "A request within the limit should be accepted")
+}
+
+func TestCSRReplay(t *testing.T) {
+	cleanTestSlateSE(t)
+	defer cleanTestSlateSE(t)
+
+	srv := TestGetRootServer(t)
+	srv.CA.Config.CSRReplay.Window = time.Hour
+	err := srv.Start()
+	util.FatalError(t, err, "Failed to start server")
+	defer srv.Stop()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: createTestCSR(t, key).Raw})
+	enroll := func(sigHash string) error {
+		reqNet := &api.EnrollmentRequestNet{SigHash: sigHash}
+		reqNet.SignRequest.Request = string(csrPEM)
+		body, err := util.Marshal(reqNet, "SignRequest")
+		util.FatalError(t, err, "Failed to marshal enroll request")
+		client := getTestClient(rootPort)
+		post, err := client.newPost("enroll", body)
+		util.FatalError(t, err, "Failed to create post request")
+		post.SetBasicAuth("admin", "adminpw")
+		return client.SendReq(post, nil)
+	}
+	// A CSR is only recorded once a certificate has been issued for it
+	assert.Error(t, enroll("MD5"), "The enrollment with an invalid hash should fail")
+	assert.NoError(t, enroll(""), "The first enrollment with the CSR should succeed")
+	err = enroll("")
+	if assert.Error(t, err, "The second enrollment with the same CSR should fail") {
+		assert.Contains(t, err.Error(), "CSR already processed")
+	}
+
+	// Only one of concurrent enrollments with the same CSR succeeds
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: createTestCSR(t, key).Raw})
+	const workers = 5
+	var wg sync.WaitGroup
+	var enrolled int32
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if enroll("") == nil {
+				atomic.AddInt32(&enrolled, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), enrolled, "Only one concurrent enrollment with the same CSR should succeed")
+}
+
+func TestMemoryCSRCache(t *testing.T) {
+	now := time.Now()
+	util.SetClock(testClock{&now})
+	defer util.SetClock(nil)
+
+	cache := NewMemoryCSRCache()
+	assert.True(t, cache.Reserve("csr1", time.Hour))
+	assert.False(t, cache.Reserve("csr1", time.Hour), "A reserved CSR should be rejected")
+	assert.True(t, cache.Reserve("csr2", time.Hour))
+	// A released CSR can be reserved again
+	cache.Release("csr2")
+	assert.True(t, cache.Reserve("csr2", time.Hour))
+
+	// The CSRs are forgotten once they are outside of the window
+	now = now.Add(time.Hour)
+	assert.True(t, cache.Reserve("csr1", time.Hour))
+	// Reserving a CSR sweeps the expired ones
+	assert.True(t, cache.Reserve("csr3", time.Hour))
+	assert.Len(t, cache.(*memCSRCache).seen, 2)
+	assert.False(t, cache.Reserve("csr3", time.Hour))
+}
+
+func TestMemoryCSRCacheConcurrentReserve(t *testing.T) {
+	cache := NewMemoryCSRCache()
+	const workers = 20
+	var wg sync.WaitGroup
+	var reserved int32
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cache.Reserve("csr", time.Hour) {
+				atomic.AddInt32(&reserved, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), reserved, "Only one of concurrent submissions of a CSR should be reserved")
+}
+
+// testClock is a util.Clock which returns the time it points to
+type testClock struct {
+	now *time.Time
+}
+
+func (c testClock) Now() time.Time {
+	return *c.now
+}
+
+func TestCheckCAPathLen(t *testing.T) {
+	ca := &CA{Config: &CAConfig{
+		Signing: &config.Signing{
+			Default: config.DefaultConfig(),
+			Profiles: map[string]*config.SigningProfile{
+				"ca":     {CAConstraint: config.CAConstraint{IsCA: true, MaxPathLen: 0, MaxPathLenZero: true}},
+				"ca1":    {CAConstraint: config.CAConstraint{IsCA: true, MaxPathLen: 1}},
+				"cafree": {CAConstraint: config.CAConstraint{IsCA: true}},
+			},
+		},
+	}}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	csrReq := createTestCSR(t, key)
+
+	ca.enrollSigner = createTestCASigner(t, 1)
+	assert.NoError(t, checkCAPathLen(csrReq, ca, "ca"))
+	err = checkCAPathLen(csrReq, ca, "ca1")
+	if assert.Error(t, err, "Path length 1 should exceed the CA's path length 1") {
+		assert.Contains(t, err.Error(), "exceeds the maximum of 0")
+	}
+	assert.Error(t, checkCAPathLen(csrReq, ca, "cafree"), "Unconstrained path length should be rejected")
+
+	ca.enrollSigner = createTestCASigner(t, 0)
+	err = checkCAPathLen(csrReq, ca, "ca")
+	if assert.Error(t, err, "CA with path length 0 should not issue CA certificates") {
+		assert.Contains(t, err.Error(), "cannot issue CA certificates")
+	}
+
+	ca.enrollSigner = createTestCASigner(t, -1)
+	assert.NoError(t, checkCAPathLen(csrReq, ca, "ca1"))
+	assert.NoError(t, checkCAPathLen(csrReq, ca, "cafree"))
+}
+
+// createTestCASigner returns a signer whose self-signed CA certificate has
+// the path length constraint maxPathLen, or none if negative
+func createTestCASigner(t *testing.T, maxPathLen int) signer.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	util.FatalError(t, err, "Failed to generate key")
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pathlenca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            maxPathLen,
+		MaxPathLenZero:        maxPathLen == 0,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	util.FatalError(t, err, "Failed to create CA certificate")
+	cert, err := x509.ParseCertificate(der)
+	util.FatalError(t, err, "Failed to parse CA certificate")
+	s, err := local.NewSigner(key, cert, x509.ECDSAWithSHA256, nil)
+	util.FatalError(t, err, "Failed to create CA signer")
+	return s
+}
+
+func createTestCSR(t *testing.T, priv interface{}) *x509.CertificateRequest {
+	tmpl := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "admin"}}
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, priv)
+	util.FatalError(t, err, "Failed to create CSR")
+	csrReq, err := x509.ParseCertificateRequest(der)
+	util.FatalError(t, err, "Failed to parse CSR")
+	return csrReq
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestCertificateValidity(t *testing.T) {
+	frozen := time.Date(2020, 3, 4, 5, 6, 0, 0, time.UTC)
+	util.SetClock(fixedClock(frozen.Add(20 * time.Second)))
+	defer util.SetClock(nil)
+
+	profile := &config.SigningProfile{Expiry: time.Hour}
+	notBefore, notAfter := certificateValidity(profile)
+	assert.Equal(t, frozen.Add(-defaultCertificateBackdate), notBefore)
+	assert.Equal(t, frozen.Add(time.Hour), notAfter)
+
+	profile.Backdate = time.Minute
+	notBefore, _ = certificateValidity(profile)
+	assert.Equal(t, frozen.Add(-time.Minute), notBefore)
+
+	profile.NotBefore = frozen.Add(-48 * time.Hour)
+	notBefore, _ = certificateValidity(profile)
+	assert.Equal(t, profile.NotBefore, notBefore)
+}
@@ -11,6 +11,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -377,12 +378,30 @@ func (ctx *serverRequestContextImpl) TryReadBody(body interface{}) (bool, error)
 func (ctx *serverRequestContextImpl) ReadBodyBytes() ([]byte, error) {
 	if !ctx.body.read {
 		r := ctx.req
-		buf, err := ioutil.ReadAll(r.Body)
+		var limit int64
+		if ctx.endpoint != nil && ctx.endpoint.maxBodySize != nil {
+			limit = ctx.endpoint.maxBodySize()
+		}
+		var buf []byte
+		var err error
+		if limit > 0 {
+			// Read one byte more than the limit to detect a larger body
+			buf, err = ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+			if err == nil && int64(len(buf)) > limit {
+				buf = nil
+				err = caerrors.NewHTTPErr(413, caerrors.ErrBadReqBody, "Request body too large: it exceeds the limit of %d bytes", limit)
+			}
+		} else {
+			buf, err = ioutil.ReadAll(r.Body)
+		}
 		ctx.body.buf = buf
 		ctx.body.err = err
 		ctx.body.read = true
 	}
 	err := ctx.body.err
+	if he, ok := err.(*caerrors.HTTPErr); ok {
+		return nil, he
+	}
 	if err != nil {
 		return nil, caerrors.NewHTTPErr(500, caerrors.ErrReadingReqBody, "Failed reading request body: %s", err)
 	}
@@ -99,7 +99,7 @@ func revokeHandler(ctx *serverRequestContextImpl) (interface{}, error) {
 			return nil, caerrors.NewHTTPErr(404, caerrors.ErrRevokeIDNotFound, "Identity %s was not found: %s", certificate.ID, err)
 		}
 
-		if !((req.AKI == calleraki) && (req.Serial == callerserial)) {
+		if !(util.SKIEqual([]byte(req.AKI), []byte(calleraki)) && (req.Serial == callerserial)) {
 			err = ctx.CanManageUser(userInfo)
 			if err != nil {
 				return nil, err
@@ -19,7 +19,9 @@ package tls
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
+	"sync"
 	"time"
 
 	"github.com/cloudflare/cfssl/log"
@@ -66,6 +68,50 @@ type KeyCertFiles struct {
 	CertFile string `help:"PEM-encoded certificate file when mutual authenticate is enabled"`
 }
 
+// ReloadableCertificate is a TLS certificate which can be replaced without
+// restarting the server using it; the server's tls.Config must get the
+// certificate from its GetCertificate method
+type ReloadableCertificate struct {
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+	csp   bccsp.BCCSP
+}
+
+// NewReloadableCertificate returns a ReloadableCertificate loaded from the
+// certificate and key files; the private key is found by csp if possible,
+// as with util.LoadX509KeyPair
+func NewReloadableCertificate(certFile, keyFile string, csp bccsp.BCCSP) (*ReloadableCertificate, error) {
+	rc := &ReloadableCertificate{csp: csp}
+	err := rc.Reload(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Reload loads the certificate and key files and replaces the certificate
+// served by rc, which is used by subsequent handshakes. If the files can't be
+// loaded, the current certificate is kept.
+func (rc *ReloadableCertificate) Reload(certFile, keyFile string) error {
+	cert, err := util.LoadX509KeyPair(certFile, keyFile, rc.csp)
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("Failed to load TLS certificate %s", certFile))
+	}
+	rc.mutex.Lock()
+	rc.cert = cert
+	rc.mutex.Unlock()
+	log.Infof("Loaded TLS certificate %s", certFile)
+	return nil
+}
+
+// GetCertificate returns the current certificate; it is meant to be used as
+// the GetCertificate callback of a tls.Config
+func (rc *ReloadableCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	rc.mutex.RLock()
+	defer rc.mutex.RUnlock()
+	return rc.cert, nil
+}
+
 // GetClientTLSConfig creates a tls.Config object from certs and roots
 func GetClientTLSConfig(cfg *ClientTLSConfig, csp bccsp.BCCSP) (*tls.Config, error) {
 	var certs []tls.Certificate
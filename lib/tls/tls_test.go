@@ -17,17 +17,24 @@ limitations under the License.
 package tls
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/hyperledger/fabric/bccsp/factory"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -202,3 +209,94 @@ func createTestCertificate() error {
 
 	return nil
 }
+
+func TestReloadableCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reloadtls")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	cert1, key1 := createTLSKeyPair(t, dir, 1)
+	cert2, key2 := createTLSKeyPair(t, dir, 2)
+
+	rc, err := NewReloadableCertificate(cert1, key1, factory.GetDefault())
+	if err != nil {
+		t.Fatalf("Failed to load TLS certificate: %s", err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: rc.GetCertificate})
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	serverSerial := func() int64 {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("Failed to connect to the server: %s", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	assert.Equal(t, int64(1), serverSerial())
+	err = rc.Reload(cert2, key2)
+	if err != nil {
+		t.Fatalf("Failed to reload TLS certificate: %s", err)
+	}
+	assert.Equal(t, int64(2), serverSerial(), "Handshakes should use the reloaded certificate")
+
+	// A failed reload keeps the current certificate
+	err = rc.Reload(filepath.Join(dir, "missing.pem"), key1)
+	assert.Error(t, err)
+	err = rc.Reload(cert1, key2)
+	assert.Error(t, err, "Reloading a certificate with a key which doesn't match should fail")
+	assert.Equal(t, int64(2), serverSerial())
+
+	_, err = NewReloadableCertificate(filepath.Join(dir, "missing.pem"), key1, factory.GetDefault())
+	assert.Error(t, err)
+}
+
+// createTLSKeyPair writes a self-signed certificate with the serial number
+// serial and its key in dir, and returns the names of the files
+func createTLSKeyPair(t *testing.T, dir string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %s", err)
+	}
+	certFile := filepath.Join(dir, fmt.Sprintf("cert%d.pem", serial))
+	keyFile := filepath.Join(dir, fmt.Sprintf("key%d.pem", serial))
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write key: %s", err)
+	}
+	return certFile, keyFile
+}
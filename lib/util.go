@@ -238,7 +238,7 @@ func (cd *CertificateDecoder) StoreCert(enrollmentID, storePath string, cert []b
 		filePath = filepath.Join(storePath, fmt.Sprintf("%s-%d.pem", enrollmentID, cd.certIDCount[enrollmentID]))
 	}
 
-	err = ioutil.WriteFile(filePath, cert, 0644)
+	err = util.WriteFile(filePath, cert, 0644)
 	if err != nil {
 		return errors.WithMessage(err, fmt.Sprintf("Failed to store certificate at: %s", storePath))
 	}